@@ -1,5 +1,7 @@
 package dtos
 
+import "time"
+
 type RegisterRequest struct {
 	Email       string `json:"email" binding:"required,email"`
 	Password    string `json:"password" binding:"required,min=6"`
@@ -14,8 +16,11 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	User  interface{} `json:"user"`
-	Token string      `json:"token"`
+	User         interface{} `json:"user"`
+	Token        string      `json:"token"`
+	ExpiresAt    time.Time   `json:"expiresAt"`
+	RefreshToken string      `json:"refreshToken"`
+	SessionID    string      `json:"sessionId"`
 }
 
 type SocialLoginRequest struct {
@@ -8,24 +8,34 @@ import (
 	"healthy_pay_backend/internal/application/dtos"
 	"healthy_pay_backend/internal/domain/entities"
 	"healthy_pay_backend/internal/domain/repositories"
+	core "healthy_pay_backend/internal/services"
 	"healthy_pay_backend/internal/utils"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// SessionCreator starts the session a sign-in's tokens belong to;
+// core.SessionService in production
+type SessionCreator interface {
+	Create(ctx context.Context, userID primitive.ObjectID, client core.SessionClient) (*core.SessionTokens, error)
+}
+
 type AuthService struct {
 	userRepo   repositories.UserRepository
 	walletRepo repositories.WalletRepository
+	sessions   SessionCreator
 }
 
-func NewAuthService(userRepo repositories.UserRepository, walletRepo repositories.WalletRepository) *AuthService {
+func NewAuthService(userRepo repositories.UserRepository, walletRepo repositories.WalletRepository, sessions SessionCreator) *AuthService {
 	return &AuthService{
 		userRepo:   userRepo,
 		walletRepo: walletRepo,
+		sessions:   sessions,
 	}
 }
 
-func (s *AuthService) Register(ctx context.Context, req *dtos.RegisterRequest) (*dtos.AuthResponse, error) {
+func (s *AuthService) Register(ctx context.Context, req *dtos.RegisterRequest, client core.SessionClient) (*dtos.AuthResponse, error) {
 	// Check if user exists
 	_, err := s.userRepo.GetByEmail(ctx, req.Email)
 	if err == nil {
@@ -68,17 +78,17 @@ func (s *AuthService) Register(ctx context.Context, req *dtos.RegisterRequest) (
 	}
 	s.walletRepo.Create(ctx, wallet)
 
-	// Generate token
-	token, err := utils.GenerateJWT(user.ID)
+	// Start a session
+	tokens, err := s.sessions.Create(ctx, user.ID, client)
 	if err != nil {
 		return nil, err
 	}
 
 	user.Password = ""
-	return &dtos.AuthResponse{User: user, Token: token}, nil
+	return authResponse(user, tokens), nil
 }
 
-func (s *AuthService) Login(ctx context.Context, req *dtos.LoginRequest) (*dtos.AuthResponse, error) {
+func (s *AuthService) Login(ctx context.Context, req *dtos.LoginRequest, client core.SessionClient) (*dtos.AuthResponse, error) {
 	user, err := s.userRepo.GetByEmail(ctx, req.Email)
 	if err != nil {
 		return nil, errors.New("invalid credentials")
@@ -88,11 +98,21 @@ func (s *AuthService) Login(ctx context.Context, req *dtos.LoginRequest) (*dtos.
 		return nil, errors.New("invalid credentials")
 	}
 
-	token, err := utils.GenerateJWT(user.ID)
+	tokens, err := s.sessions.Create(ctx, user.ID, client)
 	if err != nil {
 		return nil, err
 	}
 
 	user.Password = ""
-	return &dtos.AuthResponse{User: user, Token: token}, nil
+	return authResponse(user, tokens), nil
+}
+
+func authResponse(user *entities.User, tokens *core.SessionTokens) *dtos.AuthResponse {
+	return &dtos.AuthResponse{
+		User:         user,
+		Token:        tokens.AccessToken,
+		ExpiresAt:    tokens.ExpiresAt,
+		RefreshToken: tokens.RefreshToken,
+		SessionID:    tokens.SessionID.Hex(),
+	}
 }
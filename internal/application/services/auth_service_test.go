@@ -6,6 +6,7 @@ import (
 
 	"healthy_pay_backend/internal/application/dtos"
 	"healthy_pay_backend/internal/domain/entities"
+	core "healthy_pay_backend/internal/services"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -56,10 +57,20 @@ func (m *mockWalletRepository) UpdateBalance(ctx context.Context, userID primiti
 	return nil
 }
 
+type mockSessionCreator struct {
+	created []primitive.ObjectID
+}
+
+func (m *mockSessionCreator) Create(ctx context.Context, userID primitive.ObjectID, client core.SessionClient) (*core.SessionTokens, error) {
+	m.created = append(m.created, userID)
+	return &core.SessionTokens{SessionID: primitive.NewObjectID(), AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
 func TestAuthService_Register(t *testing.T) {
 	userRepo := &mockUserRepository{users: make(map[string]*entities.User)}
 	walletRepo := &mockWalletRepository{}
-	service := NewAuthService(userRepo, walletRepo)
+	sessions := &mockSessionCreator{}
+	service := NewAuthService(userRepo, walletRepo, sessions)
 
 	req := &dtos.RegisterRequest{
 		Email:       "test@example.com",
@@ -69,7 +80,7 @@ func TestAuthService_Register(t *testing.T) {
 		PhoneNumber: "+1234567890",
 	}
 
-	response, err := service.Register(context.Background(), req)
+	response, err := service.Register(context.Background(), req, core.SessionClient{})
 	
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
@@ -82,12 +93,17 @@ func TestAuthService_Register(t *testing.T) {
 	if response.Token == "" {
 		t.Error("Expected token, got empty string")
 	}
+
+	if len(sessions.created) != 1 || response.RefreshToken == "" {
+		t.Errorf("Expected a session for the new user, got %d", len(sessions.created))
+	}
 }
 
 func TestAuthService_RegisterDuplicate(t *testing.T) {
 	userRepo := &mockUserRepository{users: make(map[string]*entities.User)}
 	walletRepo := &mockWalletRepository{}
-	service := NewAuthService(userRepo, walletRepo)
+	sessions := &mockSessionCreator{}
+	service := NewAuthService(userRepo, walletRepo, sessions)
 
 	req := &dtos.RegisterRequest{
 		Email:       "test@example.com",
@@ -98,10 +114,10 @@ func TestAuthService_RegisterDuplicate(t *testing.T) {
 	}
 
 	// Register first user
-	service.Register(context.Background(), req)
+	service.Register(context.Background(), req, core.SessionClient{})
 	
 	// Try to register duplicate
-	_, err := service.Register(context.Background(), req)
+	_, err := service.Register(context.Background(), req, core.SessionClient{})
 	
 	if err == nil {
 		t.Error("Expected error for duplicate user, got nil")
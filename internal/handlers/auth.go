@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"healthy_pay_backend/internal/models"
@@ -19,11 +21,13 @@ import (
 )
 
 type AuthHandler struct {
-	db *mongo.Database
+	db       *mongo.Database
+	pins     *services.PINService
+	sessions *services.SessionService
 }
 
 func NewAuthHandler(db *mongo.Database) *AuthHandler {
-	return &AuthHandler{db: db}
+	return &AuthHandler{db: db, pins: services.NewPINService(db), sessions: services.NewSessionService(db)}
 }
 
 func (h *AuthHandler) ValidateEmail(c *gin.Context) {
@@ -250,6 +254,11 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	}
 
 	userID := result.InsertedID.(primitive.ObjectID)
+
+	// Handle other users send to; a missing one is assigned at the next startup
+	if _, err := services.NewWalletHandleService(h.db).AssignHandle(context.Background(), userID, req.FirstName, req.LastName); err != nil {
+		log.Printf("Error assigning wallet handle to user %s: %v", userID.Hex(), err)
+	}
 	
 	// Create default blockchain wallet
 	// blockchainServiceFactory := services.NewBlockchainServiceFactory(h.db)
@@ -349,8 +358,8 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	if err != nil {
 		wallet := models.Wallet{
 			UserID:    user.ID,
-			Balance:   0.0,
-			Currency:  "USD",
+			Balance:   models.NewMoney(0, models.DefaultCurrency),
+			Currency:  models.DefaultCurrency,
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}
@@ -360,7 +369,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	// Create default onchain wallet if doesn't exist
 	h.ensureDefaultOnchainWallet(user.ID)
 
-	token, err := utils.GenerateJWT(user.ID)
+	tokens, err := h.sessions.Create(c.Request.Context(), user.ID, sessionClient(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -371,7 +380,10 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	
 	c.JSON(http.StatusOK, gin.H{
 		"user":             user,
-		"token":            token,
+		"token":            tokens.AccessToken,
+		"expiresAt":        tokens.ExpiresAt,
+		"refreshToken":     tokens.RefreshToken,
+		"sessionId":        tokens.SessionID,
 		"hasPIN":           hasPIN,
 		"hasPaymentMethod": hasPaymentMethod,
 	})
@@ -515,15 +527,15 @@ func (h *AuthHandler) VerifyEmail(c *gin.Context) {
 	// Create wallet for verified user
 	wallet := models.Wallet{
 		UserID:    user.ID,
-		Balance:   0.0,
-		Currency:  "USD",
+		Balance:   models.NewMoney(0, models.DefaultCurrency),
+		Currency:  models.DefaultCurrency,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 	h.db.Collection("wallets").InsertOne(context.Background(), wallet)
 
-	// Generate token for verified user
-	token, err := utils.GenerateJWT(user.ID)
+	// Sign the verified user in
+	tokens, err := h.sessions.Create(c.Request.Context(), user.ID, sessionClient(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -540,7 +552,10 @@ func (h *AuthHandler) VerifyEmail(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":          "Email verified successfully",
-		"token":            token,
+		"token":            tokens.AccessToken,
+		"expiresAt":        tokens.ExpiresAt,
+		"refreshToken":     tokens.RefreshToken,
+		"sessionId":        tokens.SessionID,
 		"hasPIN":           user.PIN != "",
 		"hasPaymentMethod": hasPaymentMethod,
 	})
@@ -651,33 +666,148 @@ func (h *AuthHandler) SetupPIN(c *gin.Context) {
 		return
 	}
 
-	hashedPIN, err := utils.HashPassword(req.PIN)
+	err = h.pins.SetPIN(c.Request.Context(), userID, req.PIN)
+	switch {
+	case errors.Is(err, services.ErrInvalidPINFormat):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PIN must be exactly 4 digits"})
+		return
+	case errors.Is(err, services.ErrPINAlreadySet):
+		// Replacing a PIN needs the current one or a reset code
+		c.JSON(http.StatusConflict, gin.H{"error": "PIN already set, use change or reset"})
+		return
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set PIN"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "PIN set successfully"})
+}
+
+// VerifyPIN exchanges the PIN for a short-lived transaction token that
+// money-moving requests send as X-Transaction-Token
+func (h *AuthHandler) VerifyPIN(c *gin.Context) {
+	var req struct {
+		PIN string `json:"pin" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PIN is required"})
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userID"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash PIN"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
-	collection := h.db.Collection("users")
-	result, err := collection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": userID},
-		bson.M{"$set": bson.M{
-			"pin":        hashedPIN,
-			"updated_at": time.Now(),
-		}},
-	)
+	if err := h.pins.Verify(c.Request.Context(), userID, req.PIN); err != nil {
+		respondPINError(c, err)
+		return
+	}
 
+	token, expiresAt, err := utils.GenerateTransactionToken(userID, c.GetString("sessionID"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set PIN"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	c.JSON(http.StatusOK, gin.H{
+		"transactionToken": token,
+		"expiresAt":        expiresAt,
+	})
+}
+
+func (h *AuthHandler) ChangePIN(c *gin.Context) {
+	var req struct {
+		CurrentPIN string `json:"currentPin" binding:"required"`
+		NewPIN     string `json:"newPin" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currentPin and newPin are required"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "PIN set successfully"})
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.pins.ChangePIN(c.Request.Context(), userID, req.CurrentPIN, req.NewPIN); err != nil {
+		respondPINError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "PIN changed successfully"})
+}
+
+// RequestPINReset emails a code for setting a new PIN without the current one
+func (h *AuthHandler) RequestPINReset(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.pins.RequestReset(c.Request.Context(), userID); err != nil {
+		if errors.Is(err, services.ErrTooManyAttempts) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "A reset code was just sent, try again in a minute"})
+			return
+		}
+		respondPINError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "PIN reset code sent to your email"})
+}
+
+func (h *AuthHandler) ResetPIN(c *gin.Context) {
+	var req struct {
+		Code   string `json:"code" binding:"required"`
+		NewPIN string `json:"newPin" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code and newPin are required"})
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.pins.ResetPIN(c.Request.Context(), userID, req.Code, req.NewPIN); err != nil {
+		respondPINError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "PIN reset successfully"})
+}
+
+// respondPINError maps PINService errors to responses
+func respondPINError(c *gin.Context, err error) {
+	var invalid *services.InvalidPINError
+	var locked *services.PINLockedError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid PIN", "attemptsRemaining": invalid.AttemptsRemaining})
+	case errors.As(err, &locked):
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+		c.JSON(http.StatusLocked, gin.H{"error": "Too many wrong PINs, try again later", "lockedUntil": locked.Until})
+	case errors.Is(err, services.ErrPINNotSet):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set up a PIN first"})
+	case errors.Is(err, services.ErrInvalidPINFormat):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PIN must be exactly 4 digits"})
+	case errors.Is(err, services.ErrInvalidResetCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset code"})
+	default:
+		log.Printf("❌ PIN request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process PIN"})
+	}
 }
 
 func (h *AuthHandler) SetupPaymentMethod(c *gin.Context) {
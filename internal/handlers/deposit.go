@@ -2,12 +2,15 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
 	"healthy_pay_backend/internal/models"
 	"healthy_pay_backend/internal/services"
+	"healthy_pay_backend/internal/statemachine"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -19,12 +22,14 @@ import (
 type DepositHandler struct {
 	db         *mongo.Database
 	pspService *services.PSPService
+	settlement *services.SettlementService
 }
 
 func NewDepositHandler(db *mongo.Database) *DepositHandler {
 	return &DepositHandler{
 		db:         db,
 		pspService: services.NewPSPService(db),
+		settlement: services.NewSettlementService(db),
 	}
 }
 
@@ -53,14 +58,27 @@ func (h *DepositHandler) InitiateDeposit(c *gin.Context) {
 		return
 	}
 
+	amount, err := parseAmount(req.Amount, paymentMethod.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	reference := fmt.Sprintf("DEP_%d_%s", time.Now().Unix(), userID.Hex()[:8])
 
+	history, err := statemachine.Deposit.Start(statemachine.DepositPending, "Deposit created")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transaction record"})
+		return
+	}
+
 	transaction := models.UnifiedTransaction{
 		ID:                   primitive.NewObjectID(),
 		UserID:               userID,
 		Type:                 "deposit",
-		Amount:               req.Amount,
-		Status:               "pending",
+		Amount:               amount,
+		Status:               statemachine.DepositPending,
+		StatusHistory:        history,
 		TransactionID:        "",
 		PSPReference:         reference,
 		PaymentMethodID:      req.PaymentMethodID,
@@ -81,7 +99,7 @@ func (h *DepositHandler) InitiateDeposit(c *gin.Context) {
 	var pspResponse *services.CollectionResponse
 	if paymentMethod.Type == "mobile_money" {
 		collectionReq := services.CollectionRequest{
-			Amount:      req.Amount,
+			Amount:      amount,
 			PhoneNumber: paymentMethod.PhoneNumber,
 			Provider:    paymentMethod.Network,
 			Reference:   reference,
@@ -89,17 +107,23 @@ func (h *DepositHandler) InitiateDeposit(c *gin.Context) {
 
 		pspResponse, err = h.pspService.InitiateCollection(collectionReq)
 		if err != nil {
-			h.updateTransactionStatus(transaction.ID, "failed", nil)
+			h.updateTransactionStatus(transaction.ID, statemachine.DepositFailed, "Collection could not be initiated", nil)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initiate payment collection"})
 			return
 		}
 
-		h.updateTransactionStatus(transaction.ID, "initiated", pspResponse)
+		if err := h.updateTransactionStatus(transaction.ID, statemachine.DepositInitiated, "Collection initiated with PSP", pspResponse); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transaction record"})
+			return
+		}
 		transaction.TransactionID = pspResponse.TransactionID
-		transaction.Status = "initiated"
+		transaction.Status = statemachine.DepositInitiated
 	} else {
-		h.updateTransactionStatus(transaction.ID, "initiated", nil)
-		transaction.Status = "initiated"
+		if err := h.updateTransactionStatus(transaction.ID, statemachine.DepositInitiated, "Deposit initiated", nil); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transaction record"})
+			return
+		}
+		transaction.Status = statemachine.DepositInitiated
 		transaction.TransactionID = reference
 	}
 
@@ -156,14 +180,17 @@ func (h *DepositHandler) CheckDepositStatus(c *gin.Context) {
 		return
 	}
 
-	if (transaction.Status == "initiated" || transaction.Status == "pending") && transaction.TransactionID != "" {
-		pspStatus, err := h.pspService.CheckCollectionStatus(transaction.TransactionID)
-		if err == nil && pspStatus != transaction.Status {
-			h.updateTransactionStatus(transaction.ID, pspStatus, nil)
-			transaction.Status = pspStatus
-
-			if pspStatus == "collected" || pspStatus == "success" {
-				h.processSuccessfulDeposit(transaction)
+	if (transaction.Status == statemachine.DepositInitiated || transaction.Status == statemachine.DepositPending) && transaction.TransactionID != "" {
+		pspStatus, err := h.pspService.CheckCollectionStatus(transaction.PSPName, transaction.TransactionID)
+		if errors.Is(err, services.ErrPSPNotConfigured) && !transaction.ManualReview {
+			h.settlement.FlagDepositForReview(context.Background(), transaction, err.Error())
+		} else if err == nil {
+			applied, err := h.settlement.ApplyDepositCollectionStatus(context.Background(), transaction, pspStatus)
+			if err != nil {
+				log.Printf("Error settling deposit %s: %v", transaction.ID.Hex(), err)
+			}
+			if applied {
+				collection.FindOne(context.Background(), bson.M{"_id": transaction.ID}).Decode(&transaction)
 			}
 		}
 	}
@@ -236,54 +263,23 @@ func (h *DepositHandler) GetDeposits(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"deposits": deposits})
 }
 
-func (h *DepositHandler) updateTransactionStatus(transactionID primitive.ObjectID, status string, pspResponse interface{}) {
-	collection := h.db.Collection("transactions")
-	update := bson.M{
-		"$set": bson.M{
-			"status":    status,
-			"updatedAt": time.Now(),
-		},
-	}
-
+func (h *DepositHandler) updateTransactionStatus(transactionID primitive.ObjectID, status, reason string, pspResponse interface{}) error {
+	set := bson.M{}
 	if pspResponse != nil {
-		update["$set"].(bson.M)["pspResponse"] = pspResponse
-		
+		set["pspResponse"] = pspResponse
+
 		if collResp, ok := pspResponse.(*services.CollectionResponse); ok {
-			update["$set"].(bson.M)["transactionId"] = collResp.TransactionID
-			update["$set"].(bson.M)["pspReference"] = collResp.TransactionID
+			set["pspName"] = collResp.PSPName
+			set["transactionId"] = collResp.TransactionID
+			set["pspReference"] = collResp.TransactionID
 		}
 	}
 
-	collection.UpdateOne(context.Background(), bson.M{"_id": transactionID}, update)
-}
-
-func (h *DepositHandler) processSuccessfulDeposit(transaction models.UnifiedTransaction) {
-	walletCollection := h.db.Collection("wallets")
-	
-	savingsAmount := transaction.Amount * (100 - transaction.InvestmentPercentage) / 100
-	investmentAmount := transaction.Amount * transaction.InvestmentPercentage / 100
-
-	walletCollection.UpdateOne(
-		context.Background(),
-		bson.M{"userId": transaction.UserID},
-		bson.M{
-			"$inc": bson.M{"balance": savingsAmount},
-			"$set": bson.M{"updatedAt": time.Now()},
-		},
-	)
-
-	if investmentAmount > 0 {
-		investmentCollection := h.db.Collection("investments")
-		investment := bson.M{
-			"userId":    transaction.UserID,
-			"amount":    investmentAmount,
-			"type":      "deposit_investment",
-			"status":    "active",
-			"createdAt": time.Now(),
-			"updatedAt": time.Now(),
-		}
-		investmentCollection.InsertOne(context.Background(), investment)
+	_, err := statemachine.Deposit.Transition(context.Background(), h.db.Collection("transactions"), transactionID, status, reason, set)
+	if err != nil {
+		log.Printf("Error updating deposit %s to %s: %v", transactionID.Hex(), status, err)
 	}
+	return err
 }
 
 func (h *DepositHandler) getPaymentMethodByID(userID primitive.ObjectID, paymentMethodID string) (*models.PaymentMethod, error) {
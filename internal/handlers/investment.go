@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/services"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -14,11 +17,15 @@ import (
 )
 
 type InvestmentHandler struct {
-	db *mongo.Database
+	db     *mongo.Database
+	ledger *services.LedgerService
 }
 
 func NewInvestmentHandler(db *mongo.Database) *InvestmentHandler {
-	return &InvestmentHandler{db: db}
+	return &InvestmentHandler{
+		db:     db,
+		ledger: services.NewLedgerService(db),
+	}
 }
 
 func (h *InvestmentHandler) CreateInvestment(c *gin.Context) {
@@ -30,8 +37,8 @@ func (h *InvestmentHandler) CreateInvestment(c *gin.Context) {
 	}
 
 	var req struct {
-		Amount float64 `json:"amount" binding:"required,gt=0"`
-		Type   string  `json:"type" binding:"required"`
+		Amount json.Number `json:"amount" binding:"required"`
+		Type   string      `json:"type" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -39,7 +46,7 @@ func (h *InvestmentHandler) CreateInvestment(c *gin.Context) {
 		return
 	}
 
-	// Check wallet balance
+	// The wallet sets the investment currency
 	walletCollection := h.db.Collection("wallets")
 	var wallet models.Wallet
 	err = walletCollection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&wallet)
@@ -48,37 +55,56 @@ func (h *InvestmentHandler) CreateInvestment(c *gin.Context) {
 		return
 	}
 
-	if wallet.Balance < req.Amount {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
+	amount, err := parseAmount(req.Amount, wallet.Balance.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Create investment
 	investment := models.Investment{
+		ID:        primitive.NewObjectID(),
 		UserID:    userID,
-		Amount:    req.Amount,
+		Amount:    amount,
 		Type:      req.Type,
 		Status:    "active",
-		Returns:   0.0,
+		Returns:   models.NewMoney(0, amount.Currency),
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	collection := h.db.Collection("investments")
-	_, err = collection.InsertOne(context.Background(), investment)
+	// Move funds from wallet to investments and store the investment together;
+	// the ledger checks the balance as it debits
+	session, err := h.db.Client().StartSession()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create investment"})
 		return
 	}
-
-	// Deduct from wallet
-	_, err = walletCollection.UpdateOne(
-		context.Background(),
-		bson.M{"user_id": userID},
-		bson.M{"$inc": bson.M{"balance": -req.Amount}},
-	)
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		err := h.ledger.PostInTransaction(sc, &models.JournalEntry{
+			Reference:   "investment:" + investment.ID.Hex(),
+			Type:        "investment",
+			Description: "Investment from wallet balance",
+			Currency:    amount.Currency,
+			Postings: []models.Posting{
+				{AccountCode: services.WalletAccountCode(userID), Direction: "debit", Amount: amount.Minor},
+				{AccountCode: services.InvestmentAccountCode(userID), Direction: "credit", Amount: amount.Minor},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = h.db.Collection("investments").InsertOne(sc, investment)
+		return nil, err
+	})
+	if errors.Is(err, services.ErrInsufficientFunds) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wallet"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create investment"})
 		return
 	}
 
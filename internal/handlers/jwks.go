@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"healthy_pay_backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys our tokens are signed with. Verifiers should
+// refetch it when they see an unknown kid.
+func JWKS(c *gin.Context) {
+	keys, err := utils.DefaultSigningKeys()
+	if err != nil {
+		log.Printf("Error loading JWT signing keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Signing keys unavailable"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys.JWKS())
+}
@@ -53,7 +53,7 @@ func (h *MobileMoneyHandler) AddMobileWallet(c *gin.Context) {
 		UserID:      userID,
 		Provider:    req.Provider,
 		PhoneNumber: req.PhoneNumber,
-		Balance:     850.00, // Default balance for demo
+		Balance:     models.NewMoney(85000, models.DefaultCurrency), // Default balance for demo
 		IsActive:    true,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
@@ -102,7 +102,7 @@ func (h *OTPHandler) VerifyOTP(c *gin.Context) {
 	_, err = userCollection.UpdateOne(
 		context.Background(),
 		bson.M{"phone_number": req.PhoneNumber},
-		bson.M{"$set": bson.M{"is_verified": true}},
+		bson.M{"$set": bson.M{"is_verified": true, "phone_verified": true}},
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update verification status"})
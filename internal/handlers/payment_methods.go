@@ -300,14 +300,14 @@ func (h *PaymentMethodsHandler) DeletePaymentMethod(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Payment method deleted successfully"})
 }
 
-func (h *PaymentMethodsHandler) getWalletBalance(userID primitive.ObjectID) (float64, error) {
+func (h *PaymentMethodsHandler) getWalletBalance(userID primitive.ObjectID) (models.Money, error) {
 	// Try blockchain wallet first (default: Stellar)
 	// Fallback to traditional wallet
 	collection := h.db.Collection("wallets")
 	var wallet models.Wallet
 	err := collection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&wallet)
 	if err != nil {
-		return 0, err
+		return models.Money{}, err
 	}
 	return wallet.Balance, nil
 }
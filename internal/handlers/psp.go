@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 
+	"healthy_pay_backend/internal/models"
 	"healthy_pay_backend/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -61,7 +62,7 @@ func (h *PSPHandler) TestPSPConnection(c *gin.Context) {
 
 	// Test with a small dummy collection request
 	testReq := services.CollectionRequest{
-		Amount:      1.0, // 1 GHS test
+		Amount:      models.NewMoney(100, "GHS"), // 1 GHS test
 		PhoneNumber: "0244000000", // Test number
 		Provider:    "MTN",
 		Reference:   "TEST_" + pspName,
@@ -2,9 +2,10 @@ package handlers
 
 import (
 	"net/http"
-	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"healthy_pay_backend/internal/models"
 	"healthy_pay_backend/internal/services"
 )
 
@@ -66,13 +67,13 @@ func (rh *RateHandler) ConvertAmount(c *gin.Context) {
 		return
 	}
 
-	amount, err := strconv.ParseFloat(amountStr, 64)
+	amount, err := models.ParseMoney(amountStr, strings.ToUpper(fromCurrency))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid amount format"})
 		return
 	}
 
-	convertedAmount, err := rh.rateService.ConvertAmount(amount, fromCurrency, toCurrency)
+	convertedAmount, err := rh.rateService.ConvertAmount(amount, strings.ToUpper(toCurrency), models.RoundHalfEven)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
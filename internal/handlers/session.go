@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"healthy_pay_backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type SessionHandler struct {
+	sessions *services.SessionService
+}
+
+func NewSessionHandler(db *mongo.Database) *SessionHandler {
+	return &SessionHandler{sessions: services.NewSessionService(db)}
+}
+
+// sessionClient describes the device making the request; apps name it with X-Device-Name
+func sessionClient(c *gin.Context) services.SessionClient {
+	return services.SessionClient{
+		DeviceName: c.GetHeader("X-Device-Name"),
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token. The
+// old refresh token stops working.
+func (h *SessionHandler) Refresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refreshToken is required"})
+		return
+	}
+
+	tokens, err := h.sessions.Refresh(c.Request.Context(), req.RefreshToken, sessionClient(c))
+	if errors.Is(err, services.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Refreshing session failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the session the request was made with
+func (h *SessionHandler) Logout(c *gin.Context) {
+	userID, sessionID, ok := currentSession(c)
+	if !ok {
+		return
+	}
+
+	if err := h.sessions.Revoke(c.Request.Context(), userID, sessionID, "logout"); err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	userID, sessionID, ok := currentSession(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.sessions.List(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions":         sessions,
+		"currentSessionId": sessionID,
+	})
+}
+
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	userID, _, ok := currentSession(c)
+	if !ok {
+		return
+	}
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	err = h.sessions.Revoke(c.Request.Context(), userID, sessionID, "revoked")
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions signs out every device except the one making the request
+func (h *SessionHandler) RevokeOtherSessions(c *gin.Context) {
+	userID, sessionID, ok := currentSession(c)
+	if !ok {
+		return
+	}
+
+	if err := h.sessions.RevokeAll(c.Request.Context(), userID, &sessionID, "revoked"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out of all other devices"})
+}
+
+func currentSession(c *gin.Context) (userID, sessionID primitive.ObjectID, ok bool) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return userID, sessionID, false
+	}
+	sessionID, err = primitive.ObjectIDFromHex(c.GetString("sessionID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+		return userID, sessionID, false
+	}
+	return userID, sessionID, true
+}
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/services"
 	"healthy_pay_backend/internal/utils"
 
 	"github.com/gin-gonic/gin"
@@ -15,20 +18,43 @@ import (
 )
 
 type SocialHandler struct {
-	db *mongo.Database
+	db         *mongo.Database
+	sessions   *services.SessionService
+	identities *services.SocialIdentityService
+	accounts   *services.SocialAccountService
 }
 
 func NewSocialHandler(db *mongo.Database) *SocialHandler {
-	return &SocialHandler{db: db}
+	return &SocialHandler{
+		db:         db,
+		sessions:   services.NewSessionService(db),
+		identities: services.NewSocialIdentityService(db),
+		accounts:   services.NewSocialAccountService(db),
+	}
+}
+
+var (
+	errSocialEmailRequired = errors.New("provider did not share an email address")
+	errSocialEmailTaken    = errors.New("email belongs to an existing account")
+)
+
+// IssueNonce returns the nonce to pass to Google or Apple sign-in; the ID
+// token they return must carry it
+func (h *SocialHandler) IssueNonce(c *gin.Context) {
+	nonce, expiresAt, err := h.identities.IssueNonce(c.Request.Context())
+	if err != nil {
+		log.Printf("Error issuing sign-in nonce: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue nonce"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"nonce": nonce, "expiresAt": expiresAt})
 }
 
 func (h *SocialHandler) GoogleLogin(c *gin.Context) {
 	var req struct {
-		IDToken   string `json:"idToken" binding:"required"`
-		Email     string `json:"email" binding:"required"`
-		Name      string `json:"name" binding:"required"`
-		GoogleID  string `json:"googleId" binding:"required"`
-		PhotoURL  string `json:"photoUrl"`
+		IDToken string `json:"idToken" binding:"required"`
+		Nonce   string `json:"nonce" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -36,22 +62,12 @@ func (h *SocialHandler) GoogleLogin(c *gin.Context) {
 		return
 	}
 
-	user, token, err := h.handleSocialLogin("google", req.GoogleID, req.Email, req.Name, "")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"user": user, "token": token})
+	h.socialLogin(c, "google", req.IDToken, req.Nonce, "")
 }
 
 func (h *SocialHandler) FacebookLogin(c *gin.Context) {
 	var req struct {
 		AccessToken string `json:"accessToken" binding:"required"`
-		Email       string `json:"email" binding:"required"`
-		Name        string `json:"name" binding:"required"`
-		FacebookID  string `json:"facebookId" binding:"required"`
-		PhotoURL    string `json:"photoUrl"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -59,21 +75,14 @@ func (h *SocialHandler) FacebookLogin(c *gin.Context) {
 		return
 	}
 
-	user, token, err := h.handleSocialLogin("facebook", req.FacebookID, req.Email, req.Name, "")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"user": user, "token": token})
+	h.socialLogin(c, "facebook", req.AccessToken, "", "")
 }
 
 func (h *SocialHandler) AppleLogin(c *gin.Context) {
 	var req struct {
 		IdentityToken string `json:"identityToken" binding:"required"`
-		Email         string `json:"email"`
-		Name          string `json:"name"`
-		AppleID       string `json:"appleId" binding:"required"`
+		Nonce         string `json:"nonce" binding:"required"`
+		Name          string `json:"name"` // Apple gives the name to the app on first sign-in only, never in the token
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -81,87 +90,264 @@ func (h *SocialHandler) AppleLogin(c *gin.Context) {
 		return
 	}
 
-	user, token, err := h.handleSocialLogin("apple", req.AppleID, req.Email, req.Name, "")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	h.socialLogin(c, "apple", req.IdentityToken, req.Nonce, req.Name)
+}
+
+// socialLogin verifies the provider token and signs in the user it identifies.
+// name is only used for a new account when the provider doesn't give one.
+func (h *SocialHandler) socialLogin(c *gin.Context, provider, token, nonce, name string) {
+	identity, err := h.identities.Verify(c.Request.Context(), provider, token, nonce)
+	if !respondSocialVerifyError(c, provider, err) {
+		return
+	}
+	if identity.Name == "" {
+		identity.Name = name
+	}
+
+	user, tokens, err := h.handleSocialLogin(c, identity)
+	switch {
+	case errors.Is(err, errSocialEmailRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Your " + provider + " account did not share an email address"})
+		return
+	case errors.Is(err, errSocialEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists. Log in with your password to continue"})
+		return
+	case err != nil:
+		log.Printf("Error signing in with %s: %v", provider, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"user": user, "token": token})
+	c.JSON(http.StatusOK, gin.H{
+		"user":         user,
+		"token":        tokens.AccessToken,
+		"expiresAt":    tokens.ExpiresAt,
+		"refreshToken": tokens.RefreshToken,
+		"sessionId":    tokens.SessionID,
+	})
+}
+
+// respondSocialVerifyError answers for a failed token verification, returning
+// false if it did
+func respondSocialVerifyError(c *gin.Context, provider string, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrInvalidSocialToken), errors.Is(err, services.ErrInvalidNonce):
+		log.Printf("⚠️ Rejected %s sign-in token: %v", provider, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid " + provider + " sign-in"})
+	case errors.Is(err, services.ErrSocialProviderNotConfigured):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": provider + " sign-in is not available"})
+	case errors.Is(err, services.ErrSocialProviderUnavailable):
+		log.Printf("Error verifying %s sign-in: %v", provider, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not reach " + provider + ", please try again"})
+	default:
+		log.Printf("Error verifying %s sign-in: %v", provider, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify sign-in"})
+	}
+	return false
 }
 
-func (h *SocialHandler) handleSocialLogin(provider, providerID, email, name, phone string) (*models.User, string, error) {
+// handleSocialLogin signs in the user linked to identity. An unlinked identity
+// is linked to the account with its email only if the provider verified the
+// email; otherwise a new account is created.
+func (h *SocialHandler) handleSocialLogin(c *gin.Context, identity *services.SocialIdentity) (*models.User, *services.SessionTokens, error) {
 	socialCollection := h.db.Collection("social_accounts")
 	userCollection := h.db.Collection("users")
 
 	// Check if social account exists
 	var socialAccount models.SocialAccount
 	err := socialCollection.FindOne(context.Background(), bson.M{
-		"provider":    provider,
-		"provider_id": providerID,
+		"provider":    identity.Provider,
+		"provider_id": identity.ProviderID,
 	}).Decode(&socialAccount)
 
 	var user models.User
 	if err == mongo.ErrNoDocuments {
+		if identity.Email == "" {
+			return nil, nil, errSocialEmailRequired
+		}
 		// Check if user exists by email
-		err = userCollection.FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+		err = userCollection.FindOne(context.Background(), bson.M{"email": identity.Email}).Decode(&user)
+		if err == nil && !identity.EmailVerified {
+			return nil, nil, errSocialEmailTaken
+		}
 		if err == mongo.ErrNoDocuments {
 			// Create new user
-			names := utils.SplitName(name)
+			names := utils.SplitName(identity.Name)
 			user = models.User{
-				Email:       email,
-				FirstName:   names[0],
-				LastName:    names[1],
-				PhoneNumber: phone,
-				IsVerified:  true,
-				KYCStatus:   "pending",
-				CreatedAt:   time.Now(),
-				UpdatedAt:   time.Now(),
+				Email:      identity.Email,
+				FirstName:  names[0],
+				LastName:   names[1],
+				IsVerified: identity.EmailVerified,
+				KYCStatus:  "pending",
+				CreatedAt:  time.Now(),
+				UpdatedAt:  time.Now(),
 			}
 
 			result, err := userCollection.InsertOne(context.Background(), user)
 			if err != nil {
-				return nil, "", err
+				return nil, nil, err
 			}
 			user.ID = result.InsertedID.(primitive.ObjectID)
 
+			handle, err := services.NewWalletHandleService(h.db).AssignHandle(context.Background(), user.ID, user.FirstName, user.LastName)
+			if err != nil {
+				log.Printf("Error assigning wallet handle to user %s: %v", user.ID.Hex(), err)
+			}
+			user.WalletHandle = handle
+
 			// Create wallet
 			wallet := models.Wallet{
 				UserID:    user.ID,
-				Balance:   0.0,
-				Currency:  "USD",
+				Balance:   models.NewMoney(0, models.DefaultCurrency),
+				Currency:  models.DefaultCurrency,
 				CreatedAt: time.Now(),
 				UpdatedAt: time.Now(),
 			}
 			h.db.Collection("wallets").InsertOne(context.Background(), wallet)
 		} else if err != nil {
-			return nil, "", err
+			return nil, nil, err
 		}
 
 		// Link social account
 		socialAccount = models.SocialAccount{
-			UserID:     user.ID,
-			Provider:   provider,
-			ProviderID: providerID,
-			Email:      email,
-			CreatedAt:  time.Now(),
+			UserID:         user.ID,
+			Provider:       identity.Provider,
+			ProviderID:     identity.ProviderID,
+			Email:          identity.Email,
+			IsPrivateEmail: identity.IsPrivateEmail,
+			CreatedAt:      time.Now(),
 		}
 		socialCollection.InsertOne(context.Background(), socialAccount)
 	} else if err != nil {
-		return nil, "", err
+		return nil, nil, err
 	} else {
 		// Get existing user
 		err = userCollection.FindOne(context.Background(), bson.M{"_id": socialAccount.UserID}).Decode(&user)
 		if err != nil {
-			return nil, "", err
+			return nil, nil, err
 		}
 	}
 
-	token, err := utils.GenerateJWT(user.ID)
+	tokens, err := h.sessions.Create(c.Request.Context(), user.ID, sessionClient(c))
 	if err != nil {
-		return nil, "", err
+		return nil, nil, err
 	}
 
 	user.Password = ""
-	return &user, token, nil
+	return &user, tokens, nil
+}
+
+// ListSocialAccounts returns the providers linked to the user and whether they
+// can also log in with a password
+func (h *SocialHandler) ListSocialAccounts(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	accounts, hasPassword, err := h.accounts.List(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Error listing social accounts of user %s: %v", userID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list linked accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": accounts, "hasPassword": hasPassword})
+}
+
+// RequestLinkCode emails the code that confirms a link for users without a password
+func (h *SocialHandler) RequestLinkCode(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.accounts.RequestLinkCode(c.Request.Context(), userID); err != nil {
+		if errors.Is(err, services.ErrTooManyAttempts) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "A code was just sent, try again in a minute"})
+			return
+		}
+		log.Printf("Error sending link code to user %s: %v", userID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Code sent to your email"})
+}
+
+// LinkSocialAccount attaches a Google, Apple or Facebook account to the user
+func (h *SocialHandler) LinkSocialAccount(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	provider := c.Param("provider")
+	if !isSocialProvider(provider) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown provider"})
+		return
+	}
+
+	var req services.SocialLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := h.accounts.Link(c.Request.Context(), userID, provider, req)
+	switch {
+	case errors.Is(err, services.ErrLinkConfirmationRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Confirm with your password or an emailed code"})
+		return
+	case errors.Is(err, services.ErrLinkConfirmationFailed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Wrong password or code"})
+		return
+	case errors.Is(err, services.ErrTooManyAttempts):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, try again later"})
+		return
+	case errors.Is(err, services.ErrSocialAccountTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "This " + provider + " account is linked to another user"})
+		return
+	case errors.Is(err, services.ErrProviderAlreadyLinked):
+		c.JSON(http.StatusConflict, gin.H{"error": "Another " + provider + " account is already linked; unlink it first"})
+		return
+	case !respondSocialVerifyError(c, provider, err):
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"account": account})
+}
+
+// UnlinkSocialAccount detaches a provider, unless it is the user's last way to log in
+func (h *SocialHandler) UnlinkSocialAccount(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	provider := c.Param("provider")
+
+	err = h.accounts.Unlink(c.Request.Context(), userID, provider)
+	switch {
+	case errors.Is(err, services.ErrSocialAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "No " + provider + " account is linked"})
+		return
+	case errors.Is(err, services.ErrLastLoginMethod):
+		c.JSON(http.StatusConflict, gin.H{"error": "This is your only way to log in; link another account first"})
+		return
+	case err != nil:
+		log.Printf("Error unlinking %s from user %s: %v", provider, userID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": provider + " account unlinked"})
+}
+
+func isSocialProvider(provider string) bool {
+	return provider == "google" || provider == "apple" || provider == "facebook"
 }
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 
+	"healthy_pay_backend/internal/models"
 	"healthy_pay_backend/internal/services"
+	"healthy_pay_backend/internal/utils"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -15,11 +19,17 @@ import (
 
 type StellarWalletHandler struct {
 	stellarService *services.StellarBlockchainService
+	deposits       *services.StellarDepositService
+	cursors        *services.StreamCursorService
+	custody        string
 }
 
 func NewStellarWalletHandler(db *mongo.Database) *StellarWalletHandler {
 	return &StellarWalletHandler{
 		stellarService: services.NewStellarBlockchainService(db),
+		deposits:       services.NewStellarDepositService(db),
+		cursors:        services.NewStreamCursorService(db),
+		custody:        services.NewStellarService().CustodyAddress(),
 	}
 }
 
@@ -56,6 +66,42 @@ func (h *StellarWalletHandler) CreateWallet(c *gin.Context) {
 }
 
 // GetWallet retrieves user's Stellar wallet
+// RestoreWallet rebuilds the user's Stellar wallet from its recovery phrase
+func (h *StellarWalletHandler) RestoreWallet(c *gin.Context) {
+	userIDStr := c.GetString("userID")
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.RestoreWalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mnemonic is required"})
+		return
+	}
+
+	wallet, err := h.stellarService.RestoreWallet(userID, req.Mnemonic)
+	switch {
+	case errors.Is(err, utils.ErrInvalidMnemonic):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recovery phrase"})
+		return
+	case errors.Is(err, services.ErrWalletConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		// The phrase is never logged
+		log.Printf("❌ Restoring Stellar wallet for user %s failed: %v", userIDStr, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore wallet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Stellar wallet restored successfully",
+		"wallet":  wallet,
+	})
+}
+
 func (h *StellarWalletHandler) GetWallet(c *gin.Context) {
 	userIDStr := c.GetString("userID")
 	userID, err := primitive.ObjectIDFromHex(userIDStr)
@@ -90,7 +136,7 @@ func (h *StellarWalletHandler) SendUSDC(c *gin.Context) {
 
 	transaction, err := h.stellarService.SendUSDC(userID, req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondStellarSendError(c, transaction, err)
 		return
 	}
 
@@ -100,6 +146,105 @@ func (h *StellarWalletHandler) SendUSDC(c *gin.Context) {
 	})
 }
 
+// SendAsset sends XLM or USDC from the user's Stellar wallet
+func (h *StellarWalletHandler) SendAsset(c *gin.Context) {
+	userIDStr := c.GetString("userID")
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.SendAssetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transaction, err := h.stellarService.SendFromUserWallet(userID, req)
+	if err != nil {
+		respondStellarSendError(c, transaction, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     req.AssetCode + " sent successfully",
+		"transaction": transaction,
+	})
+}
+
+// respondStellarSendError maps a failed send to a response. A send that was
+// submitted but not confirmed yet is returned with its pending record.
+func respondStellarSendError(c *gin.Context, transaction *models.BlockchainTransaction, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidSend), errors.Is(err, services.ErrInsufficientFunds):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "transaction": transaction})
+	case transaction != nil && transaction.Status == "pending":
+		c.JSON(http.StatusAccepted, gin.H{"message": "Payment submitted, awaiting confirmation", "transaction": transaction})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Stellar payment failed", "transaction": transaction})
+	}
+}
+
+// GetDepositAddress returns the user's custodial M-address for receiving USDC
+func (h *StellarWalletHandler) GetDepositAddress(c *gin.Context) {
+	userIDStr := c.GetString("userID")
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	address, err := h.deposits.DepositAddress(c.Request.Context(), userID)
+	if errors.Is(err, services.ErrPSPNotConfigured) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Stellar deposits are not available"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get deposit address"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"address":     address.MuxedAddress,
+		"baseAddress": address.BaseAddress,
+		"memo":        address.MuxedAccountID, // For exchanges that can't send to M-addresses
+		"asset":       "USDC",
+		"network":     "STELLAR",
+	})
+}
+
+// GetIngestionStatus reports how far behind Stellar deposit ingestion is,
+// answering 503 once it is further behind than services.MaxIngestionLag
+func (h *StellarWalletHandler) GetIngestionStatus(c *gin.Context) {
+	if h.custody == "" {
+		c.JSON(http.StatusOK, gin.H{"status": "disabled"})
+		return
+	}
+
+	lag, err := h.cursors.Lag(c.Request.Context(), services.StellarPaymentStream(h.custody))
+	if errors.Is(err, services.ErrNoStreamCursor) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not_started"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read ingestion status"})
+		return
+	}
+
+	code, status := http.StatusOK, "healthy"
+	if !lag.Healthy {
+		code, status = http.StatusServiceUnavailable, "lagging"
+	}
+	c.JSON(code, gin.H{
+		"status":     status,
+		"stream":     lag.Stream,
+		"cursor":     lag.Cursor,
+		"atHead":     lag.AtHead,
+		"lagSeconds": int64(lag.Lag.Seconds()),
+	})
+}
+
 // GetTransactions retrieves user's Stellar transactions
 func (h *StellarWalletHandler) GetTransactions(c *gin.Context) {
 	userIDStr := c.GetString("userID")
@@ -109,7 +254,13 @@ func (h *StellarWalletHandler) GetTransactions(c *gin.Context) {
 		return
 	}
 
-	transactions, err := h.stellarService.GetTransactions(userID)
+	wallet, err := h.stellarService.GetWallet(userID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"transactions": []interface{}{}})
+		return
+	}
+
+	transactions, err := h.stellarService.GetTransactions(wallet.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
 		return
@@ -2,12 +2,17 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"healthy_pay_backend/internal/models"
 	"healthy_pay_backend/internal/services"
+	"healthy_pay_backend/internal/statemachine"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -19,26 +24,36 @@ import (
 type TransactionHandler struct {
 	db         *mongo.Database
 	pspService *services.PSPService
+	settlement *services.SettlementService
+	ledger     *services.LedgerService
+	handles    *services.WalletHandleService
+	transfers  *services.SihaTransferService
+	limits     *services.RateLimitService
 }
 
 func NewTransactionHandler(db *mongo.Database) *TransactionHandler {
 	return &TransactionHandler{
 		db:         db,
 		pspService: services.NewPSPService(db),
+		settlement: services.NewSettlementService(db),
+		ledger:     services.NewLedgerService(db),
+		handles:    services.NewWalletHandleService(db),
+		transfers:  services.NewSihaTransferService(db),
+		limits:     services.NewRateLimitService(db),
 	}
 }
 
 type SendMoneyRequest struct {
-	PaymentMethodID      string  `json:"paymentMethodId" binding:"required"`
-	RecipientName        string  `json:"recipientName" binding:"required"`
-	RecipientAccount     string  `json:"recipientAccount" binding:"required"`
-	RecipientType        string  `json:"recipientType" binding:"required"`
-	RecipientNetwork     string  `json:"recipientNetwork,omitempty"`
-	RecipientCurrency    string  `json:"recipientCurrency"`
-	Amount               float64 `json:"amount" binding:"required,gt=0"`
-	InvestmentPercentage float64 `json:"investmentPercentage"`
-	DonationChoice       string  `json:"donationChoice"`
-	Description          string  `json:"description"`
+	PaymentMethodID      string      `json:"paymentMethodId" binding:"required"`
+	RecipientName        string      `json:"recipientName" binding:"required"`
+	RecipientAccount     string      `json:"recipientAccount" binding:"required"`
+	RecipientType        string      `json:"recipientType" binding:"required"`
+	RecipientNetwork     string      `json:"recipientNetwork,omitempty"`
+	RecipientCurrency    string      `json:"recipientCurrency"`
+	Amount               json.Number `json:"amount" binding:"required"`
+	InvestmentPercentage float64     `json:"investmentPercentage" binding:"min=0,max=100"`
+	DonationChoice       string      `json:"donationChoice"`
+	Description          string      `json:"description"`
 }
 
 func (h *TransactionHandler) getPaymentMethodByID(userID primitive.ObjectID, paymentMethodID string) (*models.UserPaymentMethod, error) {
@@ -147,59 +162,106 @@ func (h *TransactionHandler) SendMoney(c *gin.Context) {
 		return
 	}
 
-	investmentAmount := 0.0
-	totalAmount := req.Amount
-	
-	if req.InvestmentPercentage > 0 {
-		investmentAmount = req.Amount * (req.InvestmentPercentage / 100)
-		totalAmount = req.Amount + investmentAmount
+	// Siha recipients are given by wallet handle, phone or email
+	var sihaRecipient *models.User
+	if req.RecipientType == "siha_wallet" {
+		// Counted as a lookup, since the response tells whether the recipient exists
+		allowed, reset, err := h.limits.AllowAll(context.Background(), userIDStr, services.RecipientLookupLimits)
+		if err != nil {
+			log.Printf("Error checking recipient lookup limit: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up recipient"})
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many recipient lookups, try again later"})
+			return
+		}
+
+		sihaRecipient, err = h.handles.Resolve(context.Background(), req.RecipientAccount)
+		if errors.Is(err, services.ErrRecipientNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No Siha wallet found for recipient"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up recipient"})
+			return
+		}
+		if sihaRecipient.ID == fromUserID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrSelfTransfer.Error()})
+			return
+		}
+	}
+
+	amount, err := parseAmount(req.Amount, paymentMethod.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	if paymentMethod.Type == "wallet" {
-		h.processWalletPayment(c, fromUserID, req, totalAmount, investmentAmount)
+	// Investment is added on top of the amount the recipient gets
+	investmentAmount := amount.Percent(req.InvestmentPercentage, models.RoundHalfEven)
+	totalAmount, _ := amount.Add(investmentAmount)
+
+	if paymentMethod.Type == "wallet" && sihaRecipient != nil {
+		h.processSihaTransfer(c, fromUserID, sihaRecipient, req, amount, investmentAmount)
+	} else if paymentMethod.Type == "wallet" {
+		h.processWalletPayment(c, fromUserID, req, amount, totalAmount, investmentAmount)
 	} else if paymentMethod.Type == "mobile_money" {
-		h.processTwoStageMobileMoneyPayment(c, fromUserID, paymentMethod, req, totalAmount, investmentAmount)
+		h.processTwoStageMobileMoneyPayment(c, fromUserID, paymentMethod, req, amount, totalAmount, investmentAmount)
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported payment method"})
 	}
 }
 
-func (h *TransactionHandler) processWalletPayment(c *gin.Context, fromUserID primitive.ObjectID, req SendMoneyRequest, totalAmount, investmentAmount float64) {
-	balance, err := h.getWalletBalance(fromUserID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get wallet balance"})
-		return
-	}
-	if balance < totalAmount {
+func (h *TransactionHandler) processWalletPayment(c *gin.Context, fromUserID primitive.ObjectID, req SendMoneyRequest, amount, totalAmount, investmentAmount models.Money) {
+	transaction := h.createTransaction(fromUserID, req, amount, investmentAmount, statemachine.SendProcessingDistribution)
+	err := h.saveWalletSend(&transaction, totalAmount)
+	if errors.Is(err, services.ErrInsufficientFunds) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
 		return
 	}
-
-	transaction := h.createTransaction(fromUserID, req, totalAmount, investmentAmount, "completed")
-	result, err := h.saveTransaction(transaction)
 	if err != nil {
+		log.Printf("Error debiting wallet for send: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transaction"})
 		return
 	}
+	transactionID := transaction.ID
+
+	h.handlePostTransaction(transactionID, fromUserID, investmentAmount, req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Money sent successfully",
+		"transaction": transaction,
+	})
+}
 
-	if err := h.updateBalance(fromUserID, req.PaymentMethodID, -totalAmount); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balance"})
+// processSihaTransfer moves money from the sender's wallet to another Siha
+// user's wallet in one step
+func (h *TransactionHandler) processSihaTransfer(c *gin.Context, fromUserID primitive.ObjectID, recipient *models.User, req SendMoneyRequest, amount, investmentAmount models.Money) {
+	transaction := h.createTransaction(fromUserID, req, amount, investmentAmount, statemachine.SendCompleted)
+	err := h.transfers.Transfer(context.Background(), &transaction, recipient)
+	if errors.Is(err, services.ErrInsufficientFunds) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error transferring to Siha wallet: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send money"})
 		return
 	}
 
-	h.deliverToRecipient(req, req.Amount)
-	h.handlePostTransaction(fromUserID, investmentAmount, req)
+	h.saveRecipient(fromUserID, req.RecipientName, req.RecipientAccount, req.RecipientType)
 
-	transaction.ID = result.InsertedID.(primitive.ObjectID)
 	c.JSON(http.StatusOK, gin.H{
 		"message":     "Money sent successfully",
 		"transaction": transaction,
 	})
 }
 
-func (h *TransactionHandler) processTwoStageMobileMoneyPayment(c *gin.Context, fromUserID primitive.ObjectID, paymentMethod *models.UserPaymentMethod, req SendMoneyRequest, totalAmount, investmentAmount float64) {
+func (h *TransactionHandler) processTwoStageMobileMoneyPayment(c *gin.Context, fromUserID primitive.ObjectID, paymentMethod *models.UserPaymentMethod, req SendMoneyRequest, amount, totalAmount, investmentAmount models.Money) {
 	// Create transaction with two-stage status tracking
-	transaction := h.createTwoStageTransaction(fromUserID, req, totalAmount, investmentAmount, "collection_pending")
+	transaction := h.createTwoStageTransaction(fromUserID, req, amount, investmentAmount, statemachine.SendCollectionPending)
 	transaction.CollectionStatus = "pending"
 	transaction.InvestmentStatus = "pending"
 	transaction.DeliveryStatus = "pending"
@@ -220,6 +282,8 @@ func (h *TransactionHandler) processTwoStageMobileMoneyPayment(c *gin.Context, f
 
 	collectionResp, err := h.pspService.InitiateCollection(collectionReq)
 	if err != nil {
+		statemachine.Send.Transition(context.Background(), h.db.Collection("transactions"), result.InsertedID.(primitive.ObjectID),
+			statemachine.SendFailed, "Collection could not be initiated", bson.M{"collection_status": "failed"})
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initiate collection"})
 		return
 	}
@@ -227,128 +291,29 @@ func (h *TransactionHandler) processTwoStageMobileMoneyPayment(c *gin.Context, f
 	// Update transaction with PSP details
 	h.updateTransactionWithPSPData(result.InsertedID.(primitive.ObjectID), collectionReq, collectionResp)
 	
-	// Start async processing for collection monitoring and distribution
-	go h.processTwoStageCollectionAndDistribution(result.InsertedID.(primitive.ObjectID), req, investmentAmount, fromUserID)
+	// Monitor the collection in the job queue; allocation and delivery are queued once it settles
+	err = h.settlement.EnqueueSendJob(context.Background(), services.JobMonitorSendCollection,
+		result.InsertedID.(primitive.ObjectID), time.Now().Add(5*time.Second))
+	if err != nil {
+		// The transaction queue still polls pending sends, so this isn't fatal
+		log.Printf("Error scheduling collection monitoring for transaction %s: %v", result.InsertedID.(primitive.ObjectID).Hex(), err)
+	}
+
+	// Save recipient for future use
+	h.saveRecipient(fromUserID, req.RecipientName, req.RecipientAccount, req.RecipientType)
 
 	transaction.ID = result.InsertedID.(primitive.ObjectID)
+	transaction.PSPName = collectionResp.PSPName
 	transaction.PSPTransactionID = collectionResp.TransactionID
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":     "Collection initiated, processing payment",
 		"transaction": transaction,
-		"status":      "collection_pending",
+		"status":      statemachine.SendCollectionPending,
 	})
 }
 
-func (h *TransactionHandler) processTwoStageCollectionAndDistribution(transactionID primitive.ObjectID, req SendMoneyRequest, investmentAmount float64, fromUserID primitive.ObjectID) {
-	// Check status multiple times with exponential backoff
-	maxRetries := 10
-	baseDelay := 5 * time.Second
-	
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		// Wait before checking (exponential backoff)
-		delay := time.Duration(attempt+1) * baseDelay
-		time.Sleep(delay)
-
-		collection := h.db.Collection("transactions")
-		var transaction models.Transaction
-		err := collection.FindOne(context.Background(), bson.M{"_id": transactionID}).Decode(&transaction)
-		if err != nil {
-			continue
-		}
-
-		// Skip if already processed
-		if transaction.Status == "completed" || transaction.Status == "failed" {
-			return
-		}
-
-		// Check collection status
-		status, err := h.pspService.CheckCollectionStatus(transaction.PSPTransactionID)
-		if err != nil {
-			continue
-		}
-
-		if status == "collected" {
-			// Update collection status
-			collection.UpdateOne(
-				context.Background(),
-				bson.M{"_id": transactionID},
-				bson.M{"$set": bson.M{
-					"collection_status": "collected",
-					"status":           "processing_distribution",
-					"updated_at":       time.Now(),
-				}},
-			)
-
-			// Stage 2a: Process investment allocation
-			if investmentAmount > 0 {
-				err := h.processInvestmentAllocation(fromUserID, investmentAmount, req.DonationChoice, req.RecipientCurrency)
-				if err == nil {
-					collection.UpdateOne(
-						context.Background(),
-						bson.M{"_id": transactionID},
-						bson.M{"$set": bson.M{
-							"investment_status": "allocated",
-							"updated_at":       time.Now(),
-						}},
-					)
-				}
-			}
-
-			// Stage 2b: Deliver to recipient
-			deliveryReq := services.DeliveryRequest{
-				Amount:           req.Amount,
-				RecipientType:    req.RecipientType,
-				RecipientAccount: req.RecipientAccount,
-				RecipientNetwork: req.RecipientNetwork,
-				Reference:        transactionID.Hex(),
-			}
-
-			err = h.pspService.InitiateDelivery(deliveryReq)
-			if err == nil {
-				collection.UpdateOne(
-					context.Background(),
-					bson.M{"_id": transactionID},
-					bson.M{"$set": bson.M{
-						"delivery_status": "delivered",
-						"status":         "completed",
-						"updated_at":     time.Now(),
-					}},
-				)
-			}
-
-			// Save recipient for future use
-			h.saveRecipient(fromUserID, req.RecipientName, req.RecipientAccount, req.RecipientType)
-			return
-		} else if status == "failed" {
-			// Mark transaction as failed
-			collection.UpdateOne(
-				context.Background(),
-				bson.M{"_id": transactionID},
-				bson.M{"$set": bson.M{
-					"collection_status": "failed",
-					"status":           "failed",
-					"updated_at":       time.Now(),
-				}},
-			)
-			return
-		}
-		// If still pending, continue loop
-	}
-	
-	// If we've exhausted retries, mark as failed
-	collection := h.db.Collection("transactions")
-	collection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": transactionID},
-		bson.M{"$set": bson.M{
-			"status":     "failed",
-			"updated_at": time.Now(),
-		}},
-	)
-}
-
-func (h *TransactionHandler) createTwoStageTransaction(fromUserID primitive.ObjectID, req SendMoneyRequest, totalAmount, investmentAmount float64, status string) models.Transaction {
+func (h *TransactionHandler) createTwoStageTransaction(fromUserID primitive.ObjectID, req SendMoneyRequest, amount, investmentAmount models.Money, status string) models.Transaction {
 	return models.Transaction{
 		FromUserID:           fromUserID,
 		RecipientName:        req.RecipientName,
@@ -356,7 +321,7 @@ func (h *TransactionHandler) createTwoStageTransaction(fromUserID primitive.Obje
 		RecipientType:        req.RecipientType,
 		RecipientNetwork:     req.RecipientNetwork,
 		RecipientCurrency:    req.RecipientCurrency,
-		Amount:               req.Amount,
+		Amount:               amount,
 		InvestmentAmount:     investmentAmount,
 		InvestmentPercentage: req.InvestmentPercentage,
 		DonationChoice:       req.DonationChoice,
@@ -369,30 +334,6 @@ func (h *TransactionHandler) createTwoStageTransaction(fromUserID primitive.Obje
 	}
 }
 
-func (h *TransactionHandler) processInvestmentAllocation(userID primitive.ObjectID, amount float64, donationChoice, currency string) error {
-	if amount <= 0 {
-		return nil
-	}
-
-	// Get current USD exchange rate (rate is always against USD)
-	rate := h.getCurrentUSDRate(currency)
-
-	investment := models.Investment{
-		UserID:             userID,
-		Amount:             amount,
-		InvestmentCurrency: currency,
-		Type:               "send_flow_investment",
-		Status:             "pending",
-		Returns:            0.0,
-		Rate:               rate,
-		CreatedAt:          time.Now(),
-		UpdatedAt:          time.Now(),
-	}
-
-	_, err := h.db.Collection("investments").InsertOne(context.Background(), investment)
-	return err
-}
-
 func (h *TransactionHandler) saveRecipient(userID primitive.ObjectID, name, account, recipientType string) error {
 	// Check if recipient already exists
 	var existingRecipient models.Recipient
@@ -554,8 +495,8 @@ func (h *TransactionHandler) GetRecipientDeliveryOptions(c *gin.Context) {
 		{
 			"id":             "siha_wallet",
 			"title":          "💰 Siha Wallet",
-			"subtitle":       "Send to Siha wallet ID",
-			"placeholder":    "SW123456789",
+			"subtitle":       "Send to a Siha wallet handle, phone number or email",
+			"placeholder":    "@handle, 0244123456 or name@example.com",
 			"requiresNetwork": false,
 		},
 	}
@@ -563,6 +504,25 @@ func (h *TransactionHandler) GetRecipientDeliveryOptions(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"deliveryOptions": options})
 }
 
+// ResolveRecipient shows who a Siha wallet handle, phone number or email
+// belongs to, so the sender can confirm before sending
+func (h *TransactionHandler) ResolveRecipient(c *gin.Context) {
+	recipient, err := h.handles.Resolve(context.Background(), c.Query("identifier"))
+	if errors.Is(err, services.ErrRecipientNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No Siha wallet found for recipient"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up recipient"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipient": gin.H{
+		"name":         services.DisplayName(recipient),
+		"walletHandle": "@" + recipient.WalletHandle,
+	}})
+}
+
 func (h *TransactionHandler) GetRecipients(c *gin.Context) {
 	userIDStr := c.GetString("userID")
 	userID, err := primitive.ObjectIDFromHex(userIDStr)
@@ -618,7 +578,7 @@ func (h *TransactionHandler) GetTransactions(c *gin.Context) {
 }
 
 // Helper methods
-func (h *TransactionHandler) createTransaction(fromUserID primitive.ObjectID, req SendMoneyRequest, totalAmount, investmentAmount float64, status string) models.Transaction {
+func (h *TransactionHandler) createTransaction(fromUserID primitive.ObjectID, req SendMoneyRequest, amount, investmentAmount models.Money, status string) models.Transaction {
 	return models.Transaction{
 		FromUserID:           fromUserID,
 		RecipientName:        req.RecipientName,
@@ -626,7 +586,7 @@ func (h *TransactionHandler) createTransaction(fromUserID primitive.ObjectID, re
 		RecipientType:        req.RecipientType,
 		RecipientNetwork:     req.RecipientNetwork,
 		RecipientCurrency:    req.RecipientCurrency,
-		Amount:               req.Amount,
+		Amount:               amount,
 		InvestmentAmount:     investmentAmount,
 		InvestmentPercentage: req.InvestmentPercentage,
 		DonationChoice:       req.DonationChoice,
@@ -640,6 +600,12 @@ func (h *TransactionHandler) createTransaction(fromUserID primitive.ObjectID, re
 }
 
 func (h *TransactionHandler) saveTransaction(transaction models.Transaction) (*mongo.InsertOneResult, error) {
+	history, err := statemachine.Send.Start(transaction.Status, "Transaction created")
+	if err != nil {
+		return nil, err
+	}
+	transaction.StatusHistory = history
+
 	collection := h.db.Collection("transactions")
 	return collection.InsertOne(context.Background(), transaction)
 }
@@ -650,10 +616,13 @@ func (h *TransactionHandler) updateTransactionWithPSPData(transactionID primitiv
 		context.Background(),
 		bson.M{"_id": transactionID},
 		bson.M{"$set": bson.M{
+			"psp_name":           response.PSPName,
 			"psp_transaction_id": response.TransactionID,
-			"psp_request":       request,
-			"psp_response":      response.RawResponse, // Store raw API response
-			"updated_at":        time.Now(),
+			"sender_account":     request.PhoneNumber,
+			"sender_network":     request.Provider,
+			"psp_request":        request,
+			"psp_response":       response.RawResponse, // Store raw API response
+			"updated_at":         time.Now(),
 		}},
 	)
 }
@@ -680,6 +649,32 @@ func (h *TransactionHandler) CheckTransactionStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"transaction": transaction})
 }
 
+// GetTransactionRefund returns the refund of a send whose delivery failed
+func (h *TransactionHandler) GetTransactionRefund(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	transactionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	var refund models.Refund
+	err = h.db.Collection("refunds").FindOne(context.Background(), bson.M{
+		"transaction_id": transactionID,
+		"user_id":        userID,
+	}).Decode(&refund)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Refund not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"refund": refund})
+}
+
 func (h *TransactionHandler) getUserPaymentMethod(userID primitive.ObjectID, methodType string) (*models.UserPaymentMethod, error) {
 	collection := h.db.Collection("user_payment_methods")
 	var method models.UserPaymentMethod
@@ -691,99 +686,98 @@ func (h *TransactionHandler) getUserPaymentMethod(userID primitive.ObjectID, met
 	return &method, err
 }
 
-func (h *TransactionHandler) deliverToRecipient(req SendMoneyRequest, amount float64) {
-	deliveryReq := services.DeliveryRequest{
-		Amount:           amount,
-		RecipientType:    req.RecipientType,
-		RecipientAccount: req.RecipientAccount,
-		Reference:        fmt.Sprintf("wallet_%d", time.Now().Unix()),
-	}
-	go func() {
-		pspService := services.NewPSPService(h.db)
-		pspService.InitiateDelivery(deliveryReq)
-	}()
-}
-
-func (h *TransactionHandler) handlePostTransaction(fromUserID primitive.ObjectID, investmentAmount float64, req SendMoneyRequest) {
-	if investmentAmount > 0 {
-		h.createInvestment(fromUserID, investmentAmount, req.DonationChoice, req.RecipientCurrency)
+func (h *TransactionHandler) handlePostTransaction(transactionID, fromUserID primitive.ObjectID, investmentAmount models.Money, req SendMoneyRequest) {
+	if investmentAmount.IsPositive() {
+		h.createInvestment(transactionID, fromUserID, investmentAmount, req.DonationChoice, req.RecipientCurrency)
 	}
 	h.saveRecipient(fromUserID, req.RecipientName, req.RecipientAccount, req.RecipientType)
 }
 
-func (h *TransactionHandler) getWalletBalance(userID primitive.ObjectID) (float64, error) {
-	// Try blockchain wallet first (default: Stellar)
-	blockchainServiceFactory := services.NewBlockchainServiceFactory(h.db)
-	defaultService := blockchainServiceFactory.GetDefaultBlockchainService()
-	
-	if defaultService != nil {
-		wallet, err := defaultService.GetWallet(userID)
-		if err == nil {
-			// Return USDC balance as primary balance
-			for _, balance := range wallet.Balances {
-				if balance.AssetCode == "USDC" {
-					return balance.Balance, nil
-				}
-			}
-		}
-	}
-
-	// Fallback to traditional wallet
+// getWalletBalance returns the fiat wallet balance that wallet sends are debited from
+func (h *TransactionHandler) getWalletBalance(userID primitive.ObjectID) (models.Money, error) {
 	collection := h.db.Collection("wallets")
 	var wallet models.Wallet
 	err := collection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&wallet)
 	if err != nil {
-		return 0, err
+		return models.Money{}, err
 	}
 	return wallet.Balance, nil
 }
 
-func (h *TransactionHandler) updateBalance(userID primitive.ObjectID, paymentMethod string, amount float64) error {
-	if paymentMethod == "wallet_balance" {
-		collection := h.db.Collection("wallets")
-		_, err := collection.UpdateOne(
-			context.Background(),
-			bson.M{"user_id": userID},
-			bson.M{"$inc": bson.M{"balance": amount}},
-		)
+// saveWalletSend debits the sender's wallet into in-transit, stores the send
+// and queues its delivery in one Mongo transaction, so the send exists only if
+// the money moved and can't be left undelivered. The ledger checks the
+// balance as it debits.
+func (h *TransactionHandler) saveWalletSend(transaction *models.Transaction, total models.Money) error {
+	history, err := statemachine.Send.Start(transaction.Status, "Transaction created")
+	if err != nil {
 		return err
 	}
-	return nil
-}
+	transaction.ID = primitive.NewObjectID()
+	transaction.StatusHistory = history
 
-func (h *TransactionHandler) getCurrentUSDRate(currency string) *models.ExchangeRate {
-	// Mock exchange rates - in production, this would fetch from a real exchange rate API
-	rates := map[string]float64{
-		"GHS": 12.50, // 1 USD = 12.50 GHS
-		"KES": 150.0, // 1 USD = 150 KES
-		"ZMW": 25.0,  // 1 USD = 25 ZMW
-		"USD": 1.0,   // 1 USD = 1 USD
-	}
+	ctx := context.Background()
+	session, err := h.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start send session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		err := h.ledger.PostInTransaction(sc, &models.JournalEntry{
+			Reference:   "send:" + transaction.ID.Hex(),
+			Type:        "send",
+			Description: "Send from wallet balance",
+			Currency:    total.Currency,
+			Postings: []models.Posting{
+				{AccountCode: services.WalletAccountCode(transaction.FromUserID), Direction: "debit", Amount: total.Minor},
+				{AccountCode: services.LedgerAccountInTransit, Direction: "credit", Amount: total.Minor},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := h.db.Collection("transactions").InsertOne(sc, transaction); err != nil {
+			return nil, err
+		}
+		return nil, h.settlement.EnqueueSendJob(sc, services.JobDeliverSend, transaction.ID, time.Now())
+	})
+	return err
+}
 
-	rate, exists := rates[currency]
-	if !exists {
-		rate = 1.0 // Default to 1:1 if currency not found
+// postInvestmentAllocation moves the investment share of a send out of in-transit
+func (h *TransactionHandler) postInvestmentAllocation(transactionID, userID primitive.ObjectID, amount models.Money) error {
+	err := h.ledger.Transfer(
+		context.Background(),
+		"investment:"+transactionID.Hex(),
+		"investment",
+		"Investment allocation from send",
+		services.LedgerAccountInTransit,
+		services.InvestmentAccountCode(userID),
+		amount,
+	)
+	if errors.Is(err, services.ErrDuplicateEntry) {
+		return nil
 	}
+	return err
+}
 
-	return &models.ExchangeRate{
-		FromCurrency: currency,
-		ToCurrency:   "USD",
-		Rate:         rate,
-		Timestamp:    time.Now(),
+func (h *TransactionHandler) createInvestment(transactionID, userID primitive.ObjectID, amount models.Money, donationChoice, currency string) error {
+	if err := h.postInvestmentAllocation(transactionID, userID, amount); err != nil {
+		return err
 	}
-}
 
-func (h *TransactionHandler) createInvestment(userID primitive.ObjectID, amount float64, donationChoice, currency string) error {
 	// Get current USD exchange rate (rate is always against USD)
-	rate := h.getCurrentUSDRate(currency)
+	rate := services.StaticUSDRate(currency)
 
 	investment := models.Investment{
 		UserID:             userID,
+		TransactionID:      transactionID,
 		Amount:             amount,
 		InvestmentCurrency: currency,
 		Type:               "send_investment",
 		Status:             "active",
-		Returns:            0,
+		Returns:            models.NewMoney(0, amount.Currency),
 		Rate:               rate,
 		CreatedAt:          time.Now(),
 		UpdatedAt:          time.Now(),
@@ -799,7 +793,7 @@ func (h *TransactionHandler) ProcessPendingTransactions(c *gin.Context) {
 	
 	// Find all pending transactions
 	cursor, err := collection.Find(context.Background(), bson.M{
-		"status": bson.M{"$in": []string{"collection_pending", "pending"}},
+		"status": bson.M{"$in": []string{statemachine.SendCollectionPending, statemachine.SendPending}},
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pending transactions"})
@@ -815,34 +809,26 @@ func (h *TransactionHandler) ProcessPendingTransactions(c *gin.Context) {
 
 	processed := 0
 	for _, transaction := range pendingTransactions {
-		if transaction.PSPTransactionID != "" {
-			// Check PSP status
-			status, err := h.pspService.CheckCollectionStatus(transaction.PSPTransactionID)
-			if err == nil {
-				if status == "collected" {
-					// Update to completed
-					collection.UpdateOne(
-						context.Background(),
-						bson.M{"_id": transaction.ID},
-						bson.M{"$set": bson.M{
-							"status":     "completed",
-							"updated_at": time.Now(),
-						}},
-					)
-					processed++
-				} else if status == "failed" {
-					// Update to failed
-					collection.UpdateOne(
-						context.Background(),
-						bson.M{"_id": transaction.ID},
-						bson.M{"$set": bson.M{
-							"status":     "failed",
-							"updated_at": time.Now(),
-						}},
-					)
-					processed++
-				}
-			}
+		if transaction.PSPTransactionID == "" || transaction.ManualReview {
+			continue
+		}
+
+		// Check PSP status with the PSP that handled the collection
+		status, err := h.pspService.CheckCollectionStatus(transaction.PSPName, transaction.PSPTransactionID)
+		if errors.Is(err, services.ErrPSPNotConfigured) {
+			h.settlement.FlagSendForReview(context.Background(), transaction, err.Error())
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		applied, err := h.settlement.ApplySendCollectionStatus(context.Background(), transaction, status)
+		if err != nil {
+			log.Printf("Error settling transaction %s: %v", transaction.ID.Hex(), err)
+		}
+		if applied {
+			processed++
 		}
 	}
 
@@ -853,4 +839,18 @@ func (h *TransactionHandler) ProcessPendingTransactions(c *gin.Context) {
 	})
 }
 
+// parseAmount turns a request amount into Money, rejecting zero and negative values
+func parseAmount(amount json.Number, currency string) (models.Money, error) {
+	if currency == "" {
+		currency = models.DefaultCurrency
+	}
 
+	money, err := models.ParseMoney(amount.String(), currency)
+	if err != nil {
+		return models.Money{}, err
+	}
+	if !money.IsPositive() {
+		return models.Money{}, fmt.Errorf("amount must be greater than zero")
+	}
+	return money, nil
+}
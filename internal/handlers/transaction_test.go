@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSendMoneyRejectsInvestmentOutOfRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/send/money", func(c *gin.Context) {
+		c.Set("userID", primitive.NewObjectID().Hex())
+		// Binding fails before the handler touches the database
+		(&TransactionHandler{}).SendMoney(c)
+	})
+
+	for _, percentage := range []float64{-99, -0.5, 100.5} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"paymentMethodId":      "wallet_balance",
+			"recipientName":        "Ama",
+			"recipientAccount":     "0241234567",
+			"recipientType":        "mobile_money",
+			"recipientNetwork":     "MTN",
+			"amount":               100,
+			"investmentPercentage": percentage,
+		})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/send/money", bytes.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("investmentPercentage %v: status = %d, want %d", percentage, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -61,6 +61,15 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 	}
 
 	collection := h.db.Collection("users")
+	// A new number has to be verified again before payments can find it
+	_, err = collection.UpdateOne(context.Background(),
+		bson.M{"_id": userID, "phone_number": bson.M{"$ne": req.PhoneNumber}},
+		bson.M{"$unset": bson.M{"phone_verified": ""}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
 	update := bson.M{
 		"$set": bson.M{
 			"first_name":   req.FirstName,
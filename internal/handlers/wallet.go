@@ -2,10 +2,16 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"healthy_pay_backend/internal/models"
 	"healthy_pay_backend/internal/services"
+	"healthy_pay_backend/internal/utils"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -16,12 +22,16 @@ import (
 type WalletHandler struct {
 	db                       *mongo.Database
 	blockchainServiceFactory *services.BlockchainServiceFactory
+	ledger                   *services.LedgerService
+	recoveryPhrases          *services.RecoveryPhraseService
 }
 
 func NewWalletHandler(db *mongo.Database) *WalletHandler {
 	return &WalletHandler{
 		db:                       db,
 		blockchainServiceFactory: services.NewBlockchainServiceFactory(db),
+		ledger:                   services.NewLedgerService(db),
+		recoveryPhrases:          services.NewRecoveryPhraseService(db),
 	}
 }
 
@@ -124,6 +134,51 @@ func (h *WalletHandler) CreateBlockchainWallet(c *gin.Context) {
 	})
 }
 
+// ExportRecoveryPhrase returns the wallet's recovery phrase sealed to a key the
+// client generated for this request, after re-verifying the user's PIN
+func (h *WalletHandler) ExportRecoveryPhrase(c *gin.Context) {
+	userIDStr := c.GetString("userID")
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.RecoveryPhraseExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pin and public_key are required"})
+		return
+	}
+
+	authTime, _ := c.Get("authTime")
+	loggedInAt, _ := authTime.(time.Time)
+	export, err := h.recoveryPhrases.Export(c.Request.Context(), userID, loggedInAt, req, c.ClientIP(), c.Request.UserAgent())
+	switch {
+	case errors.Is(err, utils.ErrInvalidPublicKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "public_key must be a base64 X25519 public key"})
+		return
+	case errors.Is(err, services.ErrRecentLoginRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in again to export your recovery phrase"})
+		return
+	case errors.Is(err, services.ErrPINNotSet), errors.Is(err, services.ErrInvalidPIN), errors.Is(err, services.ErrPINLocked):
+		respondPINError(c, err)
+		return
+	case errors.Is(err, services.ErrNoRecoveryPhrase):
+		c.JSON(http.StatusNotFound, gin.H{"error": "No wallet with a recovery phrase found"})
+		return
+	case errors.Is(err, services.ErrTooManyAttempts):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, try again later"})
+		return
+	case err != nil:
+		log.Printf("❌ Recovery phrase export for user %s failed: %v", userIDStr, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export recovery phrase"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, export)
+}
+
 func (h *WalletHandler) GetSupportedBlockchains(c *gin.Context) {
 	blockchains := models.GetSupportedBlockchains()
 	c.JSON(http.StatusOK, gin.H{
@@ -140,9 +195,9 @@ func (h *WalletHandler) AddFunds(c *gin.Context) {
 	}
 
 	var req struct {
-		Amount     float64 `json:"amount" binding:"required,gt=0"`
-		Blockchain string  `json:"blockchain"`
-		AssetCode  string  `json:"assetCode"`
+		Amount     json.Number `json:"amount" binding:"required"`
+		Blockchain string      `json:"blockchain"`
+		AssetCode  string      `json:"assetCode"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -152,12 +207,29 @@ func (h *WalletHandler) AddFunds(c *gin.Context) {
 
 	// Default to traditional wallet if no blockchain specified
 	if req.Blockchain == "" {
-		collection := h.db.Collection("wallets")
-		update := bson.M{
-			"$inc": bson.M{"balance": req.Amount},
+		var wallet models.Wallet
+		err = h.db.Collection("wallets").FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&wallet)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Wallet not found"})
+			return
 		}
 
-		_, err = collection.UpdateOne(context.Background(), bson.M{"user_id": userID}, update)
+		amount, err := parseAmount(req.Amount, wallet.Balance.Currency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		reference := fmt.Sprintf("funding:%s", primitive.NewObjectID().Hex())
+		err = h.ledger.Transfer(
+			context.Background(),
+			reference,
+			"wallet_funding",
+			"Manual wallet top-up",
+			services.LedgerAccountFunding,
+			services.WalletAccountCode(userID),
+			amount,
+		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add funds"})
 			return
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"healthy_pay_backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PSP callbacks are small; anything bigger is refused before it is read
+const maxWebhookBodyBytes = 1 << 20
+
+type WebhookHandler struct {
+	db             *mongo.Database
+	webhookService *services.PSPWebhookService
+}
+
+func NewWebhookHandler(db *mongo.Database) *WebhookHandler {
+	return &WebhookHandler{
+		db:             db,
+		webhookService: services.NewPSPWebhookService(db),
+	}
+}
+
+// HandlePSPWebhook receives collection and payout callbacks from a PSP.
+// Anything other than a 2xx tells the PSP to retry the delivery.
+func (h *WebhookHandler) HandlePSPWebhook(c *gin.Context) {
+	pspName := c.Param("psp")
+
+	// The signature covers the raw body, so it must be read before any binding
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBodyBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	event, duplicate, err := h.webhookService.Process(c.Request.Context(), pspName, c.Request.Header, body)
+	switch {
+	case errors.Is(err, services.ErrUnknownWebhookProvider):
+		c.JSON(http.StatusNotFound, gin.H{"error": "PSP not found"})
+		return
+	case errors.Is(err, services.ErrInvalidWebhookSignature):
+		log.Printf("⚠️ Rejected %s webhook: %v", pspName, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	case errors.Is(err, services.ErrInvalidWebhookPayload):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
+		return
+	}
+
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+		return
+	}
+
+	log.Printf("📨 %s webhook %s: %s", pspName, event.EventID, event.ProcessingStatus)
+	c.JSON(http.StatusOK, gin.H{"status": event.ProcessingStatus})
+}
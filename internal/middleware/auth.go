@@ -1,15 +1,23 @@
 package middleware
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 
+	"healthy_pay_backend/internal/services"
 	"healthy_pay_backend/internal/utils"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware accepts access tokens of live sessions; logging out or
+// revoking a session ends its tokens straight away
+func AuthMiddleware(db *mongo.Database) gin.HandlerFunc {
+	sessions := services.NewSessionService(db)
+
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -19,14 +27,35 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		userID, err := utils.ValidateJWT(tokenString)
+		claims, err := utils.ParseJWT(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		c.Set("userID", userID)
+		// Tokens from before sessions can't be revoked, so they are no longer accepted
+		if claims.SessionID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+			c.Abort()
+			return
+		}
+		err = sessions.Check(c.Request.Context(), claims.UserID, claims.SessionID)
+		if errors.Is(err, services.ErrSessionNotFound) || errors.Is(err, services.ErrSessionRevoked) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking session %s: %v", claims.SessionID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", claims.UserID)
+		c.Set("sessionID", claims.SessionID)
+		c.Set("authTime", claims.AuthTime)
 		c.Next()
 	}
 }
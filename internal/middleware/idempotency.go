@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"healthy_pay_backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	// Request bodies are held in memory to fingerprint them
+	maxIdempotentBodyBytes = 1 << 20
+)
+
+// responseRecorder keeps a copy of the response body so it can be replayed
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware requires an Idempotency-Key header on money-moving
+// routes. The first response for a user's key is stored and replayed for
+// retries with the same body; reusing the key for a different body is rejected.
+// A key whose request died can be retried once its lease runs out.
+// Must run after AuthMiddleware.
+func IdempotencyMiddleware(db *mongo.Database) gin.HandlerFunc {
+	idempotency := services.NewIdempotencyService(db)
+
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key header required"})
+			c.Abort()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := sha256.Sum256([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n" + string(body)))
+		record, err := idempotency.Begin(c.Request.Context(), c.GetString("userID"), key,
+			hex.EncodeToString(fingerprint[:]), c.Request.Method, c.Request.URL.Path)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyKeyReused):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			c.Abort()
+			return
+		case errors.Is(err, services.ErrIdempotencyKeyInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+			c.Abort()
+			return
+		case err != nil:
+			log.Printf("Error claiming idempotency key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+			c.Abort()
+			return
+		}
+
+		if record.Status == "completed" {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.ResponseStatus, record.ResponseContentType, record.ResponseBody)
+			c.Abort()
+			return
+		}
+
+		// Renewed while the handler runs, so only a dead request loses the key
+		stopLease := idempotency.KeepLease(record)
+
+		// A panicking handler left nothing to replay; free the key for a retry
+		defer func() {
+			if r := recover(); r != nil {
+				stopLease()
+				idempotency.Release(context.Background(), record)
+				panic(r)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		stopLease()
+
+		// Every outcome is stored, errors included: a 500 after money moved must not be re-run
+		err = idempotency.Complete(context.Background(), record, recorder.Status(),
+			recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+		if err != nil {
+			log.Printf("Error storing response for idempotency key %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"healthy_pay_backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RateLimitMiddleware holds each user to every one of limits on the routes
+// it guards. Must run after AuthMiddleware.
+func RateLimitMiddleware(db *mongo.Database, limits ...services.RateLimit) gin.HandlerFunc {
+	rateLimits := services.NewRateLimitService(db)
+
+	return func(c *gin.Context) {
+		allowed, reset, err := rateLimits.AllowAll(c.Request.Context(), c.GetString("userID"), limits)
+		if err != nil {
+			log.Printf("Error checking rate limit: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+			c.Abort()
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
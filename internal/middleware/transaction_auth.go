@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"healthy_pay_backend/internal/services"
+	"healthy_pay_backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TransactionAuthMiddleware requires an X-Transaction-Token from
+// POST /auth/pin/verify on money-moving routes. A token authorizes one
+// request, identified by its Idempotency-Key, from the session it was issued
+// to. Must run after AuthMiddleware and before IdempotencyMiddleware, so a
+// refused request isn't stored for replay.
+func TransactionAuthMiddleware(db *mongo.Database) gin.HandlerFunc {
+	tokens := services.NewTransactionTokenService(db)
+
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Transaction-Token")
+		if token == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Transaction authorization required, verify your PIN"})
+			c.Abort()
+			return
+		}
+
+		claims, err := utils.ValidateTransactionToken(token)
+		if err != nil || claims.UserID != c.GetString("userID") || claims.SessionID != c.GetString("sessionID") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired transaction authorization, verify your PIN again"})
+			c.Abort()
+			return
+		}
+
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key header required"})
+			c.Abort()
+			return
+		}
+		err = tokens.Use(c.Request.Context(), claims.ID, claims.UserID, key)
+		if errors.Is(err, services.ErrTransactionTokenUsed) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Transaction authorization already used, verify your PIN again"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Printf("Error recording transaction token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
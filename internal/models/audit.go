@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEvent - Security-relevant action taken on a user's account. Never holds secrets.
+type AuditEvent struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID  `bson:"user_id" json:"userId"`
+	Action    string              `bson:"action" json:"action"`   // e.g. "recovery_phrase_export"
+	Outcome   string              `bson:"outcome" json:"outcome"` // "pending" while in progress, then "success" or why it was refused, e.g. "invalid_pin"
+	WalletID  *primitive.ObjectID `bson:"wallet_id,omitempty" json:"walletId,omitempty"`
+	IPAddress string              `bson:"ip_address,omitempty" json:"ipAddress,omitempty"`
+	UserAgent string              `bson:"user_agent,omitempty" json:"userAgent,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"createdAt"`
+}
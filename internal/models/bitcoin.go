@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BitcoinAddress - Address derived from a Bitcoin wallet's account key at
+// m/84'/coin'/0'/change/index
+type BitcoinAddress struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WalletID  primitive.ObjectID `bson:"wallet_id" json:"walletId"`
+	Address   string             `bson:"address" json:"address"` // Unique
+	Change    bool               `bson:"change" json:"change"`
+	Index     uint32             `bson:"index" json:"index"`
+	CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+// BitcoinUTXO - Unspent output paying one of a wallet's addresses, mirrored
+// from the node. SpentBy holds the txid of the send that reserved it.
+type BitcoinUTXO struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WalletID      primitive.ObjectID `bson:"wallet_id" json:"walletId"`
+	Outpoint      string             `bson:"outpoint" json:"outpoint"` // "txid:vout", unique
+	TxID          string             `bson:"txid" json:"txid"`
+	Vout          uint32             `bson:"vout" json:"vout"`
+	Address       string             `bson:"address" json:"address"`
+	Change        bool               `bson:"change" json:"change"`
+	Index         uint32             `bson:"index" json:"index"`
+	ScriptPubKey  string             `bson:"script_pub_key" json:"scriptPubKey"`
+	Value         int64              `bson:"value" json:"value"` // Satoshis
+	Confirmations int64              `bson:"confirmations" json:"confirmations"`
+	SpentBy       string             `bson:"spent_by,omitempty" json:"spentBy,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"createdAt"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updatedAt"`
+}
@@ -14,9 +14,12 @@ type BlockchainWallet struct {
 	Network         string             `bson:"network" json:"network"`       // "mainnet", "testnet"
 	PublicKey       string             `bson:"public_key" json:"publicKey"`
 	PrivateKey      string             `bson:"private_key" json:"-"`         // Encrypted, never returned
-	MnemonicPhrase  string             `bson:"mnemonic_phrase" json:"mnemonicPhrase"`
+	MnemonicPhrase  string             `bson:"mnemonic_phrase" json:"-"`     // Encrypted, released only by the PIN-gated export
 	IsDefault       bool               `bson:"is_default" json:"isDefault"`  // Default blockchain wallet
 	IsActive        bool               `bson:"is_active" json:"isActive"`
+	ExtendedPublicKey string           `bson:"extended_public_key,omitempty" json:"-"` // Account xpub of HD wallets (Bitcoin)
+	NextAddressIndex  uint32           `bson:"next_address_index,omitempty" json:"-"`
+	NextChangeIndex   uint32           `bson:"next_change_index,omitempty" json:"-"`
 	Balances        []AssetBalance     `bson:"balances" json:"balances"`
 	CreatedAt       time.Time          `bson:"created_at" json:"createdAt"`
 	UpdatedAt       time.Time          `bson:"updated_at" json:"updatedAt"`
@@ -47,7 +50,12 @@ type BlockchainTransaction struct {
 	Status          string             `bson:"status" json:"status"` // "pending", "confirmed", "failed"
 	Type            string             `bson:"type" json:"type"`     // "send", "receive", "deposit", "withdraw"
 	Memo            string             `bson:"memo,omitempty" json:"memo,omitempty"`
+	Reference       string             `bson:"reference,omitempty" json:"reference,omitempty"` // Send transaction the payment delivered
 	Fee             float64            `bson:"fee" json:"fee"`
+	FailureReason   string             `bson:"failure_reason,omitempty" json:"failureReason,omitempty"`
+	Confirmations   int64              `bson:"confirmations,omitempty" json:"confirmations,omitempty"`
+	Envelope        string             `bson:"envelope,omitempty" json:"-"`    // Signed Stellar envelope or raw EVM transaction, resent while the outcome is unknown
+	ValidUntil      time.Time          `bson:"valid_until,omitempty" json:"-"` // After it the envelope can't reach a ledger
 	CreatedAt       time.Time          `bson:"created_at" json:"createdAt"`
 	UpdatedAt       time.Time          `bson:"updated_at" json:"updatedAt"`
 }
@@ -1,13 +1,15 @@
 package models
 
+import "encoding/json"
+
 // Deprecated: Use UnifiedTransaction instead
 // Keeping only request/response types for backward compatibility
 
 type DepositRequest struct {
-	Amount               float64 `json:"amount" binding:"required,gt=0"`
-	PaymentMethodID      string  `json:"paymentMethodId" binding:"required"`
-	InvestmentPercentage float64 `json:"investmentPercentage" binding:"min=0,max=100"`
-	DonationChoice       string  `json:"donationChoice"`
+	Amount               json.Number `json:"amount" binding:"required"`
+	PaymentMethodID      string      `json:"paymentMethodId" binding:"required"`
+	InvestmentPercentage float64     `json:"investmentPercentage" binding:"min=0,max=100"`
+	DonationChoice       string      `json:"donationChoice"`
 }
 
 type DepositResponse struct {
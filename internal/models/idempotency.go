@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IdempotencyRecord - First request and response for an Idempotency-Key, unique per user and key
+type IdempotencyRecord struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID              string             `bson:"user_id" json:"userId"`
+	Key                 string             `bson:"key" json:"key"`
+	Fingerprint         string             `bson:"fingerprint" json:"fingerprint"` // SHA-256 of method, path and body
+	Method              string             `bson:"method" json:"method"`
+	Path                string             `bson:"path" json:"path"`
+	Status              string             `bson:"status" json:"status"` // "processing" or "completed"
+	ResponseStatus      int                `bson:"response_status,omitempty" json:"responseStatus,omitempty"`
+	ResponseContentType string             `bson:"response_content_type,omitempty" json:"responseContentType,omitempty"`
+	ResponseBody        []byte             `bson:"response_body,omitempty" json:"-"`
+	LeaseID             primitive.ObjectID `bson:"lease_id,omitempty" json:"-"`    // New for every claim; writes are fenced on it
+	LeaseUntil          time.Time          `bson:"lease_until,omitempty" json:"-"` // A "processing" claim older than this can be taken over
+	CreatedAt           time.Time          `bson:"created_at" json:"createdAt"`
+	CompletedAt         *time.Time         `bson:"completed_at,omitempty" json:"completedAt,omitempty"`
+	ExpiresAt           time.Time          `bson:"expires_at" json:"expiresAt"`
+}
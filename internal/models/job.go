@@ -0,0 +1,25 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Job - Durable background work, claimed by one worker at a time under a lease
+type Job struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type           string             `bson:"type" json:"type"`
+	Key            string             `bson:"key" json:"key"` // Unique, so the same work is only enqueued once
+	Payload        bson.M             `bson:"payload,omitempty" json:"payload,omitempty"`
+	Status         string             `bson:"status" json:"status"` // "pending", "running", "completed", "dead"
+	Attempts       int                `bson:"attempts" json:"attempts"`
+	MaxAttempts    int                `bson:"max_attempts" json:"maxAttempts"`
+	RunAt          time.Time          `bson:"run_at" json:"runAt"`
+	LeaseOwner     string             `bson:"lease_owner,omitempty" json:"leaseOwner,omitempty"`
+	LeaseExpiresAt *time.Time         `bson:"lease_expires_at,omitempty" json:"leaseExpiresAt,omitempty"`
+	LastError      string             `bson:"last_error,omitempty" json:"lastError,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updatedAt"`
+	CompletedAt    *time.Time         `bson:"completed_at,omitempty" json:"completedAt,omitempty"`
+}
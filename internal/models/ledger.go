@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LedgerAccount - An account in the double-entry ledger
+type LedgerAccount struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Code      string              `bson:"code" json:"code"` // e.g. "wallet:<userId>", "psp_clearing"
+	Type      string              `bson:"type" json:"type"` // "asset", "liability", "equity"
+	UserID    *primitive.ObjectID `bson:"user_id,omitempty" json:"userId,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"createdAt"`
+}
+
+// JournalEntry - Immutable record of a balance change; its postings must balance
+type JournalEntry struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Reference   string             `bson:"reference" json:"reference"` // Unique, makes posting idempotent
+	Type        string             `bson:"type" json:"type"`           // "deposit", "wallet_funding", "send", "delivery", "investment"
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Currency    string             `bson:"currency" json:"currency"`
+	Postings    []Posting          `bson:"postings" json:"postings"`
+	CreatedAt   time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+// Posting - A single debit or credit line of a journal entry
+type Posting struct {
+	AccountCode string `bson:"account_code" json:"accountCode"`
+	Direction   string `bson:"direction" json:"direction"` // "debit" or "credit"
+	Amount      int64  `bson:"amount" json:"amount"`       // Minor units (pesewas/cents), always positive
+}
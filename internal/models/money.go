@@ -0,0 +1,281 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used when a wallet or request does not specify one. New
+// wallets use it because every PSP collection and payout settles in GHS.
+const DefaultCurrency = "GHS"
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrInvalidAmount    = errors.New("invalid amount")
+)
+
+// RoundingMode - How a value between two minor units is settled
+type RoundingMode int
+
+const (
+	RoundHalfEven RoundingMode = iota // Ties go to the even minor unit (banker's rounding)
+	RoundHalfUp                       // Ties go away from zero
+	RoundDown                         // Truncate towards zero
+	RoundUp                           // Always away from zero
+)
+
+// Minor unit exponents for currencies that don't use two decimal places
+var currencyExponents = map[string]int{
+	"UGX": 0,
+	"RWF": 0,
+	"XOF": 0,
+	"XAF": 0,
+}
+
+// CurrencyExponent returns the number of decimal places of a currency's minor unit
+func CurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+// Money - An amount in integer minor units (pesewas, cents) of an ISO 4217 currency
+type Money struct {
+	Minor    int64  `bson:"minor"`
+	Currency string `bson:"currency"`
+}
+
+func NewMoney(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney parses a decimal string such as "12.50" exactly. More decimal
+// places than the currency allows is an error rather than a silent rounding.
+func ParseMoney(amount, currency string) (Money, error) {
+	s := strings.TrimSpace(amount)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+	}
+
+	exp := CurrencyExponent(currency)
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("%w: %q has more than %d decimal places for %s", ErrInvalidAmount, amount, exp, currency)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, amount)
+	}
+	if negative {
+		minor = -minor
+	}
+	return NewMoney(minor, currency), nil
+}
+
+// MoneyFromMajor converts a float in major units, e.g. from a rate feed or
+// provider API, using the shortest decimal that represents it.
+func MoneyFromMajor(amount float64, currency string, mode RoundingMode) Money {
+	r := decimalRat(amount)
+	r.Mul(r, pow10(CurrencyExponent(currency)))
+	return NewMoney(roundRat(r, mode), currency)
+}
+
+// Major returns the amount in major units. Only use it for display or for
+// APIs that insist on floats; never do arithmetic on the result.
+func (m Money) Major() float64 {
+	f, _ := strconv.ParseFloat(m.Decimal(), 64)
+	return f
+}
+
+// Decimal formats the amount in major units with the currency's exact scale, e.g. "12.50"
+func (m Money) Decimal() string {
+	exp := CurrencyExponent(m.Currency)
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+
+	digits := strconv.FormatInt(minor, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func (m Money) String() string {
+	return m.Currency + " " + m.Decimal()
+}
+
+// IsZero also lets BSON omitempty skip zero amounts
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Minor > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Minor < 0
+}
+
+func (m Money) Neg() Money {
+	return Money{Minor: -m.Minor, Currency: m.Currency}
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: m.Minor + other.Minor, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: m.Minor - other.Minor, Currency: m.Currency}, nil
+}
+
+// Cmp returns -1, 0 or 1 like big.Int.Cmp
+func (m Money) Cmp(other Money) (int, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Minor < other.Minor:
+		return -1, nil
+	case m.Minor > other.Minor:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Percent returns pct percent of m, e.g. the investment share of a deposit
+func (m Money) Percent(pct float64, mode RoundingMode) Money {
+	r := new(big.Rat).SetInt64(m.Minor)
+	r.Mul(r, decimalRat(pct))
+	r.Quo(r, big.NewRat(100, 1))
+	return Money{Minor: roundRat(r, mode), Currency: m.Currency}
+}
+
+// Split divides m into a pct share, rounded down, and the remainder so the
+// two parts always add back up to m exactly
+func (m Money) Split(pct float64) (share, rest Money) {
+	share = m.Percent(pct, RoundDown)
+	rest = Money{Minor: m.Minor - share.Minor, Currency: m.Currency}
+	return share, rest
+}
+
+// Convert applies an exchange rate (units of currency per unit of m.Currency)
+func (m Money) Convert(rate float64, currency string, mode RoundingMode) Money {
+	r := new(big.Rat).SetInt64(m.Minor)
+	r.Mul(r, decimalRat(rate))
+	r.Mul(r, pow10(CurrencyExponent(currency)))
+	r.Quo(r, pow10(CurrencyExponent(m.Currency)))
+	return NewMoney(roundRat(r, mode), currency)
+}
+
+// MarshalJSON encodes as {"amount":"12.50","currency":"GHS"}; the amount is a
+// string so clients never parse it into a float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.Decimal(), m.Currency})
+}
+
+// UnmarshalJSON accepts the amount as a decimal string or a JSON number
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Amount   json.RawMessage `json:"amount"`
+		Currency string          `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Currency == "" {
+		return fmt.Errorf("%w: currency is required", ErrInvalidAmount)
+	}
+
+	amount := strings.Trim(string(raw.Amount), `"`)
+	parsed, err := ParseMoney(amount, raw.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Helper functions
+func (m Money) sameCurrency(other Money) error {
+	if m.Currency != other.Currency {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func pow10(exp int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil))
+}
+
+// decimalRat converts a float through its shortest decimal form so 0.1 stays 1/10.
+// NaN and infinities become zero.
+func decimalRat(f float64) *big.Rat {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	if !ok {
+		return new(big.Rat)
+	}
+	return r
+}
+
+func roundRat(r *big.Rat, mode RoundingMode) int64 {
+	quo, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if rem.Sign() == 0 {
+		return quo.Int64()
+	}
+
+	step := big.NewInt(int64(r.Sign()))
+	twiceRem := new(big.Int).Abs(rem)
+	twiceRem.Lsh(twiceRem, 1)
+	half := twiceRem.Cmp(r.Denom())
+
+	roundAway := false
+	switch mode {
+	case RoundUp:
+		roundAway = true
+	case RoundHalfUp:
+		roundAway = half >= 0
+	case RoundHalfEven:
+		roundAway = half > 0 || half == 0 && quo.Bit(0) == 1
+	}
+	if roundAway {
+		quo.Add(quo, step)
+	}
+	return quo.Int64()
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	m, err := ParseMoney("12.5", "GHS")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if m.Minor != 1250 || m.Currency != "GHS" {
+		t.Errorf("Expected 1250 GHS, got %d %s", m.Minor, m.Currency)
+	}
+
+	if _, err := ParseMoney("12.345", "GHS"); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Expected ErrInvalidAmount for three decimals, got %v", err)
+	}
+	if _, err := ParseMoney("1e3", "GHS"); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Expected ErrInvalidAmount for exponent notation, got %v", err)
+	}
+
+	ugx, err := ParseMoney("5000", "UGX")
+	if err != nil || ugx.Minor != 5000 {
+		t.Errorf("Expected 5000 UGX minor units, got %d (%v)", ugx.Minor, err)
+	}
+}
+
+func TestMoneyFromMajorAvoidsFloatDrift(t *testing.T) {
+	if m := MoneyFromMajor(0.1+0.2, "GHS", RoundHalfEven); m.Minor != 30 {
+		t.Errorf("Expected 30, got %d", m.Minor)
+	}
+	if m := MoneyFromMajor(1.005, "GHS", RoundHalfUp); m.Minor != 101 {
+		t.Errorf("Expected 101, got %d", m.Minor)
+	}
+}
+
+func TestPercentRoundingModes(t *testing.T) {
+	amount := NewMoney(125, "GHS") // 1.25 GHS, 10% is 12.5 pesewas
+
+	cases := map[RoundingMode]int64{
+		RoundHalfEven: 12,
+		RoundHalfUp:   13,
+		RoundDown:     12,
+		RoundUp:       13,
+	}
+	for mode, want := range cases {
+		if got := amount.Percent(10, mode).Minor; got != want {
+			t.Errorf("Mode %d: expected %d, got %d", mode, want, got)
+		}
+	}
+
+	if got := NewMoney(-125, "GHS").Percent(10, RoundHalfUp).Minor; got != -13 {
+		t.Errorf("Expected ties away from zero for negatives, got %d", got)
+	}
+}
+
+func TestConvertBetweenExponents(t *testing.T) {
+	// 10.00 USD at 3700 UGX per USD
+	converted := NewMoney(1000, "USD").Convert(3700, "UGX", RoundHalfEven)
+	if converted.Minor != 37000 || converted.Currency != "UGX" {
+		t.Errorf("Expected 37000 UGX, got %s", converted)
+	}
+}
+
+func TestAddRejectsCurrencyMismatch(t *testing.T) {
+	_, err := NewMoney(100, "GHS").Add(NewMoney(100, "USD"))
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Expected ErrCurrencyMismatch, got %v", err)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	data, err := json.Marshal(NewMoney(-5, "GHS"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(data) != `{"amount":"-0.05","currency":"GHS"}` {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte(`{"amount":19.99,"currency":"usd"}`), &m); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if m.Minor != 1999 || m.Currency != "USD" {
+		t.Errorf("Expected 1999 USD, got %s", m)
+	}
+}
@@ -26,3 +26,19 @@ type TransactionPSPLog struct {
 	ResponsePayload   interface{}        `bson:"response_payload" json:"responsePayload"`
 	CreatedAt         time.Time          `bson:"created_at" json:"createdAt"`
 }
+
+// PSPWebhookEvent - Raw PSP callback, unique per PSP and event ID
+type PSPWebhookEvent struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PSPName          string             `bson:"psp_name" json:"pspName"`
+	EventID          string             `bson:"event_id" json:"eventId"`
+	Kind             string             `bson:"kind" json:"kind"` // "collection" or "payout"
+	PSPTransactionID string             `bson:"psp_transaction_id,omitempty" json:"pspTransactionId,omitempty"`
+	Reference        string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	Status           string             `bson:"status" json:"status"`                       // Normalised PSP status
+	ProcessingStatus string             `bson:"processing_status" json:"processingStatus"` // "received", "processed", "unmatched", "failed"
+	Error            string             `bson:"error,omitempty" json:"error,omitempty"`
+	RawPayload       string             `bson:"raw_payload" json:"rawPayload"`
+	ReceivedAt       time.Time          `bson:"received_at" json:"receivedAt"`
+	ProcessedAt      *time.Time         `bson:"processed_at,omitempty" json:"processedAt,omitempty"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Refund - Return of a send's collected or debited funds to the sender after delivery failed, one per send
+type Refund struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TransactionID      primitive.ObjectID `bson:"transaction_id" json:"transactionId"`
+	UserID             primitive.ObjectID `bson:"user_id" json:"userId"`
+	Amount             Money              `bson:"amount" json:"amount"`                                              // Send amount plus any investment share
+	InvestmentReversed Money              `bson:"investment_reversed,omitempty" json:"investmentReversed,omitempty"` // Investment allocation unwound for the refund
+	Destination        string             `bson:"destination" json:"destination"`                                    // "mobile_money" or "siha_wallet"
+	Account            string             `bson:"account" json:"account"`                                            // Phone number or user ID
+	Network            string             `bson:"network,omitempty" json:"network,omitempty"`
+	PSPName            string             `bson:"psp_name,omitempty" json:"pspName,omitempty"`
+	Status             string             `bson:"status" json:"status"` // "pending", "processing", "completed", "failed"
+	StatusHistory      []StatusChange     `bson:"status_history,omitempty" json:"statusHistory,omitempty"`
+	Reason             string             `bson:"reason" json:"reason"`
+	LastError          string             `bson:"last_error,omitempty" json:"lastError,omitempty"`
+	ManualReview       bool               `bson:"manual_review,omitempty" json:"manualReview,omitempty"` // Payout outcome unknown; settle by hand
+	ReviewReason       string             `bson:"review_reason,omitempty" json:"reviewReason,omitempty"`
+	CreatedAt          time.Time          `bson:"created_at" json:"createdAt"`
+	UpdatedAt          time.Time          `bson:"updated_at" json:"updatedAt"`
+	CompletedAt        *time.Time         `bson:"completed_at,omitempty" json:"completedAt,omitempty"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session - A signed-in device. Access tokens name the session they were issued
+// for, so revoking it ends them all; the refresh token changes on every use.
+type Session struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID           primitive.ObjectID `bson:"user_id" json:"userId"`
+	RefreshTokenHash string             `bson:"refresh_token_hash" json:"-"` // SHA-256 of the current refresh token
+	DeviceName       string             `bson:"device_name,omitempty" json:"deviceName,omitempty"`
+	IPAddress        string             `bson:"ip_address,omitempty" json:"ipAddress,omitempty"`
+	UserAgent        string             `bson:"user_agent,omitempty" json:"userAgent,omitempty"`
+	AuthTime         time.Time          `bson:"auth_time" json:"authTime"` // When the user signed in; refreshes keep it
+	CreatedAt        time.Time          `bson:"created_at" json:"createdAt"`
+	LastSeenAt       time.Time          `bson:"last_seen_at" json:"lastSeenAt"`
+	ExpiresAt        time.Time          `bson:"expires_at" json:"expiresAt"`
+	RevokedAt        *time.Time         `bson:"revoked_at,omitempty" json:"revokedAt,omitempty"`
+	RevokedReason    string             `bson:"revoked_reason,omitempty" json:"revokedReason,omitempty"` // "logout", "revoked", "refresh_token_reuse"
+}
@@ -7,10 +7,11 @@ import (
 )
 
 type SocialAccount struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID     primitive.ObjectID `bson:"user_id" json:"userId"`
-	Provider   string             `bson:"provider" json:"provider"`
-	ProviderID string             `bson:"provider_id" json:"providerId"`
-	Email      string             `bson:"email" json:"email"`
-	CreatedAt  time.Time          `bson:"created_at" json:"createdAt"`
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"userId"`
+	Provider       string             `bson:"provider" json:"provider"`
+	ProviderID     string             `bson:"provider_id" json:"providerId"`
+	Email          string             `bson:"email" json:"email"`
+	IsPrivateEmail bool               `bson:"is_private_email,omitempty" json:"isPrivateEmail,omitempty"` // Apple private relay; mail reaches the user only through Apple
+	CreatedAt      time.Time          `bson:"created_at" json:"createdAt"`
 }
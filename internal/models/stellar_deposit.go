@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StellarDeposit - Incoming payment to the Stellar custody account, one per operation.
+// Payments that can't be matched to a user wait in suspense until ops resolve them.
+type StellarDeposit struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OperationID      string             `bson:"operation_id" json:"operationId"` // Horizon operation ID, unique
+	TxHash           string             `bson:"tx_hash" json:"txHash"`
+	FromAddress      string             `bson:"from_address" json:"fromAddress"`
+	ToAddress        string             `bson:"to_address" json:"toAddress"` // M-address when the payment was sent to one
+	MuxedID          uint64             `bson:"muxed_id,omitempty" json:"muxedId,omitempty"`
+	Memo             string             `bson:"memo,omitempty" json:"memo,omitempty"`
+	MemoType         string             `bson:"memo_type,omitempty" json:"memoType,omitempty"`
+	AssetCode        string             `bson:"asset_code" json:"assetCode"`
+	AssetIssuer      string             `bson:"asset_issuer,omitempty" json:"assetIssuer,omitempty"`
+	Amount           string             `bson:"amount" json:"amount"` // Exact on-chain amount
+	UserID           primitive.ObjectID `bson:"user_id,omitempty" json:"userId,omitempty"`
+	Credited         Money              `bson:"credited,omitempty" json:"credited,omitempty"` // Amount credited in the wallet's currency
+	Status           string             `bson:"status" json:"status"`                         // "credited", "suspense", "returned"
+	SuspenseReason   string             `bson:"suspense_reason,omitempty" json:"suspenseReason,omitempty"`
+	ResolvedBy       string             `bson:"resolved_by,omitempty" json:"resolvedBy,omitempty"`
+	ReturnTxHash     string             `bson:"return_tx_hash,omitempty" json:"returnTxHash,omitempty"`
+	ReturnStatus     string             `bson:"return_status,omitempty" json:"returnStatus,omitempty"` // "pending" until the return is seen on Horizon, then "confirmed"
+	ReturnEnvelope   string             `bson:"return_envelope,omitempty" json:"-"`                    // Signed return, resubmitted while its outcome is unknown
+	ReturnValidUntil time.Time          `bson:"return_valid_until,omitempty" json:"-"`
+	CreatedAt        time.Time          `bson:"created_at" json:"createdAt"`
+	UpdatedAt        time.Time          `bson:"updated_at" json:"updatedAt"`
+	ResolvedAt       *time.Time         `bson:"resolved_at,omitempty" json:"resolvedAt,omitempty"`
+}
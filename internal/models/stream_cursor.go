@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// StreamCursor - How far ingestion has got through an external event stream, one per stream
+type StreamCursor struct {
+	ID            string    `bson:"_id" json:"stream"` // e.g. "stellar_payments:<account>"
+	Cursor        string    `bson:"cursor" json:"cursor"`
+	Sequence      int64     `bson:"sequence" json:"-"`                    // Numeric cursor, so a slower replica can't move it back
+	LastEventAt   time.Time `bson:"last_event_at" json:"lastEventAt"`     // When the last ingested event happened upstream
+	HeadCheckedAt time.Time `bson:"head_checked_at" json:"headCheckedAt"` // Last poll that found nothing newer
+	AtHead        bool      `bson:"at_head" json:"atHead"`
+	UpdatedAt     time.Time `bson:"updated_at" json:"updatedAt"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -9,10 +10,12 @@ type UnifiedTransaction struct {
 	ID                   primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	UserID               primitive.ObjectID `bson:"userId" json:"userId"`
 	Type                 string             `bson:"type" json:"type"` // "deposit", "send", "receive"
-	Amount               float64            `bson:"amount" json:"amount"`
+	Amount               Money              `bson:"amount" json:"amount"`
 	Status               string             `bson:"status" json:"status"`
+	StatusHistory        []StatusChange     `bson:"statusHistory,omitempty" json:"statusHistory,omitempty"`
 	
 	// Common fields
+	PSPName              string             `bson:"pspName,omitempty" json:"pspName,omitempty"` // PSP that handled the collection
 	TransactionID        string             `bson:"transactionId" json:"transactionId"`
 	PSPReference         string             `bson:"pspReference" json:"pspReference"`
 	PSPResponse          interface{}        `bson:"pspResponse" json:"pspResponse"`
@@ -31,6 +34,8 @@ type UnifiedTransaction struct {
 	
 	// Status tracking
 	QueueStatus          string             `bson:"queueStatus" json:"queueStatus"`
+	ManualReview         bool               `bson:"manualReview,omitempty" json:"manualReview,omitempty"`
+	ReviewReason         string             `bson:"reviewReason,omitempty" json:"reviewReason,omitempty"`
 	ProcessedAt          *time.Time         `bson:"processedAt,omitempty" json:"processedAt,omitempty"`
 	CreatedAt            time.Time          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt            time.Time          `bson:"updatedAt" json:"updatedAt"`
@@ -38,7 +43,7 @@ type UnifiedTransaction struct {
 
 type TransactionRequest struct {
 	Type                 string  `json:"type" binding:"required"`
-	Amount               float64 `json:"amount" binding:"required,gt=0"`
+	Amount               json.Number `json:"amount" binding:"required"`
 	
 	// Deposit fields
 	PaymentMethodID      string  `json:"paymentMethodId,omitempty"`
@@ -60,3 +65,11 @@ type TransactionResponse struct {
 	TransactionID string      `json:"transactionId"`
 	PSPResponse   interface{} `json:"pspResponse,omitempty"`
 }
+
+// StatusChange is one entry in a transaction's status history
+type StatusChange struct {
+	From   string    `bson:"from" json:"from"`
+	To     string    `bson:"to" json:"to"`
+	Reason string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	At     time.Time `bson:"at" json:"at"`
+}
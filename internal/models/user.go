@@ -7,24 +7,33 @@ import (
 )
 
 type User struct {
-	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Email            string             `bson:"email" json:"email"`
-	Password         string             `bson:"password" json:"-"`
-	FirstName        string             `bson:"first_name" json:"firstName"`
-	LastName         string             `bson:"last_name" json:"lastName"`
-	PhoneNumber      string             `bson:"phone_number,omitempty" json:"phoneNumber,omitempty"`
-	PIN              string             `bson:"pin" json:"-"`
-	IsVerified       bool               `bson:"is_verified" json:"isVerified"`
-	VerificationCode string             `bson:"verification_code,omitempty" json:"-"`
-	KYCStatus        string             `bson:"kyc_status" json:"kycStatus"`
-	CreatedAt        time.Time          `bson:"created_at" json:"createdAt"`
-	UpdatedAt        time.Time          `bson:"updated_at" json:"updatedAt"`
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Email             string             `bson:"email" json:"email"`
+	Password          string             `bson:"password" json:"-"`
+	FirstName         string             `bson:"first_name" json:"firstName"`
+	LastName          string             `bson:"last_name" json:"lastName"`
+	PhoneNumber       string             `bson:"phone_number,omitempty" json:"phoneNumber,omitempty"`
+	PhoneVerified     bool               `bson:"phone_verified,omitempty" json:"phoneVerified"`         // Confirmed by OTP, cleared when the number changes
+	WalletHandle      string             `bson:"wallet_handle,omitempty" json:"walletHandle,omitempty"` // Unique, without the leading "@"
+	StellarDepositID  uint64             `bson:"stellar_deposit_id,omitempty" json:"-"`                 // Muxed ID of the user's custodial Stellar address
+	PIN               string             `bson:"pin" json:"-"`
+	PINFailedAttempts int                `bson:"pin_failed_attempts,omitempty" json:"-"` // Since the last correct PIN or lockout
+	PINLockouts       int                `bson:"pin_lockouts,omitempty" json:"-"`        // Consecutive lockouts, each longer than the last
+	PINLockedUntil    *time.Time         `bson:"pin_locked_until,omitempty" json:"-"`
+	PINResetCode      string             `bson:"pin_reset_code,omitempty" json:"-"` // bcrypt hash of the emailed reset code
+	PINResetExpiresAt *time.Time         `bson:"pin_reset_expires_at,omitempty" json:"-"`
+	PINResetAttempts  int                `bson:"pin_reset_attempts,omitempty" json:"-"`
+	IsVerified        bool               `bson:"is_verified" json:"isVerified"`
+	VerificationCode  string             `bson:"verification_code,omitempty" json:"-"`
+	KYCStatus         string             `bson:"kyc_status" json:"kycStatus"`
+	CreatedAt         time.Time          `bson:"created_at" json:"createdAt"`
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updatedAt"`
 }
 
 type Wallet struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	UserID    primitive.ObjectID `bson:"user_id" json:"userId"`
-	Balance   float64            `bson:"balance" json:"balance"`
+	Balance   Money              `bson:"balance" json:"balance"` // Projection of the ledger
 	Currency  string             `bson:"currency" json:"currency"`
 	CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
 	UpdatedAt time.Time          `bson:"updated_at" json:"updatedAt"`
@@ -39,19 +48,26 @@ type Transaction struct {
 	RecipientType        string             `bson:"recipient_type,omitempty" json:"recipientType,omitempty"` // 'mobile_money', 'crypto_wallet', 'siha_wallet'
 	RecipientNetwork     string             `bson:"recipient_network,omitempty" json:"recipientNetwork,omitempty"` // 'MTN', 'TELECEL', 'AIRTELTIGO', 'MPESA', etc.
 	RecipientCurrency    string             `bson:"recipient_currency,omitempty" json:"recipientCurrency,omitempty"` // 'GHS', 'USD', 'KES', 'ZMW', etc.
-	Amount               float64            `bson:"amount" json:"amount"`
-	InvestmentAmount     float64            `bson:"investment_amount,omitempty" json:"investmentAmount,omitempty"`
+	Amount               Money              `bson:"amount" json:"amount"`
+	InvestmentAmount     Money              `bson:"investment_amount,omitempty" json:"investmentAmount"`
 	InvestmentPercentage float64            `bson:"investment_percentage,omitempty" json:"investmentPercentage,omitempty"`
 	DonationChoice       string             `bson:"donation_choice,omitempty" json:"donationChoice,omitempty"`
 	PaymentMethod        string             `bson:"payment_method" json:"paymentMethod"`
+	PSPName              string             `bson:"psp_name,omitempty" json:"pspName,omitempty"` // PSP that handled the collection
 	PSPTransactionID     string             `bson:"psp_transaction_id,omitempty" json:"pspTransactionId,omitempty"`
+	SenderAccount        string             `bson:"sender_account,omitempty" json:"senderAccount,omitempty"` // Mobile money number collected from, refunds go back here
+	SenderNetwork        string             `bson:"sender_network,omitempty" json:"senderNetwork,omitempty"`
+	DeliveryPSPName      string             `bson:"delivery_psp_name,omitempty" json:"deliveryPspName,omitempty"` // PSP that took the payout
 	PSPRequest           interface{}        `bson:"psp_request,omitempty" json:"pspRequest,omitempty"`
 	PSPResponse          interface{}        `bson:"psp_response,omitempty" json:"pspResponse,omitempty"`
 	CollectionStatus     string             `bson:"collection_status,omitempty" json:"collectionStatus,omitempty"` // 'pending', 'collected', 'failed'
 	InvestmentStatus     string             `bson:"investment_status,omitempty" json:"investmentStatus,omitempty"` // 'pending', 'allocated', 'failed'
 	DeliveryStatus       string             `bson:"delivery_status,omitempty" json:"deliveryStatus,omitempty"`     // 'pending', 'delivered', 'failed'
+	ManualReview         bool               `bson:"manual_review,omitempty" json:"manualReview,omitempty"`
+	ReviewReason         string             `bson:"review_reason,omitempty" json:"reviewReason,omitempty"`
 	Type                 string             `bson:"type" json:"type"`
 	Status               string             `bson:"status" json:"status"`
+	StatusHistory        []StatusChange     `bson:"status_history,omitempty" json:"statusHistory,omitempty"`
 	Description          string             `bson:"description,omitempty" json:"description,omitempty"`
 	CreatedAt            time.Time          `bson:"created_at" json:"createdAt"`
 	UpdatedAt            time.Time          `bson:"updated_at" json:"updatedAt"`
@@ -75,11 +91,12 @@ type UserPaymentMethod struct {
 type Investment struct {
 	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	UserID             primitive.ObjectID `bson:"user_id" json:"userId"`
-	Amount             float64            `bson:"amount" json:"amount"`
+	TransactionID      primitive.ObjectID `bson:"transaction_id,omitempty" json:"transactionId,omitempty"` // Send the investment was allocated from
+	Amount             Money              `bson:"amount" json:"amount"`
 	InvestmentCurrency string             `bson:"investment_currency" json:"investmentCurrency"`
 	Type               string             `bson:"type" json:"type"`
 	Status             string             `bson:"status" json:"status"`
-	Returns            float64            `bson:"returns" json:"returns"`
+	Returns            Money              `bson:"returns" json:"returns"`
 	Rate               *ExchangeRate      `bson:"rate,omitempty" json:"rate,omitempty"`
 	CreatedAt          time.Time          `bson:"created_at" json:"createdAt"`
 	UpdatedAt          time.Time          `bson:"updated_at" json:"updatedAt"`
@@ -107,7 +124,7 @@ type MobileMoneyWallet struct {
 	UserID      primitive.ObjectID `bson:"user_id" json:"userId"`
 	Provider    string             `bson:"provider" json:"provider"` // MTN, Vodafone, AirtelTigo
 	PhoneNumber string             `bson:"phone_number" json:"phoneNumber"`
-	Balance     float64            `bson:"balance" json:"balance"`
+	Balance     Money              `bson:"balance" json:"balance"`
 	IsActive    bool               `bson:"is_active" json:"isActive"`
 	CreatedAt   time.Time          `bson:"created_at" json:"createdAt"`
 	UpdatedAt   time.Time          `bson:"updated_at" json:"updatedAt"`
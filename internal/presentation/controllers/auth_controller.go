@@ -5,6 +5,7 @@ import (
 
 	"healthy_pay_backend/internal/application/dtos"
 	"healthy_pay_backend/internal/application/services"
+	core "healthy_pay_backend/internal/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,6 +20,15 @@ func NewAuthController(authService *services.AuthService) *AuthController {
 	}
 }
 
+// sessionClient describes the device a sign-in comes from
+func sessionClient(ctx *gin.Context) core.SessionClient {
+	return core.SessionClient{
+		DeviceName: ctx.GetHeader("X-Device-Name"),
+		IPAddress:  ctx.ClientIP(),
+		UserAgent:  ctx.Request.UserAgent(),
+	}
+}
+
 func (c *AuthController) Register(ctx *gin.Context) {
 	var req dtos.RegisterRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
@@ -26,7 +36,7 @@ func (c *AuthController) Register(ctx *gin.Context) {
 		return
 	}
 
-	response, err := c.authService.Register(ctx.Request.Context(), &req)
+	response, err := c.authService.Register(ctx.Request.Context(), &req, sessionClient(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -42,7 +52,7 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
-	response, err := c.authService.Login(ctx.Request.Context(), &req)
+	response, err := c.authService.Login(ctx.Request.Context(), &req, sessionClient(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
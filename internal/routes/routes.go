@@ -3,6 +3,7 @@ package routes
 import (
 	"healthy_pay_backend/internal/handlers"
 	"healthy_pay_backend/internal/middleware"
+	"healthy_pay_backend/internal/services"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,6 +13,7 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db)
 	socialHandler := handlers.NewSocialHandler(db)
+	sessionHandler := handlers.NewSessionHandler(db)
 	userHandler := handlers.NewUserHandler(db)
 	walletHandler := handlers.NewWalletHandler(db)
 	transactionHandler := handlers.NewTransactionHandler(db)
@@ -23,6 +25,19 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 	pspHandler := handlers.NewPSPHandler(db)
 	rateHandler := handlers.NewRateHandler()
 	depositHandler := handlers.NewDepositHandler(db)
+	webhookHandler := handlers.NewWebhookHandler(db)
+
+	// Money-moving routes require an Idempotency-Key and a transaction
+	// token from PIN verification, checked first so refusals aren't replayed
+	idempotent := middleware.IdempotencyMiddleware(db)
+	pinAuthorized := middleware.TransactionAuthMiddleware(db)
+
+	// Looking recipients up by phone or email is throttled so the directory
+	// can't be enumerated; sends to Siha wallets count against the same limits
+	recipientLookups := middleware.RateLimitMiddleware(db, services.RecipientLookupLimits...)
+
+	// Public keys other services verify our tokens with
+	r.GET("/.well-known/jwks.json", handlers.JWKS)
 
 	// Public routes
 	api := r.Group("/api/v1")
@@ -31,6 +46,7 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 		api.GET("/health", func(c *gin.Context) {
 			c.JSON(200, gin.H{"status": "healthy", "message": "Backend is running"})
 		})
+		api.GET("/health/stellar-ingestion", stellarWalletHandler.GetIngestionStatus)
 
 		auth := api.Group("/auth")
 		{
@@ -38,8 +54,10 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 			auth.POST("/verify-email-otp", authHandler.VerifyEmailOTP)
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
+			auth.POST("/refresh", sessionHandler.Refresh)
 			auth.POST("/verify-email", authHandler.VerifyEmail)
 			auth.POST("/send-verification", authHandler.SendVerification)
+			auth.POST("/social/nonce", socialHandler.IssueNonce)
 			auth.POST("/google", socialHandler.GoogleLogin)
 			auth.POST("/facebook", socialHandler.FacebookLogin)
 			auth.POST("/apple", socialHandler.AppleLogin)
@@ -50,11 +68,14 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 			otp.POST("/send", otpHandler.SendOTP)
 			otp.POST("/verify", otpHandler.VerifyOTP)
 		}
+
+		// PSP callbacks are authenticated by their signature, not a user token
+		api.POST("/webhooks/psp/:psp", webhookHandler.HandlePSPWebhook)
 	}
 
 	// Protected routes
 	protected := api.Group("/")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(db))
 	{
 		// Test endpoint
 		protected.GET("/test", func(c *gin.Context) {
@@ -62,7 +83,19 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 		})
 
 		// Auth routes (protected)
+		protected.POST("/auth/logout", sessionHandler.Logout)
+		protected.GET("/auth/sessions", sessionHandler.ListSessions)
+		protected.DELETE("/auth/sessions/:id", sessionHandler.RevokeSession)
+		protected.POST("/auth/sessions/revoke-others", sessionHandler.RevokeOtherSessions)
+		protected.GET("/auth/social-accounts", socialHandler.ListSocialAccounts)
+		protected.POST("/auth/social-accounts/link-code", socialHandler.RequestLinkCode)
+		protected.POST("/auth/social-accounts/:provider", socialHandler.LinkSocialAccount)
+		protected.DELETE("/auth/social-accounts/:provider", socialHandler.UnlinkSocialAccount)
 		protected.POST("/auth/setup-pin", authHandler.SetupPIN)
+		protected.POST("/auth/pin/verify", authHandler.VerifyPIN)
+		protected.POST("/auth/pin/change", authHandler.ChangePIN)
+		protected.POST("/auth/pin/reset/request", authHandler.RequestPINReset)
+		protected.POST("/auth/pin/reset", authHandler.ResetPIN)
 		protected.POST("/auth/setup-payment-method", authHandler.SetupPaymentMethod)
 		protected.GET("/auth/payment-method", authHandler.GetPaymentMethod)
 
@@ -85,10 +118,12 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 		{
 			wallet.GET("", walletHandler.GetBalance) // Default wallet endpoint
 			wallet.GET("/balance", walletHandler.GetBalance)
-			wallet.POST("/add-funds", walletHandler.AddFunds)
+			wallet.POST("/add-funds", pinAuthorized, idempotent, walletHandler.AddFunds)
 			wallet.POST("/create-blockchain", walletHandler.CreateBlockchainWallet)
 			wallet.POST("/activate-blockchain", walletHandler.ActivateBlockchain)
 			wallet.GET("/supported-blockchains", walletHandler.GetSupportedBlockchains)
+			// Never behind the idempotency middleware, which would store the response
+			wallet.POST("/recovery-phrase/export", walletHandler.ExportRecoveryPhrase)
 		}
 
 		// Send flow routes
@@ -98,7 +133,8 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 			send.GET("/recipients", transactionHandler.GetRecipients)
 			send.GET("/delivery-options", transactionHandler.GetRecipientDeliveryOptions)
 			send.GET("/mobile-networks", transactionHandler.GetMobileNetworks)
-			send.POST("/money", transactionHandler.SendMoney)
+			send.GET("/resolve-recipient", recipientLookups, transactionHandler.ResolveRecipient)
+			send.POST("/money", pinAuthorized, idempotent, transactionHandler.SendMoney)
 		}
 
 		// Mobile money routes
@@ -111,9 +147,10 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 		// Transaction routes
 		transactions := protected.Group("/transactions")
 		{
-			transactions.POST("/send", transactionHandler.SendMoney) // Legacy endpoint
+			transactions.POST("/send", pinAuthorized, idempotent, transactionHandler.SendMoney) // Legacy endpoint
 			transactions.GET("/", transactionHandler.GetTransactions)
 			transactions.GET("/:id/status", transactionHandler.CheckTransactionStatus)
+			transactions.GET("/:id/refund", transactionHandler.GetTransactionRefund)
 			transactions.POST("/process-pending", transactionHandler.ProcessPendingTransactions)
 		}
 
@@ -123,7 +160,10 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 			stellar.GET("/info", stellarWalletHandler.GetWalletInfo)
 			stellar.POST("/wallet", stellarWalletHandler.CreateWallet)
 			stellar.GET("/wallet", stellarWalletHandler.GetWallet)
-			stellar.POST("/send-usdc", stellarWalletHandler.SendUSDC)
+			stellar.POST("/wallet/restore", stellarWalletHandler.RestoreWallet)
+			stellar.GET("/deposit-address", stellarWalletHandler.GetDepositAddress)
+			stellar.POST("/send-usdc", pinAuthorized, idempotent, stellarWalletHandler.SendUSDC)
+			stellar.POST("/send", pinAuthorized, idempotent, stellarWalletHandler.SendAsset)
 			stellar.GET("/transactions", stellarWalletHandler.GetTransactions)
 			stellar.GET("/asset-info", stellarWalletHandler.GetAssetInfo)
 		}
@@ -131,7 +171,7 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 		// Investment routes
 		investments := protected.Group("/investments")
 		{
-			investments.POST("/", investmentHandler.CreateInvestment)
+			investments.POST("/", pinAuthorized, idempotent, investmentHandler.CreateInvestment)
 			investments.GET("/", investmentHandler.GetInvestments)
 		}
 
@@ -155,7 +195,7 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 		// Deposit routes
 		deposits := protected.Group("/deposits")
 		{
-			deposits.POST("/initiate", depositHandler.InitiateDeposit)
+			deposits.POST("/initiate", pinAuthorized, idempotent, depositHandler.InitiateDeposit)
 			deposits.GET("/:id/status", depositHandler.CheckDepositStatus)
 			deposits.GET("/", depositHandler.GetDeposits)
 		}
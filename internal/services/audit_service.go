@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AuditOutcomePending marks an attempt recorded before its result is known,
+// so that concurrent attempts see each other
+const AuditOutcomePending = "pending"
+
+// AuditService records security-relevant account actions in audit_events
+type AuditService struct {
+	db *mongo.Database
+}
+
+func NewAuditService(db *mongo.Database) *AuditService {
+	return &AuditService{db: db}
+}
+
+// EnsureIndexes supports looking up a user's recent events by action
+func (a *AuditService) EnsureIndexes(ctx context.Context) error {
+	_, err := a.db.Collection("audit_events").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "action", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create audit event index: %w", err)
+	}
+	return nil
+}
+
+// Record stores an event stamped with the current time and returns its ID
+func (a *AuditService) Record(ctx context.Context, event models.AuditEvent) (primitive.ObjectID, error) {
+	event.CreatedAt = time.Now()
+	result, err := a.db.Collection("audit_events").InsertOne(ctx, event)
+	if err != nil {
+		log.Printf("❌ Failed to record audit event %s/%s for user %s: %v", event.Action, event.Outcome, event.UserID.Hex(), err)
+		return primitive.NilObjectID, fmt.Errorf("failed to record audit event: %w", err)
+	}
+	if event.Outcome != AuditOutcomePending {
+		log.Printf("🔐 Audit: %s %s for user %s", event.Action, event.Outcome, event.UserID.Hex())
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// Resolve sets the outcome of an event recorded as pending
+func (a *AuditService) Resolve(ctx context.Context, event models.AuditEvent) error {
+	set := bson.M{"outcome": event.Outcome}
+	if event.WalletID != nil {
+		set["wallet_id"] = event.WalletID
+	}
+	if _, err := a.db.Collection("audit_events").UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("❌ Failed to resolve audit event %s for user %s: %v", event.ID.Hex(), event.UserID.Hex(), err)
+		return fmt.Errorf("failed to resolve audit event: %w", err)
+	}
+	log.Printf("🔐 Audit: %s %s for user %s", event.Action, event.Outcome, event.UserID.Hex())
+	return nil
+}
+
+// CountSince counts the user's events for action since a time, leaving out the
+// given outcomes
+func (a *AuditService) CountSince(ctx context.Context, userID primitive.ObjectID, action string, since time.Time, excludeOutcomes ...string) (int64, error) {
+	filter := bson.M{"user_id": userID, "action": action, "created_at": bson.M{"$gte": since}}
+	if len(excludeOutcomes) > 0 {
+		filter["outcome"] = bson.M{"$nin": excludeOutcomes}
+	}
+	return a.db.Collection("audit_events").CountDocuments(ctx, filter)
+}
@@ -0,0 +1,846 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/utils"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Bitcoin job types
+const JobConfirmBitcoinTransaction = "blockchain.confirm_bitcoin_transaction"
+
+const (
+	btcDecimals = 8
+
+	// Addresses watched beyond the last one handed out, so a payment to an
+	// address derived by another replica is still seen
+	bitcoinAddressGap = 20
+
+	bitcoinFeeTarget            = 6   // Blocks
+	bitcoinDustLimit            = 294 // Smallest P2WPKH output relayed, in satoshis
+	bitcoinP2WPKHScriptLen      = 22
+	bitcoinDefaultFeeRate       = 2.0 // sat/vB when the node has no estimate
+	bitcoinDefaultConfirmations = 3
+
+	bitcoinConfirmationPollDelay = 2 * time.Minute
+	bitcoinConfirmationWindow    = 24 * time.Hour
+)
+
+var ErrBitcoinNotConfigured = errors.New("BITCOIN_RPC_URL is not configured")
+
+// BitcoinBlockchainService - Bitcoin implementation of BlockchainService. Each
+// wallet is a BIP-84 account whose addresses are watched by a watch-only
+// wallet on the node; the service mirrors their UTXOs and spends them
+// through PSBTs signed with keys derived from the account key.
+type BitcoinBlockchainService struct {
+	db                    *mongo.Database
+	network               string
+	params                *chaincfg.Params
+	rpc                   *BitcoinRPCClient // nil when no RPC endpoint is configured
+	fallbackFeeRate       float64
+	requiredConfirmations int64
+	jobs                  *JobService
+}
+
+func NewBitcoinBlockchainService(db interface{}) *BitcoinBlockchainService {
+	btcNetwork := os.Getenv("BITCOIN_NETWORK")
+	if btcNetwork == "" {
+		btcNetwork = "testnet"
+	}
+
+	var rpc *BitcoinRPCClient
+	if rpcURL := os.Getenv("BITCOIN_RPC_URL"); rpcURL != "" {
+		client, err := NewBitcoinRPCClient(rpcURL, os.Getenv("BITCOIN_RPC_WALLET"))
+		if err != nil {
+			log.Printf("Error configuring Bitcoin RPC: %v", err)
+		} else {
+			rpc = client
+		}
+	}
+
+	fallbackFeeRate := bitcoinDefaultFeeRate
+	if rate, err := strconv.ParseFloat(os.Getenv("BITCOIN_FALLBACK_FEE_RATE"), 64); err == nil && rate >= 1 {
+		fallbackFeeRate = rate
+	}
+	requiredConfirmations := int64(bitcoinDefaultConfirmations)
+	if n, err := strconv.ParseInt(os.Getenv("BITCOIN_CONFIRMATIONS"), 10, 64); err == nil && n > 0 {
+		requiredConfirmations = n
+	}
+
+	return &BitcoinBlockchainService{
+		db:                    db.(*mongo.Database),
+		network:               btcNetwork,
+		params:                bitcoinParams(btcNetwork),
+		rpc:                   rpc,
+		fallbackFeeRate:       fallbackFeeRate,
+		requiredConfirmations: requiredConfirmations,
+		jobs:                  NewJobService(db.(*mongo.Database)),
+	}
+}
+
+func bitcoinParams(network string) *chaincfg.Params {
+	switch network {
+	case "mainnet":
+		return &chaincfg.MainNetParams
+	case "signet":
+		return &chaincfg.SigNetParams
+	case "regtest":
+		return &chaincfg.RegressionNetParams
+	default:
+		return &chaincfg.TestNet3Params
+	}
+}
+
+// EnsureIndexes - An address belongs to one wallet and an output is tracked once
+func (s *BitcoinBlockchainService) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("bitcoin_addresses").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "address", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "wallet_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Collection("bitcoin_utxos").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "outpoint", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "wallet_id", Value: 1}, {Key: "spent_by", Value: 1}}},
+	})
+	return err
+}
+
+// RegisterJobHandlers adds the confirmation tracking handler to a worker
+func (s *BitcoinBlockchainService) RegisterJobHandlers(worker *JobWorker) {
+	worker.Handle(JobConfirmBitcoinTransaction, s.ConfirmTransaction)
+}
+
+// CreateWallet generates an HD seed for the user and stores the encrypted
+// BIP-84 account key; the wallet's public key is its first receive address
+func (s *BitcoinBlockchainService) CreateWallet(userID primitive.ObjectID, network string) (*models.BlockchainWallet, error) {
+	ctx := context.Background()
+
+	var existing models.BlockchainWallet
+	err := s.db.Collection("blockchain_wallets").FindOne(ctx,
+		bson.M{"user_id": userID, "blockchain": "bitcoin", "is_active": true}).Decode(&existing)
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	seed, err := hdkeychain.GenerateSeed(hdkeychain.RecommendedSeedLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate seed: %w", err)
+	}
+	master, err := hdkeychain.NewMaster(seed, s.params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive master key: %w", err)
+	}
+	account, err := bitcoinAccountKey(master, s.params)
+	if err != nil {
+		return nil, err
+	}
+	accountPub, err := account.Neuter()
+	if err != nil {
+		return nil, err
+	}
+	first, err := deriveBitcoinAddress(accountPub, false, 0, s.params)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := utils.EncryptPrivateKey(account.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	wallet := &models.BlockchainWallet{
+		UserID:            userID,
+		Blockchain:        "bitcoin",
+		Network:           s.network,
+		PublicKey:         first.EncodeAddress(),
+		PrivateKey:        encryptedKey,
+		ExtendedPublicKey: accountPub.String(),
+		NextAddressIndex:  1,
+		IsActive:          true,
+		Balances: []models.AssetBalance{
+			{AssetCode: "BTC", Balance: 0.0, Symbol: "BTC", Name: "Bitcoin"},
+		},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	result, err := s.db.Collection("blockchain_wallets").InsertOne(ctx, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save blockchain wallet: %w", err)
+	}
+	wallet.ID = result.InsertedID.(primitive.ObjectID)
+
+	if err := s.saveAddress(ctx, wallet.ID, first.EncodeAddress(), false, 0); err != nil {
+		return nil, err
+	}
+	if err := s.watch(ctx, wallet); err != nil {
+		// Re-imported with the next address the wallet hands out
+		log.Printf("⚠️ Error watching Bitcoin wallet %s: %v", wallet.ID.Hex(), err)
+	}
+
+	log.Printf("Created Bitcoin wallet %s for user %s", wallet.PublicKey, userID.Hex())
+	return wallet, nil
+}
+
+// NewAddress derives the wallet's next unused receive address
+func (s *BitcoinBlockchainService) NewAddress(walletID primitive.ObjectID) (string, error) {
+	address, _, err := s.nextAddress(context.Background(), walletID, false)
+	if err != nil {
+		return "", err
+	}
+	return address.EncodeAddress(), nil
+}
+
+// nextAddress reserves the next index on the receive or change chain, so
+// concurrent callers never get the same address
+func (s *BitcoinBlockchainService) nextAddress(ctx context.Context, walletID primitive.ObjectID, change bool) (btcutil.Address, []byte, error) {
+	field := "next_address_index"
+	if change {
+		field = "next_change_index"
+	}
+
+	var wallet models.BlockchainWallet
+	err := s.db.Collection("blockchain_wallets").FindOneAndUpdate(ctx,
+		bson.M{"_id": walletID, "blockchain": "bitcoin"},
+		bson.M{"$inc": bson.M{field: 1}, "$set": bson.M{"updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&wallet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wallet not found: %w", err)
+	}
+	index := wallet.NextAddressIndex - 1
+	if change {
+		index = wallet.NextChangeIndex - 1
+	}
+
+	accountPub, err := hdkeychain.NewKeyFromString(wallet.ExtendedPublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wallet %s has an invalid extended key: %w", walletID.Hex(), err)
+	}
+	address, err := deriveBitcoinAddress(accountPub, change, index, s.params)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.saveAddress(ctx, walletID, address.EncodeAddress(), change, index); err != nil {
+		return nil, nil, err
+	}
+	if err := s.watch(ctx, &wallet); err != nil {
+		return nil, nil, fmt.Errorf("failed to watch address: %w", err)
+	}
+
+	script, err := txscript.PayToAddrScript(address)
+	return address, script, err
+}
+
+func (s *BitcoinBlockchainService) saveAddress(ctx context.Context, walletID primitive.ObjectID, address string, change bool, index uint32) error {
+	_, err := s.db.Collection("bitcoin_addresses").InsertOne(ctx, models.BitcoinAddress{
+		WalletID:  walletID,
+		Address:   address,
+		Change:    change,
+		Index:     index,
+		CreatedAt: time.Now(),
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to save address: %w", err)
+	}
+	return nil
+}
+
+// watch imports the wallet's receive and change chains into the node's
+// watch-only wallet, up to bitcoinAddressGap past the last address handed out
+func (s *BitcoinBlockchainService) watch(ctx context.Context, wallet *models.BlockchainWallet) error {
+	if s.rpc == nil {
+		return ErrBitcoinNotConfigured
+	}
+	end := max(wallet.NextAddressIndex, wallet.NextChangeIndex) + bitcoinAddressGap
+	return s.rpc.ImportDescriptors(ctx, []string{
+		fmt.Sprintf("wpkh(%s/0/*)", wallet.ExtendedPublicKey),
+		fmt.Sprintf("wpkh(%s/1/*)", wallet.ExtendedPublicKey),
+	}, end)
+}
+
+func (s *BitcoinBlockchainService) GetWallet(userID primitive.ObjectID) (*models.BlockchainWallet, error) {
+	var wallet models.BlockchainWallet
+	err := s.db.Collection("blockchain_wallets").FindOne(context.Background(),
+		bson.M{"user_id": userID, "blockchain": "bitcoin", "is_active": true}).Decode(&wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refresh the balance from the node, keeping the stored one if it can't be reached
+	if s.rpc != nil {
+		if balance, err := s.GetBalance(wallet.ID, "BTC"); err == nil {
+			for i := range wallet.Balances {
+				if wallet.Balances[i].AssetCode == "BTC" {
+					wallet.Balances[i].Balance = balance
+				}
+			}
+		}
+	}
+	return &wallet, nil
+}
+
+// GetBalance syncs the wallet's UTXOs and returns their total, including
+// unconfirmed ones and excluding those reserved by a pending send
+func (s *BitcoinBlockchainService) GetBalance(walletID primitive.ObjectID, assetCode string) (float64, error) {
+	ctx := context.Background()
+	if !strings.EqualFold(strings.TrimSpace(assetCode), "BTC") {
+		return 0, fmt.Errorf("%w: unsupported asset %q", ErrInvalidSend, assetCode)
+	}
+	if err := s.SyncUTXOs(ctx, walletID); err != nil {
+		return 0, err
+	}
+
+	utxos, err := s.unspent(ctx, walletID, 0)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, utxo := range utxos {
+		total += utxo.Value
+	}
+	return fromBaseUnits(big.NewInt(total), btcDecimals), nil
+}
+
+// SyncUTXOs mirrors the node's view of the wallet's unspent outputs into
+// bitcoin_utxos, dropping outputs that have since been spent
+func (s *BitcoinBlockchainService) SyncUTXOs(ctx context.Context, walletID primitive.ObjectID) error {
+	if s.rpc == nil {
+		return ErrBitcoinNotConfigured
+	}
+
+	cursor, err := s.db.Collection("bitcoin_addresses").Find(ctx, bson.M{"wallet_id": walletID})
+	if err != nil {
+		return err
+	}
+	var addresses []models.BitcoinAddress
+	if err := cursor.All(ctx, &addresses); err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+	byAddress := make(map[string]models.BitcoinAddress, len(addresses))
+	names := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		byAddress[address.Address] = address
+		names = append(names, address.Address)
+	}
+
+	unspent, err := s.rpc.ListUnspent(ctx, names)
+	if err != nil {
+		return fmt.Errorf("failed to list unspent outputs: %w", err)
+	}
+
+	utxos := s.db.Collection("bitcoin_utxos")
+	outpoints := make([]string, 0, len(unspent))
+	for _, output := range unspent {
+		address, ok := byAddress[output.Address]
+		if !ok {
+			continue
+		}
+		value, err := btcutil.NewAmount(output.Amount)
+		if err != nil {
+			return err
+		}
+		outpoint := fmt.Sprintf("%s:%d", output.TxID, output.Vout)
+		outpoints = append(outpoints, outpoint)
+
+		_, err = utxos.UpdateOne(ctx,
+			bson.M{"outpoint": outpoint},
+			bson.M{
+				"$set": bson.M{
+					"wallet_id":      walletID,
+					"txid":           output.TxID,
+					"vout":           output.Vout,
+					"address":        output.Address,
+					"change":         address.Change,
+					"index":          address.Index,
+					"script_pub_key": output.ScriptPubKey,
+					"value":          int64(value),
+					"confirmations":  output.Confirmations,
+					"updated_at":     time.Now(),
+				},
+				"$setOnInsert": bson.M{"created_at": time.Now()},
+			},
+			options.Update().SetUpsert(true))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to save UTXO %s: %w", outpoint, err)
+		}
+	}
+
+	_, err = utxos.DeleteMany(ctx, bson.M{"wallet_id": walletID, "outpoint": bson.M{"$nin": outpoints}})
+	return err
+}
+
+// unspent returns the wallet's unreserved UTXOs with at least minConf
+// confirmations, largest first
+func (s *BitcoinBlockchainService) unspent(ctx context.Context, walletID primitive.ObjectID, minConf int64) ([]models.BitcoinUTXO, error) {
+	cursor, err := s.db.Collection("bitcoin_utxos").Find(ctx,
+		bson.M{"wallet_id": walletID, "spent_by": bson.M{"$exists": false}, "confirmations": bson.M{"$gte": minConf}},
+		options.Find().SetSort(bson.D{{Key: "value", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	var utxos []models.BitcoinUTXO
+	err = cursor.All(ctx, &utxos)
+	return utxos, err
+}
+
+// SendAsset sends BTC from a user's wallet, spending confirmed UTXOs and
+// returning change to a fresh change address. The transaction is recorded as
+// pending under its txid and confirmed by a JobConfirmBitcoinTransaction job
+// once it has enough confirmations. Bitcoin sends carry no memo; it is kept
+// on the record only.
+func (s *BitcoinBlockchainService) SendAsset(fromWalletID primitive.ObjectID, toAddress string, amount float64, assetCode string, memo string) (*models.BlockchainTransaction, error) {
+	ctx := context.Background()
+	if s.rpc == nil {
+		return nil, ErrBitcoinNotConfigured
+	}
+
+	wallet, err := s.getWalletByID(fromWalletID)
+	if err != nil {
+		return nil, fmt.Errorf("wallet not found: %w", err)
+	}
+	if !wallet.IsActive {
+		return nil, fmt.Errorf("wallet %s is not active", wallet.ID.Hex())
+	}
+	if !strings.EqualFold(strings.TrimSpace(assetCode), "BTC") {
+		return nil, fmt.Errorf("%w: unsupported asset %q", ErrInvalidSend, assetCode)
+	}
+
+	to, err := btcutil.DecodeAddress(strings.TrimSpace(toAddress), s.params)
+	if err != nil || !to.IsForNet(s.params) {
+		return nil, fmt.Errorf("%w: invalid Bitcoin address %s", ErrInvalidSend, toAddress)
+	}
+	own, err := s.db.Collection("bitcoin_addresses").CountDocuments(ctx,
+		bson.M{"wallet_id": wallet.ID, "address": to.EncodeAddress()})
+	if err != nil {
+		return nil, err
+	}
+	if own > 0 {
+		return nil, fmt.Errorf("%w: cannot send to the same wallet", ErrInvalidSend)
+	}
+	toScript, err := txscript.PayToAddrScript(to)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unsupported address %s", ErrInvalidSend, toAddress)
+	}
+	value, err := toBaseUnits(amount, btcDecimals)
+	if err != nil {
+		return nil, err
+	}
+	if value.Int64() < bitcoinDustLimit {
+		return nil, fmt.Errorf("%w: amount is below the dust limit of %d satoshis", ErrInvalidSend, bitcoinDustLimit)
+	}
+
+	keyString, err := utils.DecryptPrivateKey(wallet.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt wallet key: %w", err)
+	}
+	account, err := hdkeychain.NewKeyFromString(keyString)
+	if err != nil || !account.IsPrivate() {
+		return nil, fmt.Errorf("wallet %s has an invalid key", wallet.ID.Hex())
+	}
+
+	if err := s.SyncUTXOs(ctx, wallet.ID); err != nil {
+		return nil, err
+	}
+	utxos, err := s.unspent(ctx, wallet.ID, 1)
+	if err != nil {
+		return nil, err
+	}
+	feeRate := s.feeRate(ctx)
+	inputs, fee, change, err := selectBitcoinInputs(utxos, value.Int64(), len(toScript), feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := []*wire.TxOut{wire.NewTxOut(value.Int64(), toScript)}
+	if change > 0 {
+		_, changeScript, err := s.nextAddress(ctx, wallet.ID, true)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, wire.NewTxOut(change, changeScript))
+	}
+
+	tx, err := signBitcoinTransaction(account, inputs, outputs, s.params)
+	if err != nil {
+		return nil, err
+	}
+	txid := tx.TxHash().String()
+
+	if err := s.reserve(ctx, inputs, txid); err != nil {
+		return nil, err
+	}
+
+	record := &models.BlockchainTransaction{
+		UserID:      wallet.UserID,
+		WalletID:    wallet.ID,
+		Blockchain:  "bitcoin",
+		Network:     wallet.Network,
+		FromAddress: wallet.PublicKey,
+		ToAddress:   to.EncodeAddress(),
+		Amount:      amount,
+		AssetCode:   "BTC",
+		TxHash:      txid,
+		Status:      "pending",
+		Type:        "send",
+		Memo:        memo,
+		Fee:         fromBaseUnits(big.NewInt(fee), btcDecimals),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	result, err := s.db.Collection("blockchain_transactions").InsertOne(ctx, record)
+	if err != nil {
+		s.release(ctx, txid)
+		return nil, fmt.Errorf("failed to record transaction: %w", err)
+	}
+	record.ID = result.InsertedID.(primitive.ObjectID)
+
+	var raw bytes.Buffer
+	if err := tx.Serialize(&raw); err != nil {
+		return nil, err
+	}
+	if _, err := s.rpc.SendRawTransaction(ctx, hex.EncodeToString(raw.Bytes())); err != nil {
+		var rpcErr *BitcoinRPCError
+		if !errors.As(err, &rpcErr) {
+			// The node may have accepted it; the confirmation job finds out
+			log.Printf("⚠️ Bitcoin send %s left pending: %v", txid, err)
+			s.enqueueConfirmation(ctx, record)
+			return record, fmt.Errorf("failed to broadcast transaction: %w", err)
+		}
+		s.release(ctx, txid)
+		s.finishTransaction(ctx, record, "failed", 0, rpcErr.Message)
+		return record, fmt.Errorf("failed to broadcast transaction: %w", err)
+	}
+	s.enqueueConfirmation(ctx, record)
+
+	log.Printf("⛓️ Bitcoin send %s: %s BTC from %s to %s (fee %d sat at %.1f sat/vB)",
+		txid, strconv.FormatFloat(amount, 'f', -1, 64), wallet.PublicKey, to.EncodeAddress(), fee, feeRate)
+	return record, nil
+}
+
+// feeRate returns the node's estimate for confirmation within
+// bitcoinFeeTarget blocks, or the fallback rate when it has none
+func (s *BitcoinBlockchainService) feeRate(ctx context.Context) float64 {
+	rate, ok, err := s.rpc.EstimateFeeRate(ctx, bitcoinFeeTarget)
+	if err != nil {
+		log.Printf("Error estimating Bitcoin fee rate, using %.1f sat/vB: %v", s.fallbackFeeRate, err)
+	}
+	if !ok || err != nil {
+		return s.fallbackFeeRate
+	}
+	return max(rate, 1)
+}
+
+// reserve marks inputs as spent by txid. An input already reserved by another
+// send fails the whole reservation.
+func (s *BitcoinBlockchainService) reserve(ctx context.Context, inputs []models.BitcoinUTXO, txid string) error {
+	for _, input := range inputs {
+		result, err := s.db.Collection("bitcoin_utxos").UpdateOne(ctx,
+			bson.M{"_id": input.ID, "spent_by": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"spent_by": txid, "updated_at": time.Now()}})
+		if err == nil && result.ModifiedCount == 0 {
+			err = fmt.Errorf("output %s is already being spent, try again", input.Outpoint)
+		}
+		if err != nil {
+			s.release(ctx, txid)
+			return err
+		}
+	}
+	return nil
+}
+
+// release frees the inputs reserved by txid
+func (s *BitcoinBlockchainService) release(ctx context.Context, txid string) {
+	_, err := s.db.Collection("bitcoin_utxos").UpdateMany(ctx,
+		bson.M{"spent_by": txid},
+		bson.M{"$unset": bson.M{"spent_by": ""}, "$set": bson.M{"updated_at": time.Now()}})
+	if err != nil {
+		log.Printf("Error releasing inputs of Bitcoin transaction %s: %v", txid, err)
+	}
+}
+
+func (s *BitcoinBlockchainService) enqueueConfirmation(ctx context.Context, record *models.BlockchainTransaction) {
+	err := s.jobs.Enqueue(ctx, JobConfirmBitcoinTransaction, JobConfirmBitcoinTransaction+":"+record.ID.Hex(),
+		bson.M{"blockchain_transaction_id": record.ID.Hex()}, time.Now().Add(bitcoinConfirmationPollDelay))
+	if err != nil {
+		log.Printf("Error queueing confirmation check for %s: %v", record.TxHash, err)
+	}
+}
+
+// ConfirmTransaction counts a pending transaction's confirmations, settling it
+// once it has enough or failing it if it was never seen or conflicts with
+// another transaction in the chain
+func (s *BitcoinBlockchainService) ConfirmTransaction(ctx context.Context, job *models.Job) error {
+	if s.rpc == nil {
+		return ErrBitcoinNotConfigured
+	}
+
+	idHex, _ := job.Payload["blockchain_transaction_id"].(string)
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return fmt.Errorf("%w: invalid blockchain_transaction_id %q", ErrPermanentJobFailure, idHex)
+	}
+
+	var record models.BlockchainTransaction
+	err = s.db.Collection("blockchain_transactions").FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: blockchain transaction %s not found", ErrPermanentJobFailure, idHex)
+	}
+	if err != nil {
+		return err
+	}
+	if record.Status != "pending" {
+		return nil
+	}
+
+	confirmations, err := s.rpc.Confirmations(ctx, record.TxHash)
+	var rpcErr *BitcoinRPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == bitcoinRPCInvalidAddressOrKey {
+		if time.Since(record.CreatedAt) < bitcoinConfirmationWindow {
+			return RescheduleJob(bitcoinConfirmationPollDelay)
+		}
+		s.release(ctx, record.TxHash)
+		s.finishTransaction(ctx, &record, "failed", 0, "transaction never reached the network")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case confirmations < 0:
+		s.release(ctx, record.TxHash)
+		s.finishTransaction(ctx, &record, "failed", 0, "transaction conflicts with one in the chain")
+	case confirmations >= s.requiredConfirmations:
+		s.finishTransaction(ctx, &record, "confirmed", confirmations, "")
+	default:
+		if confirmations != record.Confirmations {
+			_, err := s.db.Collection("blockchain_transactions").UpdateOne(ctx,
+				bson.M{"_id": record.ID},
+				bson.M{"$set": bson.M{"confirmations": confirmations, "updated_at": time.Now()}})
+			if err != nil {
+				return err
+			}
+		}
+		return RescheduleJob(bitcoinConfirmationPollDelay)
+	}
+	return nil
+}
+
+func (s *BitcoinBlockchainService) finishTransaction(ctx context.Context, record *models.BlockchainTransaction, status string, confirmations int64, reason string) {
+	record.Status = status
+	record.Confirmations = confirmations
+	record.FailureReason = reason
+	record.UpdatedAt = time.Now()
+
+	_, err := s.db.Collection("blockchain_transactions").UpdateOne(ctx,
+		bson.M{"_id": record.ID},
+		bson.M{"$set": bson.M{"status": status, "confirmations": confirmations, "failure_reason": reason, "updated_at": record.UpdatedAt}})
+	if err != nil {
+		log.Printf("Error updating Bitcoin transaction %s to %s: %v", record.TxHash, status, err)
+	}
+}
+
+func (s *BitcoinBlockchainService) GetTransactions(walletID primitive.ObjectID) ([]models.BlockchainTransaction, error) {
+	var transactions []models.BlockchainTransaction
+	cursor, err := s.db.Collection("blockchain_transactions").Find(context.Background(),
+		bson.M{"wallet_id": walletID, "blockchain": "bitcoin"})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	err = cursor.All(context.Background(), &transactions)
+	return transactions, err
+}
+
+func (s *BitcoinBlockchainService) GetSupportedAssets() ([]models.AssetBalance, error) {
+	return []models.AssetBalance{
+		{AssetCode: "BTC", Symbol: "BTC", Name: "Bitcoin"},
+	}, nil
+}
+
+func (s *BitcoinBlockchainService) getWalletByID(walletID primitive.ObjectID) (*models.BlockchainWallet, error) {
+	var wallet models.BlockchainWallet
+	err := s.db.Collection("blockchain_wallets").FindOne(context.Background(),
+		bson.M{"_id": walletID, "blockchain": "bitcoin"}).Decode(&wallet)
+	return &wallet, err
+}
+
+// bitcoinAccountKey derives the BIP-84 account m/84'/coin'/0'
+func bitcoinAccountKey(master *hdkeychain.ExtendedKey, params *chaincfg.Params) (*hdkeychain.ExtendedKey, error) {
+	key := master
+	for _, index := range []uint32{84, params.HDCoinType, 0} {
+		var err error
+		if key, err = key.Derive(hdkeychain.HardenedKeyStart + index); err != nil {
+			return nil, fmt.Errorf("failed to derive account key: %w", err)
+		}
+	}
+	return key, nil
+}
+
+// deriveBitcoinAddress returns the P2WPKH address at change/index below an
+// account key, which may be public or private
+func deriveBitcoinAddress(account *hdkeychain.ExtendedKey, change bool, index uint32, params *chaincfg.Params) (*btcutil.AddressWitnessPubKeyHash, error) {
+	key, err := deriveBitcoinKey(account, change, index)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := key.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pub.SerializeCompressed()), params)
+}
+
+func deriveBitcoinKey(account *hdkeychain.ExtendedKey, change bool, index uint32) (*hdkeychain.ExtendedKey, error) {
+	var branch uint32
+	if change {
+		branch = 1
+	}
+	key, err := account.Derive(branch)
+	if err == nil {
+		key, err = key.Derive(index)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key %d/%d: %w", branch, index, err)
+	}
+	return key, nil
+}
+
+// bitcoinFee prices a transaction spending inputs P2WPKH outputs to outputs
+// with the given script lengths
+func bitcoinFee(inputs int, feeRate float64, outputScriptLens ...int) int64 {
+	// Version, locktime, counts and segwit marker; 68 vB per signed P2WPKH
+	// input; value and script per output
+	vbytes := 11 + 68*inputs
+	for _, scriptLen := range outputScriptLens {
+		vbytes += 9 + scriptLen
+	}
+	return int64(math.Ceil(float64(vbytes) * feeRate))
+}
+
+// selectBitcoinInputs picks UTXOs, largest first, until they cover amount and
+// the fee. Change below the dust limit is left to the miner.
+func selectBitcoinInputs(utxos []models.BitcoinUTXO, amount int64, toScriptLen int, feeRate float64) (selected []models.BitcoinUTXO, fee, change int64, err error) {
+	var total int64
+	for _, utxo := range utxos {
+		selected = append(selected, utxo)
+		total += utxo.Value
+
+		fee = bitcoinFee(len(selected), feeRate, toScriptLen, bitcoinP2WPKHScriptLen)
+		if change = total - amount - fee; change >= bitcoinDustLimit {
+			return selected, fee, change, nil
+		}
+		if fee = bitcoinFee(len(selected), feeRate, toScriptLen); total-amount >= fee {
+			return selected, total - amount, 0, nil
+		}
+	}
+	needed := amount + bitcoinFee(max(len(utxos), 1), feeRate, toScriptLen)
+	return nil, 0, 0, fmt.Errorf("%w: spendable balance is %s BTC, %s BTC needed including fees",
+		ErrInsufficientFunds, formatBaseUnits(big.NewInt(total), btcDecimals), formatBaseUnits(big.NewInt(needed), btcDecimals))
+}
+
+// signBitcoinTransaction spends inputs to outputs through a PSBT, signing each
+// input with the key derived from account for the address it pays
+func signBitcoinTransaction(account *hdkeychain.ExtendedKey, inputs []models.BitcoinUTXO, outputs []*wire.TxOut, params *chaincfg.Params) (*wire.MsgTx, error) {
+	unsigned := wire.NewMsgTx(2)
+	prevOuts := txscript.NewMultiPrevOutFetcher(nil)
+	spent := make([]*wire.TxOut, len(inputs))
+	for i, input := range inputs {
+		hash, err := chainhash.NewHashFromStr(input.TxID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid txid %s: %w", input.TxID, err)
+		}
+		script, err := hex.DecodeString(input.ScriptPubKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid script of %s: %w", input.Outpoint, err)
+		}
+		outpoint := wire.NewOutPoint(hash, input.Vout)
+		unsigned.AddTxIn(wire.NewTxIn(outpoint, nil, nil))
+		spent[i] = wire.NewTxOut(input.Value, script)
+		prevOuts.AddPrevOut(*outpoint, spent[i])
+	}
+	for _, output := range outputs {
+		unsigned.AddTxOut(output)
+	}
+
+	packet, err := psbt.NewFromUnsignedTx(unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PSBT: %w", err)
+	}
+	updater, err := psbt.NewUpdater(packet)
+	if err != nil {
+		return nil, err
+	}
+	sigHashes := txscript.NewTxSigHashes(packet.UnsignedTx, prevOuts)
+
+	for i, input := range inputs {
+		if err := updater.AddInWitnessUtxo(spent[i], i); err != nil {
+			return nil, err
+		}
+		if err := updater.AddInSighashType(txscript.SigHashAll, i); err != nil {
+			return nil, err
+		}
+
+		key, err := deriveBitcoinKey(account, input.Change, input.Index)
+		if err != nil {
+			return nil, err
+		}
+		priv, err := key.ECPrivKey()
+		if err != nil {
+			return nil, err
+		}
+		pub := priv.PubKey().SerializeCompressed()
+		expected, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pub), params)
+		if err != nil {
+			return nil, err
+		}
+		if script, _ := txscript.PayToAddrScript(expected); !bytes.Equal(script, spent[i].PkScript) {
+			return nil, fmt.Errorf("output %s does not pay the wallet's address %s", input.Outpoint, expected.EncodeAddress())
+		}
+
+		sig, err := txscript.RawTxInWitnessSignature(packet.UnsignedTx, sigHashes, i, input.Value, spent[i].PkScript, txscript.SigHashAll, priv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign input %d: %w", i, err)
+		}
+		if _, err := updater.Sign(i, sig, pub, nil, nil); err != nil {
+			return nil, fmt.Errorf("failed to add signature for input %d: %w", i, err)
+		}
+	}
+
+	if err := psbt.MaybeFinalizeAll(packet); err != nil {
+		return nil, fmt.Errorf("failed to finalize PSBT: %w", err)
+	}
+	return psbt.Extract(packet)
+}
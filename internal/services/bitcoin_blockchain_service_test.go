@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"healthy_pay_backend/internal/models"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// testBitcoinAccount derives the BIP-84 account of the "abandon ... about" test mnemonic
+func testBitcoinAccount(t *testing.T, params *chaincfg.Params) *hdkeychain.ExtendedKey {
+	t.Helper()
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	seed := pbkdf2.Key([]byte(mnemonic), []byte("mnemonic"), 2048, 64, sha512.New)
+	master, err := hdkeychain.NewMaster(seed, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := bitcoinAccountKey(master, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func TestDeriveBitcoinAddress(t *testing.T) {
+	account := testBitcoinAccount(t, &chaincfg.MainNetParams)
+	accountPub, _ := account.Neuter()
+
+	// BIP-84 test vectors
+	for _, tc := range []struct {
+		change bool
+		index  uint32
+		want   string
+	}{
+		{false, 0, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu"},
+		{false, 1, "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g"},
+		{true, 0, "bc1q8c6fshw2dlwun7ekn9qwf37cu2rn755upcp6el"},
+	} {
+		for _, key := range []*hdkeychain.ExtendedKey{account, accountPub} {
+			address, err := deriveBitcoinAddress(key, tc.change, tc.index, &chaincfg.MainNetParams)
+			if err != nil || address.EncodeAddress() != tc.want {
+				t.Errorf("address %v/%d = %v, %v; want %s", tc.change, tc.index, address, err, tc.want)
+			}
+		}
+	}
+}
+
+func TestSignBitcoinTransaction(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	account := testBitcoinAccount(t, params)
+
+	var utxos []models.BitcoinUTXO
+	for i, value := range []int64{40_000, 100_000} {
+		address, _ := deriveBitcoinAddress(account, i == 1, uint32(i), params)
+		script, _ := txscript.PayToAddrScript(address)
+		utxos = append(utxos, models.BitcoinUTXO{
+			Outpoint:     fmt.Sprintf("funding:%d", i),
+			TxID:         "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
+			Vout:         uint32(i),
+			Change:       i == 1,
+			Index:        uint32(i),
+			ScriptPubKey: hex.EncodeToString(script),
+			Value:        value,
+		})
+	}
+	// Largest first, as unspent returns them
+	utxos[0], utxos[1] = utxos[1], utxos[0]
+
+	to, _ := deriveBitcoinAddress(account, false, 5, params)
+	toScript, _ := txscript.PayToAddrScript(to)
+	changeAddress, _ := deriveBitcoinAddress(account, true, 1, params)
+	changeScript, _ := txscript.PayToAddrScript(changeAddress)
+
+	inputs, fee, change, err := selectBitcoinInputs(utxos, 120_000, len(toScript), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 || fee != bitcoinFee(2, 10, len(toScript), len(changeScript)) || 120_000+fee+change != 140_000 {
+		t.Fatalf("selected %d inputs, fee %d, change %d", len(inputs), fee, change)
+	}
+
+	tx, err := signBitcoinTransaction(account, inputs, []*wire.TxOut{
+		wire.NewTxOut(120_000, toScript),
+		wire.NewTxOut(change, changeScript),
+	}, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every input must satisfy the script it spends
+	prevOuts := txscript.NewMultiPrevOutFetcher(nil)
+	for i, input := range inputs {
+		script, _ := hex.DecodeString(input.ScriptPubKey)
+		prevOuts.AddPrevOut(tx.TxIn[i].PreviousOutPoint, wire.NewTxOut(input.Value, script))
+	}
+	sigHashes := txscript.NewTxSigHashes(tx, prevOuts)
+	for i, input := range inputs {
+		script, _ := hex.DecodeString(input.ScriptPubKey)
+		engine, err := txscript.NewEngine(script, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, input.Value, prevOuts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := engine.Execute(); err != nil {
+			t.Errorf("input %d does not verify: %v", i, err)
+		}
+	}
+	if vsize := (tx.SerializeSizeStripped()*3 + tx.SerializeSize() + 3) / 4; int64(vsize)*10 > fee {
+		t.Errorf("fee %d does not cover %d vB at 10 sat/vB", fee, vsize)
+	}
+
+	if _, _, _, err := selectBitcoinInputs(utxos, 140_000, len(toScript), 10); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("spending the whole balance error = %v, want ErrInsufficientFunds", err)
+	}
+}
+
+func TestBitcoinRPCClient(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "rpc" || pass != "secret" || r.URL.Path != "/wallet/watch" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		switch req.Method {
+		case "estimatesmartfee":
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"feerate": 0.00012, "blocks": 6}})
+		case "listunspent":
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": []map[string]interface{}{
+				{"txid": "ab", "vout": 1, "address": "bcrt1qexample", "scriptPubKey": "0014", "amount": 0.015, "confirmations": 2},
+			}})
+		default:
+			// bitcoind reports RPC errors with HTTP 500
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": -5, "message": "Invalid or non-wallet transaction id"}})
+		}
+	}))
+	defer stub.Close()
+
+	client, err := NewBitcoinRPCClient("http://rpc:secret@"+stub.Listener.Addr().String(), "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if rate, ok, err := client.EstimateFeeRate(ctx, bitcoinFeeTarget); err != nil || !ok || rate != 12 {
+		t.Errorf("fee rate = %v, %v, %v; want 12 sat/vB", rate, ok, err)
+	}
+	unspent, err := client.ListUnspent(ctx, []string{"bcrt1qexample"})
+	if err != nil || len(unspent) != 1 || unspent[0].Amount != 0.015 || unspent[0].Confirmations != 2 {
+		t.Errorf("unspent = %+v, %v", unspent, err)
+	}
+	var rpcErr *BitcoinRPCError
+	if _, err := client.Confirmations(ctx, "ab"); !errors.As(err, &rpcErr) || rpcErr.Code != bitcoinRPCInvalidAddressOrKey {
+		t.Errorf("unknown transaction error = %v, want RPC error -5", err)
+	}
+}
@@ -29,11 +29,9 @@ func (f *BlockchainServiceFactory) GetService(blockchain string) BlockchainServi
 	case "stellar":
 		return NewStellarBlockchainService(f.db)
 	case "ethereum":
-		// TODO: Implement Ethereum service
-		return nil
+		return NewEVMBlockchainService(f.db)
 	case "bitcoin":
-		// TODO: Implement Bitcoin service
-		return nil
+		return NewBitcoinBlockchainService(f.db)
 	default:
 		// Default to Stellar
 		return NewStellarBlockchainService(f.db)
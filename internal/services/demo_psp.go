@@ -46,6 +46,6 @@ func (d *DemoPSP) CheckCollectionStatus(transactionID string) (string, error) {
 
 func (d *DemoPSP) InitiateDelivery(req DeliveryRequest) error {
 	// Simulate delivery
-	fmt.Printf("%s PSP: Delivering ₵%.2f to %s\n", d.name, req.Amount, req.RecipientAccount)
+	fmt.Printf("%s PSP: Delivering %s to %s\n", d.name, req.Amount, req.RecipientAccount)
 	return nil
 }
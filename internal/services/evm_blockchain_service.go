@@ -0,0 +1,659 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/utils"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// EVM job types
+const JobConfirmEVMTransaction = "blockchain.confirm_evm_transaction"
+
+const (
+	ethDecimals  = 18
+	usdcDecimals = 6
+
+	// A transaction not mined after this long is retried with backoff and
+	// eventually dead-lettered for ops instead of polled every few seconds
+	evmConfirmationWindow = time.Hour
+	evmReceiptPollDelay   = 15 * time.Second
+)
+
+// Circle's USDC contracts, ETHEREUM_USDC_ADDRESS overrides them
+var evmUSDCAddresses = map[string]string{
+	"mainnet": "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48",
+	"sepolia": "0x1c7D4B196Cb0C7B01d743Fbc6116a902379C7238",
+}
+
+// ERC-20 function selectors
+var (
+	erc20BalanceOf = crypto.Keccak256([]byte("balanceOf(address)"))[:4]
+	erc20Transfer  = crypto.Keccak256([]byte("transfer(address,uint256)"))[:4]
+)
+
+var ErrEVMNotConfigured = errors.New("ETHEREUM_RPC_URL is not configured")
+
+// EVMClient is the part of an Ethereum JSON-RPC client the service needs.
+// ethclient.Client implements it, and so does the simulated backend's client.
+type EVMClient interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// EVMBlockchainService - Ethereum implementation of BlockchainService, holding
+// ETH and ERC-20 USDC in user wallets
+type EVMBlockchainService struct {
+	db      *mongo.Database
+	network string
+	chain   *evmChain // nil when no RPC endpoint is configured
+	jobs    *JobService
+}
+
+func NewEVMBlockchainService(db interface{}) *EVMBlockchainService {
+	evmNetwork := os.Getenv("ETHEREUM_NETWORK")
+	if evmNetwork == "" {
+		evmNetwork = "sepolia"
+	}
+
+	var chain *evmChain
+	if rpcURL := os.Getenv("ETHEREUM_RPC_URL"); rpcURL != "" {
+		client, err := ethclient.Dial(rpcURL)
+		if err != nil {
+			log.Printf("Error connecting to Ethereum RPC: %v", err)
+		} else {
+			usdc := os.Getenv("ETHEREUM_USDC_ADDRESS")
+			if usdc == "" {
+				usdc = evmUSDCAddresses[evmNetwork]
+			}
+			chain = newEVMChain(client, common.HexToAddress(usdc))
+		}
+	}
+
+	return &EVMBlockchainService{
+		db:      db.(*mongo.Database),
+		network: evmNetwork,
+		chain:   chain,
+		jobs:    NewJobService(db.(*mongo.Database)),
+	}
+}
+
+// RegisterJobHandlers adds the receipt tracking handler to a worker
+func (s *EVMBlockchainService) RegisterJobHandlers(worker *JobWorker) {
+	worker.Handle(JobConfirmEVMTransaction, s.ConfirmTransaction)
+}
+
+// CreateWallet generates a key pair for the user on the configured network;
+// network is informational since the RPC endpoint decides the chain
+func (s *EVMBlockchainService) CreateWallet(userID primitive.ObjectID, network string) (*models.BlockchainWallet, error) {
+	ctx := context.Background()
+
+	var existing models.BlockchainWallet
+	err := s.db.Collection("blockchain_wallets").FindOne(ctx,
+		bson.M{"user_id": userID, "blockchain": "ethereum", "is_active": true}).Decode(&existing)
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	encryptedKey, err := utils.EncryptPrivateKey(common.Bytes2Hex(crypto.FromECDSA(key)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	wallet := &models.BlockchainWallet{
+		UserID:     userID,
+		Blockchain: "ethereum",
+		Network:    s.network,
+		PublicKey:  crypto.PubkeyToAddress(key.PublicKey).Hex(),
+		PrivateKey: encryptedKey,
+		IsActive:   true,
+		Balances: []models.AssetBalance{
+			{AssetCode: "ETH", Balance: 0.0, Symbol: "ETH", Name: "Ether"},
+			{AssetCode: "USDC", Balance: 0.0, Symbol: "USDC", Name: "USD Coin"},
+		},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	result, err := s.db.Collection("blockchain_wallets").InsertOne(ctx, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save blockchain wallet: %w", err)
+	}
+	wallet.ID = result.InsertedID.(primitive.ObjectID)
+
+	log.Printf("Created Ethereum wallet %s for user %s", wallet.PublicKey, userID.Hex())
+	return wallet, nil
+}
+
+func (s *EVMBlockchainService) GetWallet(userID primitive.ObjectID) (*models.BlockchainWallet, error) {
+	var wallet models.BlockchainWallet
+	err := s.db.Collection("blockchain_wallets").FindOne(context.Background(),
+		bson.M{"user_id": userID, "blockchain": "ethereum", "is_active": true}).Decode(&wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refresh balances from the chain, keeping the stored ones if it can't be reached
+	if s.chain != nil {
+		for i, balance := range wallet.Balances {
+			if amount, err := s.readBalance(context.Background(), wallet.PublicKey, balance.AssetCode); err == nil {
+				wallet.Balances[i].Balance = amount
+			}
+		}
+	}
+	return &wallet, nil
+}
+
+// GetBalance reads the wallet's ETH or USDC balance from the chain
+func (s *EVMBlockchainService) GetBalance(walletID primitive.ObjectID, assetCode string) (float64, error) {
+	wallet, err := s.getWalletByID(walletID)
+	if err != nil {
+		return 0, err
+	}
+	return s.readBalance(context.Background(), wallet.PublicKey, assetCode)
+}
+
+func (s *EVMBlockchainService) readBalance(ctx context.Context, address, assetCode string) (float64, error) {
+	if s.chain == nil {
+		return 0, ErrEVMNotConfigured
+	}
+	asset, err := evmAssetByCode(assetCode)
+	if err != nil {
+		return 0, err
+	}
+	balance, err := s.chain.balance(ctx, common.HexToAddress(address), asset)
+	if err != nil {
+		return 0, err
+	}
+	return fromBaseUnits(balance, asset.decimals), nil
+}
+
+// SendAsset sends ETH or USDC from a user's wallet. The transaction is recorded
+// as pending under its hash before it is broadcast and confirmed from its
+// receipt by a JobConfirmEVMTransaction job. EVM transfers carry no memo; it
+// is kept on the record only.
+func (s *EVMBlockchainService) SendAsset(fromWalletID primitive.ObjectID, toAddress string, amount float64, assetCode string, memo string) (*models.BlockchainTransaction, error) {
+	ctx := context.Background()
+	if s.chain == nil {
+		return nil, ErrEVMNotConfigured
+	}
+
+	wallet, err := s.getWalletByID(fromWalletID)
+	if err != nil {
+		return nil, fmt.Errorf("wallet not found: %w", err)
+	}
+	if !wallet.IsActive {
+		return nil, fmt.Errorf("wallet %s is not active", wallet.ID.Hex())
+	}
+
+	asset, err := evmAssetByCode(assetCode)
+	if err != nil {
+		return nil, err
+	}
+	if !common.IsHexAddress(toAddress) {
+		return nil, fmt.Errorf("%w: invalid Ethereum address %s", ErrInvalidSend, toAddress)
+	}
+	to := common.HexToAddress(toAddress)
+	if to == common.HexToAddress(wallet.PublicKey) {
+		return nil, fmt.Errorf("%w: cannot send to the same wallet", ErrInvalidSend)
+	}
+	value, err := toBaseUnits(amount, asset.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	keyHex, err := utils.DecryptPrivateKey(wallet.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt wallet key: %w", err)
+	}
+	key, err := crypto.HexToECDSA(keyHex)
+	if err != nil || crypto.PubkeyToAddress(key.PublicKey) != common.HexToAddress(wallet.PublicKey) {
+		return nil, fmt.Errorf("wallet %s has an invalid key", wallet.ID.Hex())
+	}
+
+	tx, release, err := s.chain.buildTransfer(ctx, key, to, asset, value)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.BlockchainTransaction{
+		UserID:      wallet.UserID,
+		WalletID:    wallet.ID,
+		Blockchain:  "ethereum",
+		Network:     wallet.Network,
+		FromAddress: wallet.PublicKey,
+		ToAddress:   to.Hex(),
+		Amount:      amount,
+		AssetCode:   asset.code,
+		TxHash:      tx.Hash().Hex(),
+		Status:      "pending",
+		Type:        "send",
+		Memo:        memo,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if asset.token != (common.Address{}) {
+		record.AssetIssuer = asset.token.Hex()
+	}
+	if raw, err := tx.MarshalBinary(); err == nil {
+		// Kept so ConfirmTransaction can rebroadcast a send the node may not have
+		record.Envelope = hexutil.Encode(raw)
+	}
+
+	result, err := s.db.Collection("blockchain_transactions").InsertOne(ctx, record)
+	if err != nil {
+		release(false)
+		return nil, fmt.Errorf("failed to record transaction: %w", err)
+	}
+	record.ID = result.InsertedID.(primitive.ObjectID)
+
+	if err := s.chain.client.SendTransaction(ctx, tx); err != nil && !evmBroadcastUnknown(err) {
+		release(false)
+		s.finishTransaction(ctx, record, "failed", 0, err.Error())
+		return record, fmt.Errorf("failed to broadcast transaction: %w", err)
+	} else if err != nil {
+		// The node may hold the transaction, so its nonce stays taken and the
+		// receipt check settles it like any other send
+		log.Printf("⚠️ Ethereum send %s broadcast outcome unknown: %v", record.TxHash, err)
+	}
+	release(true)
+
+	err = s.jobs.Enqueue(ctx, JobConfirmEVMTransaction, JobConfirmEVMTransaction+":"+record.ID.Hex(),
+		bson.M{"blockchain_transaction_id": record.ID.Hex()}, time.Now().Add(evmReceiptPollDelay))
+	if err != nil {
+		log.Printf("Error queueing receipt check for %s: %v", record.TxHash, err)
+	}
+
+	log.Printf("⛓️ Ethereum send %s: %s %s from %s to %s", record.TxHash, strconv.FormatFloat(amount, 'f', -1, 64), asset.code, wallet.PublicKey, to.Hex())
+	return record, nil
+}
+
+// ConfirmTransaction settles a pending transaction from its receipt
+func (s *EVMBlockchainService) ConfirmTransaction(ctx context.Context, job *models.Job) error {
+	if s.chain == nil {
+		return ErrEVMNotConfigured
+	}
+
+	hex, _ := job.Payload["blockchain_transaction_id"].(string)
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return fmt.Errorf("%w: invalid blockchain_transaction_id %q", ErrPermanentJobFailure, hex)
+	}
+
+	var record models.BlockchainTransaction
+	err = s.db.Collection("blockchain_transactions").FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: blockchain transaction %s not found", ErrPermanentJobFailure, hex)
+	}
+	if err != nil {
+		return err
+	}
+	if record.Status != "pending" {
+		return nil
+	}
+
+	receipt, err := s.chain.client.TransactionReceipt(ctx, common.HexToHash(record.TxHash))
+	if errors.Is(err, ethereum.NotFound) {
+		s.rebroadcast(ctx, &record)
+		if time.Since(record.CreatedAt) < evmConfirmationWindow {
+			return RescheduleJob(evmReceiptPollDelay)
+		}
+		return fmt.Errorf("transaction %s not mined after %s", record.TxHash, time.Since(record.CreatedAt).Round(time.Minute))
+	}
+	if err != nil {
+		return err
+	}
+
+	status, reason := "confirmed", ""
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		status, reason = "failed", "transaction reverted"
+	}
+	s.finishTransaction(ctx, &record, status, receiptFee(receipt), reason)
+	return nil
+}
+
+// rebroadcast resends a pending transaction whose broadcast may not have
+// reached the node. Resending the same signed transaction can't pay twice, and
+// it keeps later sends from queueing behind a missing nonce.
+func (s *EVMBlockchainService) rebroadcast(ctx context.Context, record *models.BlockchainTransaction) {
+	if record.Envelope == "" {
+		return
+	}
+	raw, err := hexutil.Decode(record.Envelope)
+	if err != nil {
+		return
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return
+	}
+	if err := s.chain.client.SendTransaction(ctx, tx); err != nil && !evmBroadcastUnknown(err) {
+		log.Printf("Error rebroadcasting Ethereum send %s: %v", record.TxHash, err)
+	}
+}
+
+func (s *EVMBlockchainService) finishTransaction(ctx context.Context, record *models.BlockchainTransaction, status string, fee float64, reason string) {
+	record.Status = status
+	record.Fee = fee
+	record.FailureReason = reason
+	record.UpdatedAt = time.Now()
+
+	_, err := s.db.Collection("blockchain_transactions").UpdateOne(ctx,
+		bson.M{"_id": record.ID},
+		bson.M{"$set": bson.M{"status": status, "fee": fee, "failure_reason": reason, "updated_at": record.UpdatedAt}})
+	if err != nil {
+		log.Printf("Error updating Ethereum transaction %s to %s: %v", record.TxHash, status, err)
+	}
+}
+
+func (s *EVMBlockchainService) GetTransactions(walletID primitive.ObjectID) ([]models.BlockchainTransaction, error) {
+	var transactions []models.BlockchainTransaction
+	cursor, err := s.db.Collection("blockchain_transactions").Find(context.Background(),
+		bson.M{"wallet_id": walletID, "blockchain": "ethereum"})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	err = cursor.All(context.Background(), &transactions)
+	return transactions, err
+}
+
+func (s *EVMBlockchainService) GetSupportedAssets() ([]models.AssetBalance, error) {
+	return []models.AssetBalance{
+		{AssetCode: "ETH", Symbol: "ETH", Name: "Ether"},
+		{AssetCode: "USDC", Symbol: "USDC", Name: "USD Coin"},
+	}, nil
+}
+
+func (s *EVMBlockchainService) getWalletByID(walletID primitive.ObjectID) (*models.BlockchainWallet, error) {
+	var wallet models.BlockchainWallet
+	err := s.db.Collection("blockchain_wallets").FindOne(context.Background(),
+		bson.M{"_id": walletID, "blockchain": "ethereum"}).Decode(&wallet)
+	return &wallet, err
+}
+
+func evmAssetByCode(assetCode string) (evmAsset, error) {
+	switch strings.ToUpper(strings.TrimSpace(assetCode)) {
+	case "ETH":
+		return evmAsset{code: "ETH", decimals: ethDecimals}, nil
+	case "USDC":
+		return evmAsset{code: "USDC", decimals: usdcDecimals, isToken: true}, nil
+	default:
+		return evmAsset{}, fmt.Errorf("%w: unsupported asset %q", ErrInvalidSend, assetCode)
+	}
+}
+
+// evmAsset - Ether or an ERC-20 token; token is filled in by the chain
+type evmAsset struct {
+	code     string
+	decimals int
+	isToken  bool
+	token    common.Address
+}
+
+// evmChain talks to one EVM chain and hands out nonces for the accounts it
+// sends from, so back-to-back sends don't reuse a nonce before the node has
+// seen the first one
+type evmChain struct {
+	client EVMClient
+	usdc   common.Address
+
+	mu      sync.Mutex
+	chainID *big.Int
+	nonces  map[common.Address]uint64 // Next nonce for each account
+	locks   map[common.Address]*sync.Mutex
+}
+
+func newEVMChain(client EVMClient, usdc common.Address) *evmChain {
+	return &evmChain{
+		client: client,
+		usdc:   usdc,
+		nonces: map[common.Address]uint64{},
+		locks:  map[common.Address]*sync.Mutex{},
+	}
+}
+
+func (c *evmChain) id(ctx context.Context) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.chainID == nil {
+		chainID, err := c.client.ChainID(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chain ID: %w", err)
+		}
+		c.chainID = chainID
+	}
+	return c.chainID, nil
+}
+
+// resolve fills in the token contract of a token asset
+func (c *evmChain) resolve(asset evmAsset) evmAsset {
+	if asset.isToken {
+		asset.token = c.usdc
+	}
+	return asset
+}
+
+// balance returns an account's balance of an asset in base units
+func (c *evmChain) balance(ctx context.Context, account common.Address, asset evmAsset) (*big.Int, error) {
+	asset = c.resolve(asset)
+	if !asset.isToken {
+		return c.client.BalanceAt(ctx, account, nil)
+	}
+
+	data := append(append([]byte{}, erc20BalanceOf...), common.LeftPadBytes(account.Bytes(), 32)...)
+	result, err := c.client.CallContract(ctx, ethereum.CallMsg{To: &asset.token, Data: data}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s balance: %w", asset.code, err)
+	}
+	if len(result) != 32 {
+		return nil, fmt.Errorf("unexpected %s balanceOf result %x", asset.code, result)
+	}
+	return new(big.Int).SetBytes(result), nil
+}
+
+// buildTransfer signs a transfer of value base units of asset, checking that
+// the sender can cover it and the gas. The nonce is reserved until release is
+// called: release(true) once the node accepted the transaction, release(false)
+// to hand the nonce back.
+func (c *evmChain) buildTransfer(ctx context.Context, key *ecdsa.PrivateKey, to common.Address, asset evmAsset, value *big.Int) (*types.Transaction, func(sent bool), error) {
+	asset = c.resolve(asset)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+
+	chainID, err := c.id(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	call := ethereum.CallMsg{From: from, To: &to, Value: value}
+	if asset.isToken {
+		call = ethereum.CallMsg{From: from, To: &asset.token, Data: erc20TransferData(to, value)}
+
+		tokens, err := c.balance(ctx, from, asset)
+		if err != nil {
+			return nil, nil, err
+		}
+		if tokens.Cmp(value) < 0 {
+			return nil, nil, fmt.Errorf("%w: %s balance is %s", ErrInsufficientFunds, asset.code, formatBaseUnits(tokens, asset.decimals))
+		}
+	}
+
+	gas, err := c.client.EstimateGas(ctx, call)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to estimate gas: %w", err)
+	}
+	tip, err := c.client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to suggest gas tip: %w", err)
+	}
+	head, err := c.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read latest block: %w", err)
+	}
+	// Room for the base fee to double before the transaction is mined
+	feeCap := new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+
+	needed := new(big.Int).Mul(feeCap, new(big.Int).SetUint64(gas))
+	if !asset.isToken {
+		needed.Add(needed, value)
+	}
+	eth, err := c.client.BalanceAt(ctx, from, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read ETH balance: %w", err)
+	}
+	if eth.Cmp(needed) < 0 {
+		return nil, nil, fmt.Errorf("%w: ETH balance %s does not cover %s including gas", ErrInsufficientFunds, formatBaseUnits(eth, ethDecimals), formatBaseUnits(needed, ethDecimals))
+	}
+
+	nonce, release, err := c.reserveNonce(ctx, from)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(chainID), &types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+		Gas:       gas,
+		To:        call.To,
+		Value:     call.Value,
+		Data:      call.Data,
+	})
+	if err != nil {
+		release(false)
+		return nil, nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	return tx, release, nil
+}
+
+// reserveNonce locks the account and returns its next nonce: the node's
+// pending nonce, or the one after our last send if the node hasn't caught up
+func (c *evmChain) reserveNonce(ctx context.Context, account common.Address) (uint64, func(sent bool), error) {
+	c.mu.Lock()
+	lock, ok := c.locks[account]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[account] = lock
+	}
+	c.mu.Unlock()
+
+	lock.Lock()
+	pending, err := c.client.PendingNonceAt(ctx, account)
+	if err != nil {
+		lock.Unlock()
+		return 0, nil, fmt.Errorf("failed to read nonce: %w", err)
+	}
+
+	c.mu.Lock()
+	nonce := max(pending, c.nonces[account])
+	c.mu.Unlock()
+
+	var once sync.Once
+	release := func(sent bool) {
+		once.Do(func() {
+			if sent {
+				c.mu.Lock()
+				c.nonces[account] = nonce + 1
+				c.mu.Unlock()
+			}
+			lock.Unlock()
+		})
+	}
+	return nonce, release, nil
+}
+
+// evmBroadcastUnknown reports whether a SendTransaction error leaves it
+// unclear if the node took the transaction: the request timed out or the
+// connection dropped after it was written, or the node already has it.
+func evmBroadcastUnknown(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "already known") || strings.Contains(msg, "known transaction")
+}
+
+func erc20TransferData(to common.Address, value *big.Int) []byte {
+	data := append([]byte{}, erc20Transfer...)
+	data = append(data, common.LeftPadBytes(to.Bytes(), 32)...)
+	return append(data, common.LeftPadBytes(value.Bytes(), 32)...)
+}
+
+// receiptFee returns the fee paid in ETH
+func receiptFee(receipt *types.Receipt) float64 {
+	if receipt.EffectiveGasPrice == nil {
+		return 0
+	}
+	fee := new(big.Int).Mul(receipt.EffectiveGasPrice, new(big.Int).SetUint64(receipt.GasUsed))
+	return fromBaseUnits(fee, ethDecimals)
+}
+
+// toBaseUnits converts an amount in major units to base units (wei, USDC's
+// 6 decimals), rejecting amounts finer than the asset allows
+func toBaseUnits(amount float64, decimals int) (*big.Int, error) {
+	if amount <= 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidSend)
+	}
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(amount, 'f', -1, 64))
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid amount %v", ErrInvalidSend, amount)
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	if !r.IsInt() {
+		return nil, fmt.Errorf("%w: %v has more than %d decimal places", ErrInvalidSend, amount, decimals)
+	}
+	return new(big.Int).Set(r.Num()), nil
+}
+
+func fromBaseUnits(value *big.Int, decimals int) float64 {
+	f, _ := strconv.ParseFloat(formatBaseUnits(value, decimals), 64)
+	return f
+}
+
+// formatBaseUnits formats base units as an exact decimal in major units
+func formatBaseUnits(value *big.Int, decimals int) string {
+	r := new(big.Rat).SetFrac(value, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	return strings.TrimRight(strings.TrimRight(r.FloatString(decimals), "0"), ".")
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestEVMChainTransfers(t *testing.T) {
+	ctx := context.Background()
+	sender, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(sender.PublicKey)
+	to := common.HexToAddress("0x00000000000000000000000000000000000000b0")
+	token := common.HexToAddress("0x00000000000000000000000000000000000000c0")
+
+	backend := simulated.NewBackend(types.GenesisAlloc{
+		from: {Balance: new(big.Int).Mul(big.NewInt(10), big.NewInt(params.Ether))},
+		token: {
+			Balance: big.NewInt(0),
+			Code:    tokenRuntime(),
+			Storage: map[common.Hash]common.Hash{common.BytesToHash(from.Bytes()): common.BigToHash(big.NewInt(100_000_000))},
+		},
+	})
+	defer backend.Close()
+	chain := newEVMChain(backend.Client(), token)
+	usdc, _ := evmAssetByCode("USDC")
+	eth, _ := evmAssetByCode("ETH")
+
+	if balance, err := chain.balance(ctx, from, usdc); err != nil || balance.Int64() != 100_000_000 {
+		t.Fatalf("USDC balance = %v, %v; want 100000000", balance, err)
+	}
+
+	// Two sends before a block is mined must get consecutive nonces
+	var sent []*types.Transaction
+	for _, transfer := range []struct {
+		asset  evmAsset
+		amount float64
+	}{{usdc, 25.5}, {eth, 1.5}} {
+		value, err := toBaseUnits(transfer.amount, transfer.asset.decimals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, release, err := chain.buildTransfer(ctx, sender, to, transfer.asset, value)
+		if err != nil {
+			t.Fatalf("building %s transfer: %v", transfer.asset.code, err)
+		}
+		if err := backend.Client().SendTransaction(ctx, tx); err != nil {
+			release(false)
+			t.Fatalf("sending %s transfer: %v", transfer.asset.code, err)
+		}
+		release(true)
+		sent = append(sent, tx)
+	}
+	if sent[0].Nonce() != 0 || sent[1].Nonce() != 1 {
+		t.Fatalf("nonces = %d, %d; want 0, 1", sent[0].Nonce(), sent[1].Nonce())
+	}
+	// A rebroadcast of a send the node already holds must not read as a failure
+	if err := backend.Client().SendTransaction(ctx, sent[0]); err == nil || !evmBroadcastUnknown(err) {
+		t.Errorf("resending %s error = %v, want an unknown outcome", sent[0].Hash(), err)
+	}
+	backend.Commit()
+
+	for _, tx := range sent {
+		receipt, err := backend.Client().TransactionReceipt(ctx, tx.Hash())
+		if err != nil || receipt.Status != types.ReceiptStatusSuccessful {
+			t.Fatalf("receipt for %s = %+v, %v", tx.Hash(), receipt, err)
+		}
+		if receiptFee(receipt) <= 0 {
+			t.Errorf("receipt fee for %s is not positive", tx.Hash())
+		}
+	}
+	if balance, _ := chain.balance(ctx, to, usdc); balance.Int64() != 25_500_000 {
+		t.Errorf("recipient USDC = %v, want 25500000", balance)
+	}
+	if balance, _ := chain.balance(ctx, to, eth); fromBaseUnits(balance, ethDecimals) != 1.5 {
+		t.Errorf("recipient ETH = %v, want 1.5 ETH", balance)
+	}
+
+	value, _ := toBaseUnits(1000, usdcDecimals)
+	if _, _, err := chain.buildTransfer(ctx, sender, to, usdc, value); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("oversized USDC transfer error = %v, want ErrInsufficientFunds", err)
+	}
+}
+
+func TestToBaseUnits(t *testing.T) {
+	if value, err := toBaseUnits(25.5, usdcDecimals); err != nil || value.Int64() != 25_500_000 {
+		t.Errorf("toBaseUnits(25.5, 6) = %v, %v", value, err)
+	}
+	if _, err := toBaseUnits(0.1234567, usdcDecimals); !errors.Is(err, ErrInvalidSend) {
+		t.Errorf("toBaseUnits(0.1234567, 6) error = %v, want ErrInvalidSend", err)
+	}
+	if got := formatBaseUnits(big.NewInt(1_500_000), usdcDecimals); got != "1.5" {
+		t.Errorf("formatBaseUnits(1500000, 6) = %s, want 1.5", got)
+	}
+}
+
+func TestEVMBroadcastUnknown(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{context.DeadlineExceeded, true},
+		{fmt.Errorf("post: %w", &net.OpError{Op: "read", Err: errors.New("connection reset")}), true},
+		{errors.New("already known"), true},
+		{errors.New("nonce too low"), false},
+		{errors.New("insufficient funds for gas * price + value"), false},
+	} {
+		if got := evmBroadcastUnknown(tc.err); got != tc.want {
+			t.Errorf("evmBroadcastUnknown(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+// tokenRuntime assembles a minimal ERC-20 with balanceOf, transfer and
+// decimals, keeping each balance in the storage slot named by the holder's address
+func tokenRuntime() []byte {
+	var code []byte
+	labels := map[string]int{}
+	jumps := map[int]string{}
+
+	op := func(ops ...vm.OpCode) {
+		for _, o := range ops {
+			code = append(code, byte(o))
+		}
+	}
+	push := func(b ...byte) { code = append(append(code, byte(vm.PUSH1)+byte(len(b)-1)), b...) }
+	jumpIf := func(label string) {
+		push(0, 0)
+		jumps[len(code)-2] = label
+		op(vm.JUMPI)
+	}
+	label := func(name string) {
+		labels[name] = len(code)
+		op(vm.JUMPDEST)
+	}
+	returnWord := func() { push(0); op(vm.MSTORE); push(32); push(0); op(vm.RETURN) }
+
+	// Dispatch on the function selector
+	push(0)
+	op(vm.CALLDATALOAD)
+	push(0xe0)
+	op(vm.SHR)
+	for name, selector := range map[string][]byte{"balanceOf": erc20BalanceOf, "transfer": erc20Transfer, "decimals": {0x31, 0x3c, 0xe5, 0x67}} {
+		op(vm.DUP1)
+		push(selector...)
+		op(vm.EQ)
+		jumpIf(name)
+	}
+	label("revert")
+	push(0)
+	op(vm.DUP1, vm.REVERT)
+
+	label("balanceOf")
+	push(4)
+	op(vm.CALLDATALOAD, vm.SLOAD)
+	returnWord()
+
+	label("decimals")
+	push(usdcDecimals)
+	returnWord()
+
+	label("transfer")
+	op(vm.CALLER, vm.SLOAD) // [balance]
+	push(36)
+	op(vm.CALLDATALOAD)         // [balance, amount]
+	op(vm.DUP1, vm.DUP3, vm.LT) // [balance, amount, balance < amount]
+	jumpIf("revert")            // [balance, amount]
+	op(vm.SWAP1, vm.SUB)        // [balance - amount]
+	op(vm.CALLER, vm.SSTORE)    // []
+	push(4)
+	op(vm.CALLDATALOAD, vm.DUP1, vm.SLOAD) // [to, toBalance]
+	push(36)
+	op(vm.CALLDATALOAD, vm.ADD) // [to, toBalance + amount]
+	op(vm.SWAP1, vm.SSTORE)     // []
+	push(1)
+	returnWord()
+
+	for pos, name := range jumps {
+		code[pos], code[pos+1] = byte(labels[name]>>8), byte(labels[name])
+	}
+	return code
+}
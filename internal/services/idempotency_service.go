@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Keys can be replayed for this long, after which Mongo's TTL monitor removes them
+	idempotencyKeyTTL = 24 * time.Hour
+	// A request still "processing" after this long without its lease being
+	// renewed is taken to have died without a response, e.g. with its server,
+	// and a retry may claim the key
+	idempotencyLease = 5 * time.Minute
+	// How often a running request renews its lease
+	idempotencyLeaseRenewal = time.Minute
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key reused with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key is still in progress")
+	ErrIdempotencyLeaseLost     = errors.New("idempotency key was taken over by another request")
+)
+
+// IdempotencyService stores the first response for each user's Idempotency-Key
+// so retried requests are answered without running them again
+type IdempotencyService struct {
+	db *mongo.Database
+}
+
+func NewIdempotencyService(db *mongo.Database) *IdempotencyService {
+	return &IdempotencyService{db: db}
+}
+
+// EnsureIndexes creates the unique key index and the TTL index on expires_at
+func (s *IdempotencyService) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("idempotency_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create idempotency key indexes: %w", err)
+	}
+	return nil
+}
+
+// Begin claims key for a request. A new claim, or one taken over from a
+// request whose lease ran out, comes back with status "processing"; a key
+// whose request already finished comes back "completed" with the response to
+// replay.
+func (s *IdempotencyService) Begin(ctx context.Context, userID, key, fingerprint, method, path string) (*models.IdempotencyRecord, error) {
+	now := time.Now()
+	record := &models.IdempotencyRecord{
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+		Method:      method,
+		Path:        path,
+		Status:      "processing",
+		LeaseID:     primitive.NewObjectID(),
+		LeaseUntil:  now.Add(idempotencyLease),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(idempotencyKeyTTL),
+	}
+
+	collection := s.db.Collection("idempotency_keys")
+	result, err := collection.InsertOne(ctx, record)
+	if err == nil {
+		record.ID = result.InsertedID.(primitive.ObjectID)
+		return record, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+
+	var existing models.IdempotencyRecord
+	if err := collection.FindOne(ctx, bson.M{"user_id": userID, "key": key}).Decode(&existing); err != nil {
+		return nil, err
+	}
+	if existing.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if existing.Status == "completed" {
+		return &existing, nil
+	}
+
+	// Only one retry may take over, so the claim is swapped on the lease it read
+	filter := bson.M{"_id": existing.ID, "status": "processing", "lease_until": existing.LeaseUntil}
+	leaseUntil := existing.LeaseUntil
+	if leaseUntil.IsZero() {
+		// Claimed before leases were stored
+		leaseUntil = existing.CreatedAt.Add(idempotencyLease)
+		filter["lease_until"] = bson.M{"$exists": false}
+	}
+	if now.Before(leaseUntil) {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	existing.LeaseID = primitive.NewObjectID()
+	existing.LeaseUntil = now.Add(idempotencyLease)
+	update, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"lease_id":    existing.LeaseID,
+		"lease_until": existing.LeaseUntil,
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to take over idempotency key: %w", err)
+	}
+	if update.MatchedCount == 0 {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return &existing, nil
+}
+
+// KeepLease renews the lease on a claim every idempotencyLeaseRenewal until
+// the returned stop is called, so a slow request isn't taken over by a retry
+func (s *IdempotencyService) KeepLease(record *models.IdempotencyRecord) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(idempotencyLeaseRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.renew(context.Background(), record); err != nil {
+					log.Printf("⚠️ Failed to renew lease on idempotency key %s: %v", record.Key, err)
+					if errors.Is(err, ErrIdempotencyLeaseLost) {
+						return
+					}
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (s *IdempotencyService) renew(ctx context.Context, record *models.IdempotencyRecord) error {
+	result, err := s.db.Collection("idempotency_keys").UpdateOne(ctx,
+		bson.M{"_id": record.ID, "lease_id": record.LeaseID, "status": "processing"},
+		bson.M{"$set": bson.M{"lease_until": time.Now().Add(idempotencyLease)}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrIdempotencyLeaseLost
+	}
+	return nil
+}
+
+// Complete stores the response that retries of the request will get. Only
+// the request holding the claim's lease can store it.
+func (s *IdempotencyService) Complete(ctx context.Context, record *models.IdempotencyRecord, status int, contentType string, body []byte) error {
+	result, err := s.db.Collection("idempotency_keys").UpdateOne(ctx, bson.M{"_id": record.ID, "lease_id": record.LeaseID}, bson.M{"$set": bson.M{
+		"status":                "completed",
+		"response_status":       status,
+		"response_content_type": contentType,
+		"response_body":         body,
+		"completed_at":          time.Now(),
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrIdempotencyLeaseLost
+	}
+	return nil
+}
+
+// Release drops a claim whose request never produced a response, so the key can be retried
+func (s *IdempotencyService) Release(ctx context.Context, record *models.IdempotencyRecord) error {
+	_, err := s.db.Collection("idempotency_keys").DeleteOne(ctx, bson.M{"_id": record.ID, "lease_id": record.LeaseID, "status": "processing"})
+	return err
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestIdempotencyBegin(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	duplicate := mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"})
+	leaseID := primitive.NewObjectID()
+	stored := func(fingerprint, status string, leaseUntil time.Time) bson.D {
+		return mtest.CreateCursorResponse(0, "test.idempotency_keys", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "user_id", Value: "user-1"},
+			{Key: "key", Value: "key-1"},
+			{Key: "fingerprint", Value: fingerprint},
+			{Key: "status", Value: status},
+			{Key: "response_status", Value: 200},
+			{Key: "lease_id", Value: leaseID},
+			{Key: "lease_until", Value: leaseUntil},
+			{Key: "created_at", Value: time.Now().Add(-time.Hour)},
+		})
+	}
+	begin := func(mt *mtest.T) (*models.IdempotencyRecord, error) {
+		return NewIdempotencyService(mt.DB).Begin(context.Background(), "user-1", "key-1", "fp", "POST", "/send/money")
+	}
+
+	mt.Run("new key", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		record, err := begin(mt)
+		if err != nil || record.Status != "processing" || record.LeaseID.IsZero() || !record.LeaseUntil.After(time.Now()) {
+			mt.Errorf("Begin = %+v, %v; want a leased processing claim", record, err)
+		}
+	})
+
+	mt.Run("key reused for another request", func(mt *mtest.T) {
+		mt.AddMockResponses(duplicate, stored("other", "completed", time.Time{}))
+		if _, err := begin(mt); !errors.Is(err, ErrIdempotencyKeyReused) {
+			mt.Errorf("error = %v, want ErrIdempotencyKeyReused", err)
+		}
+	})
+
+	mt.Run("finished request is replayed", func(mt *mtest.T) {
+		mt.AddMockResponses(duplicate, stored("fp", "completed", time.Time{}))
+		record, err := begin(mt)
+		if err != nil || record.Status != "completed" || record.ResponseStatus != 200 {
+			mt.Errorf("Begin = %+v, %v; want the stored response", record, err)
+		}
+	})
+
+	mt.Run("running request keeps the key", func(mt *mtest.T) {
+		mt.AddMockResponses(duplicate, stored("fp", "processing", time.Now().Add(time.Minute)))
+		if _, err := begin(mt); !errors.Is(err, ErrIdempotencyKeyInProgress) {
+			mt.Errorf("error = %v, want ErrIdempotencyKeyInProgress", err)
+		}
+	})
+
+	mt.Run("expired lease is taken over", func(mt *mtest.T) {
+		mt.AddMockResponses(duplicate, stored("fp", "processing", time.Now().Add(-time.Minute)), mockUpdated(1))
+		record, err := begin(mt)
+		if err != nil || record.Status != "processing" || record.LeaseID == leaseID {
+			mt.Fatalf("Begin = %+v, %v; want the claim under a new lease", record, err)
+		}
+		var takeover bson.Raw
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName == "update" {
+				takeover = event.Command
+			}
+		}
+		update := takeover.Lookup("updates").Array().Index(0).Value().Document()
+		if _, err := update.LookupErr("q", "lease_until"); err != nil {
+			mt.Errorf("takeover filter = %s, want it fenced on the old lease", update.Lookup("q"))
+		}
+		if update.Lookup("u", "$set", "lease_id").ObjectID() != record.LeaseID {
+			mt.Errorf("takeover update = %s, want lease %s", update.Lookup("u"), record.LeaseID.Hex())
+		}
+	})
+
+	mt.Run("only one retry takes over", func(mt *mtest.T) {
+		mt.AddMockResponses(duplicate, stored("fp", "processing", time.Now().Add(-time.Minute)), mockUpdated(0))
+		if _, err := begin(mt); !errors.Is(err, ErrIdempotencyKeyInProgress) {
+			mt.Errorf("error = %v, want ErrIdempotencyKeyInProgress", err)
+		}
+	})
+}
+
+func TestIdempotencyCompleteNeedsLease(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("taken over", func(mt *mtest.T) {
+		mt.AddMockResponses(mockUpdated(0))
+		record := &models.IdempotencyRecord{ID: primitive.NewObjectID(), LeaseID: primitive.NewObjectID()}
+		err := NewIdempotencyService(mt.DB).Complete(context.Background(), record, 200, "application/json", []byte("{}"))
+		if !errors.Is(err, ErrIdempotencyLeaseLost) {
+			mt.Errorf("error = %v, want ErrIdempotencyLeaseLost", err)
+		}
+		filter := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q").Document()
+		if filter.Lookup("lease_id").ObjectID() != record.LeaseID {
+			mt.Errorf("complete filter = %s, want it fenced on the lease", filter)
+		}
+	})
+}
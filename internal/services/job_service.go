@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Job types
+const (
+	JobMonitorSendCollection  = "send.monitor_collection"
+	JobAllocateSendInvestment = "send.allocate_investment"
+	JobDeliverSend            = "send.deliver"
+)
+
+const (
+	defaultJobMaxAttempts = 8
+	jobLeaseDuration      = 2 * time.Minute
+	jobBaseBackoff        = 10 * time.Second
+	jobMaxBackoff         = 30 * time.Minute
+)
+
+// RescheduleError asks the worker to run the job again after Delay without
+// counting the run as a failed attempt, e.g. while a PSP still reports pending
+type RescheduleError struct {
+	Delay time.Duration
+}
+
+func (e *RescheduleError) Error() string {
+	return fmt.Sprintf("job rescheduled in %s", e.Delay)
+}
+
+// RescheduleJob returns the error a JobHandler uses to poll again later
+func RescheduleJob(delay time.Duration) error {
+	return &RescheduleError{Delay: delay}
+}
+
+// ErrPermanentJobFailure marks a failure that retrying cannot fix; the job
+// is dead-lettered straight away
+var ErrPermanentJobFailure = errors.New("permanent job failure")
+
+// JobService stores jobs in Mongo. Workers claim a due job by taking a lease
+// on it; a job whose lease expired (its worker died) can be claimed again.
+type JobService struct {
+	db *mongo.Database
+}
+
+func NewJobService(db *mongo.Database) *JobService {
+	return &JobService{db: db}
+}
+
+// EnsureIndexes creates the unique key index and the index used to find due jobs
+func (j *JobService) EnsureIndexes(ctx context.Context) error {
+	_, err := j.db.Collection("jobs").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_expires_at", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create job indexes: %w", err)
+	}
+	return nil
+}
+
+// Enqueue schedules a job to run at runAt. Enqueuing a key that already
+// exists is a no-op, so callers racing to schedule the same work are safe.
+func (j *JobService) Enqueue(ctx context.Context, jobType, key string, payload bson.M, runAt time.Time) error {
+	now := time.Now()
+	job := models.Job{
+		Type:        jobType,
+		Key:         key,
+		Payload:     payload,
+		Status:      "pending",
+		MaxAttempts: defaultJobMaxAttempts,
+		RunAt:       runAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	_, err := j.db.Collection("jobs").InsertOne(ctx, job)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to enqueue %s job: %w", jobType, err)
+	}
+	return nil
+}
+
+// Claim leases the next due job of one of jobTypes to workerID. Returns
+// mongo.ErrNoDocuments when nothing is due.
+func (j *JobService) Claim(ctx context.Context, workerID string, jobTypes []string) (*models.Job, error) {
+	now := time.Now()
+	filter := bson.M{
+		"type": bson.M{"$in": jobTypes},
+		"$or": []bson.M{
+			{"status": "pending", "run_at": bson.M{"$lte": now}},
+			{"status": "running", "lease_expires_at": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":           "running",
+			"lease_owner":      workerID,
+			"lease_expires_at": now.Add(jobLeaseDuration),
+			"updated_at":       now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "run_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job models.Job
+	if err := j.db.Collection("jobs").FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Complete marks a claimed job done
+func (j *JobService) Complete(ctx context.Context, job *models.Job) error {
+	now := time.Now()
+	return j.finish(ctx, job, bson.M{"$set": bson.M{
+		"status":       "completed",
+		"completed_at": now,
+		"updated_at":   now,
+	}, "$unset": bson.M{"lease_owner": "", "lease_expires_at": ""}})
+}
+
+// Fail records a failed run. The job is retried with exponential backoff
+// until it runs out of attempts, then moved to the dead-letter state.
+func (j *JobService) Fail(ctx context.Context, job *models.Job, runErr error) (dead bool, err error) {
+	dead = job.Attempts >= job.MaxAttempts || errors.Is(runErr, ErrPermanentJobFailure)
+
+	set := bson.M{"last_error": runErr.Error(), "updated_at": time.Now()}
+	if dead {
+		set["status"] = "dead"
+	} else {
+		set["status"] = "pending"
+		set["run_at"] = time.Now().Add(jobBackoff(job.Attempts))
+	}
+	return dead, j.finish(ctx, job, bson.M{"$set": set, "$unset": bson.M{"lease_owner": "", "lease_expires_at": ""}})
+}
+
+// Reschedule puts a job back to run after delay, giving back the attempt it used
+func (j *JobService) Reschedule(ctx context.Context, job *models.Job, delay time.Duration) error {
+	return j.finish(ctx, job, bson.M{
+		"$set": bson.M{
+			"status":     "pending",
+			"run_at":     time.Now().Add(delay),
+			"updated_at": time.Now(),
+		},
+		"$inc":   bson.M{"attempts": -1},
+		"$unset": bson.M{"lease_owner": "", "lease_expires_at": "", "last_error": ""},
+	})
+}
+
+// IsQueued reports whether the job with key is still waiting to run or running
+func (j *JobService) IsQueued(ctx context.Context, key string) (bool, error) {
+	count, err := j.db.Collection("jobs").CountDocuments(ctx, bson.M{
+		"key":    key,
+		"status": bson.M{"$in": []string{"pending", "running"}},
+	})
+	return count > 0, err
+}
+
+// Requeue moves a dead-lettered job back to pending with fresh attempts
+func (j *JobService) Requeue(ctx context.Context, id primitive.ObjectID) error {
+	result, err := j.db.Collection("jobs").UpdateOne(ctx, bson.M{"_id": id, "status": "dead"}, bson.M{"$set": bson.M{
+		"status":     "pending",
+		"attempts":   0,
+		"run_at":     time.Now(),
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// finish applies update only while the caller still holds the lease, so a
+// worker whose lease expired can't overwrite the run that replaced it
+func (j *JobService) finish(ctx context.Context, job *models.Job, update bson.M) error {
+	result, err := j.db.Collection("jobs").UpdateOne(ctx, bson.M{
+		"_id":         job.ID,
+		"status":      "running",
+		"lease_owner": job.LeaseOwner,
+	}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("lease on job %s was lost", job.ID.Hex())
+	}
+	return nil
+}
+
+func jobBackoff(attempts int) time.Duration {
+	backoff := time.Duration(float64(jobBaseBackoff) * math.Pow(2, float64(attempts-1)))
+	if backoff > jobMaxBackoff || backoff <= 0 {
+		return jobMaxBackoff
+	}
+	return backoff
+}
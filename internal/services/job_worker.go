@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// JobHandler runs one job. Returning RescheduleJob(delay) runs it again
+// later; any other error is retried with backoff.
+type JobHandler func(ctx context.Context, job *models.Job) error
+
+// JobWorker claims due jobs and runs them. Several workers, in one process or
+// across replicas, can share the jobs collection.
+type JobWorker struct {
+	jobs     *JobService
+	workerID string
+	handlers map[string]JobHandler
+	ticker   *time.Ticker
+	stopChan chan bool
+}
+
+func NewJobWorker(db *mongo.Database) *JobWorker {
+	worker := &JobWorker{
+		jobs:     NewJobService(db),
+		workerID: newWorkerID(),
+		handlers: map[string]JobHandler{},
+		stopChan: make(chan bool),
+	}
+	NewSettlementService(db).RegisterJobHandlers(worker)
+	NewRefundService(db).RegisterJobHandlers(worker)
+	NewEVMBlockchainService(db).RegisterJobHandlers(worker)
+	NewBitcoinBlockchainService(db).RegisterJobHandlers(worker)
+	NewStellarBlockchainService(db).RegisterJobHandlers(worker)
+	return worker
+}
+
+// newWorkerID identifies this process as a lease owner
+func newWorkerID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
+
+// Handle registers the handler for a job type
+func (w *JobWorker) Handle(jobType string, handler JobHandler) {
+	w.handlers[jobType] = handler
+}
+
+func (w *JobWorker) Start() {
+	log.Printf("Starting job worker %s (every 5 seconds)...", w.workerID)
+	w.ticker = time.NewTicker(5 * time.Second)
+
+	go func() {
+		for {
+			select {
+			case <-w.ticker.C:
+				w.runDueJobs()
+			case <-w.stopChan:
+				w.ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (w *JobWorker) Stop() {
+	log.Println("Stopping job worker...")
+	w.stopChan <- true
+}
+
+// runDueJobs drains the jobs that are due, one at a time
+func (w *JobWorker) runDueJobs() {
+	jobTypes := make([]string, 0, len(w.handlers))
+	for jobType := range w.handlers {
+		jobTypes = append(jobTypes, jobType)
+	}
+
+	for {
+		job, err := w.jobs.Claim(context.Background(), w.workerID, jobTypes)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error claiming job: %v", err)
+			return
+		}
+		w.run(job)
+	}
+}
+
+func (w *JobWorker) run(job *models.Job) {
+	// Finish well inside the lease so no other worker picks the job up meanwhile
+	ctx, cancel := context.WithTimeout(context.Background(), jobLeaseDuration/2)
+	defer cancel()
+
+	err := w.handlers[job.Type](ctx, job)
+
+	var reschedule *RescheduleError
+	switch {
+	case err == nil:
+		err = w.jobs.Complete(context.Background(), job)
+	case errors.As(err, &reschedule):
+		err = w.jobs.Reschedule(context.Background(), job, reschedule.Delay)
+	default:
+		dead, failErr := w.jobs.Fail(context.Background(), job, err)
+		if dead {
+			log.Printf("☠️ Job %s (%s) moved to dead letter after %d attempts: %v", job.Key, job.Type, job.Attempts, err)
+		} else {
+			log.Printf("⚠️ Job %s (%s) failed, will retry: %v", job.Key, job.Type, err)
+		}
+		err = failErr
+	}
+	if err != nil {
+		log.Printf("Error updating job %s: %v", job.Key, err)
+	}
+}
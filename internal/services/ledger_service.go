@@ -0,0 +1,541 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Platform ledger accounts. User accounts are derived with WalletAccountCode
+// and InvestmentAccountCode.
+const (
+	LedgerAccountPSPClearing    = "psp_clearing"         // Money held at PSPs after collection
+	LedgerAccountInTransit      = "transfers_in_transit" // Sends collected but not yet delivered
+	LedgerAccountPayouts        = "payouts"              // Money paid out to external rails
+	LedgerAccountFunding        = "manual_funding"       // Manual top-ups through /wallet/add-funds
+	LedgerAccountStellarCustody = "stellar_custody"      // USDC held in the Stellar custody account
+	LedgerAccountOpeningBalance = "opening_balances"     // Wallet balances that predate the ledger
+
+	walletAccountPrefix     = "wallet:"
+	investmentAccountPrefix = "investments:"
+)
+
+var (
+	ErrUnbalancedEntry = errors.New("journal entry does not balance")
+	ErrDuplicateEntry  = errors.New("journal entry already posted")
+	// Rebuilding before PostOpeningBalances would zero every pre-ledger wallet
+	ErrOpeningBalancesMissing = errors.New("opening balances not posted; run scripts/opening_balances first")
+)
+
+// Marks PostOpeningBalances as done in ledger_migrations
+const openingBalancesMigration = "opening_balances"
+
+// LedgerService records every wallet balance change as a balanced journal entry.
+// Journal entries are never updated or deleted; mistakes are corrected with a
+// reversing entry. Wallet.Balance is a projection of the journal.
+type LedgerService struct {
+	db *mongo.Database
+}
+
+func NewLedgerService(db *mongo.Database) *LedgerService {
+	return &LedgerService{db: db}
+}
+
+func WalletAccountCode(userID primitive.ObjectID) string {
+	return walletAccountPrefix + userID.Hex()
+}
+
+func InvestmentAccountCode(userID primitive.ObjectID) string {
+	return investmentAccountPrefix + userID.Hex()
+}
+
+// EnsureIndexes creates the unique indexes the ledger relies on for idempotency
+func (l *LedgerService) EnsureIndexes(ctx context.Context) error {
+	_, err := l.db.Collection("journal_entries").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "reference", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "postings.account_code", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create journal indexes: %w", err)
+	}
+
+	_, err = l.db.Collection("ledger_accounts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create ledger account index: %w", err)
+	}
+	return nil
+}
+
+// Post validates and records a journal entry, updating wallet projections in the
+// same Mongo transaction. Posting the same reference twice returns ErrDuplicateEntry,
+// and debiting a wallet below zero returns ErrInsufficientFunds.
+func (l *LedgerService) Post(ctx context.Context, entry *models.JournalEntry) error {
+	if err := validateEntry(entry); err != nil {
+		return err
+	}
+
+	session, err := l.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start ledger session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, l.post(sc, entry)
+	})
+	return err
+}
+
+// PostInTransaction is Post for callers that need the entry committed together
+// with their own writes; sc must be inside a session transaction
+func (l *LedgerService) PostInTransaction(sc mongo.SessionContext, entry *models.JournalEntry) error {
+	if err := validateEntry(entry); err != nil {
+		return err
+	}
+	return l.post(sc, entry)
+}
+
+func (l *LedgerService) post(sc mongo.SessionContext, entry *models.JournalEntry) error {
+	entry.ID = primitive.NewObjectID()
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	for _, posting := range entry.Postings {
+		if err := l.ensureAccount(sc, posting.AccountCode); err != nil {
+			return err
+		}
+	}
+
+	if _, err := l.db.Collection("journal_entries").InsertOne(sc, entry); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateEntry
+		}
+		return fmt.Errorf("failed to insert journal entry: %w", err)
+	}
+
+	for _, posting := range entry.Postings {
+		if err := l.applyProjection(sc, entry.Currency, posting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transfer posts a two-line entry moving amount from one account to another
+func (l *LedgerService) Transfer(ctx context.Context, reference, entryType, description, fromAccount, toAccount string, amount models.Money) error {
+	return l.Post(ctx, &models.JournalEntry{
+		Reference:   reference,
+		Type:        entryType,
+		Description: description,
+		Currency:    amount.Currency,
+		Postings: []models.Posting{
+			{AccountCode: fromAccount, Direction: "debit", Amount: amount.Minor},
+			{AccountCode: toAccount, Direction: "credit", Amount: amount.Minor},
+		},
+	})
+}
+
+// PostDepositInTransaction records a collected deposit split between the
+// user's wallet and investments; sc must be inside a session transaction
+func (l *LedgerService) PostDepositInTransaction(sc mongo.SessionContext, depositID, userID primitive.ObjectID, savings, investment models.Money) error {
+	total, err := savings.Add(investment)
+	if err != nil {
+		return err
+	}
+
+	postings := []models.Posting{
+		{AccountCode: LedgerAccountPSPClearing, Direction: "debit", Amount: total.Minor},
+	}
+	if savings.IsPositive() {
+		postings = append(postings, models.Posting{AccountCode: WalletAccountCode(userID), Direction: "credit", Amount: savings.Minor})
+	}
+	if investment.IsPositive() {
+		postings = append(postings, models.Posting{AccountCode: InvestmentAccountCode(userID), Direction: "credit", Amount: investment.Minor})
+	}
+
+	return l.PostInTransaction(sc, &models.JournalEntry{
+		Reference:   "deposit:" + depositID.Hex(),
+		Type:        "deposit",
+		Description: "Mobile money deposit collected",
+		Currency:    total.Currency,
+		Postings:    postings,
+	})
+}
+
+// GetAccountBalance returns the credit-normal balance of an account in one currency
+func (l *LedgerService) GetAccountBalance(ctx context.Context, accountCode, currency string) (models.Money, error) {
+	balances, err := l.aggregateBalances(ctx, bson.M{"postings.account_code": accountCode})
+	if err != nil {
+		return models.Money{}, err
+	}
+	return models.NewMoney(balances[accountCode][currency], currency), nil
+}
+
+// RebuildWalletBalance recomputes a single wallet projection from the journal
+func (l *LedgerService) RebuildWalletBalance(ctx context.Context, userID primitive.ObjectID) error {
+	posted, err := l.OpeningBalancesPosted(ctx)
+	if err != nil {
+		return err
+	}
+	if !posted {
+		return ErrOpeningBalancesMissing
+	}
+
+	var wallet models.Wallet
+	if err := l.db.Collection("wallets").FindOne(ctx, bson.M{"user_id": userID}).Decode(&wallet); err != nil {
+		return fmt.Errorf("failed to fetch wallet: %w", err)
+	}
+
+	balance, err := l.GetAccountBalance(ctx, WalletAccountCode(userID), wallet.Balance.Currency)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.db.Collection("wallets").UpdateOne(
+		ctx,
+		bson.M{"_id": wallet.ID},
+		bson.M{"$set": bson.M{"balance": balance, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// PostOpeningBalances is a one-time migration that journals the part of each
+// wallet balance the ledger doesn't explain, against the opening balances
+// equity account. The wallet projection already holds that money, so it is
+// left as is. Run it before the first RebuildAllWalletBalances; re-running it
+// skips wallets that already have an opening entry. Returns how many entries
+// were posted.
+func (l *LedgerService) PostOpeningBalances(ctx context.Context) (int, error) {
+	cursor, err := l.db.Collection("wallets").Find(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch wallets: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	session, err := l.db.Client().StartSession()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start ledger session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	posted := 0
+	for cursor.Next(ctx) {
+		var wallet models.Wallet
+		if err := cursor.Decode(&wallet); err != nil {
+			// A float balance migrate_money left for manual review
+			return posted, fmt.Errorf("failed to read wallet %v: %w", cursor.Current.Lookup("_id"), err)
+		}
+
+		_, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, l.postOpeningBalance(sc, wallet.ID)
+		})
+		if errors.Is(err, ErrDuplicateEntry) || errors.Is(err, errNoOpeningBalance) {
+			continue
+		}
+		if err != nil {
+			return posted, fmt.Errorf("failed to post opening balance for wallet %s: %w", wallet.ID.Hex(), err)
+		}
+		posted++
+	}
+	if err := cursor.Err(); err != nil {
+		return posted, err
+	}
+
+	_, err = l.db.Collection("ledger_migrations").UpdateOne(ctx,
+		bson.M{"_id": openingBalancesMigration},
+		bson.M{"$set": bson.M{"completed_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return posted, fmt.Errorf("failed to record opening balance migration: %w", err)
+	}
+	return posted, nil
+}
+
+var errNoOpeningBalance = errors.New("wallet balance matches the journal")
+
+func (l *LedgerService) postOpeningBalance(sc mongo.SessionContext, walletID primitive.ObjectID) error {
+	// Touching the wallet makes a concurrent posting conflict with this
+	// transaction, so the balance and the journal are read together
+	var wallet models.Wallet
+	err := l.db.Collection("wallets").FindOneAndUpdate(sc,
+		bson.M{"_id": walletID},
+		bson.M{"$set": bson.M{"updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&wallet)
+	if err != nil {
+		return fmt.Errorf("failed to fetch wallet: %w", err)
+	}
+
+	account := WalletAccountCode(wallet.UserID)
+	currency := wallet.Balance.Currency
+	balances, err := l.aggregateBalances(sc, bson.M{"postings.account_code": account})
+	if err != nil {
+		return err
+	}
+	opening := wallet.Balance.Minor - balances[account][currency]
+	if opening == 0 {
+		return errNoOpeningBalance
+	}
+
+	walletSide, equitySide := "credit", "debit"
+	if opening < 0 {
+		walletSide, equitySide, opening = "debit", "credit", -opening
+	}
+	entry := &models.JournalEntry{
+		ID:          primitive.NewObjectID(),
+		Reference:   "opening_balance:" + wallet.ID.Hex(),
+		Type:        "opening_balance",
+		Description: "Wallet balance carried over from before the ledger",
+		Currency:    currency,
+		Postings: []models.Posting{
+			{AccountCode: LedgerAccountOpeningBalance, Direction: equitySide, Amount: opening},
+			{AccountCode: account, Direction: walletSide, Amount: opening},
+		},
+		CreatedAt: time.Now(),
+	}
+	if err := validateEntry(entry); err != nil {
+		return err
+	}
+	for _, posting := range entry.Postings {
+		if err := l.ensureAccount(sc, posting.AccountCode); err != nil {
+			return err
+		}
+	}
+	// Inserted without applyProjection: the wallet already holds this money
+	if _, err := l.db.Collection("journal_entries").InsertOne(sc, entry); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateEntry
+		}
+		return fmt.Errorf("failed to insert journal entry: %w", err)
+	}
+	return nil
+}
+
+// OpeningBalancesPosted reports whether PostOpeningBalances has completed
+func (l *LedgerService) OpeningBalancesPosted(ctx context.Context) (bool, error) {
+	count, err := l.db.Collection("ledger_migrations").CountDocuments(ctx, bson.M{"_id": openingBalancesMigration})
+	if err != nil {
+		return false, fmt.Errorf("failed to check ledger migrations: %w", err)
+	}
+	return count > 0, nil
+}
+
+// RebuildAllWalletBalances recomputes every wallet projection and returns how
+// many changed. It refuses to run until PostOpeningBalances has completed.
+func (l *LedgerService) RebuildAllWalletBalances(ctx context.Context) (int, error) {
+	posted, err := l.OpeningBalancesPosted(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !posted {
+		return 0, ErrOpeningBalancesMissing
+	}
+
+	balances, err := l.aggregateBalances(ctx, bson.M{
+		"postings.account_code": bson.M{"$regex": "^" + walletAccountPrefix},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := l.db.Collection("wallets").Find(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch wallets: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	changed := 0
+	for cursor.Next(ctx) {
+		var wallet models.Wallet
+		if err := cursor.Decode(&wallet); err != nil {
+			return changed, err
+		}
+
+		currency := wallet.Balance.Currency
+		balance := models.NewMoney(balances[WalletAccountCode(wallet.UserID)][currency], currency)
+		if balance == wallet.Balance {
+			continue
+		}
+
+		_, err := l.db.Collection("wallets").UpdateOne(
+			ctx,
+			bson.M{"_id": wallet.ID},
+			bson.M{"$set": bson.M{"balance": balance, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			return changed, fmt.Errorf("failed to update wallet %s: %w", wallet.ID.Hex(), err)
+		}
+		changed++
+	}
+	return changed, cursor.Err()
+}
+
+// Helper methods
+func (l *LedgerService) ensureAccount(ctx context.Context, code string) error {
+	account := bson.M{"code": code, "type": accountType(code), "created_at": time.Now()}
+	if userID, ok := accountOwner(code); ok {
+		account["user_id"] = userID
+	}
+
+	_, err := l.db.Collection("ledger_accounts").UpdateOne(
+		ctx,
+		bson.M{"code": code},
+		bson.M{"$setOnInsert": account},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to ensure ledger account %s: %w", code, err)
+	}
+	return nil
+}
+
+func (l *LedgerService) applyProjection(ctx context.Context, currency string, posting models.Posting) error {
+	if !strings.HasPrefix(posting.AccountCode, walletAccountPrefix) {
+		return nil
+	}
+	userID, _ := accountOwner(posting.AccountCode)
+
+	filter := bson.M{"user_id": userID, "balance.currency": currency}
+	delta := posting.Amount
+	if posting.Direction == "debit" {
+		delta = -delta
+		// Checked in the update itself, so concurrent debits can't overdraw
+		filter["balance.minor"] = bson.M{"$gte": posting.Amount}
+	}
+
+	result, err := l.db.Collection("wallets").UpdateOne(
+		ctx,
+		filter,
+		bson.M{
+			"$inc": bson.M{"balance.minor": delta},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update wallet projection: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		exists, err := l.db.Collection("wallets").CountDocuments(ctx, bson.M{"user_id": userID, "balance.currency": currency})
+		if err != nil {
+			return fmt.Errorf("failed to update wallet projection: %w", err)
+		}
+		if exists > 0 {
+			return ErrInsufficientFunds
+		}
+		return fmt.Errorf("no %s wallet for user %s", currency, userID.Hex())
+	}
+	return nil
+}
+
+// aggregateBalances returns credit-normal balances (credits minus debits) per account and currency
+func (l *LedgerService) aggregateBalances(ctx context.Context, match bson.M) (map[string]map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$postings"}},
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"account": "$postings.account_code", "currency": "$currency"},
+			"balance": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$postings.direction", "credit"}},
+				"$postings.amount",
+				bson.M{"$multiply": bson.A{"$postings.amount", -1}},
+			}}},
+		}}},
+	}
+
+	cursor, err := l.db.Collection("journal_entries").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate journal: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		ID struct {
+			AccountCode string `bson:"account"`
+			Currency    string `bson:"currency"`
+		} `bson:"_id"`
+		Balance int64 `bson:"balance"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	balances := make(map[string]map[string]int64, len(results))
+	for _, result := range results {
+		if balances[result.ID.AccountCode] == nil {
+			balances[result.ID.AccountCode] = make(map[string]int64)
+		}
+		balances[result.ID.AccountCode][result.ID.Currency] = result.Balance
+	}
+	return balances, nil
+}
+
+func validateEntry(entry *models.JournalEntry) error {
+	if entry.Reference == "" {
+		return fmt.Errorf("journal entry reference is required")
+	}
+	if entry.Currency == "" {
+		return fmt.Errorf("journal entry currency is required")
+	}
+	if len(entry.Postings) < 2 {
+		return fmt.Errorf("journal entry needs at least two postings")
+	}
+
+	var debits, credits int64
+	for _, posting := range entry.Postings {
+		if posting.AccountCode == "" {
+			return fmt.Errorf("posting account is required")
+		}
+		if posting.Amount <= 0 {
+			return fmt.Errorf("posting amount must be positive")
+		}
+		switch posting.Direction {
+		case "debit":
+			debits += posting.Amount
+		case "credit":
+			credits += posting.Amount
+		default:
+			return fmt.Errorf("invalid posting direction: %s", posting.Direction)
+		}
+	}
+
+	if debits != credits {
+		return fmt.Errorf("%w: debits %d, credits %d", ErrUnbalancedEntry, debits, credits)
+	}
+	return nil
+}
+
+func accountType(code string) string {
+	switch {
+	case strings.HasPrefix(code, walletAccountPrefix), strings.HasPrefix(code, investmentAccountPrefix):
+		return "liability"
+	case code == LedgerAccountFunding, code == LedgerAccountOpeningBalance:
+		return "equity"
+	default:
+		return "asset"
+	}
+}
+
+func accountOwner(code string) (primitive.ObjectID, bool) {
+	for _, prefix := range []string{walletAccountPrefix, investmentAccountPrefix} {
+		if strings.HasPrefix(code, prefix) {
+			userID, err := primitive.ObjectIDFromHex(strings.TrimPrefix(code, prefix))
+			return userID, err == nil
+		}
+	}
+	return primitive.NilObjectID, false
+}
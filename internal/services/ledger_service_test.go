@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestValidateEntry(t *testing.T) {
+	balanced := func() *models.JournalEntry {
+		return &models.JournalEntry{
+			Reference: "send:1",
+			Currency:  "GHS",
+			Postings: []models.Posting{
+				{AccountCode: LedgerAccountInTransit, Direction: "credit", Amount: 500},
+				{AccountCode: LedgerAccountFunding, Direction: "debit", Amount: 500},
+			},
+		}
+	}
+	if err := validateEntry(balanced()); err != nil {
+		t.Fatalf("balanced entry: %v", err)
+	}
+
+	unbalanced := balanced()
+	unbalanced.Postings[1].Amount = 499
+	if err := validateEntry(unbalanced); !errors.Is(err, ErrUnbalancedEntry) {
+		t.Errorf("unbalanced entry error = %v, want ErrUnbalancedEntry", err)
+	}
+
+	for name, broken := range map[string]func(*models.JournalEntry){
+		"no reference":      func(e *models.JournalEntry) { e.Reference = "" },
+		"no currency":       func(e *models.JournalEntry) { e.Currency = "" },
+		"one posting":       func(e *models.JournalEntry) { e.Postings = e.Postings[:1] },
+		"zero amount":       func(e *models.JournalEntry) { e.Postings[0].Amount, e.Postings[1].Amount = 0, 0 },
+		"negative amount":   func(e *models.JournalEntry) { e.Postings[0].Amount, e.Postings[1].Amount = -5, -5 },
+		"unknown direction": func(e *models.JournalEntry) { e.Postings[0].Direction = "sideways" },
+		"no account":        func(e *models.JournalEntry) { e.Postings[0].AccountCode = "" },
+	} {
+		entry := balanced()
+		broken(entry)
+		if err := validateEntry(entry); err == nil {
+			t.Errorf("%s: entry accepted", name)
+		}
+	}
+}
+
+// mockCount answers a CountDocuments with n
+func mockCount(n int) bson.D {
+	return mtest.CreateCursorResponse(0, "test.wallets", mtest.FirstBatch, bson.D{{Key: "n", Value: n}})
+}
+
+// mockUpdated answers an UpdateOne that matched n documents
+func mockUpdated(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
+
+func TestApplyProjection(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	userID := primitive.NewObjectID()
+	wallet := WalletAccountCode(userID)
+
+	mt.Run("debit checks the balance in the update", func(mt *mtest.T) {
+		mt.AddMockResponses(mockUpdated(1))
+		err := NewLedgerService(mt.DB).applyProjection(context.Background(), "GHS", models.Posting{AccountCode: wallet, Direction: "debit", Amount: 700})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		filter := update.Lookup("q").Document()
+		if filter.Lookup("balance.minor", "$gte").AsInt64() != 700 || filter.Lookup("balance.currency").StringValue() != "GHS" {
+			mt.Errorf("debit filter = %s, want a GHS balance of at least 700", filter)
+		}
+		if update.Lookup("u", "$inc", "balance.minor").AsInt64() != -700 {
+			mt.Errorf("debit update = %s, want -700", update.Lookup("u"))
+		}
+	})
+
+	mt.Run("debit beyond the balance", func(mt *mtest.T) {
+		mt.AddMockResponses(mockUpdated(0), mockCount(1))
+		err := NewLedgerService(mt.DB).applyProjection(context.Background(), "GHS", models.Posting{AccountCode: wallet, Direction: "debit", Amount: 700})
+		if !errors.Is(err, ErrInsufficientFunds) {
+			mt.Errorf("error = %v, want ErrInsufficientFunds", err)
+		}
+	})
+
+	mt.Run("currency the wallet isn't in", func(mt *mtest.T) {
+		mt.AddMockResponses(mockUpdated(0), mockCount(0))
+		err := NewLedgerService(mt.DB).applyProjection(context.Background(), "USD", models.Posting{AccountCode: wallet, Direction: "credit", Amount: 700})
+		if err == nil || errors.Is(err, ErrInsufficientFunds) || !strings.Contains(err.Error(), "no USD wallet") {
+			mt.Errorf("error = %v, want no USD wallet", err)
+		}
+	})
+
+	mt.Run("accounts other than wallets have no projection", func(mt *mtest.T) {
+		err := NewLedgerService(mt.DB).applyProjection(context.Background(), "GHS", models.Posting{AccountCode: LedgerAccountInTransit, Direction: "debit", Amount: 700})
+		if err != nil || mt.GetStartedEvent() != nil {
+			mt.Errorf("in-transit posting = %v with a command sent", err)
+		}
+	})
+}
+
+func TestPostDuplicateReference(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("duplicate", func(mt *mtest.T) {
+		// Two account upserts, then the insert hits the unique reference index
+		mt.AddMockResponses(mockUpdated(1), mockUpdated(1),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}))
+
+		entry := &models.JournalEntry{
+			Reference: "send:1",
+			Currency:  "GHS",
+			Postings: []models.Posting{
+				{AccountCode: WalletAccountCode(primitive.NewObjectID()), Direction: "debit", Amount: 500},
+				{AccountCode: LedgerAccountInTransit, Direction: "credit", Amount: 500},
+			},
+		}
+		err := mt.Client.UseSession(context.Background(), func(sc mongo.SessionContext) error {
+			return NewLedgerService(mt.DB).PostInTransaction(sc, entry)
+		})
+		if !errors.Is(err, ErrDuplicateEntry) {
+			mt.Errorf("error = %v, want ErrDuplicateEntry", err)
+		}
+		// The wallet must not be debited a second time
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName == "update" && event.Command.Lookup("update").StringValue() == "wallets" {
+				mt.Error("wallet projection updated for a duplicate entry")
+			}
+		}
+	})
+}
+
+func TestRebuildAllWalletBalances(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("refused before opening balances", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCount(0))
+		if _, err := NewLedgerService(mt.DB).RebuildAllWalletBalances(context.Background()); !errors.Is(err, ErrOpeningBalancesMissing) {
+			mt.Errorf("error = %v, want ErrOpeningBalancesMissing", err)
+		}
+	})
+
+	mt.Run("rewrites wallets that drifted", func(mt *mtest.T) {
+		drifted, current := primitive.NewObjectID(), primitive.NewObjectID()
+		wallet := func(userID primitive.ObjectID, minor int64) bson.D {
+			return bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "user_id", Value: userID},
+				{Key: "balance", Value: bson.D{{Key: "minor", Value: minor}, {Key: "currency", Value: "GHS"}}},
+				{Key: "currency", Value: "GHS"},
+			}
+		}
+		balance := func(userID primitive.ObjectID, minor int64) bson.D {
+			return bson.D{
+				{Key: "_id", Value: bson.D{{Key: "account", Value: WalletAccountCode(userID)}, {Key: "currency", Value: "GHS"}}},
+				{Key: "balance", Value: minor},
+			}
+		}
+		mt.AddMockResponses(
+			mockCount(1),
+			mtest.CreateCursorResponse(0, "test.journal_entries", mtest.FirstBatch, balance(drifted, 1500), balance(current, 800)),
+			mtest.CreateCursorResponse(0, "test.wallets", mtest.FirstBatch, wallet(drifted, 2000), wallet(current, 800)),
+			mockUpdated(1),
+		)
+
+		changed, err := NewLedgerService(mt.DB).RebuildAllWalletBalances(context.Background())
+		if err != nil || changed != 1 {
+			mt.Fatalf("RebuildAllWalletBalances = %d, %v; want 1 wallet changed", changed, err)
+		}
+		var last *bson.Raw
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName == "update" {
+				last = &event.Command
+			}
+		}
+		if last == nil {
+			mt.Fatal("no wallet update sent")
+		}
+		set := last.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set", "balance").Document()
+		if set.Lookup("minor").AsInt64() != 1500 {
+			mt.Errorf("rebuilt balance = %s, want 1500", set)
+		}
+	})
+}
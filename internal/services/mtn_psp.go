@@ -1,76 +1,457 @@
 package services
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	mtnProductCollection   = "collection"
+	mtnProductDisbursement = "disbursement"
+
+	// Tokens are refreshed this long before MoMo expires them
+	mtnTokenExpiryMargin = 60 * time.Second
+)
+
+// mtnReferenceNamespace seeds the name-based X-Reference-Id, so retrying a
+// request with the same reference reuses the ID of the first attempt and MoMo
+// rejects it as a duplicate instead of charging or paying out twice
+var mtnReferenceNamespace = []byte("healthy_pay/mtn-momo/")
+
+// MTNCredentials are the API user, API key and subscription key provisioned
+// for one MoMo product (Collections or Disbursements)
+type MTNCredentials struct {
+	UserID          string
+	APIKey          string
+	SubscriptionKey string
+}
+
+func (c MTNCredentials) configured() bool {
+	return c.UserID != "" && c.APIKey != "" && c.SubscriptionKey != ""
+}
+
+type MTNConfig struct {
+	BaseURL           string
+	TargetEnvironment string // "sandbox" or the live environment MTN assigns, e.g. "mtnghana"
+	CountryCode       string // Prefix for local phone numbers, e.g. "233"
+	Collection        MTNCredentials
+	Disbursement      MTNCredentials
+}
+
+// MTNAPIError is an error response documented by the MoMo API, e.g.
+// PAYER_NOT_FOUND, NOT_ENOUGH_FUNDS or RESOURCE_ALREADY_EXIST
+type MTNAPIError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *MTNAPIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("mtn momo: %d %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("mtn momo: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Retryable reports whether the same request may succeed if sent again later
+func (e *MTNAPIError) Retryable() bool {
+	switch e.Code {
+	case "SERVICE_UNAVAILABLE", "INTERNAL_PROCESSING_ERROR", "COULD_NOT_PERFORM_TRANSACTION":
+		return true
+	}
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// rejected reports whether MoMo refused the request itself, e.g. an unknown
+// payee, so sending it again can't succeed. Our credentials being refused is
+// not the request's fault and is left to the caller's retries.
+func (e *MTNAPIError) rejected() bool {
+	return !e.Retryable() && e.StatusCode != http.StatusUnauthorized && e.StatusCode != http.StatusForbidden
+}
+
+type mtnToken struct {
+	accessToken string
+	expiresAt   time.Time
+}
+
 // MTNPSP implements PSPProvider interface for MTN MoMo API
 type MTNPSP struct {
-	apiKey    string
-	userID    string
-	baseURL   string
-	subscriptionKey string
+	config MTNConfig
+	client *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]mtnToken // Per product
+}
+
+type mtnParty struct {
+	PartyIDType string `json:"partyIdType"`
+	PartyID     string `json:"partyId"`
+}
+
+type mtnRequestToPay struct {
+	Amount       string   `json:"amount"`
+	Currency     string   `json:"currency"`
+	ExternalID   string   `json:"externalId"`
+	Payer        mtnParty `json:"payer"`
+	PayerMessage string   `json:"payerMessage"`
+	PayeeNote    string   `json:"payeeNote"`
+}
+
+type mtnTransfer struct {
+	Amount       string   `json:"amount"`
+	Currency     string   `json:"currency"`
+	ExternalID   string   `json:"externalId"`
+	Payee        mtnParty `json:"payee"`
+	PayerMessage string   `json:"payerMessage"`
+	PayeeNote    string   `json:"payeeNote"`
 }
 
-func NewMTNPSP(apiKey, userID, baseURL, subscriptionKey string) *MTNPSP {
+type mtnTransactionStatus struct {
+	Amount                 string          `json:"amount"`
+	Currency               string          `json:"currency"`
+	FinancialTransactionID string          `json:"financialTransactionId"`
+	ExternalID             string          `json:"externalId"`
+	Status                 string          `json:"status"`
+	Reason                 json.RawMessage `json:"reason,omitempty"`
+}
+
+func NewMTNPSP(config MTNConfig) *MTNPSP {
+	if config.TargetEnvironment == "" {
+		config.TargetEnvironment = "sandbox"
+	}
+	if config.CountryCode == "" {
+		config.CountryCode = "233"
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+
 	return &MTNPSP{
-		apiKey:    apiKey,
-		userID:    userID,
-		baseURL:   baseURL,
-		subscriptionKey: subscriptionKey,
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+		tokens: make(map[string]mtnToken),
 	}
 }
 
 // NewMTNPSPFromEnv creates MTNPSP from environment variables
 func NewMTNPSPFromEnv() *MTNPSP {
-	apiKey := os.Getenv("MTN_API_KEY")
-	userID := os.Getenv("MTN_USER_ID")
-	baseURL := os.Getenv("MTN_BASE_URL")
-	subscriptionKey := os.Getenv("MTN_SUBSCRIPTION_KEY")
-	
-	if apiKey == "" || userID == "" {
+	config := MTNConfig{
+		BaseURL:           os.Getenv("MTN_BASE_URL"),
+		TargetEnvironment: os.Getenv("MTN_TARGET_ENVIRONMENT"),
+		CountryCode:       os.Getenv("MTN_COUNTRY_CODE"),
+		Collection: MTNCredentials{
+			UserID:          os.Getenv("MTN_USER_ID"),
+			APIKey:          os.Getenv("MTN_API_KEY"),
+			SubscriptionKey: os.Getenv("MTN_SUBSCRIPTION_KEY"),
+		},
+		Disbursement: MTNCredentials{
+			UserID:          os.Getenv("MTN_DISBURSEMENT_USER_ID"),
+			APIKey:          os.Getenv("MTN_DISBURSEMENT_API_KEY"),
+			SubscriptionKey: os.Getenv("MTN_DISBURSEMENT_SUBSCRIPTION_KEY"),
+		},
+	}
+
+	if !config.Collection.configured() {
 		return nil // Return nil if required credentials not configured
 	}
-	
-	if baseURL == "" {
-		baseURL = "https://sandbox.momodeveloper.mtn.com" // Default sandbox URL
+
+	if config.BaseURL == "" {
+		config.BaseURL = "https://sandbox.momodeveloper.mtn.com" // Default sandbox URL
 	}
-	
-	return NewMTNPSP(apiKey, userID, baseURL, subscriptionKey)
+
+	return NewMTNPSP(config)
 }
 
 func (m *MTNPSP) GetName() string {
 	return "mtn"
 }
 
+// InitiateCollection sends a requesttopay; the payer approves it on their phone.
+// The X-Reference-Id is returned as the transaction ID for status polling.
 func (m *MTNPSP) InitiateCollection(req CollectionRequest) (*CollectionResponse, error) {
-	// TODO: Implement MTN MoMo collection API
-	// This would integrate with MTN's Collection API
-	
-	// Simulate MTN API call
-	transactionID := fmt.Sprintf("MTN_%d", time.Now().Unix())
-	
+	if !m.config.Collection.configured() {
+		return nil, fmt.Errorf("MTN collections not configured")
+	}
+
+	referenceID := mtnReferenceID(mtnProductCollection, req.Reference)
+	payload := mtnRequestToPay{
+		Amount:     req.Amount.Decimal(),
+		Currency:   req.Amount.Currency,
+		ExternalID: req.Reference,
+		Payer: mtnParty{
+			PartyIDType: "MSISDN",
+			PartyID:     m.msisdn(req.PhoneNumber),
+		},
+		PayerMessage: "Siha payment",
+		PayeeNote:    req.Reference,
+	}
+
+	err := m.do(mtnProductCollection, "POST", "/collection/v1_0/requesttopay", referenceID, payload, nil)
+	if err != nil && !isMTNDuplicate(err) {
+		return nil, err
+	}
+
 	return &CollectionResponse{
-		TransactionID: transactionID,
+		TransactionID: referenceID,
 		Status:        "pending",
-		Message:       "MTN collection initiated successfully",
+		Message:       "MTN collection initiated, awaiting payer approval",
+		RawResponse:   map[string]string{"referenceId": referenceID, "externalId": req.Reference},
 	}, nil
 }
 
 func (m *MTNPSP) CheckCollectionStatus(transactionID string) (string, error) {
-	// TODO: Implement MTN MoMo status check API
-	// This would call MTN's transaction status endpoint
-	
-	// Simulate status check
-	return "collected", nil
+	var status mtnTransactionStatus
+	if err := m.do(mtnProductCollection, "GET", "/collection/v1_0/requesttopay/"+transactionID, "", nil, &status); err != nil {
+		return "", err
+	}
+
+	switch strings.ToUpper(status.Status) {
+	case "SUCCESSFUL":
+		return "collected", nil
+	case "FAILED", "REJECTED", "TIMEOUT":
+		log.Printf("MTN collection %s failed: %s", transactionID, status.reasonCode())
+		return "failed", nil
+	default:
+		return "pending", nil
+	}
 }
 
+// InitiateDelivery sends a Disbursements transfer to the recipient's wallet
 func (m *MTNPSP) InitiateDelivery(req DeliveryRequest) error {
-	// TODO: Implement MTN MoMo disbursement API
-	// This would integrate with MTN's Disbursement API
-	
-	fmt.Printf("MTN PSP: Delivering ₵%.2f to %s\n", req.Amount, req.RecipientAccount)
-	return nil
+	if !m.config.Disbursement.configured() {
+		return fmt.Errorf("MTN disbursements not configured")
+	}
+
+	payload := mtnTransfer{
+		Amount:     req.Amount.Decimal(),
+		Currency:   req.Amount.Currency,
+		ExternalID: req.Reference,
+		Payee: mtnParty{
+			PartyIDType: "MSISDN",
+			PartyID:     m.msisdn(req.RecipientAccount),
+		},
+		PayerMessage: "Siha transfer",
+		PayeeNote:    req.Reference,
+	}
+
+	err := m.do(mtnProductDisbursement, "POST", "/disbursement/v1_0/transfer", mtnReferenceID(mtnProductDisbursement, req.Reference), payload, nil)
+	if isMTNDuplicate(err) {
+		return nil
+	}
+	var apiErr *MTNAPIError
+	if errors.As(err, &apiErr) && apiErr.rejected() {
+		return fmt.Errorf("%w: %w", ErrDeliveryRejected, err)
+	}
+	return err
+}
+
+// CheckDeliveryStatus looks up a transfer by our delivery reference. The
+// X-Reference-Id is derived from it, so nothing else needs to be stored.
+func (m *MTNPSP) CheckDeliveryStatus(reference string) (string, error) {
+	var status mtnTransactionStatus
+	path := "/disbursement/v1_0/transfer/" + mtnReferenceID(mtnProductDisbursement, reference)
+	err := m.do(mtnProductDisbursement, "GET", path, "", nil, &status)
+	var apiErr *MTNAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		// MTN never received a transfer under this reference
+		return "not_found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToUpper(status.Status) {
+	case "SUCCESSFUL":
+		return "delivered", nil
+	case "FAILED", "REJECTED", "TIMEOUT":
+		log.Printf("MTN transfer %s failed: %s", reference, status.reasonCode())
+		return "failed", nil
+	default:
+		return "pending", nil
+	}
+}
+
+// reasonCode reads the failure reason, which MoMo sends either as a bare
+// code or as {"code": ..., "message": ...}
+func (s mtnTransactionStatus) reasonCode() string {
+	if len(s.Reason) == 0 {
+		return ""
+	}
+	var code string
+	if err := json.Unmarshal(s.Reason, &code); err == nil {
+		return code
+	}
+	var reason struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(s.Reason, &reason)
+	return reason.Code
+}
+
+func (m *MTNPSP) credentials(product string) MTNCredentials {
+	if product == mtnProductDisbursement {
+		return m.config.Disbursement
+	}
+	return m.config.Collection
+}
+
+// token returns a cached access token for product, fetching a new one when
+// it is missing, about to expire or refresh is set
+func (m *MTNPSP) token(product string, refresh bool) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cached, ok := m.tokens[product]; ok && !refresh && time.Now().Before(cached.expiresAt) {
+		return cached.accessToken, nil
+	}
+
+	creds := m.credentials(product)
+	req, err := http.NewRequest("POST", m.config.BaseURL+"/"+product+"/token/", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(creds.UserID, creds.APIKey)
+	req.Header.Set("Ocp-Apim-Subscription-Key", creds.SubscriptionKey)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", parseMTNError(resp.StatusCode, body)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("invalid MTN token response: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("missing access token in MTN token response")
+	}
+
+	lifetime := time.Duration(result.ExpiresIn)*time.Second - mtnTokenExpiryMargin
+	if lifetime < 0 {
+		lifetime = 0
+	}
+	m.tokens[product] = mtnToken{accessToken: result.AccessToken, expiresAt: time.Now().Add(lifetime)}
+	return result.AccessToken, nil
+}
+
+// do sends an authenticated MoMo API request and decodes the response into
+// out. A 401 refreshes the token and retries once.
+func (m *MTNPSP) do(product, method, endpoint, referenceID string, payload, out interface{}) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		// Log request payload to endpoint
+		log.Printf("PSP Request to %s%s: %s", m.config.BaseURL, endpoint, string(body))
+	}
+
+	for attempt := 0; ; attempt++ {
+		accessToken, err := m.token(product, attempt > 0)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(method, m.config.BaseURL+endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		req.Header.Set("X-Target-Environment", m.config.TargetEnvironment)
+		req.Header.Set("Ocp-Apim-Subscription-Key", m.credentials(product).SubscriptionKey)
+		req.Header.Set("Content-Type", "application/json")
+		if referenceID != "" {
+			req.Header.Set("X-Reference-Id", referenceID)
+		}
+
+		resp, err := m.client.Do(req)
+		if err != nil {
+			return err
+		}
+		responseBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		// Log response from endpoint
+		log.Printf("PSP Response from %s%s: %d %s", m.config.BaseURL, endpoint, resp.StatusCode, string(responseBody))
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return parseMTNError(resp.StatusCode, responseBody)
+		}
+		if out != nil {
+			if err := json.Unmarshal(responseBody, out); err != nil {
+				return fmt.Errorf("invalid MTN response: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// msisdn puts a phone number in the international format MoMo expects
+func (m *MTNPSP) msisdn(phone string) string {
+	phone = strings.NewReplacer(" ", "", "-", "").Replace(phone)
+	phone = strings.TrimPrefix(phone, "+")
+	if strings.HasPrefix(phone, "0") {
+		return m.config.CountryCode + phone[1:]
+	}
+	return phone
+}
+
+func parseMTNError(statusCode int, body []byte) error {
+	apiErr := &MTNAPIError{StatusCode: statusCode}
+	json.Unmarshal(body, apiErr)
+	if apiErr.Code == "" {
+		apiErr.Code = strings.ToUpper(strings.ReplaceAll(http.StatusText(statusCode), " ", "_"))
+	}
+	return apiErr
+}
+
+func isMTNDuplicate(err error) bool {
+	apiErr, ok := err.(*MTNAPIError)
+	return ok && (apiErr.StatusCode == http.StatusConflict || apiErr.Code == "RESOURCE_ALREADY_EXIST")
+}
+
+// mtnReferenceID derives a version 5 style UUID from our reference. Requests
+// without a reference get a random version 4 UUID.
+func mtnReferenceID(product, reference string) string {
+	var id [16]byte
+	if reference == "" {
+		rand.Read(id[:])
+		id[6] = (id[6] & 0x0f) | 0x40
+	} else {
+		sum := sha1.Sum(append(append([]byte{}, mtnReferenceNamespace...), product+":"+reference...))
+		copy(id[:], sum[:16])
+		id[6] = (id[6] & 0x0f) | 0x50
+	}
+	id[8] = (id[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
 }
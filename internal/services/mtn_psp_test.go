@@ -0,0 +1,327 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"healthy_pay_backend/internal/models"
+)
+
+// momoSandbox is a local stand-in for the MoMo sandbox. It issues tokens,
+// stores requesttopay and transfer requests by X-Reference-Id and answers
+// status lookups with whatever status the test sets.
+type momoSandbox struct {
+	mu           sync.Mutex
+	tokensIssued map[string]int
+	validToken   map[string]bool
+	requests     map[string]json.RawMessage
+	statuses     map[string]string
+	failWith     map[string]string // Path -> error code
+}
+
+func newMoMoSandbox(t *testing.T) (*momoSandbox, *httptest.Server) {
+	sandbox := &momoSandbox{
+		tokensIssued: make(map[string]int),
+		validToken:   make(map[string]bool),
+		requests:     make(map[string]json.RawMessage),
+		statuses:     make(map[string]string),
+		failWith:     make(map[string]string),
+	}
+	server := httptest.NewServer(http.HandlerFunc(sandbox.serve))
+	t.Cleanup(server.Close)
+	return sandbox, server
+}
+
+func (s *momoSandbox) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")[0]
+	if r.Header.Get("Ocp-Apim-Subscription-Key") != product+"-sub-key" {
+		writeMoMoError(w, http.StatusUnauthorized, "", "invalid subscription key")
+		return
+	}
+
+	if r.URL.Path == "/"+product+"/token/" {
+		user, key, ok := r.BasicAuth()
+		if !ok || user != product+"-user" || key != product+"-key" {
+			writeMoMoError(w, http.StatusUnauthorized, "", "invalid credentials")
+			return
+		}
+		s.tokensIssued[product]++
+		token := product + "-token-" + string(rune('0'+s.tokensIssued[product]))
+		s.validToken[token] = true
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": token,
+			"token_type":   "access_token",
+			"expires_in":   3600,
+		})
+		return
+	}
+
+	if !s.validToken[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		writeMoMoError(w, http.StatusUnauthorized, "", "access token expired")
+		return
+	}
+	if r.Header.Get("X-Target-Environment") != "sandbox" {
+		writeMoMoError(w, http.StatusBadRequest, "NOT_ALLOWED_TARGET_ENVIRONMENT", "")
+		return
+	}
+	if code, ok := s.failWith[r.URL.Path]; ok {
+		writeMoMoError(w, http.StatusBadRequest, code, "rejected by stand-in")
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		referenceID := r.Header.Get("X-Reference-Id")
+		if referenceID == "" {
+			writeMoMoError(w, http.StatusBadRequest, "", "missing X-Reference-Id")
+			return
+		}
+		if _, exists := s.requests[referenceID]; exists {
+			writeMoMoError(w, http.StatusConflict, "RESOURCE_ALREADY_EXIST", "duplicated reference id")
+			return
+		}
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		s.requests[referenceID] = body
+		s.statuses[referenceID] = "PENDING"
+		w.WriteHeader(http.StatusAccepted)
+	case "GET":
+		referenceID := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		status, exists := s.statuses[referenceID]
+		if !exists {
+			writeMoMoError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "requested resource was not found")
+			return
+		}
+		response := map[string]interface{}{"status": status}
+		if status == "FAILED" {
+			response["reason"] = "APPROVAL_REJECTED"
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func (s *momoSandbox) setStatus(referenceID, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[referenceID] = status
+}
+
+// expireTokens makes the sandbox reject every token issued so far
+func (s *momoSandbox) expireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.validToken = make(map[string]bool)
+}
+
+func writeMoMoError(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "message": message})
+}
+
+func newTestMTNPSP(baseURL string) *MTNPSP {
+	return NewMTNPSP(MTNConfig{
+		BaseURL: baseURL,
+		Collection: MTNCredentials{
+			UserID:          "collection-user",
+			APIKey:          "collection-key",
+			SubscriptionKey: "collection-sub-key",
+		},
+		Disbursement: MTNCredentials{
+			UserID:          "disbursement-user",
+			APIKey:          "disbursement-key",
+			SubscriptionKey: "disbursement-sub-key",
+		},
+	})
+}
+
+func TestMTNCollectionLifecycle(t *testing.T) {
+	sandbox, server := newMoMoSandbox(t)
+	mtn := newTestMTNPSP(server.URL)
+
+	resp, err := mtn.InitiateCollection(CollectionRequest{
+		Amount:      models.NewMoney(1250, "GHS"),
+		PhoneNumber: "024 400 0000",
+		Provider:    "MTN",
+		Reference:   "send-1",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var body mtnRequestToPay
+	json.Unmarshal(sandbox.requests[resp.TransactionID], &body)
+	if body.Amount != "12.50" || body.Currency != "GHS" {
+		t.Errorf("Expected amount 12.50 GHS, got %s %s", body.Amount, body.Currency)
+	}
+	if body.Payer.PartyID != "233244000000" || body.ExternalID != "send-1" {
+		t.Errorf("Expected payer 233244000000 and externalId send-1, got %s %s", body.Payer.PartyID, body.ExternalID)
+	}
+
+	status, err := mtn.CheckCollectionStatus(resp.TransactionID)
+	if err != nil || status != "pending" {
+		t.Errorf("Expected pending, got %s (%v)", status, err)
+	}
+
+	sandbox.setStatus(resp.TransactionID, "SUCCESSFUL")
+	if status, _ := mtn.CheckCollectionStatus(resp.TransactionID); status != "collected" {
+		t.Errorf("Expected collected, got %s", status)
+	}
+
+	sandbox.setStatus(resp.TransactionID, "FAILED")
+	if status, _ := mtn.CheckCollectionStatus(resp.TransactionID); status != "failed" {
+		t.Errorf("Expected failed, got %s", status)
+	}
+
+	if sandbox.tokensIssued["collection"] != 1 {
+		t.Errorf("Expected the token to be fetched once and cached, got %d fetches", sandbox.tokensIssued["collection"])
+	}
+}
+
+func TestMTNRetriedCollectionReusesReferenceID(t *testing.T) {
+	sandbox, server := newMoMoSandbox(t)
+	mtn := newTestMTNPSP(server.URL)
+
+	req := CollectionRequest{Amount: models.NewMoney(100, "GHS"), PhoneNumber: "0244000000", Reference: "deposit-1"}
+	first, err := mtn.InitiateCollection(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The stand-in answers the retry with 409 RESOURCE_ALREADY_EXIST
+	second, err := mtn.InitiateCollection(req)
+	if err != nil {
+		t.Fatalf("Expected a duplicate request to be accepted, got %v", err)
+	}
+	if first.TransactionID != second.TransactionID || len(sandbox.requests) != 1 {
+		t.Errorf("Expected one request under one reference ID, got %s and %s", first.TransactionID, second.TransactionID)
+	}
+}
+
+func TestMTNExpiredTokenIsRefreshed(t *testing.T) {
+	sandbox, server := newMoMoSandbox(t)
+	mtn := newTestMTNPSP(server.URL)
+
+	resp, err := mtn.InitiateCollection(CollectionRequest{Amount: models.NewMoney(100, "GHS"), PhoneNumber: "0244000000", Reference: "send-2"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	sandbox.expireTokens()
+	if _, err := mtn.CheckCollectionStatus(resp.TransactionID); err != nil {
+		t.Fatalf("Expected the request to be retried with a new token, got %v", err)
+	}
+	if sandbox.tokensIssued["collection"] != 2 {
+		t.Errorf("Expected 2 token fetches, got %d", sandbox.tokensIssued["collection"])
+	}
+}
+
+func TestMTNErrorCodes(t *testing.T) {
+	sandbox, server := newMoMoSandbox(t)
+	mtn := newTestMTNPSP(server.URL)
+
+	sandbox.failWith["/collection/v1_0/requesttopay"] = "PAYER_NOT_FOUND"
+	_, err := mtn.InitiateCollection(CollectionRequest{Amount: models.NewMoney(100, "GHS"), PhoneNumber: "0244000000", Reference: "send-3"})
+
+	var apiErr *MTNAPIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "PAYER_NOT_FOUND" || apiErr.Retryable() {
+		t.Errorf("Expected a non-retryable PAYER_NOT_FOUND error, got %v", err)
+	}
+
+	_, err = mtn.CheckCollectionStatus("00000000-0000-4000-8000-000000000000")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != "RESOURCE_NOT_FOUND" {
+		t.Errorf("Expected RESOURCE_NOT_FOUND, got %v", err)
+	}
+
+	badCredentials := newTestMTNPSP(server.URL)
+	badCredentials.config.Collection.APIKey = "wrong"
+	if _, err := badCredentials.CheckCollectionStatus("any"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 from the token endpoint, got %v", err)
+	}
+}
+
+func TestMTNDisbursement(t *testing.T) {
+	sandbox, server := newMoMoSandbox(t)
+	mtn := newTestMTNPSP(server.URL)
+
+	req := DeliveryRequest{
+		Amount:           models.NewMoney(5000, "GHS"),
+		RecipientType:    "mobile_money",
+		RecipientAccount: "+233 24 111 2222",
+		Reference:        "64b7f0c2e4b0a1a2b3c4d5e6",
+	}
+	if err := mtn.InitiateDelivery(req); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// A retried payout must not reach the recipient twice
+	if err := mtn.InitiateDelivery(req); err != nil || len(sandbox.requests) != 1 {
+		t.Errorf("Expected the retry to be absorbed as a duplicate, got %v with %d requests", err, len(sandbox.requests))
+	}
+
+	referenceID := mtnReferenceID(mtnProductDisbursement, req.Reference)
+	var body mtnTransfer
+	json.Unmarshal(sandbox.requests[referenceID], &body)
+	if body.Amount != "50.00" || body.Payee.PartyID != "233241112222" {
+		t.Errorf("Expected 50.00 to 233241112222, got %s to %s", body.Amount, body.Payee.PartyID)
+	}
+
+	sandbox.setStatus(referenceID, "SUCCESSFUL")
+	if status, err := mtn.CheckDeliveryStatus(req.Reference); err != nil || status != "delivered" {
+		t.Errorf("Expected delivered, got %s (%v)", status, err)
+	}
+	// A refund settling a failed payout relies on this to know nothing was sent
+	if status, err := mtn.CheckDeliveryStatus("64b7f0c2e4b0a1a2b3c4d5e7"); err != nil || status != "not_found" {
+		t.Errorf("Expected not_found for a reference MTN never saw, got %s (%v)", status, err)
+	}
+	if sandbox.tokensIssued["disbursement"] != 1 || sandbox.tokensIssued["collection"] != 0 {
+		t.Errorf("Expected disbursement requests to use the disbursement token only, got %v", sandbox.tokensIssued)
+	}
+
+	// A payee MoMo refuses is rejected for good, so the send is refunded
+	sandbox.failWith["/disbursement/v1_0/transfer"] = "PAYEE_NOT_FOUND"
+	req.Reference = "64b7f0c2e4b0a1a2b3c4d5e8"
+	var apiErr *MTNAPIError
+	if err := mtn.InitiateDelivery(req); !errors.Is(err, ErrDeliveryRejected) || !errors.As(err, &apiErr) || apiErr.Code != "PAYEE_NOT_FOUND" {
+		t.Errorf("Expected a rejected delivery for PAYEE_NOT_FOUND, got %v", err)
+	}
+}
+
+func TestMTNAPIErrorRejected(t *testing.T) {
+	for _, tc := range []struct {
+		err      MTNAPIError
+		rejected bool
+	}{
+		{MTNAPIError{StatusCode: http.StatusBadRequest, Code: "PAYEE_NOT_FOUND"}, true},
+		{MTNAPIError{StatusCode: http.StatusInternalServerError, Code: "INTERNAL_PROCESSING_ERROR"}, false},
+		{MTNAPIError{StatusCode: http.StatusBadRequest, Code: "COULD_NOT_PERFORM_TRANSACTION"}, false},
+		{MTNAPIError{StatusCode: http.StatusTooManyRequests, Code: "TOO_MANY_REQUESTS"}, false},
+		{MTNAPIError{StatusCode: http.StatusUnauthorized, Code: "UNAUTHORIZED"}, false},
+	} {
+		if got := tc.err.rejected(); got != tc.rejected {
+			t.Errorf("%d %s rejected = %v, want %v", tc.err.StatusCode, tc.err.Code, got, tc.rejected)
+		}
+	}
+}
+
+func TestMTNReferenceID(t *testing.T) {
+	id := mtnReferenceID(mtnProductCollection, "send-1")
+	if id != mtnReferenceID(mtnProductCollection, "send-1") {
+		t.Error("Expected the same reference to give the same ID")
+	}
+	if id == mtnReferenceID(mtnProductDisbursement, "send-1") {
+		t.Error("Expected collection and disbursement IDs to differ")
+	}
+	if len(id) != 36 || id[14] != '5' || !strings.ContainsRune("89ab", rune(id[19])) {
+		t.Errorf("Expected a version 5 UUID, got %s", id)
+	}
+	if random := mtnReferenceID(mtnProductCollection, ""); random[14] != '4' {
+		t.Errorf("Expected a version 4 UUID without a reference, got %s", random)
+	}
+}
@@ -2,6 +2,9 @@ package services
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 type Currency string
@@ -88,18 +92,21 @@ type OgateApiCollectionResponseModel struct {
 	ClosingBalance  int         `json:"closing_balance"`
 }
 
-// OgatePSP implements the PSPProvider interface
+// OgatePSP implements the PSPProvider and WebhookProvider interfaces
 type OgatePSP struct {
-	baseURL string
-	apiKey  string
-	client  *http.Client
+	baseURL       string
+	apiKey        string
+	webhookSecret string
+	callbackURL   string
+	client        *http.Client
 }
 
 func NewOgatePSP(baseURL, apiKey string) *OgatePSP {
 	return &OgatePSP{
-		baseURL: baseURL,
-		apiKey:  apiKey,
-		client:  &http.Client{},
+		baseURL:     baseURL,
+		apiKey:      apiKey,
+		callbackURL: pspCallbackURL("ogate"),
+		client:      &http.Client{Timeout: 30 * time.Second},
 	}
 }
 
@@ -116,7 +123,9 @@ func NewOgatePSPFromEnv() *OgatePSP {
 		return nil // Return nil if no API key configured
 	}
 	
-	return NewOgatePSP(baseURL, apiKey)
+	ogate := NewOgatePSP(baseURL, apiKey)
+	ogate.webhookSecret = os.Getenv("OGATE_WEBHOOK_SECRET")
+	return ogate
 }
 
 func (o *OgatePSP) GetName() string {
@@ -125,13 +134,14 @@ func (o *OgatePSP) GetName() string {
 
 func (o *OgatePSP) InitiateCollection(req CollectionRequest) (*CollectionResponse, error) {
 	payload := OgateApiCollectionRequestModel{
-		Amount:        int(req.Amount), // Convert to pesewas
+		Amount:        int(req.Amount.Minor), // Ogate takes pesewas
 		Reason:        fmt.Sprintf("Collection with reference %s", req.Reference),
-		Currency:      string(GHS),
+		Currency:      req.Amount.Currency,
 		Network:       o.getNetwork(req.Provider),
 		AccountName:   "Customer",
 		AccountNumber: req.PhoneNumber,
 		Reference:     req.Reference,
+		CallbackURL:   o.callbackURL,
 	}
 
 	rawResponse, err := o.makeRequest("POST", "/collections/mobilemoney", payload)
@@ -196,19 +206,76 @@ func (o *OgatePSP) InitiateDelivery(req DeliveryRequest) error {
 		}{
 			{
 				Network:       o.getNetwork(req.RecipientNetwork),
-				Currency:      string(GHS),
-				Amount:        int(req.Amount * 100), // Convert to pesewas
+				Currency:      req.Amount.Currency,
+				Amount:        int(req.Amount.Minor), // Ogate takes pesewas
 				AccountName:   "Recipient",
 				AccountNumber: req.RecipientAccount,
 			},
 		},
-		Reference: req.Reference,
+		Reference:   req.Reference,
+		CallbackURL: o.callbackURL,
 	}
 
 	_, err := o.makeRequest("POST", "/disbursements/mobilemoney", payload)
 	return err
 }
 
+// VerifyWebhook checks the hex HMAC-SHA256 of the raw body in X-Ogate-Signature
+func (o *OgatePSP) VerifyWebhook(headers http.Header, body []byte) error {
+	if o.webhookSecret == "" {
+		return fmt.Errorf("ogate webhook secret not configured")
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(headers.Get("X-Ogate-Signature"), "sha256="))
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("missing or malformed signature")
+	}
+
+	mac := hmac.New(sha256.New, []byte(o.webhookSecret))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func (o *OgatePSP) ParseWebhook(headers http.Header, body []byte) (*WebhookEvent, error) {
+	var payload OgateApiCollectionResponseModel
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+	if payload.ID == "" || payload.Status == "" {
+		return nil, fmt.Errorf("webhook payload missing id or status")
+	}
+
+	event := &WebhookEvent{
+		EventID:          headers.Get("X-Ogate-Event-Id"),
+		Kind:             "collection",
+		PSPTransactionID: payload.ID,
+		Reference:        payload.Reference,
+		Status:           "pending",
+	}
+	// Ogate retries send the same event ID; without one a status change is the event
+	if event.EventID == "" {
+		event.EventID = payload.ID + ":" + payload.Status
+	}
+
+	if strings.Contains(strings.ToUpper(payload.Type), "DISBURSE") || strings.Contains(strings.ToUpper(payload.Type), "PAYOUT") {
+		event.Kind = "payout"
+	}
+
+	switch TransactionState(strings.ToUpper(payload.Status)) {
+	case Completed, Success:
+		event.Status = "collected"
+		if event.Kind == "payout" {
+			event.Status = "delivered"
+		}
+	case Failed, Cancelled, Expired, ErrorOccurred:
+		event.Status = "failed"
+	}
+	return event, nil
+}
+
 func (o *OgatePSP) getNetwork(network string) string {
 	switch strings.ToUpper(network) {
 	case "MTN":
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AuditPINLockout = "pin_lockout"
+	AuditPINChange  = "pin_change"
+	AuditPINReset   = "pin_reset"
+)
+
+const (
+	// Wrong PINs in a row before the PIN locks
+	pinMaxAttempts = 5
+	// Wrong codes allowed per emailed reset code
+	pinResetMaxAttempts = 5
+	pinResetCodeTTL     = 15 * time.Minute
+	// Minimum time between reset codes
+	pinResetRequestInterval = time.Minute
+)
+
+// Each lockout in a row lasts longer, up to the last duration
+var pinLockoutDurations = []time.Duration{5 * time.Minute, 15 * time.Minute, time.Hour, 24 * time.Hour}
+
+var (
+	ErrPINNotSet        = errors.New("PIN not set")
+	ErrPINAlreadySet    = errors.New("PIN already set")
+	ErrInvalidPIN       = errors.New("invalid PIN")
+	ErrPINLocked        = errors.New("PIN locked")
+	ErrInvalidPINFormat = errors.New("PIN must be exactly 4 digits")
+	ErrInvalidResetCode = errors.New("invalid or expired reset code")
+
+	pinFormat = regexp.MustCompile(`^[0-9]{4}$`)
+)
+
+// InvalidPINError is returned for a wrong PIN that didn't lock it
+type InvalidPINError struct {
+	AttemptsRemaining int
+}
+
+func (e *InvalidPINError) Error() string {
+	return fmt.Sprintf("invalid PIN, %d attempts remaining", e.AttemptsRemaining)
+}
+
+func (e *InvalidPINError) Is(target error) bool {
+	return target == ErrInvalidPIN
+}
+
+// PINLockedError is returned while the PIN is locked after too many wrong attempts
+type PINLockedError struct {
+	Until time.Time
+}
+
+func (e *PINLockedError) Error() string {
+	return fmt.Sprintf("PIN locked until %s", e.Until.Format(time.RFC3339))
+}
+
+func (e *PINLockedError) Is(target error) bool {
+	return target == ErrPINLocked
+}
+
+// PINService verifies transaction PINs, locking them after repeated wrong
+// attempts, and handles setting, changing and resetting them
+type PINService struct {
+	db    *mongo.Database
+	audit *AuditService
+}
+
+func NewPINService(db *mongo.Database) *PINService {
+	return &PINService{db: db, audit: NewAuditService(db)}
+}
+
+// Verify checks pin against the user's PIN. Every attempt is counted before
+// the comparison, so parallel guesses can't get past the limit.
+func (p *PINService) Verify(ctx context.Context, userID primitive.ObjectID, pin string) error {
+	users := p.db.Collection("users")
+	now := time.Now()
+
+	var user models.User
+	err := users.FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":              userID,
+			"pin":              bson.M{"$type": "string", "$ne": ""},
+			"pin_locked_until": bson.M{"$not": bson.M{"$gt": now}},
+		},
+		bson.M{"$inc": bson.M{"pin_failed_attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return p.unverifiable(ctx, userID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load PIN: %w", err)
+	}
+
+	// Attempts that raced past the one that locked the PIN
+	if user.PINFailedAttempts > pinMaxAttempts {
+		return &PINLockedError{Until: now.Add(pinLockoutDuration(user.PINLockouts))}
+	}
+
+	if utils.CheckPassword(pin, user.PIN) {
+		_, err := users.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+			"$set":   bson.M{"pin_failed_attempts": 0},
+			"$unset": bson.M{"pin_lockouts": "", "pin_locked_until": ""},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to reset PIN attempts: %w", err)
+		}
+		return nil
+	}
+
+	if user.PINFailedAttempts < pinMaxAttempts {
+		return &InvalidPINError{AttemptsRemaining: pinMaxAttempts - user.PINFailedAttempts}
+	}
+
+	until := now.Add(pinLockoutDuration(user.PINLockouts))
+	_, err = users.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set": bson.M{"pin_failed_attempts": 0, "pin_locked_until": until},
+		"$inc": bson.M{"pin_lockouts": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to lock PIN: %w", err)
+	}
+	p.audit.Record(ctx, models.AuditEvent{UserID: userID, Action: AuditPINLockout, Outcome: "locked"})
+	return &PINLockedError{Until: until}
+}
+
+// unverifiable explains why Verify found no PIN it could check
+func (p *PINService) unverifiable(ctx context.Context, userID primitive.ObjectID) error {
+	var user models.User
+	if err := p.db.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	if user.PIN == "" {
+		return ErrPINNotSet
+	}
+	if user.PINLockedUntil != nil {
+		return &PINLockedError{Until: *user.PINLockedUntil}
+	}
+	return fmt.Errorf("PIN of user %s could not be checked", userID.Hex())
+}
+
+func pinLockoutDuration(lockouts int) time.Duration {
+	if lockouts >= len(pinLockoutDurations) {
+		return pinLockoutDurations[len(pinLockoutDurations)-1]
+	}
+	return pinLockoutDurations[lockouts]
+}
+
+// SetPIN sets the PIN of a user who doesn't have one yet
+func (p *PINService) SetPIN(ctx context.Context, userID primitive.ObjectID, pin string) error {
+	hashed, err := hashPIN(pin)
+	if err != nil {
+		return err
+	}
+	result, err := p.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "pin": bson.M{"$in": []interface{}{nil, ""}}},
+		bson.M{"$set": bson.M{"pin": hashed, "updated_at": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("failed to set PIN: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		count, err := p.db.Collection("users").CountDocuments(ctx, bson.M{"_id": userID})
+		if err != nil {
+			return fmt.Errorf("failed to load user: %w", err)
+		}
+		if count == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return ErrPINAlreadySet
+	}
+	return nil
+}
+
+// ChangePIN replaces the PIN after verifying the current one
+func (p *PINService) ChangePIN(ctx context.Context, userID primitive.ObjectID, currentPIN, newPIN string) error {
+	hashed, err := hashPIN(newPIN)
+	if err != nil {
+		return err
+	}
+	if err := p.Verify(ctx, userID, currentPIN); err != nil {
+		return err
+	}
+	if err := p.replacePIN(ctx, userID, hashed, bson.M{}); err != nil {
+		return err
+	}
+	p.audit.Record(ctx, models.AuditEvent{UserID: userID, Action: AuditPINChange, Outcome: "success"})
+	return nil
+}
+
+// RequestReset emails the user a code that lets them set a new PIN without the
+// current one. A new request replaces the previous code.
+func (p *PINService) RequestReset(ctx context.Context, userID primitive.ObjectID) error {
+	var user models.User
+	if err := p.db.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	if user.PIN == "" {
+		return ErrPINNotSet
+	}
+	// Re-requesting would otherwise give a fresh set of attempts at once
+	if user.PINResetExpiresAt != nil && time.Until(*user.PINResetExpiresAt) > pinResetCodeTTL-pinResetRequestInterval {
+		return ErrTooManyAttempts
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	hashed, err := utils.HashPassword(code)
+	if err != nil {
+		return fmt.Errorf("failed to hash reset code: %w", err)
+	}
+	_, err = p.db.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{
+		"pin_reset_code":       hashed,
+		"pin_reset_expires_at": time.Now().Add(pinResetCodeTTL),
+		"pin_reset_attempts":   0,
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to store reset code: %w", err)
+	}
+	return utils.SendVerificationEmail(user.Email, code)
+}
+
+// ResetPIN sets a new PIN with an emailed reset code, clearing any lockout
+func (p *PINService) ResetPIN(ctx context.Context, userID primitive.ObjectID, code, newPIN string) error {
+	hashed, err := hashPIN(newPIN)
+	if err != nil {
+		return err
+	}
+
+	// Count the attempt first, as Verify does
+	var user models.User
+	err = p.db.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":                  userID,
+			"pin_reset_code":       bson.M{"$type": "string", "$ne": ""},
+			"pin_reset_expires_at": bson.M{"$gt": time.Now()},
+			"pin_reset_attempts":   bson.M{"$lt": pinResetMaxAttempts},
+		},
+		bson.M{"$inc": bson.M{"pin_reset_attempts": 1}},
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalidResetCode
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load reset code: %w", err)
+	}
+	if !utils.CheckPassword(code, user.PINResetCode) {
+		return ErrInvalidResetCode
+	}
+
+	err = p.replacePIN(ctx, userID, hashed, bson.M{"pin_reset_code": user.PINResetCode})
+	if err == mongo.ErrNoDocuments {
+		// Another reset used the code first
+		return ErrInvalidResetCode
+	}
+	if err != nil {
+		return err
+	}
+	p.audit.Record(ctx, models.AuditEvent{UserID: userID, Action: AuditPINReset, Outcome: "success"})
+	return nil
+}
+
+// replacePIN stores a new PIN hash and clears attempts, lockouts and any reset
+// code, if the user still matches filter
+func (p *PINService) replacePIN(ctx context.Context, userID primitive.ObjectID, hashed string, filter bson.M) error {
+	filter["_id"] = userID
+	result, err := p.db.Collection("users").UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"pin": hashed, "pin_failed_attempts": 0, "updated_at": time.Now()},
+		"$unset": bson.M{
+			"pin_lockouts":         "",
+			"pin_locked_until":     "",
+			"pin_reset_code":       "",
+			"pin_reset_expires_at": "",
+			"pin_reset_attempts":   "",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update PIN: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func hashPIN(pin string) (string, error) {
+	if !pinFormat.MatchString(pin) {
+		return "", ErrInvalidPINFormat
+	}
+	hashed, err := utils.HashPassword(pin)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash PIN: %w", err)
+	}
+	return hashed, nil
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"healthy_pay_backend/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestPINVerify(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	hashed, err := utils.HashPassword("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := primitive.NewObjectID()
+	// counted answers the FindOneAndUpdate that counts the attempt
+	counted := func(attempts, lockouts int) bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+			{Key: "_id", Value: userID},
+			{Key: "pin", Value: hashed},
+			{Key: "pin_failed_attempts", Value: attempts},
+			{Key: "pin_lockouts", Value: lockouts},
+		}})
+	}
+	lastUpdate := func(mt *mtest.T) bson.Raw {
+		var update bson.Raw
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName == "update" {
+				update = event.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u").Document()
+			}
+		}
+		return update
+	}
+
+	mt.Run("correct PIN clears attempts", func(mt *mtest.T) {
+		mt.AddMockResponses(counted(3, 2), mockUpdated(1))
+		if err := NewPINService(mt.DB).Verify(context.Background(), userID, "1234"); err != nil {
+			mt.Fatal(err)
+		}
+		if _, err := lastUpdate(mt).LookupErr("$unset", "pin_lockouts"); err != nil {
+			mt.Error("lockout count kept after a correct PIN")
+		}
+	})
+
+	mt.Run("wrong PIN", func(mt *mtest.T) {
+		mt.AddMockResponses(counted(2, 0))
+		var invalid *InvalidPINError
+		if err := NewPINService(mt.DB).Verify(context.Background(), userID, "0000"); !errors.As(err, &invalid) || invalid.AttemptsRemaining != 3 {
+			mt.Errorf("error = %v, want 3 attempts remaining", err)
+		}
+	})
+
+	mt.Run("each lockout in a row lasts longer", func(mt *mtest.T) {
+		mt.AddMockResponses(counted(pinMaxAttempts, 1), mockUpdated(1), mtest.CreateSuccessResponse())
+		var locked *PINLockedError
+		err := NewPINService(mt.DB).Verify(context.Background(), userID, "0000")
+		if !errors.As(err, &locked) {
+			mt.Fatalf("error = %v, want ErrPINLocked", err)
+		}
+		if wait := time.Until(locked.Until); wait < 14*time.Minute || wait > 15*time.Minute {
+			mt.Errorf("second lockout lasts %v, want 15m", wait)
+		}
+		update := lastUpdate(mt)
+		if update.Lookup("$inc", "pin_lockouts").AsInt64() != 1 || update.Lookup("$set", "pin_failed_attempts").AsInt64() != 0 {
+			mt.Errorf("lock update = %s", update)
+		}
+	})
+
+	mt.Run("attempts racing past the lock", func(mt *mtest.T) {
+		mt.AddMockResponses(counted(pinMaxAttempts+1, 0))
+		// Refused before the PIN is compared, even when it is right
+		if err := NewPINService(mt.DB).Verify(context.Background(), userID, "1234"); !errors.Is(err, ErrPINLocked) {
+			mt.Errorf("error = %v, want ErrPINLocked", err)
+		}
+		if lastUpdate(mt) != nil {
+			mt.Error("racing attempt changed the PIN state")
+		}
+	})
+
+	mt.Run("locked PIN isn't compared", func(mt *mtest.T) {
+		until := time.Now().Add(time.Hour)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: userID},
+				{Key: "pin", Value: hashed},
+				{Key: "pin_locked_until", Value: until},
+			}),
+		)
+		var locked *PINLockedError
+		if err := NewPINService(mt.DB).Verify(context.Background(), userID, "1234"); !errors.As(err, &locked) || locked.Until.Unix() != until.Unix() {
+			mt.Errorf("error = %v, want locked until %v", err, until)
+		}
+	})
+}
+
+func TestPINLockoutDuration(t *testing.T) {
+	for lockouts, want := range map[int]time.Duration{0: 5 * time.Minute, 1: 15 * time.Minute, 3: 24 * time.Hour, 10: 24 * time.Hour} {
+		if got := pinLockoutDuration(lockouts); got != want {
+			t.Errorf("pinLockoutDuration(%d) = %v, want %v", lockouts, got, want)
+		}
+	}
+}
@@ -3,15 +3,36 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
+	"healthy_pay_backend/internal/models"
+
+	"github.com/stellar/go/clients/horizonclient"
+	"github.com/stellar/go/keypair"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// ErrPSPNotConfigured is returned when a transaction is pinned to a PSP that
+// is no longer set up, e.g. its credentials were removed
+var ErrPSPNotConfigured = errors.New("PSP not configured")
+
+// ErrDeliveryRejected is returned when a payout was refused for a reason a
+// retry can't fix, e.g. an invalid destination; the send is refunded straight away
+var ErrDeliveryRejected = errors.New("delivery rejected")
+
+// ErrDeliveryOutcomeUnknown is returned when a payout was submitted but it is
+// not known yet whether it went through. It must be settled before the send
+// is retried elsewhere or refunded.
+var ErrDeliveryOutcomeUnknown = errors.New("delivery outcome unknown")
+
 // PSPProvider interface for payment service providers
 type PSPProvider interface {
 	GetName() string
@@ -20,17 +41,43 @@ type PSPProvider interface {
 	InitiateDelivery(req DeliveryRequest) error
 }
 
+// WebhookProvider is implemented by PSPs that push status callbacks to
+// /api/v1/webhooks/psp/:psp
+type WebhookProvider interface {
+	VerifyWebhook(headers http.Header, body []byte) error
+	ParseWebhook(headers http.Header, body []byte) (*WebhookEvent, error)
+}
+
+// DeliveryStatusProvider is implemented by PSPs whose payouts are confirmed by
+// polling rather than callbacks
+type DeliveryStatusProvider interface {
+	CheckDeliveryStatus(reference string) (string, error) // "delivered", "failed", "pending" or "not_found" if the PSP never got it
+}
+
+// WebhookEvent - A PSP callback normalised to the statuses CheckCollectionStatus returns
+type WebhookEvent struct {
+	EventID          string // Unique per PSP, used for de-duplication
+	Kind             string // "collection" or "payout"
+	PSPTransactionID string
+	Reference        string // Our reference sent with the request
+	Status           string // "collected"/"delivered", "failed" or "pending"
+}
+
 type PSPService struct {
 	db        *mongo.Database
 	providers map[string]PSPProvider
 	defaultPSP string
+	ledger    *LedgerService
+	stellar   *StellarService
+	rates     *RateService
+	transfers *SihaTransferService
 }
 
 type CollectionRequest struct {
-	Amount      float64 `json:"amount"`
-	PhoneNumber string  `json:"phoneNumber"`
-	Provider    string  `json:"provider"`
-	Reference   string  `json:"reference"`
+	Amount      models.Money `json:"amount"`
+	PhoneNumber string       `json:"phoneNumber"`
+	Provider    string       `json:"provider"`
+	Reference   string       `json:"reference"`
 }
 
 type CollectionResponse struct {
@@ -42,11 +89,13 @@ type CollectionResponse struct {
 }
 
 type DeliveryRequest struct {
-	Amount           float64 `json:"amount"`
-	RecipientType    string  `json:"recipientType"`
-	RecipientAccount string  `json:"recipientAccount"`
-	RecipientNetwork string  `json:"recipientNetwork,omitempty"`
-	Reference        string  `json:"reference"`
+	Amount           models.Money `json:"amount"`
+	RecipientType    string       `json:"recipientType"`
+	RecipientAccount string       `json:"recipientAccount"`
+	RecipientNetwork string       `json:"recipientNetwork,omitempty"`
+	Reference        string       `json:"reference"`
+	PSPName          string       `json:"pspName,omitempty"` // Pins a retry to the PSP used before; empty selects by network
+	UserID           primitive.ObjectID `json:"userId,omitempty"` // Sender, recorded with on-chain payouts
 }
 
 func NewPSPService(db *mongo.Database) *PSPService {
@@ -54,6 +103,10 @@ func NewPSPService(db *mongo.Database) *PSPService {
 		db:        db,
 		providers: make(map[string]PSPProvider),
 		defaultPSP: "ogate", // Default to Ogate
+		ledger:    NewLedgerService(db),
+		stellar:   NewStellarService(),
+		rates:     NewRateService(),
+		transfers: NewSihaTransferService(db),
 	}
 
 	// Initialize PSP providers
@@ -81,6 +134,12 @@ func (p *PSPService) GetProvider(name string) PSPProvider {
 	return p.providers[p.defaultPSP]
 }
 
+// LookupProvider returns the named provider without falling back to the default
+func (p *PSPService) LookupProvider(name string) (PSPProvider, bool) {
+	provider, exists := p.providers[name]
+	return provider, exists
+}
+
 func (p *PSPService) GetAvailableProviders() []string {
 	var providers []string
 	for name := range p.providers {
@@ -115,29 +174,96 @@ func (p *PSPService) InitiateCollection(req CollectionRequest) (*CollectionRespo
 	return response, nil
 }
 
-func (p *PSPService) CheckCollectionStatus(transactionID string) (string, error) {
-	// For now, use default PSP. In production, you'd track which PSP was used
-	provider := p.GetProvider(p.defaultPSP)
-	if provider == nil {
-		return "failed", fmt.Errorf("no default PSP available")
+// CheckCollectionStatus asks the PSP that handled the collection. Transactions
+// recorded before the PSP name was stored have an empty pspName and go to the default PSP.
+func (p *PSPService) CheckCollectionStatus(pspName, transactionID string) (string, error) {
+	if pspName == "" {
+		pspName = p.defaultPSP
+	}
+
+	provider, exists := p.LookupProvider(pspName)
+	if !exists {
+		return "", fmt.Errorf("%w: %s", ErrPSPNotConfigured, pspName)
 	}
 
 	return provider.CheckCollectionStatus(transactionID)
 }
 
-func (p *PSPService) InitiateDelivery(req DeliveryRequest) error {
+// CheckDeliveryStatus polls the PSP that took a payout. ok is false when the
+// PSP reports payouts through webhooks only.
+func (p *PSPService) CheckDeliveryStatus(pspName, reference string) (status string, ok bool, err error) {
+	provider, exists := p.LookupProvider(pspName)
+	if !exists {
+		return "", false, fmt.Errorf("%w: %s", ErrPSPNotConfigured, pspName)
+	}
+
+	poller, ok := provider.(DeliveryStatusProvider)
+	if !ok {
+		return "", false, nil
+	}
+	status, err = poller.CheckDeliveryStatus(reference)
+	return status, true, err
+}
+
+// ConfirmsPayouts reports whether pspName reports the final status of a
+// payout after accepting it, by webhook or by polling
+func (p *PSPService) ConfirmsPayouts(pspName string) bool {
+	provider, exists := p.LookupProvider(pspName)
+	if !exists {
+		return false
+	}
+	_, polled := provider.(DeliveryStatusProvider)
+	_, pushed := provider.(WebhookProvider)
+	return polled || pushed
+}
+
+// DeliveryPollingProviders lists the configured PSPs that implement DeliveryStatusProvider
+func (p *PSPService) DeliveryPollingProviders() []string {
+	var names []string
+	for name, provider := range p.providers {
+		if _, ok := provider.(DeliveryStatusProvider); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// InitiateDelivery pays out to the recipient and returns the name of the PSP
+// that took the payout, empty for deliveries that don't go through a PSP
+func (p *PSPService) InitiateDelivery(req DeliveryRequest) (string, error) {
+	var pspName string
+	var err error
 	switch req.RecipientType {
 	case "mobile_money":
-		return p.deliverToMobileMoney(req)
+		pspName, err = p.deliverToMobileMoney(req)
 	case "crypto_wallet":
-		return p.deliverToCrypto(req)
+		err = p.deliverToCrypto(req)
 	case "stellar_wallet":
-		return p.deliverToStellar(req)
+		err = p.deliverToStellar(req)
 	case "siha_wallet":
-		return p.deliverToSihaWallet(req)
+		// Ledger posting credits the recipient wallet directly
+		return "", p.deliverToSihaWallet(req)
 	default:
-		return fmt.Errorf("unsupported recipient type: %s", req.RecipientType)
+		return "", fmt.Errorf("unsupported recipient type: %s", req.RecipientType)
+	}
+	if err != nil {
+		return pspName, err
 	}
+
+	// Money has left the platform, move it out of in-transit
+	err = p.ledger.Transfer(
+		context.Background(),
+		"delivery:"+req.Reference,
+		"delivery",
+		fmt.Sprintf("Payout to %s %s", req.RecipientType, req.RecipientAccount),
+		LedgerAccountInTransit,
+		LedgerAccountPayouts,
+		req.Amount,
+	)
+	if errors.Is(err, ErrDuplicateEntry) {
+		return pspName, nil
+	}
+	return pspName, err
 }
 
 func (p *PSPService) selectPSPForProvider(provider string) string {
@@ -174,54 +300,213 @@ func (p *PSPService) selectPSPForProvider(provider string) string {
 	return "demo" // Ultimate fallback
 }
 
-func (p *PSPService) deliverToMobileMoney(req DeliveryRequest) error {
-	// Select PSP for mobile money delivery
-	pspName := p.selectPSPForProvider(req.RecipientNetwork)
-	provider := p.GetProvider(pspName)
-	
-	if provider == nil {
-		return fmt.Errorf("no PSP available for network: %s", req.RecipientNetwork)
+// SelectDeliveryPSP picks the PSP a mobile money payout goes through. Callers
+// that retry must store the choice before the first attempt and pass it back
+// as DeliveryRequest.PSPName, so every attempt goes to the same PSP.
+func (p *PSPService) SelectDeliveryPSP(network string) (string, error) {
+	pspName := p.selectPSPForProvider(network)
+	if _, exists := p.LookupProvider(pspName); !exists {
+		return "", fmt.Errorf("no PSP available for network: %s", network)
 	}
+	return pspName, nil
+}
 
-	return provider.InitiateDelivery(req)
+func (p *PSPService) deliverToMobileMoney(req DeliveryRequest) (string, error) {
+	// A retry stays with the PSP that was asked first so the payout is never sent twice
+	if req.PSPName != "" {
+		provider, exists := p.LookupProvider(req.PSPName)
+		if !exists {
+			return req.PSPName, fmt.Errorf("%w: %s", ErrPSPNotConfigured, req.PSPName)
+		}
+		return req.PSPName, provider.InitiateDelivery(req)
+	}
+
+	pspName, err := p.SelectDeliveryPSP(req.RecipientNetwork)
+	if err != nil {
+		return "", err
+	}
+	provider, _ := p.LookupProvider(pspName)
+	return pspName, provider.InitiateDelivery(req)
 }
 
+// deliverToStellar pays the recipient in USDC from the distributor account,
+// converting the send amount at the current USD rate (USDC is 1:1 with USD).
+// The signed envelope is stored before it is submitted, and a retry settles
+// that envelope on Horizon before a new payment is ever built.
 func (p *PSPService) deliverToStellar(req DeliveryRequest) error {
-	// TODO: Integrate with Stellar network to send USDC
-	fmt.Printf("Delivering ₵%.2f as USDC to Stellar address: %s\n", req.Amount, req.RecipientAccount)
-	
-	// In production, this would:
-	// 1. Convert GHS amount to USDC using exchange rate
-	// 2. Use Stellar SDK to send USDC to recipient address
-	// 3. Handle transaction fees and confirmations
-	
+	ctx := context.Background()
+	transactions := p.db.Collection("blockchain_transactions")
+
+	var previous models.BlockchainTransaction
+	err := transactions.FindOne(ctx, bson.M{
+		"reference": req.Reference,
+		"type":      "send",
+		"status":    bson.M{"$in": []string{"confirmed", "pending"}},
+	}).Decode(&previous)
+	if err == nil {
+		if previous.Status == "confirmed" {
+			log.Printf("Stellar payout for %s already confirmed in %s", req.Reference, previous.TxHash)
+			return nil
+		}
+		settled, err := p.settleStellarPayout(ctx, &previous)
+		if settled || err != nil {
+			return err
+		}
+		// The earlier envelope expired without reaching a ledger; pay afresh
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	if !p.stellar.IsMuxedAddress(req.RecipientAccount) {
+		if _, err := keypair.ParseAddress(req.RecipientAccount); err != nil {
+			return fmt.Errorf("%w: invalid Stellar address %s", ErrDeliveryRejected, req.RecipientAccount)
+		}
+	}
+
+	distributor := p.stellar.DistributorAddress()
+	if distributor == "" {
+		return fmt.Errorf("%w: stellar distributor", ErrPSPNotConfigured)
+	}
+
+	usdc, err := p.rates.ConvertAmount(req.Amount, "USD", models.RoundDown)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s to USDC: %w", req.Amount, err)
+	}
+	if !usdc.IsPositive() {
+		return fmt.Errorf("%w: %s is less than the smallest USDC payout", ErrDeliveryRejected, req.Amount)
+	}
+
+	// Stellar text memos are limited to 28 bytes; references are 24-character IDs
+	payment, err := p.stellar.SignPayment(p.stellar.config.Stellar.DistributorSecretKey, req.RecipientAccount, usdc.Decimal(), req.Reference)
+	if err != nil {
+		return fmt.Errorf("stellar payment failed: %w", err)
+	}
+
+	record := models.BlockchainTransaction{
+		UserID:      req.UserID,
+		Blockchain:  "stellar",
+		Network:     p.stellar.NetworkName(),
+		FromAddress: distributor,
+		ToAddress:   req.RecipientAccount,
+		Amount:      usdc.Major(),
+		AssetCode:   "USDC",
+		AssetIssuer: p.stellar.config.Stellar.USDCContractAddress,
+		TxHash:      payment.Hash,
+		Envelope:    payment.Envelope,
+		ValidUntil:  payment.ValidUntil,
+		Status:      "pending",
+		Type:        "send",
+		Memo:        req.Reference,
+		Reference:   req.Reference,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	result, err := transactions.InsertOne(ctx, record)
+	if err != nil {
+		return fmt.Errorf("failed to record stellar payout: %w", err)
+	}
+	record.ID = result.InsertedID.(primitive.ObjectID)
+
+	if err := p.submitStellarPayout(ctx, &record); err != nil {
+		return err
+	}
+	log.Printf("🚀 Delivered %s as %s USDC to %s (tx %s)", req.Amount, usdc.Decimal(), req.RecipientAccount, record.TxHash)
+	return nil
+}
+
+// settleStellarPayout works out what became of a pending payout. It returns
+// true once the payout is settled either way, and false when its envelope
+// expired without reaching a ledger, so a new payment is safe.
+func (p *PSPService) settleStellarPayout(ctx context.Context, record *models.BlockchainTransaction) (bool, error) {
+	result, err := p.stellar.PaymentResult(record.TxHash)
+	if err == nil {
+		if !result.Status {
+			p.finishStellarPayout(ctx, record, "failed", result.FeeCharged, "transaction failed on ledger")
+			return true, fmt.Errorf("stellar transaction %s was not successful", record.TxHash)
+		}
+		p.finishStellarPayout(ctx, record, "confirmed", result.FeeCharged, "")
+		log.Printf("🚀 Stellar payout for %s confirmed in %s", record.Reference, record.TxHash)
+		return true, nil
+	}
+	if !errors.Is(err, ErrStellarTxNotFound) {
+		return true, fmt.Errorf("%w: %v", ErrDeliveryOutcomeUnknown, err)
+	}
+
+	// Allow for Horizon ingesting the ledger after the time bound passed
+	if record.Envelope == "" || time.Now().After(record.ValidUntil.Add(time.Minute)) {
+		p.finishStellarPayout(ctx, record, "failed", 0, "expired without reaching a ledger")
+		return false, nil
+	}
+	return true, p.submitStellarPayout(ctx, record)
+}
+
+// submitStellarPayout submits the payout's stored envelope and records the
+// outcome. Anything short of a definite answer leaves the payout pending.
+func (p *PSPService) submitStellarPayout(ctx context.Context, record *models.BlockchainTransaction) error {
+	result, err := p.stellar.SubmitSignedPayment(&SignedPayment{Hash: record.TxHash, Envelope: record.Envelope, ValidUntil: record.ValidUntil})
+	if err != nil {
+		if !StellarSubmitRejected(err) {
+			log.Printf("⚠️ Stellar payout %s for %s outcome unknown: %v", record.TxHash, record.Reference, err)
+			return fmt.Errorf("%w: stellar payout %s: %v", ErrDeliveryOutcomeUnknown, record.TxHash, err)
+		}
+		p.finishStellarPayout(ctx, record, "failed", 0, err.Error())
+		if stellarPaymentRejected(err) {
+			return fmt.Errorf("%w: %v", ErrDeliveryRejected, err)
+		}
+		return fmt.Errorf("stellar payment failed: %w", err)
+	}
+	if !result.Status {
+		p.finishStellarPayout(ctx, record, "failed", result.FeeCharged, "transaction failed on ledger")
+		return fmt.Errorf("stellar transaction %s was not successful", record.TxHash)
+	}
+	p.finishStellarPayout(ctx, record, "confirmed", result.FeeCharged, "")
 	return nil
 }
 
+// finishStellarPayout stores the final status of a payout; fee is in stroops
+func (p *PSPService) finishStellarPayout(ctx context.Context, record *models.BlockchainTransaction, status string, fee int64, reason string) {
+	record.Status = status
+	record.Fee = float64(fee) / 1e7 // Stroops to XLM
+	record.FailureReason = reason
+	record.UpdatedAt = time.Now()
+
+	_, err := p.db.Collection("blockchain_transactions").UpdateOne(ctx,
+		bson.M{"_id": record.ID},
+		bson.M{"$set": bson.M{"status": status, "fee": record.Fee, "failure_reason": reason, "updated_at": record.UpdatedAt}})
+	if err != nil {
+		log.Printf("Error updating Stellar payout %s to %s: %v", record.TxHash, status, err)
+	}
+}
+
+// stellarPaymentRejected reports whether Horizon refused the payment for a
+// reason on the recipient's side, such as a missing account or USDC trustline
+func stellarPaymentRejected(err error) bool {
+	var hErr *horizonclient.Error
+	if !errors.As(err, &hErr) {
+		return false
+	}
+	codes, codesErr := hErr.ResultCodes()
+	if codesErr != nil {
+		return false
+	}
+	for _, code := range codes.OperationCodes {
+		switch code {
+		case "op_no_destination", "op_no_trust", "op_not_authorized", "op_line_full":
+			return true
+		}
+	}
+	return false
+}
+
 func (p *PSPService) deliverToCrypto(req DeliveryRequest) error {
 	// TODO: Integrate with crypto wallet APIs
-	fmt.Printf("Delivering ₵%.2f to crypto wallet: %s\n", req.Amount, req.RecipientAccount)
+	fmt.Printf("Delivering %s to crypto wallet: %s\n", req.Amount, req.RecipientAccount)
 	return nil
 }
 
+// deliverToSihaWallet credits the Siha user the recipient account resolves to
 func (p *PSPService) deliverToSihaWallet(req DeliveryRequest) error {
-	// TODO: Credit Siha wallet directly
-	fmt.Printf("Delivering ₵%.2f to Siha wallet: %s\n", req.Amount, req.RecipientAccount)
-	
-	// Find user by wallet ID and credit balance
-	collection := p.db.Collection("wallets")
-	userID, _ := primitive.ObjectIDFromHex(req.RecipientAccount)
-	
-	_, err := collection.UpdateOne(
-		context.Background(),
-		map[string]interface{}{"user_id": userID},
-		map[string]interface{}{
-			"$inc": map[string]interface{}{"balance": req.Amount},
-			"$set": map[string]interface{}{"updated_at": time.Now()},
-		},
-	)
-	
-	return err
+	return p.transfers.CreditFromTransit(context.Background(), req.Reference, req.RecipientAccount, req.UserID, req.Amount)
 }
 
 // PSP tracking methods
@@ -257,3 +542,13 @@ func (p *PSPService) logPSPResponse(transactionRef, operation string, response i
 	p.db.Collection("psp_logs").InsertOne(context.Background(), pspLog)
 	log.Printf("PSP Response [%s] %s: %s - Status: %s", pspName, operation, string(responseJSON), status)
 }
+
+// pspCallbackURL is the public webhook URL a PSP should call back for pspName.
+// Empty when PSP_CALLBACK_BASE_URL is not configured.
+func pspCallbackURL(pspName string) string {
+	baseURL := strings.TrimRight(os.Getenv("PSP_CALLBACK_BASE_URL"), "/")
+	if baseURL == "" {
+		return ""
+	}
+	return baseURL + "/api/v1/webhooks/psp/" + pspName
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// An event still "received" after this long was never finished, e.g. its
+// server died while applying it, and a redelivery applies it again
+const staleWebhookEvent = time.Minute
+
+var (
+	ErrUnknownWebhookProvider  = errors.New("unknown webhook provider")
+	ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+	ErrInvalidWebhookPayload   = errors.New("invalid webhook payload")
+)
+
+// PSPWebhookService ingests PSP callbacks: it verifies the signature, stores the
+// raw payload once per PSP event ID and applies the status via SettlementService.
+type PSPWebhookService struct {
+	db         *mongo.Database
+	pspService *PSPService
+	settlement *SettlementService
+}
+
+func NewPSPWebhookService(db *mongo.Database) *PSPWebhookService {
+	return &PSPWebhookService{
+		db:         db,
+		pspService: NewPSPService(db),
+		settlement: NewSettlementService(db),
+	}
+}
+
+// EnsureIndexes creates the unique index used to de-duplicate webhook deliveries
+func (w *PSPWebhookService) EnsureIndexes(ctx context.Context) error {
+	_, err := w.db.Collection("psp_webhook_events").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "psp_name", Value: 1}, {Key: "event_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create webhook event index: %w", err)
+	}
+	return nil
+}
+
+// Process handles one callback. duplicate is true when the event was already
+// handled, in which case nothing is applied again.
+func (w *PSPWebhookService) Process(ctx context.Context, pspName string, headers http.Header, body []byte) (event *models.PSPWebhookEvent, duplicate bool, err error) {
+	provider, exists := w.pspService.LookupProvider(pspName)
+	webhookProvider, ok := provider.(WebhookProvider)
+	if !exists || !ok {
+		return nil, false, fmt.Errorf("%w: %s", ErrUnknownWebhookProvider, pspName)
+	}
+
+	if err := webhookProvider.VerifyWebhook(headers, body); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidWebhookSignature, err)
+	}
+
+	parsed, err := webhookProvider.ParseWebhook(headers, body)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidWebhookPayload, err)
+	}
+
+	event = &models.PSPWebhookEvent{
+		PSPName:          pspName,
+		EventID:          parsed.EventID,
+		Kind:             parsed.Kind,
+		PSPTransactionID: parsed.PSPTransactionID,
+		Reference:        parsed.Reference,
+		Status:           parsed.Status,
+		ProcessingStatus: "received",
+		RawPayload:       string(body),
+		ReceivedAt:       time.Now(),
+	}
+
+	collection := w.db.Collection("psp_webhook_events")
+	result, err := collection.InsertOne(ctx, event)
+	if mongo.IsDuplicateKeyError(err) {
+		var existing models.PSPWebhookEvent
+		filter := bson.M{"psp_name": pspName, "event_id": parsed.EventID}
+		if err := collection.FindOne(ctx, filter).Decode(&existing); err != nil {
+			return nil, false, err
+		}
+		// Only a delivery that failed or never finished applying is worth
+		// another attempt; applying a status twice changes nothing
+		stale := existing.ProcessingStatus == "received" && time.Since(existing.ReceivedAt) > staleWebhookEvent
+		if existing.ProcessingStatus != "failed" && !stale {
+			return &existing, true, nil
+		}
+		event = &existing
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to store webhook event: %w", err)
+	} else {
+		event.ID = result.InsertedID.(primitive.ObjectID)
+	}
+
+	event.ProcessingStatus, err = w.apply(ctx, pspName, parsed)
+	event.Error = ""
+	if err != nil {
+		event.ProcessingStatus = "failed"
+		event.Error = err.Error()
+		log.Printf("Error applying %s webhook %s: %v", pspName, parsed.EventID, err)
+	}
+	now := time.Now()
+	event.ProcessedAt = &now
+
+	_, updateErr := collection.UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{"$set": bson.M{
+		"processing_status": event.ProcessingStatus,
+		"error":             event.Error,
+		"processed_at":      now,
+	}})
+	if updateErr != nil {
+		log.Printf("Error recording %s webhook %s as %s: %v", pspName, parsed.EventID, event.ProcessingStatus, updateErr)
+		if err == nil {
+			// Fail the delivery so the PSP redelivers it once the event is stale
+			err = fmt.Errorf("failed to record webhook event: %w", updateErr)
+		}
+	}
+	return event, false, err
+}
+
+// apply drives the same transitions as the queue poller and returns the processing status
+func (w *PSPWebhookService) apply(ctx context.Context, pspName string, event *WebhookEvent) (string, error) {
+	collection := w.db.Collection("transactions")
+
+	if event.Kind == "payout" {
+		_, err := w.settlement.ApplyPayoutStatus(ctx, event.Reference, event.Status)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "unmatched", nil
+		}
+		return "processed", err
+	}
+
+	// PSP transaction IDs are only unique per PSP. Transactions stored before the
+	// PSP name was recorded have none and are matched on the ID alone.
+	var transaction models.Transaction
+	err := collection.FindOne(ctx, bson.M{
+		"type":               bson.M{"$ne": "deposit"},
+		"psp_name":           bson.M{"$in": []interface{}{pspName, nil}},
+		"psp_transaction_id": event.PSPTransactionID,
+	}).Decode(&transaction)
+	if err == nil {
+		_, err = w.settlement.ApplySendCollectionStatus(ctx, transaction, event.Status)
+		return "processed", err
+	}
+	if err != mongo.ErrNoDocuments {
+		return "", err
+	}
+
+	matches := []bson.M{{"transactionId": event.PSPTransactionID}}
+	if event.Reference != "" {
+		matches = append(matches, bson.M{"pspReference": event.Reference})
+	}
+
+	var deposit models.UnifiedTransaction
+	err = collection.FindOne(ctx, bson.M{
+		"type":    "deposit",
+		"pspName": bson.M{"$in": []interface{}{pspName, nil}},
+		"$or":     matches,
+	}).Decode(&deposit)
+	if err == mongo.ErrNoDocuments {
+		return "unmatched", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, err = w.settlement.ApplyDepositCollectionStatus(ctx, deposit, event.Status)
+	return "processed", err
+}
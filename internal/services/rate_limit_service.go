@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimit - Limit requests per Window, counted under Name
+type RateLimit struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// RecipientLookupLimits throttle looking Siha recipients up, by the lookup
+// route and by sends alike, so the directory can't be enumerated
+var RecipientLookupLimits = []RateLimit{
+	{Name: "resolve_recipient_minute", Limit: 10, Window: time.Minute},
+	{Name: "resolve_recipient_day", Limit: 100, Window: 24 * time.Hour},
+}
+
+// RateLimitService counts requests per key in fixed windows kept in
+// rate_limits, so the limit holds across every API instance
+type RateLimitService struct {
+	db *mongo.Database
+}
+
+func NewRateLimitService(db *mongo.Database) *RateLimitService {
+	return &RateLimitService{db: db}
+}
+
+// EnsureIndexes drops counters once their window is over
+func (r *RateLimitService) EnsureIndexes(ctx context.Context) error {
+	_, err := r.db.Collection("rate_limits").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create rate limit indexes: %w", err)
+	}
+	return nil
+}
+
+// Allow counts a request against key and reports whether it is within limit
+// requests for the current window, and when the window ends
+func (r *RateLimitService) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Time, error) {
+	start := time.Now().Truncate(window)
+	end := start.Add(window)
+
+	var counter struct {
+		Count int `bson:"count"`
+	}
+	err := r.db.Collection("rate_limits").FindOneAndUpdate(ctx,
+		bson.M{"_id": fmt.Sprintf("%s:%d", key, start.Unix())},
+		bson.M{"$inc": bson.M{"count": 1}, "$setOnInsert": bson.M{"expires_at": end}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return false, end, fmt.Errorf("failed to count request: %w", err)
+	}
+	return counter.Count <= limit, end, nil
+}
+
+// AllowAll counts a request by subject against each of limits and reports
+// whether all of them allow it, and when the last one it exceeds resets
+func (r *RateLimitService) AllowAll(ctx context.Context, subject string, limits []RateLimit) (bool, time.Time, error) {
+	allowed := true
+	var reset time.Time
+	for _, limit := range limits {
+		ok, end, err := r.Allow(ctx, limit.Name+":"+subject, limit.Limit, limit.Window)
+		if err != nil {
+			return false, end, err
+		}
+		if !ok {
+			allowed = false
+			if end.After(reset) {
+				reset = end
+			}
+		}
+	}
+	return allowed, reset, nil
+}
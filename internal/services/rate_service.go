@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"healthy_pay_backend/internal/models"
 )
 
 type RateService struct {
@@ -89,39 +91,60 @@ func (rs *RateService) GetOfframpRate(toCurrency string) (*ConversionRate, error
 	}, nil
 }
 
-func (rs *RateService) ConvertAmount(amount float64, fromCurrency, toCurrency string) (float64, error) {
+// ConvertAmount converts money into toCurrency at the current USD cross rate
+func (rs *RateService) ConvertAmount(amount models.Money, toCurrency string, mode models.RoundingMode) (models.Money, error) {
+	if amount.Currency == toCurrency {
+		return amount, nil
+	}
+
 	rates, err := rs.GetExchangeRates()
 	if err != nil {
-		return 0, err
+		return models.Money{}, err
 	}
 
-	if fromCurrency == "USD" {
-		rate, exists := rates.Rates[toCurrency]
-		if !exists {
-			return 0, fmt.Errorf("currency %s not found", toCurrency)
-		}
-		return amount * rate, nil
+	fromRate, err := usdRate(rates, amount.Currency)
+	if err != nil {
+		return models.Money{}, err
 	}
+	toRate, err := usdRate(rates, toCurrency)
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	return amount.Convert(toRate/fromRate, toCurrency, mode), nil
+}
 
-	if toCurrency == "USD" {
-		rate, exists := rates.Rates[fromCurrency]
-		if !exists {
-			return 0, fmt.Errorf("currency %s not found", fromCurrency)
-		}
-		return amount / rate, nil
+// usdRate returns units of currency per USD
+func usdRate(rates *ExchangeRateResponse, currency string) (float64, error) {
+	if currency == "USD" {
+		return 1, nil
+	}
+	rate, exists := rates.Rates[currency]
+	if !exists || rate <= 0 {
+		return 0, fmt.Errorf("currency %s not found", currency)
 	}
+	return rate, nil
+}
 
-	// Convert via USD
-	fromRate, fromExists := rates.Rates[fromCurrency]
-	toRate, toExists := rates.Rates[toCurrency]
-	
-	if !fromExists {
-		return 0, fmt.Errorf("currency %s not found", fromCurrency)
+// StaticUSDRate returns a fixed USD rate snapshot stored with investments
+func StaticUSDRate(currency string) *models.ExchangeRate {
+	// Mock exchange rates - in production, this would fetch from a real exchange rate API
+	rates := map[string]float64{
+		"GHS": 12.50, // 1 USD = 12.50 GHS
+		"KES": 150.0, // 1 USD = 150 KES
+		"ZMW": 25.0,  // 1 USD = 25 ZMW
+		"USD": 1.0,   // 1 USD = 1 USD
 	}
-	if !toExists {
-		return 0, fmt.Errorf("currency %s not found", toCurrency)
+
+	rate, exists := rates[currency]
+	if !exists {
+		rate = 1.0 // Default to 1:1 if currency not found
 	}
 
-	usdAmount := amount / fromRate
-	return usdAmount * toRate, nil
+	return &models.ExchangeRate{
+		FromCurrency: currency,
+		ToCurrency:   "USD",
+		Rate:         rate,
+		Timestamp:    time.Now(),
+	}
 }
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const AuditRecoveryPhraseExport = "recovery_phrase_export"
+
+const (
+	// Exports need a login at most this old, not just an unexpired token
+	recoveryPhraseLoginMaxAge = 5 * time.Minute
+	// Exports allowed per user per window, on top of the PIN lockout
+	recoveryPhraseExportAttempts = 5
+	recoveryPhraseExportWindow   = time.Hour
+	// Clients must discard an envelope they haven't opened by then
+	recoveryPhraseEnvelopeTTL = 2 * time.Minute
+)
+
+var (
+	ErrRecentLoginRequired = errors.New("recent login required")
+	ErrTooManyAttempts     = errors.New("too many attempts")
+	ErrNoRecoveryPhrase    = errors.New("wallet has no recovery phrase")
+)
+
+// RecoveryPhraseExportRequest - PIN and a one-time X25519 key to seal the phrase to
+type RecoveryPhraseExportRequest struct {
+	PIN        string `json:"pin" binding:"required"`
+	PublicKey  string `json:"public_key" binding:"required"` // Base64 X25519 public key generated for this export
+	Blockchain string `json:"blockchain"`                    // Defaults to "stellar"
+}
+
+// RecoveryPhraseExport - The phrase sealed to the client's key. The envelope's
+// additional data is "<wallet_id>:<expires_at as Unix seconds>".
+type RecoveryPhraseExport struct {
+	WalletID   primitive.ObjectID `json:"wallet_id"`
+	Blockchain string             `json:"blockchain"`
+	ExpiresAt  time.Time          `json:"expires_at"`
+	Envelope   *utils.SealedBox   `json:"envelope"`
+}
+
+// RecoveryPhraseService releases a wallet's recovery phrase to its owner after
+// PIN re-verification, auditing every attempt
+type RecoveryPhraseService struct {
+	db    *mongo.Database
+	audit *AuditService
+	pins  *PINService
+}
+
+func NewRecoveryPhraseService(db *mongo.Database) *RecoveryPhraseService {
+	return &RecoveryPhraseService{db: db, audit: NewAuditService(db), pins: NewPINService(db)}
+}
+
+// Export checks the login age, attempt limit and PIN, then returns the phrase
+// sealed to req.PublicKey. ipAddress and userAgent go into the audit event.
+func (r *RecoveryPhraseService) Export(ctx context.Context, userID primitive.ObjectID, authTime time.Time, req RecoveryPhraseExportRequest, ipAddress, userAgent string) (*RecoveryPhraseExport, error) {
+	if _, err := utils.ParseX25519PublicKey(req.PublicKey); err != nil {
+		return nil, err
+	}
+	if req.Blockchain == "" {
+		req.Blockchain = "stellar"
+	}
+	event := models.AuditEvent{
+		UserID:    userID,
+		Action:    AuditRecoveryPhraseExport,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+	}
+
+	// A stale login is refused before it counts as an attempt
+	if authTime.IsZero() || time.Since(authTime) > recoveryPhraseLoginMaxAge {
+		event.Outcome = "reauth_required"
+		r.audit.Record(ctx, event)
+		return nil, ErrRecentLoginRequired
+	}
+
+	// Record the attempt before counting, so parallel requests can't all pass the limit
+	event.Outcome = AuditOutcomePending
+	id, err := r.audit.Record(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	event.ID = id
+	refuse := func(outcome string, err error) (*RecoveryPhraseExport, error) {
+		event.Outcome = outcome
+		r.audit.Resolve(ctx, event)
+		return nil, err
+	}
+
+	attempts, err := r.audit.CountSince(ctx, userID, AuditRecoveryPhraseExport,
+		time.Now().Add(-recoveryPhraseExportWindow), "rate_limited", "reauth_required")
+	if err != nil {
+		return refuse("error", fmt.Errorf("failed to count export attempts: %w", err))
+	}
+	if attempts > recoveryPhraseExportAttempts {
+		return refuse("rate_limited", ErrTooManyAttempts)
+	}
+
+	switch err := r.pins.Verify(ctx, userID, req.PIN); {
+	case errors.Is(err, ErrPINNotSet):
+		return refuse("pin_not_set", err)
+	case errors.Is(err, ErrInvalidPIN):
+		return refuse("invalid_pin", err)
+	case errors.Is(err, ErrPINLocked):
+		return refuse("pin_locked", err)
+	case err != nil:
+		return refuse("error", err)
+	}
+
+	var wallet models.BlockchainWallet
+	err = r.db.Collection("blockchain_wallets").FindOne(ctx, bson.M{
+		"user_id":         userID,
+		"blockchain":      req.Blockchain,
+		"is_active":       true,
+		"mnemonic_phrase": bson.M{"$type": "string", "$ne": ""},
+	}).Decode(&wallet)
+	if err == mongo.ErrNoDocuments {
+		return refuse("no_recovery_phrase", ErrNoRecoveryPhrase)
+	}
+	if err != nil {
+		return refuse("error", fmt.Errorf("failed to load wallet: %w", err))
+	}
+	event.WalletID = &wallet.ID
+
+	mnemonic, err := utils.DecryptPrivateKey(wallet.MnemonicPhrase)
+	if err != nil {
+		return refuse("error", fmt.Errorf("failed to decrypt recovery phrase of wallet %s: %w", wallet.ID.Hex(), err))
+	}
+	expiresAt := time.Now().Add(recoveryPhraseEnvelopeTTL).Truncate(time.Second)
+	additionalData := fmt.Sprintf("%s:%d", wallet.ID.Hex(), expiresAt.Unix())
+	envelope, err := utils.SealToPublicKey(req.PublicKey, []byte(mnemonic), []byte(additionalData))
+	if err != nil {
+		return refuse("error", fmt.Errorf("failed to seal recovery phrase: %w", err))
+	}
+
+	// The phrase is only released once the export is on record
+	event.Outcome = "success"
+	if err := r.audit.Resolve(ctx, event); err != nil {
+		return nil, err
+	}
+	return &RecoveryPhraseExport{
+		WalletID:   wallet.ID,
+		Blockchain: wallet.Blockchain,
+		ExpiresAt:  expiresAt,
+		Envelope:   envelope,
+	}, nil
+}
@@ -0,0 +1,449 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/statemachine"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Refund job types
+const (
+	JobProcessRefund       = "refund.process"
+	JobConfirmRefundPayout = "refund.confirm_payout"
+)
+
+// Refund destinations
+const (
+	RefundToMobileMoney = "mobile_money"
+	RefundToWallet      = "siha_wallet"
+)
+
+// RefundService returns a send's funds to the sender once delivery has failed
+// for good. Mobile money sends are refunded to the number they were collected
+// from through the same PSP; wallet sends, and mobile money refunds the PSP
+// can't pay out, are credited to the sender's Siha wallet.
+type RefundService struct {
+	db         *mongo.Database
+	pspService *PSPService
+	ledger     *LedgerService
+	jobs       *JobService
+}
+
+func NewRefundService(db *mongo.Database) *RefundService {
+	return &RefundService{
+		db:         db,
+		pspService: NewPSPService(db),
+		ledger:     NewLedgerService(db),
+		jobs:       NewJobService(db),
+	}
+}
+
+// EnsureIndexes allows one refund per send
+func (r *RefundService) EnsureIndexes(ctx context.Context) error {
+	_, err := r.db.Collection("refunds").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "transaction_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create refund indexes: %w", err)
+	}
+	return nil
+}
+
+// RegisterJobHandlers adds the refund job handlers to a worker
+func (r *RefundService) RegisterJobHandlers(worker *JobWorker) {
+	worker.Handle(JobProcessRefund, r.ProcessRefund)
+	worker.Handle(JobConfirmRefundPayout, r.ConfirmRefundPayout)
+}
+
+// StartRefund records a refund for a send whose delivery failed and queues it.
+// Starting a refund that already exists only makes sure its job is queued.
+func (r *RefundService) StartRefund(ctx context.Context, transaction models.Transaction, reason string) error {
+	amount := transaction.Amount
+	if transaction.InvestmentAmount.IsPositive() {
+		var err error
+		if amount, err = amount.Add(transaction.InvestmentAmount); err != nil {
+			return err
+		}
+	}
+
+	history, err := statemachine.Refund.Start(statemachine.RefundPending, reason)
+	if err != nil {
+		return err
+	}
+	refund := models.Refund{
+		TransactionID: transaction.ID,
+		UserID:        transaction.FromUserID,
+		Amount:        amount,
+		Destination:   RefundToWallet,
+		Account:       transaction.FromUserID.Hex(),
+		Status:        statemachine.RefundPending,
+		StatusHistory: history,
+		Reason:        reason,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	if transaction.PaymentMethod != "wallet_balance" && transaction.SenderAccount != "" {
+		refund.Destination = RefundToMobileMoney
+		refund.Account = transaction.SenderAccount
+		refund.Network = transaction.SenderNetwork
+		refund.PSPName = transaction.PSPName
+	}
+
+	_, err = r.db.Collection("refunds").InsertOne(ctx, refund)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to create refund: %w", err)
+	}
+	if err == nil {
+		log.Printf("↩️ Refund of %s for transaction %s started (%s)", amount, transaction.ID.Hex(), refund.Destination)
+	}
+
+	return r.jobs.Enqueue(ctx, JobProcessRefund, JobProcessRefund+":"+transaction.ID.Hex(),
+		bson.M{"transaction_id": transaction.ID.Hex()}, time.Now())
+}
+
+// ProcessRefund unwinds the send's investment allocation and pays the refund out
+func (r *RefundService) ProcessRefund(ctx context.Context, job *models.Job) error {
+	hex, _ := job.Payload["transaction_id"].(string)
+	transactionID, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return fmt.Errorf("%w: invalid transaction_id %q", ErrPermanentJobFailure, hex)
+	}
+
+	var refund models.Refund
+	err = r.db.Collection("refunds").FindOne(ctx, bson.M{"transaction_id": transactionID}).Decode(&refund)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: no refund for transaction %s", ErrPermanentJobFailure, hex)
+	}
+	if err != nil {
+		return err
+	}
+	if refund.Status != statemachine.RefundPending || refund.ManualReview {
+		return nil
+	}
+
+	// Let a running allocation finish so it can't allocate after it is unwound
+	allocating, err := r.jobs.IsQueued(ctx, JobAllocateSendInvestment+":"+hex)
+	if err != nil {
+		return err
+	}
+	if allocating {
+		return RescheduleJob(30 * time.Second)
+	}
+
+	if err := r.reverseInvestment(ctx, &refund); err != nil {
+		return fmt.Errorf("failed to unwind investment: %w", err)
+	}
+
+	if refund.Destination == RefundToMobileMoney {
+		return r.refundToMobileMoney(ctx, job, refund)
+	}
+
+	err = r.refundToWallet(ctx, refund, "Refunded to Siha wallet")
+	if err != nil && job.Attempts >= job.MaxAttempts {
+		r.fail(ctx, refund, err)
+	}
+	return err
+}
+
+// ConfirmRefundPayout polls PSPs that don't send payout callbacks for the
+// final status of a mobile money refund
+func (r *RefundService) ConfirmRefundPayout(ctx context.Context, job *models.Job) error {
+	refundID, err := primitive.ObjectIDFromHex(fmt.Sprint(job.Payload["refund_id"]))
+	if err != nil {
+		return fmt.Errorf("%w: invalid refund_id", ErrPermanentJobFailure)
+	}
+
+	var refund models.Refund
+	if err := r.db.Collection("refunds").FindOne(ctx, bson.M{"_id": refundID}).Decode(&refund); err != nil {
+		return err
+	}
+	if refund.Status != statemachine.RefundProcessing {
+		return nil
+	}
+
+	status, ok, err := r.pspService.CheckDeliveryStatus(refund.PSPName, refund.ID.Hex())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// The PSP reports the outcome through its webhook
+		return nil
+	}
+
+	applied, err := r.ApplyPayoutStatus(ctx, refund.ID.Hex(), status)
+	if err != nil || applied {
+		return err
+	}
+	return RescheduleJob(time.Minute)
+}
+
+// ApplyPayoutStatus records the PSP's final status for a refund payout.
+// Refund payout references are refund IDs.
+func (r *RefundService) ApplyPayoutStatus(ctx context.Context, reference, status string) (bool, error) {
+	refundID, err := primitive.ObjectIDFromHex(reference)
+	if err != nil {
+		return false, fmt.Errorf("invalid payout reference: %s", reference)
+	}
+
+	var refund models.Refund
+	if err := r.db.Collection("refunds").FindOne(ctx, bson.M{"_id": refundID}).Decode(&refund); err != nil {
+		return false, err
+	}
+	if refund.Status != statemachine.RefundProcessing {
+		return false, nil
+	}
+
+	switch status {
+	case "delivered", "completed", "success":
+		return true, r.complete(ctx, refund, "Refund confirmed by PSP", nil)
+
+	case "failed", "cancelled", "error":
+		// The refund payout was booked when it was accepted; bring it back and use the wallet instead
+		err := r.ledger.Transfer(ctx, "refund-payout-failed:"+reference, "refund", "Refund payout failed at PSP",
+			LedgerAccountPayouts, LedgerAccountInTransit, refund.Amount)
+		if err != nil && !errors.Is(err, ErrDuplicateEntry) {
+			return false, err
+		}
+		log.Printf("❌ Refund payout %s failed at PSP, crediting wallet instead", reference)
+		return true, r.refundToWallet(ctx, refund, failureReason("Refund payout", status)+", refunded to Siha wallet")
+	}
+	return false, nil
+}
+
+func (r *RefundService) refundToMobileMoney(ctx context.Context, job *models.Job, refund models.Refund) error {
+	pspName, err := r.refundPSP(ctx, &refund)
+	if err == nil {
+		pspName, err = r.pspService.InitiateDelivery(DeliveryRequest{
+			Amount:           refund.Amount,
+			RecipientType:    "mobile_money",
+			RecipientAccount: refund.Account,
+			RecipientNetwork: refund.Network,
+			Reference:        refund.ID.Hex(),
+			PSPName:          pspName,
+		})
+	}
+	if err != nil {
+		if errors.Is(err, ErrDeliveryRejected) {
+			log.Printf("⚠️ Mobile money refund for transaction %s rejected, crediting wallet instead: %v", refund.TransactionID.Hex(), err)
+			return r.refundToWallet(ctx, refund, "Mobile money refund rejected, refunded to Siha wallet")
+		}
+		if errors.Is(err, ErrPSPNotConfigured) || job.Attempts >= job.MaxAttempts {
+			return r.settleFailedPayout(ctx, refund, err)
+		}
+		r.db.Collection("refunds").UpdateOne(ctx, bson.M{"_id": refund.ID}, bson.M{"$set": bson.M{
+			"last_error": err.Error(),
+			"updated_at": time.Now(),
+		}})
+		return fmt.Errorf("refund payout failed: %w", err)
+	}
+
+	_, err = statemachine.Refund.Transition(ctx, r.db.Collection("refunds"), refund.ID, statemachine.RefundProcessing,
+		"Refund payout accepted by PSP", bson.M{"psp_name": pspName})
+	if applied, err := transitioned(err); !applied {
+		return err
+	}
+
+	// PSPs with callbacks confirm through the webhook; the rest are polled
+	return r.enqueueConfirmPayout(ctx, refund)
+}
+
+// refundPSP returns the PSP a mobile money refund is paid out through,
+// storing the choice before the first attempt so every attempt uses it
+func (r *RefundService) refundPSP(ctx context.Context, refund *models.Refund) (string, error) {
+	if refund.PSPName != "" {
+		return refund.PSPName, nil
+	}
+	pspName, err := r.pspService.SelectDeliveryPSP(refund.Network)
+	if err != nil {
+		return "", err
+	}
+
+	refunds := r.db.Collection("refunds")
+	result, err := refunds.UpdateOne(ctx,
+		bson.M{"_id": refund.ID, "psp_name": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"$set": bson.M{"psp_name": pspName, "updated_at": time.Now()}})
+	if err != nil {
+		return "", fmt.Errorf("failed to store refund PSP: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		var stored models.Refund
+		if err := refunds.FindOne(ctx, bson.M{"_id": refund.ID}).Decode(&stored); err != nil {
+			return "", fmt.Errorf("failed to load refund PSP: %w", err)
+		}
+		pspName = stored.PSPName
+	}
+	refund.PSPName = pspName
+	return pspName, nil
+}
+
+// settleFailedPayout decides what to do with a refund payout that kept
+// failing. A failed attempt may still have reached the PSP, so the wallet is
+// only credited once the PSP confirms it has no payout under the refund's
+// reference; anything less certain is parked for manual review.
+func (r *RefundService) settleFailedPayout(ctx context.Context, refund models.Refund, cause error) error {
+	if refund.PSPName == "" {
+		// No PSP was ever chosen, so nothing was sent
+		log.Printf("⚠️ Mobile money refund for transaction %s failed, crediting wallet instead: %v", refund.TransactionID.Hex(), cause)
+		return r.refundToWallet(ctx, refund, "Mobile money refund failed, refunded to Siha wallet")
+	}
+
+	status, ok, err := r.pspService.CheckDeliveryStatus(refund.PSPName, refund.ID.Hex())
+	if err != nil {
+		return r.park(ctx, refund, fmt.Sprintf("Refund payout failed (%v) and its status could not be checked: %v", cause, err))
+	}
+	if !ok {
+		return r.park(ctx, refund, fmt.Sprintf("Refund payout failed (%v) and %s can't be asked for its status", cause, refund.PSPName))
+	}
+
+	switch status {
+	case "failed", "cancelled", "error", "not_found":
+		log.Printf("⚠️ Mobile money refund for transaction %s not paid by %s (%s), crediting wallet instead", refund.TransactionID.Hex(), refund.PSPName, status)
+		return r.refundToWallet(ctx, refund, "Mobile money refund failed, refunded to Siha wallet")
+
+	case "delivered", "completed", "success":
+		// The payout went through after all; book it as InitiateDelivery would have
+		err := r.ledger.Transfer(ctx, "delivery:"+refund.ID.Hex(), "delivery", "Refund payout to mobile money "+refund.Account,
+			LedgerAccountInTransit, LedgerAccountPayouts, refund.Amount)
+		if err != nil && !errors.Is(err, ErrDuplicateEntry) {
+			return err
+		}
+		return r.complete(ctx, refund, "Refund confirmed by PSP", bson.M{"psp_name": refund.PSPName})
+
+	case "pending":
+		_, err := statemachine.Refund.Transition(ctx, r.db.Collection("refunds"), refund.ID, statemachine.RefundProcessing,
+			"Refund payout in progress at PSP", bson.M{"psp_name": refund.PSPName})
+		if applied, err := transitioned(err); !applied {
+			return err
+		}
+		return r.enqueueConfirmPayout(ctx, refund)
+	}
+	return r.park(ctx, refund, fmt.Sprintf("Refund payout failed (%v) with PSP status %q", cause, status))
+}
+
+func (r *RefundService) enqueueConfirmPayout(ctx context.Context, refund models.Refund) error {
+	return r.jobs.Enqueue(ctx, JobConfirmRefundPayout, JobConfirmRefundPayout+":"+refund.ID.Hex(),
+		bson.M{"refund_id": refund.ID.Hex()}, time.Now().Add(time.Minute))
+}
+
+// park takes a refund whose payout outcome is unknown out of automatic
+// processing, along with its send
+func (r *RefundService) park(ctx context.Context, refund models.Refund, reason string) error {
+	now := time.Now()
+	_, err := r.db.Collection("refunds").UpdateOne(ctx, bson.M{"_id": refund.ID}, bson.M{"$set": bson.M{
+		"manual_review": true,
+		"review_reason": reason,
+		"updated_at":    now,
+	}})
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Collection("transactions").UpdateOne(ctx, bson.M{"_id": refund.TransactionID}, bson.M{"$set": bson.M{
+		"manual_review": true,
+		"review_reason": "Refund parked: " + reason,
+		"updated_at":    now,
+	}})
+	if err == nil {
+		log.Printf("🚩 Refund %s for transaction %s parked for manual review: %s", refund.ID.Hex(), refund.TransactionID.Hex(), reason)
+	}
+	return err
+}
+
+func (r *RefundService) refundToWallet(ctx context.Context, refund models.Refund, reason string) error {
+	err := r.ledger.Transfer(ctx, "refund:"+refund.TransactionID.Hex(), "refund", "Refund of failed send",
+		LedgerAccountInTransit, WalletAccountCode(refund.UserID), refund.Amount)
+	if err != nil && !errors.Is(err, ErrDuplicateEntry) {
+		return fmt.Errorf("failed to post refund to ledger: %w", err)
+	}
+	return r.complete(ctx, refund, reason, bson.M{
+		"destination": RefundToWallet,
+		"account":     refund.UserID.Hex(),
+	})
+}
+
+// complete marks the refund and its send as refunded
+func (r *RefundService) complete(ctx context.Context, refund models.Refund, reason string, set bson.M) error {
+	fields := bson.M{"completed_at": time.Now()}
+	for key, value := range set {
+		fields[key] = value
+	}
+	_, err := statemachine.Refund.Transition(ctx, r.db.Collection("refunds"), refund.ID, statemachine.RefundCompleted, reason, fields)
+	if applied, err := transitioned(err); !applied {
+		return err
+	}
+
+	_, err = statemachine.Send.Transition(ctx, r.db.Collection("transactions"), refund.TransactionID, statemachine.SendRefunded, reason, nil)
+	if _, err := transitioned(err); err != nil {
+		return err
+	}
+	log.Printf("✅ Refunded %s for transaction %s", refund.Amount, refund.TransactionID.Hex())
+	return nil
+}
+
+// fail gives up on a refund and leaves the send for manual review
+func (r *RefundService) fail(ctx context.Context, refund models.Refund, cause error) {
+	_, err := statemachine.Refund.Transition(ctx, r.db.Collection("refunds"), refund.ID, statemachine.RefundFailed,
+		"Refund could not be paid out", bson.M{"last_error": cause.Error()})
+	if _, err := transitioned(err); err != nil {
+		log.Printf("Error marking refund %s failed: %v", refund.ID.Hex(), err)
+	}
+	r.db.Collection("transactions").UpdateOne(ctx, bson.M{"_id": refund.TransactionID}, bson.M{"$set": bson.M{
+		"manual_review": true,
+		"review_reason": "Refund failed: " + cause.Error(),
+		"updated_at":    time.Now(),
+	}})
+	log.Printf("🚩 Refund for transaction %s failed, flagged for manual review: %v", refund.TransactionID.Hex(), cause)
+}
+
+// reverseInvestment moves an investment allocated from the send back into
+// in-transit so it is refunded with the rest of the send
+func (r *RefundService) reverseInvestment(ctx context.Context, refund *models.Refund) error {
+	var investment models.Investment
+	err := r.db.Collection("investments").FindOne(ctx, bson.M{
+		"transaction_id": refund.TransactionID,
+		"status":         bson.M{"$ne": "reversed"},
+	}).Decode(&investment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = r.ledger.Transfer(ctx, "investment-reversal:"+refund.TransactionID.Hex(), "investment", "Investment reversed for refund",
+		InvestmentAccountCode(refund.UserID), LedgerAccountInTransit, investment.Amount)
+	if err != nil && !errors.Is(err, ErrDuplicateEntry) {
+		return err
+	}
+
+	now := time.Now()
+	if _, err := r.db.Collection("investments").UpdateOne(ctx, bson.M{"_id": investment.ID}, bson.M{"$set": bson.M{
+		"status":     "reversed",
+		"updated_at": now,
+	}}); err != nil {
+		return err
+	}
+	if _, err := r.db.Collection("transactions").UpdateOne(ctx, bson.M{"_id": refund.TransactionID}, bson.M{"$set": bson.M{
+		"investment_status": "reversed",
+		"updated_at":        now,
+	}}); err != nil {
+		return err
+	}
+	refund.InvestmentReversed = investment.Amount
+	_, err = r.db.Collection("refunds").UpdateOne(ctx, bson.M{"_id": refund.ID}, bson.M{"$set": bson.M{
+		"investment_reversed": investment.Amount,
+		"updated_at":          now,
+	}})
+	log.Printf("📉 Reversed investment %s for transaction %s", investment.Amount, refund.TransactionID.Hex())
+	return err
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/statemachine"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestStartRefund(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	send := models.Transaction{
+		ID:               primitive.NewObjectID(),
+		FromUserID:       primitive.NewObjectID(),
+		Amount:           models.NewMoney(1000, "GHS"),
+		InvestmentAmount: models.NewMoney(100, "GHS"),
+		PaymentMethod:    "mobile_money",
+		SenderAccount:    "0241234567",
+		SenderNetwork:    "mtn",
+		PSPName:          "mtn",
+	}
+	ok := mtest.CreateSuccessResponse()
+	duplicate := mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"})
+
+	mt.Run("mobile money sends go back to the payer", func(mt *mtest.T) {
+		mt.AddMockResponses(ok, ok)
+		if err := NewRefundService(mt.DB).StartRefund(context.Background(), send, "Delivery failed"); err != nil {
+			mt.Fatal(err)
+		}
+		refund := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		if refund.Lookup("destination").StringValue() != RefundToMobileMoney || refund.Lookup("account").StringValue() != send.SenderAccount {
+			mt.Errorf("refund = %s, want it paid to %s", refund, send.SenderAccount)
+		}
+		if refund.Lookup("amount", "minor").AsInt64() != 1100 {
+			mt.Errorf("refund amount = %s, want the send plus its investment", refund.Lookup("amount"))
+		}
+		job := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		if job.Lookup("key").StringValue() != JobProcessRefund+":"+send.ID.Hex() {
+			mt.Errorf("job = %s", job)
+		}
+	})
+
+	mt.Run("wallet sends go back to the wallet", func(mt *mtest.T) {
+		mt.AddMockResponses(ok, ok)
+		walletSend := send
+		walletSend.PaymentMethod = "wallet_balance"
+		if err := NewRefundService(mt.DB).StartRefund(context.Background(), walletSend, "Delivery failed"); err != nil {
+			mt.Fatal(err)
+		}
+		refund := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		if refund.Lookup("destination").StringValue() != RefundToWallet || refund.Lookup("account").StringValue() != send.FromUserID.Hex() {
+			mt.Errorf("refund = %s, want it paid to the sender's wallet", refund)
+		}
+	})
+
+	mt.Run("starting again only makes sure it is queued", func(mt *mtest.T) {
+		mt.AddMockResponses(duplicate, duplicate)
+		if err := NewRefundService(mt.DB).StartRefund(context.Background(), send, "Delivery failed"); err != nil {
+			mt.Errorf("restarting a refund = %v", err)
+		}
+	})
+}
+
+func TestProcessRefund(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	transactionID := primitive.NewObjectID()
+	userID := primitive.NewObjectID()
+	refundID := primitive.NewObjectID()
+	refund := func(destination, status string) bson.D {
+		return mtest.CreateCursorResponse(0, "test.refunds", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: refundID},
+			{Key: "transaction_id", Value: transactionID},
+			{Key: "user_id", Value: userID},
+			{Key: "amount", Value: bson.D{{Key: "minor", Value: int64(1100)}, {Key: "currency", Value: "GHS"}}},
+			{Key: "destination", Value: destination},
+			{Key: "account", Value: "0241234567"},
+			{Key: "psp_name", Value: "mtn"},
+			{Key: "status", Value: status},
+		})
+	}
+	status := func(collection, status string) bson.D {
+		return mtest.CreateCursorResponse(0, "test."+collection, mtest.FirstBatch, bson.D{{Key: "status", Value: status}})
+	}
+	job := func(attempts int) *models.Job {
+		return &models.Job{Attempts: attempts, MaxAttempts: 5, Payload: bson.M{"transaction_id": transactionID.Hex()}}
+	}
+	ok := mtest.CreateSuccessResponse()
+	noInvestment := mtest.CreateCursorResponse(0, "test.investments", mtest.FirstBatch)
+	// A transfer between system accounts, and one crediting the wallet projection
+	transfer := []bson.D{mockUpdated(1), mockUpdated(1), ok, ok}
+	toWallet := []bson.D{mockUpdated(1), mockUpdated(1), ok, mockUpdated(1), ok}
+	completed := []bson.D{
+		status("refunds", statemachine.RefundPending), mockUpdated(1),
+		status("transactions", statemachine.SendDeliveryFailed), mockUpdated(1),
+	}
+	responses := func(groups ...[]bson.D) []bson.D {
+		var all []bson.D
+		for _, group := range groups {
+			all = append(all, group...)
+		}
+		return all
+	}
+	// journal lists the references of the journal entries a test posted
+	journal := func(mt *mtest.T) []string {
+		var references []string
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName == "insert" && event.Command.Lookup("insert").StringValue() == "journal_entries" {
+				entry := event.Command.Lookup("documents").Array().Index(0).Value().Document()
+				references = append(references, entry.Lookup("reference").StringValue())
+			}
+		}
+		return references
+	}
+
+	mt.Run("wallet refund unwinds the investment first", func(mt *mtest.T) {
+		investment := mtest.CreateCursorResponse(0, "test.investments", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "amount", Value: bson.D{{Key: "minor", Value: int64(100)}, {Key: "currency", Value: "GHS"}}},
+		})
+		mt.AddMockResponses(responses(
+			[]bson.D{refund(RefundToWallet, statemachine.RefundPending), mockCount(0), investment},
+			transfer, []bson.D{mockUpdated(1), mockUpdated(1), mockUpdated(1)},
+			toWallet, completed)...)
+		if err := NewRefundService(mt.DB).ProcessRefund(context.Background(), job(1)); err != nil {
+			mt.Fatal(err)
+		}
+		want := []string{"investment-reversal:" + transactionID.Hex(), "refund:" + transactionID.Hex()}
+		if got := journal(mt); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+			mt.Errorf("journal = %v, want %v", got, want)
+		}
+	})
+
+	mt.Run("waits for a running allocation", func(mt *mtest.T) {
+		mt.AddMockResponses(refund(RefundToWallet, statemachine.RefundPending), mockCount(1))
+		var reschedule *RescheduleError
+		if err := NewRefundService(mt.DB).ProcessRefund(context.Background(), job(1)); !errors.As(err, &reschedule) {
+			mt.Errorf("error = %v, want the refund rescheduled", err)
+		}
+	})
+
+	mt.Run("settled refunds are left alone", func(mt *mtest.T) {
+		mt.AddMockResponses(refund(RefundToWallet, statemachine.RefundCompleted))
+		if err := NewRefundService(mt.DB).ProcessRefund(context.Background(), job(1)); err != nil {
+			mt.Fatal(err)
+		}
+		mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("%s sent for a completed refund", event.CommandName)
+		}
+	})
+
+	// The last mobile money attempt fails, so the PSP is asked what happened
+	lastPayout := func(mt *mtest.T, psp *fakePSP) error {
+		s := NewSettlementService(mt.DB)
+		s.usePSP(psp)
+		return s.refunds.ProcessRefund(context.Background(), job(5))
+	}
+	failedPayout := []bson.D{refund(RefundToMobileMoney, statemachine.RefundPending), mockCount(0), noInvestment}
+
+	mt.Run("payout the PSP never got goes to the wallet", func(mt *mtest.T) {
+		mt.AddMockResponses(responses(failedPayout, toWallet, completed)...)
+		if err := lastPayout(mt, &fakePSP{deliveryErr: errors.New("connection reset"), status: "not_found"}); err != nil {
+			mt.Fatal(err)
+		}
+		if got := journal(mt); len(got) != 1 || got[0] != "refund:"+transactionID.Hex() {
+			mt.Errorf("journal = %v, want the wallet credited", got)
+		}
+	})
+
+	mt.Run("payout that went through is not paid again", func(mt *mtest.T) {
+		mt.AddMockResponses(responses(failedPayout, transfer, completed)...)
+		if err := lastPayout(mt, &fakePSP{deliveryErr: errors.New("connection reset"), status: "delivered"}); err != nil {
+			mt.Fatal(err)
+		}
+		if got := journal(mt); len(got) != 1 || got[0] != "delivery:"+refundID.Hex() {
+			mt.Errorf("journal = %v, want only the payout booked", got)
+		}
+	})
+
+	mt.Run("payout with unknown outcome is parked", func(mt *mtest.T) {
+		mt.AddMockResponses(responses(failedPayout, []bson.D{mockUpdated(1), mockUpdated(1)})...)
+		if err := lastPayout(mt, &fakePSP{deliveryErr: errors.New("connection reset"), statusErr: errors.New("timeout")}); err != nil {
+			mt.Fatal(err)
+		}
+		var parked int
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			switch event.CommandName {
+			case "insert":
+				mt.Errorf("%s posted for a payout that may have gone through", event.Command.Lookup("insert"))
+			case "update":
+				if event.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set", "manual_review").Boolean() {
+					parked++
+				}
+			}
+		}
+		if parked != 2 {
+			mt.Errorf("%d documents flagged, want the refund and its send", parked)
+		}
+	})
+
+	mt.Run("payout failed at the PSP goes to the wallet", func(mt *mtest.T) {
+		mt.AddMockResponses(responses(
+			[]bson.D{refund(RefundToMobileMoney, statemachine.RefundProcessing)},
+			transfer, toWallet,
+			[]bson.D{
+				status("refunds", statemachine.RefundProcessing), mockUpdated(1),
+				status("transactions", statemachine.SendDeliveryFailed), mockUpdated(1),
+			})...)
+		applied, err := NewRefundService(mt.DB).ApplyPayoutStatus(context.Background(), refundID.Hex(), "failed")
+		if err != nil || !applied {
+			mt.Fatalf("ApplyPayoutStatus = %v, %v", applied, err)
+		}
+		want := []string{"refund-payout-failed:" + refundID.Hex(), "refund:" + transactionID.Hex()}
+		if got := journal(mt); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+			mt.Errorf("journal = %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const AuditRefreshTokenReuse = "refresh_token_reuse"
+
+const (
+	// Sessions unused for this long expire; every refresh extends them
+	sessionIdleTTL = 30 * 24 * time.Hour
+	// last_seen_at is written at most this often
+	sessionLastSeenInterval = time.Minute
+	maxDeviceNameLength     = 100
+)
+
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionRevoked      = errors.New("session revoked or expired")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+// SessionClient - Device a session was started or refreshed from
+type SessionClient struct {
+	DeviceName string
+	IPAddress  string
+	UserAgent  string
+}
+
+// SessionTokens - Issued at sign-in and on every refresh
+type SessionTokens struct {
+	SessionID    primitive.ObjectID `json:"sessionId"`
+	AccessToken  string             `json:"token"`
+	ExpiresAt    time.Time          `json:"expiresAt"` // Of the access token
+	RefreshToken string             `json:"refreshToken"`
+}
+
+// SessionService stores signed-in devices in sessions and issues their access
+// and refresh tokens. Refresh tokens are "<session id>.<secret>" and only
+// their hash is stored.
+type SessionService struct {
+	db    *mongo.Database
+	audit *AuditService
+}
+
+func NewSessionService(db *mongo.Database) *SessionService {
+	return &SessionService{db: db, audit: NewAuditService(db)}
+}
+
+// EnsureIndexes supports listing a user's sessions and drops expired ones
+func (s *SessionService) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("sessions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session indexes: %w", err)
+	}
+	return nil
+}
+
+// Create starts a session for a user who just signed in
+func (s *SessionService) Create(ctx context.Context, userID primitive.ObjectID, client SessionClient) (*SessionTokens, error) {
+	sessionID := primitive.NewObjectID()
+	refreshToken, refreshHash, err := newRefreshToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := models.Session{
+		ID:               sessionID,
+		UserID:           userID,
+		RefreshTokenHash: refreshHash,
+		DeviceName:       truncateDeviceName(client.DeviceName),
+		IPAddress:        client.IPAddress,
+		UserAgent:        client.UserAgent,
+		AuthTime:         now,
+		CreatedAt:        now,
+		LastSeenAt:       now,
+		ExpiresAt:        now.Add(sessionIdleTTL),
+	}
+	if _, err := s.db.Collection("sessions").InsertOne(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return s.issue(&session, refreshToken)
+}
+
+// Refresh rotates a refresh token, returning a new access and refresh token.
+// A refresh token that was already rotated away means it leaked, so the
+// session is revoked.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string, client SessionClient) (*SessionTokens, error) {
+	sessionID, ok := refreshTokenSession(refreshToken)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	sessions := s.db.Collection("sessions")
+
+	var session models.Session
+	err := sessions.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	presentedHash := hashRefreshToken(refreshToken)
+	if subtle.ConstantTimeCompare([]byte(presentedHash), []byte(session.RefreshTokenHash)) != 1 {
+		s.revokeReused(ctx, &session)
+		return nil, ErrInvalidRefreshToken
+	}
+
+	next, nextHash, err := newRefreshToken(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	set := bson.M{
+		"refresh_token_hash": nextHash,
+		"last_seen_at":       now,
+		"expires_at":         now.Add(sessionIdleTTL),
+		"ip_address":         client.IPAddress,
+		"user_agent":         client.UserAgent,
+	}
+	if client.DeviceName != "" {
+		set["device_name"] = truncateDeviceName(client.DeviceName)
+	}
+	// Only one refresh can rotate a given token
+	result, err := sessions.UpdateOne(ctx,
+		bson.M{"_id": session.ID, "refresh_token_hash": presentedHash, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": set})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		s.revokeReused(ctx, &session)
+		return nil, ErrInvalidRefreshToken
+	}
+	return s.issue(&session, next)
+}
+
+func (s *SessionService) revokeReused(ctx context.Context, session *models.Session) {
+	if err := s.revoke(ctx, bson.M{"_id": session.ID}, AuditRefreshTokenReuse); err != nil {
+		log.Printf("❌ Failed to revoke session %s after refresh token reuse: %v", session.ID.Hex(), err)
+	}
+	log.Printf("⚠️ Refresh token reuse on session %s of user %s, session revoked", session.ID.Hex(), session.UserID.Hex())
+	s.audit.Record(ctx, models.AuditEvent{UserID: session.UserID, Action: AuditRefreshTokenReuse, Outcome: "session_revoked"})
+}
+
+func (s *SessionService) issue(session *models.Session, refreshToken string) (*SessionTokens, error) {
+	accessToken, expiresAt, err := utils.GenerateAccessToken(session.UserID, session.ID.Hex(), session.AuthTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+	return &SessionTokens{
+		SessionID:    session.ID,
+		AccessToken:  accessToken,
+		ExpiresAt:    expiresAt,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// Check returns an error unless the session is live and belongs to userID,
+// recording when it was last seen
+func (s *SessionService) Check(ctx context.Context, userID, sessionID string) error {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	sessions := s.db.Collection("sessions")
+
+	var session models.Session
+	err = sessions.FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load session: %w", err)
+	}
+	if session.UserID.Hex() != userID {
+		return ErrSessionNotFound
+	}
+	now := time.Now()
+	if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+		return ErrSessionRevoked
+	}
+
+	if now.Sub(session.LastSeenAt) > sessionLastSeenInterval {
+		_, err := sessions.UpdateOne(ctx,
+			bson.M{"_id": id, "last_seen_at": bson.M{"$lt": now.Add(-sessionLastSeenInterval)}},
+			bson.M{"$set": bson.M{"last_seen_at": now}})
+		if err != nil {
+			log.Printf("Error updating last seen of session %s: %v", sessionID, err)
+		}
+	}
+	return nil
+}
+
+// List returns the user's live sessions, most recently used first
+func (s *SessionService) List(ctx context.Context, userID primitive.ObjectID) ([]models.Session, error) {
+	cursor, err := s.db.Collection("sessions").Find(ctx,
+		bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	sessions := []models.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Revoke ends one of the user's sessions
+func (s *SessionService) Revoke(ctx context.Context, userID, sessionID primitive.ObjectID, reason string) error {
+	count, err := s.db.Collection("sessions").CountDocuments(ctx,
+		bson.M{"_id": sessionID, "user_id": userID, "revoked_at": bson.M{"$exists": false}})
+	if err != nil {
+		return fmt.Errorf("failed to load session: %w", err)
+	}
+	if count == 0 {
+		return ErrSessionNotFound
+	}
+	return s.revoke(ctx, bson.M{"_id": sessionID, "user_id": userID}, reason)
+}
+
+// RevokeAll ends every session of the user except keep, which may be nil
+func (s *SessionService) RevokeAll(ctx context.Context, userID primitive.ObjectID, keep *primitive.ObjectID, reason string) error {
+	filter := bson.M{"user_id": userID}
+	if keep != nil {
+		filter["_id"] = bson.M{"$ne": *keep}
+	}
+	return s.revoke(ctx, filter, reason)
+}
+
+func (s *SessionService) revoke(ctx context.Context, filter bson.M, reason string) error {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	result, err := s.db.Collection("sessions").UpdateMany(ctx, filter,
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": reason}})
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("🔒 Revoked %d sessions (%s)", result.ModifiedCount, reason)
+	}
+	return nil
+}
+
+func newRefreshToken(sessionID primitive.ObjectID) (token, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = sessionID.Hex() + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return token, hashRefreshToken(token), nil
+}
+
+func refreshTokenSession(token string) (primitive.ObjectID, bool) {
+	idHex, secret, found := strings.Cut(token, ".")
+	if !found || secret == "" {
+		return primitive.NilObjectID, false
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	return id, err == nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncateDeviceName(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) > maxDeviceNameLength {
+		name = strings.ToValidUTF8(name[:maxDeviceNameLength], "")
+	}
+	return name
+}
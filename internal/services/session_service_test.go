@@ -0,0 +1,33 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRefreshTokenNamesItsSession(t *testing.T) {
+	sessionID := primitive.NewObjectID()
+	token, hash, err := newRefreshToken(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(hash, token) || hash != hashRefreshToken(token) {
+		t.Errorf("hash %q does not match token", hash)
+	}
+	if got, ok := refreshTokenSession(token); !ok || got != sessionID {
+		t.Errorf("refreshTokenSession(%q) = %s, %v", token, got.Hex(), ok)
+	}
+
+	other, _, _ := newRefreshToken(sessionID)
+	if other == token {
+		t.Error("refresh tokens repeat")
+	}
+
+	for _, bad := range []string{"", sessionID.Hex(), sessionID.Hex() + ".", "not-an-id.secret"} {
+		if _, ok := refreshTokenSession(bad); ok {
+			t.Errorf("refreshTokenSession(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,619 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/statemachine"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SettlementService applies PSP collection and payout outcomes to transactions.
+// The queue poller, the send flow and PSP webhooks all go through it, so a
+// status is acted on exactly once no matter which of them sees it first.
+type SettlementService struct {
+	db         *mongo.Database
+	pspService *PSPService
+	ledger     *LedgerService
+	jobs       *JobService
+	refunds    *RefundService
+}
+
+func NewSettlementService(db *mongo.Database) *SettlementService {
+	return &SettlementService{
+		db:         db,
+		pspService: NewPSPService(db),
+		ledger:     NewLedgerService(db),
+		jobs:       NewJobService(db),
+		refunds:    NewRefundService(db),
+	}
+}
+
+// ApplySendCollectionStatus moves a mobile money send on once its collection
+// settles. A collected send gets allocation and delivery jobs; seeing the
+// collection again retries those for a send already in processing_distribution,
+// in case distribution failed the first time. Returns false if the status
+// changed nothing, e.g. still pending or already applied.
+func (s *SettlementService) ApplySendCollectionStatus(ctx context.Context, transaction models.Transaction, status string) (bool, error) {
+	collection := s.db.Collection("transactions")
+
+	switch status {
+	case "collected", "completed", "success":
+		from, err := statemachine.Send.Transition(ctx, collection, transaction.ID, statemachine.SendProcessingDistribution,
+			"Collected by PSP", bson.M{"collection_status": "collected"})
+		if applied, err := transitioned(err); !applied {
+			if err == nil && from == statemachine.SendProcessingDistribution {
+				return false, s.redistributeSend(ctx, transaction.ID)
+			}
+			return false, err
+		}
+		log.Printf("✅ Transaction %s collected", transaction.ID.Hex())
+		return true, s.distributeSend(ctx, transaction)
+
+	case "failed", "cancelled", "error", "timeout":
+		_, err := statemachine.Send.Transition(ctx, collection, transaction.ID, statemachine.SendFailed,
+			failureReason("Collection", status), bson.M{"collection_status": "failed"})
+		if applied, err := transitioned(err); !applied {
+			return false, err
+		}
+		log.Printf("❌ Transaction %s collection failed", transaction.ID.Hex())
+		return true, nil
+	}
+	return false, nil
+}
+
+// ApplyDepositCollectionStatus credits a collected deposit or fails it. A
+// deposit is marked collected in the same transaction that credits it, so a
+// failed credit leaves it pending for the poller or webhook to retry.
+func (s *SettlementService) ApplyDepositCollectionStatus(ctx context.Context, deposit models.UnifiedTransaction, status string) (bool, error) {
+	collection := s.db.Collection("transactions")
+
+	switch status {
+	case "collected", "completed", "success":
+		applied, err := s.collectDeposit(ctx, deposit)
+		if !applied {
+			return false, err
+		}
+		log.Printf("✅ Deposit %s marked as collected", deposit.ID.Hex())
+		s.recordDepositAllocations(ctx, deposit)
+		return true, nil
+
+	case "failed", "cancelled", "error", "timeout":
+		_, err := statemachine.Deposit.Transition(ctx, collection, deposit.ID, statemachine.DepositFailed,
+			failureReason("Collection", status), bson.M{"queueStatus": "failed"})
+		if applied, err := transitioned(err); !applied {
+			return false, err
+		}
+		log.Printf("❌ Deposit %s marked as failed", deposit.ID.Hex())
+		return true, nil
+	}
+	return false, nil
+}
+
+// ApplyPayoutStatus records the final outcome of a payout. Delivery references
+// are send transaction IDs.
+func (s *SettlementService) ApplyPayoutStatus(ctx context.Context, reference, status string) (bool, error) {
+	transactionID, err := primitive.ObjectIDFromHex(reference)
+	if err != nil {
+		return false, fmt.Errorf("invalid payout reference: %s", reference)
+	}
+
+	var transaction models.Transaction
+	collection := s.db.Collection("transactions")
+	err = collection.FindOne(ctx, bson.M{"_id": transactionID}).Decode(&transaction)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Not a send, so possibly the payout of a refund
+		return s.refunds.ApplyPayoutStatus(ctx, reference, status)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case "delivered", "completed", "success":
+		result, err := collection.UpdateOne(ctx, bson.M{
+			"_id":             transactionID,
+			"delivery_status": bson.M{"$nin": []string{"confirmed", "failed"}},
+		}, bson.M{"$set": bson.M{
+			"delivery_status": "confirmed",
+			"updated_at":      time.Now(),
+		}})
+		if err != nil || result.ModifiedCount == 0 {
+			return false, err
+		}
+
+		// The payout is final, so the send is too
+		_, err = statemachine.Send.Transition(ctx, collection, transactionID, statemachine.SendCompleted, "Payout confirmed by PSP", nil)
+		if _, err := transitioned(err); err != nil {
+			return true, err
+		}
+		log.Printf("✅ Payout for transaction %s confirmed", reference)
+		return true, nil
+
+	case "failed", "cancelled", "error":
+		_, err := statemachine.Send.Transition(ctx, collection, transactionID, statemachine.SendDeliveryFailed,
+			failureReason("Payout", status), bson.M{"delivery_status": "failed"})
+		if applied, err := transitioned(err); !applied {
+			return false, err
+		}
+
+		// The payout was booked when it was accepted; bring the money back into transit
+		err = s.ledger.Transfer(ctx, "payout-failed:"+reference, "delivery", "Payout failed at PSP",
+			LedgerAccountPayouts, LedgerAccountInTransit, transaction.Amount)
+		if err != nil && !errors.Is(err, ErrDuplicateEntry) {
+			return true, err
+		}
+		log.Printf("❌ Payout for transaction %s failed", reference)
+		return true, s.refunds.StartRefund(ctx, transaction, failureReason("Payout", status))
+	}
+	return false, nil
+}
+
+// Helper methods
+func (s *SettlementService) distributeSend(ctx context.Context, transaction models.Transaction) error {
+	// Collected funds are held in transit until delivered
+	totalAmount := transaction.Amount
+	if transaction.InvestmentAmount.IsPositive() {
+		var err error
+		if totalAmount, err = totalAmount.Add(transaction.InvestmentAmount); err != nil {
+			return err
+		}
+	}
+	err := s.ledger.Transfer(ctx, "collection:"+transaction.ID.Hex(), "send", "Mobile money collection for send",
+		LedgerAccountPSPClearing, LedgerAccountInTransit, totalAmount)
+	if err != nil && !errors.Is(err, ErrDuplicateEntry) {
+		return fmt.Errorf("failed to post collection to ledger: %w", err)
+	}
+
+	// Stage 2a: Investment allocation, Stage 2b: delivery to recipient
+	if transaction.InvestmentAmount.IsPositive() {
+		if err := s.EnqueueSendJob(ctx, JobAllocateSendInvestment, transaction.ID, time.Now()); err != nil {
+			return err
+		}
+	}
+	return s.EnqueueSendJob(ctx, JobDeliverSend, transaction.ID, time.Now())
+}
+
+// redistributeSend re-runs distributeSend for a collected send still in
+// processing_distribution. Distribution is idempotent, so this only fills in
+// the ledger entry and jobs a failed run left out.
+func (s *SettlementService) redistributeSend(ctx context.Context, transactionID primitive.ObjectID) error {
+	var transaction models.Transaction
+	if err := s.db.Collection("transactions").FindOne(ctx, bson.M{"_id": transactionID}).Decode(&transaction); err != nil {
+		return err
+	}
+	// Wallet sends are processing from the start but were never collected
+	if transaction.Status != statemachine.SendProcessingDistribution || transaction.CollectionStatus != "collected" {
+		return nil
+	}
+	return s.distributeSend(ctx, transaction)
+}
+
+// EnqueueSendJob schedules a send job. Each job type runs once per send.
+func (s *SettlementService) EnqueueSendJob(ctx context.Context, jobType string, transactionID primitive.ObjectID, runAt time.Time) error {
+	return s.jobs.Enqueue(ctx, jobType, jobType+":"+transactionID.Hex(),
+		bson.M{"transaction_id": transactionID.Hex()}, runAt)
+}
+
+// RegisterJobHandlers adds the send job handlers to a worker
+func (s *SettlementService) RegisterJobHandlers(worker *JobWorker) {
+	worker.Handle(JobMonitorSendCollection, s.MonitorSendCollection)
+	worker.Handle(JobAllocateSendInvestment, s.AllocateSendInvestment)
+	worker.Handle(JobDeliverSend, s.DeliverSend)
+}
+
+// MonitorSendCollection polls the PSP for a send's collection status until
+// it settles, backing off while it is still pending
+func (s *SettlementService) MonitorSendCollection(ctx context.Context, job *models.Job) error {
+	transaction, err := s.jobTransaction(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	// A retry after distribution failed finishes distributing
+	if transaction.Status == statemachine.SendProcessingDistribution {
+		return s.redistributeSend(ctx, transaction.ID)
+	}
+	// Skip if a webhook or the queue already settled the collection
+	if transaction.Status != statemachine.SendCollectionPending && transaction.Status != statemachine.SendPending {
+		return nil
+	}
+	if transaction.ManualReview {
+		return nil
+	}
+
+	status, err := s.pspService.CheckCollectionStatus(transaction.PSPName, transaction.PSPTransactionID)
+	if errors.Is(err, ErrPSPNotConfigured) {
+		return s.FlagSendForReview(ctx, *transaction, err.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("collection status check failed: %w", err)
+	}
+
+	if status != "collected" && status != "completed" && status != "success" &&
+		status != "failed" && status != "cancelled" && status != "error" {
+		if time.Since(transaction.CreatedAt) > 24*time.Hour {
+			status = "timeout"
+		} else {
+			// Poll often right after initiation, then back off to every 5 minutes
+			delay := time.Since(transaction.CreatedAt) / 4
+			return RescheduleJob(min(max(delay, 5*time.Second), 5*time.Minute))
+		}
+	}
+
+	_, err = s.ApplySendCollectionStatus(ctx, *transaction, status)
+	return err
+}
+
+// AllocateSendInvestment moves the investment share of a collected send into
+// the sender's investment account and records the investment
+func (s *SettlementService) AllocateSendInvestment(ctx context.Context, job *models.Job) error {
+	transaction, err := s.jobTransaction(ctx, job)
+	if err != nil {
+		return err
+	}
+	if transaction.InvestmentStatus == "allocated" || !transaction.InvestmentAmount.IsPositive() {
+		return nil
+	}
+	// A send being refunded keeps its investment share in transit
+	if transaction.Status == statemachine.SendDeliveryFailed || transaction.Status == statemachine.SendRefunded {
+		return nil
+	}
+
+	if err := s.allocateSendInvestment(ctx, *transaction); err != nil {
+		return fmt.Errorf("investment allocation failed: %w", err)
+	}
+	_, err = s.db.Collection("transactions").UpdateOne(ctx, bson.M{"_id": transaction.ID}, bson.M{"$set": bson.M{
+		"investment_status": "allocated",
+		"updated_at":        time.Now(),
+	}})
+	if err == nil {
+		log.Printf("📈 Allocated investment %s for transaction %s", transaction.InvestmentAmount, transaction.ID.Hex())
+	}
+	return err
+}
+
+// DeliverSend pays a send out to its recipient, through the same PSP on every
+// attempt. A send the PSP rejects, or that its PSP confirms it never paid
+// after the last attempt, is marked delivery_failed and refunded.
+func (s *SettlementService) DeliverSend(ctx context.Context, job *models.Job) error {
+	transaction, err := s.jobTransaction(ctx, job)
+	if err != nil {
+		return err
+	}
+	if transaction.DeliveryStatus == "delivered" || transaction.DeliveryStatus == "confirmed" ||
+		transaction.DeliveryStatus == "failed" || transaction.ManualReview {
+		return nil
+	}
+
+	deliveryPSP, err := s.deliveryPSP(ctx, transaction)
+	if err == nil {
+		deliveryPSP, err = s.pspService.InitiateDelivery(DeliveryRequest{
+			Amount:           transaction.Amount,
+			RecipientType:    transaction.RecipientType,
+			RecipientAccount: transaction.RecipientAccount,
+			RecipientNetwork: transaction.RecipientNetwork,
+			Reference:        transaction.ID.Hex(),
+			PSPName:          deliveryPSP,
+			UserID:           transaction.FromUserID,
+		})
+	}
+	if errors.Is(err, ErrPSPNotConfigured) {
+		return s.FlagSendForReview(ctx, *transaction, err.Error())
+	}
+	if err != nil {
+		if errors.Is(err, ErrDeliveryRejected) {
+			return s.failDelivery(ctx, *transaction, err)
+		}
+		if job.Attempts >= job.MaxAttempts && errors.Is(err, ErrDeliveryOutcomeUnknown) {
+			// The payout may still land, so refunding could pay out twice
+			return s.FlagSendForReview(ctx, *transaction, "Delivery outcome unknown: "+err.Error())
+		}
+		if job.Attempts >= job.MaxAttempts {
+			return s.settleFailedDelivery(ctx, *transaction, err)
+		}
+		return fmt.Errorf("delivery failed: %w", err)
+	}
+
+	collection := s.db.Collection("transactions")
+	if s.pspService.ConfirmsPayouts(deliveryPSP) {
+		// Accepted, not final: the send completes when the PSP confirms the
+		// payout through its webhook or the payout poller
+		_, err = collection.UpdateOne(ctx, bson.M{
+			"_id":             transaction.ID,
+			"delivery_status": bson.M{"$nin": []string{"confirmed", "failed"}},
+		}, bson.M{"$set": bson.M{"delivery_status": "delivered", "delivery_psp_name": deliveryPSP, "updated_at": time.Now()}})
+		return err
+	}
+
+	_, err = statemachine.Send.Transition(ctx, collection, transaction.ID, statemachine.SendCompleted, "Delivered to recipient", bson.M{
+		"delivery_status":   "delivered",
+		"delivery_psp_name": deliveryPSP,
+	})
+	_, err = transitioned(err)
+	return err
+}
+
+// deliveryPSP returns the PSP a mobile money send is paid out through,
+// storing the choice before the first attempt so a retry can't go to another
+// PSP and pay twice. Other sends don't go through a PSP.
+func (s *SettlementService) deliveryPSP(ctx context.Context, transaction *models.Transaction) (string, error) {
+	if transaction.RecipientType != "mobile_money" || transaction.DeliveryPSPName != "" {
+		return transaction.DeliveryPSPName, nil
+	}
+	pspName, err := s.pspService.SelectDeliveryPSP(transaction.RecipientNetwork)
+	if err != nil {
+		return "", err
+	}
+
+	collection := s.db.Collection("transactions")
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": transaction.ID, "delivery_psp_name": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"$set": bson.M{"delivery_psp_name": pspName, "updated_at": time.Now()}})
+	if err != nil {
+		return "", fmt.Errorf("failed to store delivery PSP: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		// Another attempt chose first; use its PSP
+		var stored models.Transaction
+		if err := collection.FindOne(ctx, bson.M{"_id": transaction.ID}).Decode(&stored); err != nil {
+			return "", fmt.Errorf("failed to load delivery PSP: %w", err)
+		}
+		pspName = stored.DeliveryPSPName
+	}
+	transaction.DeliveryPSPName = pspName
+	return pspName, nil
+}
+
+// settleFailedDelivery decides what to do with a delivery that kept failing.
+// A timed out or 5xx attempt may still have reached the PSP, so the send is
+// only refunded once the PSP confirms it has no payout under the send's
+// reference; anything less certain is flagged for manual review.
+func (s *SettlementService) settleFailedDelivery(ctx context.Context, transaction models.Transaction, cause error) error {
+	if transaction.DeliveryPSPName == "" {
+		// Not a PSP payout, so nothing left the platform
+		return s.failDelivery(ctx, transaction, cause)
+	}
+
+	reference := transaction.ID.Hex()
+	status, ok, err := s.pspService.CheckDeliveryStatus(transaction.DeliveryPSPName, reference)
+	if err != nil {
+		return s.FlagSendForReview(ctx, transaction, fmt.Sprintf("Delivery failed (%v) and its status could not be checked: %v", cause, err))
+	}
+	if !ok {
+		return s.FlagSendForReview(ctx, transaction, fmt.Sprintf("Delivery failed (%v) and %s can't be asked for its status", cause, transaction.DeliveryPSPName))
+	}
+
+	switch status {
+	case "failed", "cancelled", "error", "not_found":
+		return s.failDelivery(ctx, transaction, cause)
+
+	case "delivered", "completed", "success", "pending":
+		// The payout reached the PSP after all; book it as InitiateDelivery would have
+		err := s.ledger.Transfer(ctx, "delivery:"+reference, "delivery",
+			fmt.Sprintf("Payout to %s %s", transaction.RecipientType, transaction.RecipientAccount),
+			LedgerAccountInTransit, LedgerAccountPayouts, transaction.Amount)
+		if err != nil && !errors.Is(err, ErrDuplicateEntry) {
+			return err
+		}
+		_, err = s.db.Collection("transactions").UpdateOne(ctx, bson.M{
+			"_id":             transaction.ID,
+			"delivery_status": bson.M{"$nin": []string{"confirmed", "failed"}},
+		}, bson.M{"$set": bson.M{"delivery_status": "delivered", "updated_at": time.Now()}})
+		if err != nil {
+			return err
+		}
+		log.Printf("⚠️ Delivery for transaction %s failed (%v) but %s has it as %s", reference, cause, transaction.DeliveryPSPName, status)
+		if status == "pending" {
+			// The payout poller confirms it
+			return nil
+		}
+		_, err = s.ApplyPayoutStatus(ctx, reference, status)
+		return err
+	}
+	return s.FlagSendForReview(ctx, transaction, fmt.Sprintf("Delivery failed (%v) with PSP status %q", cause, status))
+}
+
+// failDelivery gives up on delivering a send and refunds the sender
+func (s *SettlementService) failDelivery(ctx context.Context, transaction models.Transaction, cause error) error {
+	_, err := statemachine.Send.Transition(ctx, s.db.Collection("transactions"), transaction.ID, statemachine.SendDeliveryFailed,
+		"Delivery could not be initiated", bson.M{"delivery_status": "failed"})
+	if _, err := transitioned(err); err != nil {
+		return err
+	}
+	log.Printf("❌ Delivery for transaction %s failed after retries: %v", transaction.ID.Hex(), cause)
+
+	if err := s.refunds.StartRefund(ctx, transaction, "Delivery failed: "+cause.Error()); err != nil {
+		return s.FlagSendForReview(ctx, transaction, "Refund could not be started: "+err.Error())
+	}
+	return nil
+}
+
+func (s *SettlementService) jobTransaction(ctx context.Context, job *models.Job) (*models.Transaction, error) {
+	hex, _ := job.Payload["transaction_id"].(string)
+	transactionID, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid transaction_id %q", ErrPermanentJobFailure, hex)
+	}
+
+	var transaction models.Transaction
+	err = s.db.Collection("transactions").FindOne(ctx, bson.M{"_id": transactionID}).Decode(&transaction)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: transaction %s not found", ErrPermanentJobFailure, hex)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// FlagSendForReview takes a send out of automatic processing, e.g. when the
+// PSP it was routed to is no longer configured
+func (s *SettlementService) FlagSendForReview(ctx context.Context, transaction models.Transaction, reason string) error {
+	_, err := s.db.Collection("transactions").UpdateOne(ctx, bson.M{"_id": transaction.ID}, bson.M{"$set": bson.M{
+		"manual_review": true,
+		"review_reason": reason,
+		"updated_at":    time.Now(),
+	}})
+	if err == nil {
+		log.Printf("🚩 Transaction %s flagged for manual review: %s", transaction.ID.Hex(), reason)
+	}
+	return err
+}
+
+// FlagDepositForReview is FlagSendForReview for deposits
+func (s *SettlementService) FlagDepositForReview(ctx context.Context, deposit models.UnifiedTransaction, reason string) error {
+	_, err := s.db.Collection("transactions").UpdateOne(ctx, bson.M{"_id": deposit.ID}, bson.M{"$set": bson.M{
+		"manualReview": true,
+		"reviewReason": reason,
+		"updatedAt":    time.Now(),
+	}})
+	if err == nil {
+		log.Printf("🚩 Deposit %s flagged for manual review: %s", deposit.ID.Hex(), reason)
+	}
+	return err
+}
+
+func (s *SettlementService) allocateSendInvestment(ctx context.Context, transaction models.Transaction) error {
+	amount := transaction.InvestmentAmount
+	err := s.ledger.Transfer(ctx, "investment:"+transaction.ID.Hex(), "investment", "Investment allocation from send",
+		LedgerAccountInTransit, InvestmentAccountCode(transaction.FromUserID), amount)
+	if err != nil && !errors.Is(err, ErrDuplicateEntry) {
+		return err
+	}
+
+	// Keyed by transaction so a retried allocation doesn't record it twice
+	investment := models.Investment{
+		UserID:             transaction.FromUserID,
+		TransactionID:      transaction.ID,
+		Amount:             amount,
+		InvestmentCurrency: transaction.RecipientCurrency,
+		Type:               "send_flow_investment",
+		Status:             "pending",
+		Returns:            models.NewMoney(0, amount.Currency),
+		Rate:               StaticUSDRate(transaction.RecipientCurrency),
+		CreatedAt:          time.Now(),
+		UpdatedAt:          time.Now(),
+	}
+	_, err = s.db.Collection("investments").UpdateOne(ctx,
+		bson.M{"transaction_id": transaction.ID},
+		bson.M{"$setOnInsert": investment},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// collectDeposit marks a deposit collected and posts it to the ledger, which
+// also updates the wallet balance, in one transaction
+func (s *SettlementService) collectDeposit(ctx context.Context, deposit models.UnifiedTransaction) (bool, error) {
+	investmentAmount, savingsAmount := deposit.Amount.Split(deposit.InvestmentPercentage)
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return false, fmt.Errorf("failed to start deposit session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	var applied bool
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		_, err := statemachine.Deposit.Transition(sc, s.db.Collection("transactions"), deposit.ID, statemachine.DepositCollected,
+			"Collected by PSP", bson.M{"queueStatus": "completed", "processedAt": time.Now()})
+		if applied, err = transitioned(err); !applied {
+			return nil, err
+		}
+		if err := s.ledger.PostDepositInTransaction(sc, deposit.ID, deposit.UserID, savingsAmount, investmentAmount); err != nil {
+			applied = false
+			return nil, err
+		}
+		return nil, nil
+	})
+	if errors.Is(err, ErrDuplicateEntry) {
+		// Credited already, so only the status is missing
+		log.Printf("Deposit %s already posted to ledger, marking it collected", deposit.ID.Hex())
+		_, err := statemachine.Deposit.Transition(ctx, s.db.Collection("transactions"), deposit.ID, statemachine.DepositCollected,
+			"Collected by PSP", bson.M{"queueStatus": "completed", "processedAt": time.Now()})
+		_, err = transitioned(err)
+		return false, err
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to collect deposit: %w", err)
+	}
+	if applied {
+		log.Printf("💰 Updated wallet balance for user %s: +%s", deposit.UserID.Hex(), savingsAmount)
+	}
+	return applied, nil
+}
+
+// recordDepositAllocations records the investment and donation a credited
+// deposit was split into
+func (s *SettlementService) recordDepositAllocations(ctx context.Context, deposit models.UnifiedTransaction) {
+	investmentAmount, _ := deposit.Amount.Split(deposit.InvestmentPercentage)
+
+	// Create investment record if applicable
+	if investmentAmount.IsPositive() {
+		investment := bson.M{
+			"userId":    deposit.UserID,
+			"amount":    investmentAmount,
+			"type":      "deposit_investment",
+			"status":    "active",
+			"depositId": deposit.ID,
+			"createdAt": time.Now(),
+			"updatedAt": time.Now(),
+		}
+		if _, err := s.db.Collection("investments").InsertOne(ctx, investment); err != nil {
+			log.Printf("Error creating investment for deposit %s: %v", deposit.ID.Hex(), err)
+		} else {
+			log.Printf("📈 Created investment record: %s for user %s", investmentAmount, deposit.UserID.Hex())
+		}
+	}
+
+	// Handle donation if applicable
+	if deposit.DonationChoice != "none" && deposit.DonationChoice != "" {
+		var donationAmount models.Money
+		if deposit.DonationChoice == "both" {
+			donationAmount = deposit.Amount
+		} else if deposit.DonationChoice == "profit" {
+			donationAmount = investmentAmount
+		}
+
+		if donationAmount.IsPositive() {
+			donation := bson.M{
+				"userId":    deposit.UserID,
+				"amount":    donationAmount,
+				"type":      deposit.DonationChoice,
+				"depositId": deposit.ID,
+				"status":    "pending",
+				"createdAt": time.Now(),
+			}
+			if _, err := s.db.Collection("donations").InsertOne(ctx, donation); err != nil {
+				log.Printf("Error creating donation for deposit %s: %v", deposit.ID.Hex(), err)
+			} else {
+				log.Printf("🎁 Created donation record: %s (%s) for user %s", donationAmount, deposit.DonationChoice, deposit.UserID.Hex())
+			}
+		}
+	}
+}
+
+// transitioned reports whether a status transition was applied. An illegal
+// transition is not an error here: the queue, the send flow and webhooks race
+// to apply the same PSP status, and whoever loses finds it already moved on.
+func transitioned(err error) (bool, error) {
+	if errors.Is(err, statemachine.ErrIllegalTransition) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func failureReason(stage, status string) string {
+	if status == "timeout" {
+		return stage + " timed out without a final PSP status"
+	}
+	return stage + " " + status + " at PSP"
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/statemachine"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// fakePSP is a mobile money PSP that can be polled for payout status
+type fakePSP struct {
+	deliveryErr   error
+	status        string
+	statusErr     error
+	statusChecked bool
+}
+
+func (f *fakePSP) GetName() string { return "mtn" }
+
+func (f *fakePSP) InitiateCollection(req CollectionRequest) (*CollectionResponse, error) {
+	return nil, errors.New("not supported")
+}
+
+func (f *fakePSP) CheckCollectionStatus(transactionID string) (string, error) {
+	return "", errors.New("not supported")
+}
+
+func (f *fakePSP) InitiateDelivery(req DeliveryRequest) error { return f.deliveryErr }
+
+func (f *fakePSP) CheckDeliveryStatus(reference string) (string, error) {
+	f.statusChecked = true
+	return f.status, f.statusErr
+}
+
+// usePSP routes every payout of the service through psp
+func (s *SettlementService) usePSP(psp PSPProvider) {
+	s.pspService.providers = map[string]PSPProvider{psp.GetName(): psp}
+	s.refunds.pspService = s.pspService
+}
+
+// sentCommands lists the commands a test sent as "command collection"
+func sentCommands(mt *mtest.T) []string {
+	var sent []string
+	for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+		collection, _ := event.Command.Index(0).Value().StringValueOK()
+		sent = append(sent, event.CommandName+" "+collection)
+	}
+	return sent
+}
+
+func TestDeliverSend(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	transactionID := primitive.NewObjectID()
+	send := mtest.CreateCursorResponse(0, "test.transactions", mtest.FirstBatch, bson.D{
+		{Key: "_id", Value: transactionID},
+		{Key: "from_user_id", Value: primitive.NewObjectID()},
+		{Key: "amount", Value: bson.D{{Key: "minor", Value: int64(1000)}, {Key: "currency", Value: "GHS"}}},
+		{Key: "payment_method", Value: "wallet_balance"},
+		{Key: "recipient_type", Value: "mobile_money"},
+		{Key: "recipient_account", Value: "0241234567"},
+		{Key: "delivery_psp_name", Value: "mtn"},
+		{Key: "delivery_status", Value: "pending"},
+		{Key: "status", Value: statemachine.SendProcessingDistribution},
+	})
+	status := func(status string) bson.D {
+		return mtest.CreateCursorResponse(0, "test.transactions", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: transactionID},
+			{Key: "status", Value: status},
+		})
+	}
+	job := func(attempts int) *models.Job {
+		return &models.Job{Attempts: attempts, MaxAttempts: 5, Payload: bson.M{"transaction_id": transactionID.Hex()}}
+	}
+	ok := mtest.CreateSuccessResponse()
+	// failDelivery moves the send to delivery_failed and starts its refund
+	refunded := []bson.D{status(statemachine.SendProcessingDistribution), mockUpdated(1), ok, ok}
+
+	mt.Run("rejected payout is refunded", func(mt *mtest.T) {
+		psp := &fakePSP{deliveryErr: fmt.Errorf("%w: invalid number", ErrDeliveryRejected)}
+		s := NewSettlementService(mt.DB)
+		s.usePSP(psp)
+		mt.AddMockResponses(append([]bson.D{send}, refunded...)...)
+		if err := s.DeliverSend(context.Background(), job(1)); err != nil {
+			mt.Fatal(err)
+		}
+		if psp.statusChecked {
+			mt.Error("status checked for a payout the PSP rejected")
+		}
+		if sent := sentCommands(mt); !contains(sent, "insert refunds") || !contains(sent, "insert jobs") {
+			mt.Errorf("commands = %v, want a refund started", sent)
+		}
+	})
+
+	mt.Run("earlier attempts are retried", func(mt *mtest.T) {
+		psp := &fakePSP{deliveryErr: errors.New("connection reset")}
+		s := NewSettlementService(mt.DB)
+		s.usePSP(psp)
+		mt.AddMockResponses(send)
+		if err := s.DeliverSend(context.Background(), job(1)); err == nil {
+			mt.Fatal("failed delivery not retried")
+		}
+		if psp.statusChecked || len(sentCommands(mt)) != 1 {
+			mt.Error("delivery settled before its last attempt")
+		}
+	})
+
+	mt.Run("last attempt the PSP never got is refunded", func(mt *mtest.T) {
+		psp := &fakePSP{deliveryErr: errors.New("connection reset"), status: "not_found"}
+		s := NewSettlementService(mt.DB)
+		s.usePSP(psp)
+		mt.AddMockResponses(append([]bson.D{send}, refunded...)...)
+		if err := s.DeliverSend(context.Background(), job(5)); err != nil {
+			mt.Fatal(err)
+		}
+		if sent := sentCommands(mt); !psp.statusChecked || !contains(sent, "insert refunds") {
+			mt.Errorf("status checked = %v, commands = %v; want a refund after the check", psp.statusChecked, sent)
+		}
+	})
+
+	mt.Run("last attempt that reached the recipient is not refunded", func(mt *mtest.T) {
+		psp := &fakePSP{deliveryErr: errors.New("connection reset"), status: "delivered"}
+		s := NewSettlementService(mt.DB)
+		s.usePSP(psp)
+		mt.AddMockResponses(send,
+			// Payout booked out of in-transit, then marked delivered
+			mockUpdated(1), mockUpdated(1), ok, ok, mockUpdated(1),
+			// and confirmed
+			send, mockUpdated(1), status(statemachine.SendProcessingDistribution), mockUpdated(1))
+		if err := s.DeliverSend(context.Background(), job(5)); err != nil {
+			mt.Fatal(err)
+		}
+
+		var booked bool
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			switch {
+			case event.CommandName == "insert" && event.Command.Lookup("insert").StringValue() == "refunds":
+				mt.Error("refund started for a delivered payout")
+			case event.CommandName == "insert" && event.Command.Lookup("insert").StringValue() == "journal_entries":
+				entry := event.Command.Lookup("documents").Array().Index(0).Value().Document()
+				booked = entry.Lookup("reference").StringValue() == "delivery:"+transactionID.Hex()
+			}
+		}
+		if !booked {
+			mt.Error("payout not booked out of in-transit")
+		}
+	})
+
+	mt.Run("unknown status is flagged for review", func(mt *mtest.T) {
+		psp := &fakePSP{deliveryErr: errors.New("connection reset"), statusErr: errors.New("timeout")}
+		s := NewSettlementService(mt.DB)
+		s.usePSP(psp)
+		mt.AddMockResponses(send, mockUpdated(1))
+		if err := s.DeliverSend(context.Background(), job(5)); err != nil {
+			mt.Fatal(err)
+		}
+		mt.GetStartedEvent()
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		if !update.Lookup("u", "$set", "manual_review").Boolean() {
+			mt.Errorf("update = %s, want the send flagged", update.Lookup("u"))
+		}
+		if event := mt.GetStartedEvent(); event != nil {
+			mt.Errorf("%s sent after flagging", event.CommandName)
+		}
+	})
+}
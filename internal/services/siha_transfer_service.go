@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/statemachine"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient balance")
+	ErrSelfTransfer      = errors.New("cannot send to your own Siha wallet")
+)
+
+// SihaTransferService moves money between Siha wallets. Each transfer commits
+// the ledger entry, the send and the history entries of both users in a single
+// Mongo transaction, so a failure leaves no partial transfer behind.
+type SihaTransferService struct {
+	db      *mongo.Database
+	ledger  *LedgerService
+	handles *WalletHandleService
+}
+
+func NewSihaTransferService(db *mongo.Database) *SihaTransferService {
+	return &SihaTransferService{
+		db:      db,
+		ledger:  NewLedgerService(db),
+		handles: NewWalletHandleService(db),
+	}
+}
+
+// Transfer debits the sender's wallet for the send amount plus investment and
+// credits the recipient with the amount and the sender's investments with the
+// rest. send is stored as a completed send; its ID is set on success.
+func (t *SihaTransferService) Transfer(ctx context.Context, send *models.Transaction, recipient *models.User) error {
+	if send.FromUserID == recipient.ID {
+		return ErrSelfTransfer
+	}
+
+	total := send.Amount
+	if send.InvestmentAmount.IsPositive() {
+		var err error
+		if total, err = total.Add(send.InvestmentAmount); err != nil {
+			return err
+		}
+	}
+
+	history, err := statemachine.Send.Start(statemachine.SendCompleted, "Siha wallet transfer")
+	if err != nil {
+		return err
+	}
+	send.ID = primitive.NewObjectID()
+	send.ToUserID = recipient.ID
+	send.RecipientType = "siha_wallet"
+	send.Status = statemachine.SendCompleted
+	send.StatusHistory = history
+	send.DeliveryStatus = "delivered"
+	if send.InvestmentAmount.IsPositive() {
+		send.InvestmentStatus = "allocated"
+	}
+
+	var sender models.User
+	if err := t.db.Collection("users").FindOne(ctx, bson.M{"_id": send.FromUserID}).Decode(&sender); err != nil {
+		return fmt.Errorf("failed to load sender: %w", err)
+	}
+
+	session, err := t.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start transfer session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// Read inside the transaction: a concurrent transfer from the same
+		// wallet conflicts on it and is retried against the new balance
+		var wallet models.Wallet
+		if err := t.db.Collection("wallets").FindOne(sc, bson.M{"user_id": send.FromUserID}).Decode(&wallet); err != nil {
+			return nil, fmt.Errorf("failed to load sender wallet: %w", err)
+		}
+		if cmp, err := wallet.Balance.Cmp(total); err != nil || cmp < 0 {
+			return nil, ErrInsufficientFunds
+		}
+
+		if _, err := t.db.Collection("transactions").InsertOne(sc, send); err != nil {
+			return nil, fmt.Errorf("failed to create transaction: %w", err)
+		}
+
+		postings := []models.Posting{
+			{AccountCode: WalletAccountCode(send.FromUserID), Direction: "debit", Amount: total.Minor},
+			{AccountCode: WalletAccountCode(recipient.ID), Direction: "credit", Amount: send.Amount.Minor},
+		}
+		if send.InvestmentAmount.IsPositive() {
+			postings = append(postings, models.Posting{
+				AccountCode: InvestmentAccountCode(send.FromUserID), Direction: "credit", Amount: send.InvestmentAmount.Minor,
+			})
+		}
+		err := t.ledger.PostInTransaction(sc, &models.JournalEntry{
+			Reference:   "send:" + send.ID.Hex(),
+			Type:        "send",
+			Description: "Siha wallet transfer",
+			Currency:    total.Currency,
+			Postings:    postings,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if send.InvestmentAmount.IsPositive() {
+			investment := models.Investment{
+				UserID:             send.FromUserID,
+				TransactionID:      send.ID,
+				Amount:             send.InvestmentAmount,
+				InvestmentCurrency: send.RecipientCurrency,
+				Type:               "send_investment",
+				Status:             "active",
+				Returns:            models.NewMoney(0, send.InvestmentAmount.Currency),
+				Rate:               StaticUSDRate(send.RecipientCurrency),
+				CreatedAt:          time.Now(),
+				UpdatedAt:          time.Now(),
+			}
+			if _, err := t.db.Collection("investments").InsertOne(sc, investment); err != nil {
+				return nil, fmt.Errorf("failed to create investment: %w", err)
+			}
+		}
+
+		return nil, t.insertHistory(sc, send, &sender, recipient)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("💸 Siha transfer %s: %s from %s to %s", send.ID.Hex(), send.Amount, send.FromUserID.Hex(), recipient.ID.Hex())
+	return nil
+}
+
+// CreditFromTransit delivers a send that was collected by mobile money into a
+// Siha wallet, recording the recipient's history entry with the credit
+func (t *SihaTransferService) CreditFromTransit(ctx context.Context, reference, recipientIdentifier string, senderID primitive.ObjectID, amount models.Money) error {
+	recipient, err := t.handles.Resolve(ctx, recipientIdentifier)
+	if errors.Is(err, ErrRecipientNotFound) {
+		return fmt.Errorf("%w: no Siha wallet for %s", ErrDeliveryRejected, recipientIdentifier)
+	}
+	if err != nil {
+		return err
+	}
+
+	var sender models.User
+	err = t.db.Collection("users").FindOne(ctx, bson.M{"_id": senderID}).Decode(&sender)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// The money is already in transit, so a deleted sender only costs the
+		// recipient's history its name
+		log.Printf("⚠️ Sender %s of Siha transfer %s not found", senderID.Hex(), reference)
+	} else if err != nil {
+		return fmt.Errorf("failed to look up sender: %w", err)
+	}
+
+	session, err := t.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start transfer session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		err := t.ledger.PostInTransaction(sc, &models.JournalEntry{
+			Reference:   "delivery:" + reference,
+			Type:        "delivery",
+			Description: "Siha wallet transfer",
+			Currency:    amount.Currency,
+			Postings: []models.Posting{
+				{AccountCode: LedgerAccountInTransit, Direction: "debit", Amount: amount.Minor},
+				{AccountCode: WalletAccountCode(recipient.ID), Direction: "credit", Amount: amount.Minor},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = t.db.Collection("transactions").InsertOne(sc, receiveEntry(reference, amount, &sender, recipient))
+		return nil, err
+	})
+	if errors.Is(err, ErrDuplicateEntry) {
+		return nil
+	}
+	return err
+}
+
+// insertHistory records the transfer in both users' transaction history
+func (t *SihaTransferService) insertHistory(sc mongo.SessionContext, send *models.Transaction, sender, recipient *models.User) error {
+	now := time.Now()
+	sent := models.UnifiedTransaction{
+		UserID:           send.FromUserID,
+		Type:             "send",
+		Amount:           send.Amount,
+		Status:           statemachine.SendCompleted,
+		TransactionID:    send.ID.Hex(),
+		RecipientName:    DisplayName(recipient),
+		RecipientAccount: "@" + recipient.WalletHandle,
+		RecipientType:    "siha_wallet",
+		QueueStatus:      "completed",
+		ProcessedAt:      &now,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	_, err := t.db.Collection("transactions").InsertMany(sc, []interface{}{
+		sent,
+		receiveEntry(send.ID.Hex(), send.Amount, sender, recipient),
+	})
+	return err
+}
+
+func receiveEntry(reference string, amount models.Money, sender, recipient *models.User) models.UnifiedTransaction {
+	now := time.Now()
+	return models.UnifiedTransaction{
+		UserID:        recipient.ID,
+		Type:          "receive",
+		Amount:        amount,
+		Status:        statemachine.SendCompleted,
+		TransactionID: reference,
+		SenderName:    DisplayName(sender),
+		RecipientType: "siha_wallet",
+		QueueStatus:   "completed",
+		ProcessedAt:   &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// DisplayName is the name shown to the other party: first name and last initial
+func DisplayName(user *models.User) string {
+	name := strings.TrimSpace(user.FirstName)
+	if last := []rune(strings.TrimSpace(user.LastName)); len(last) > 0 {
+		name += " " + string(last[0]) + "."
+	}
+	if name == "" && user.WalletHandle != "" {
+		return "@" + user.WalletHandle
+	}
+	return name
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSihaTransfer(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	senderID := primitive.NewObjectID()
+	recipient := &models.User{ID: primitive.NewObjectID(), FirstName: "Kofi", WalletHandle: "kofi"}
+	newSend := func() *models.Transaction {
+		return &models.Transaction{
+			FromUserID:        senderID,
+			Amount:            models.NewMoney(1000, "GHS"),
+			InvestmentAmount:  models.NewMoney(100, "GHS"),
+			RecipientCurrency: "GHS",
+		}
+	}
+	sender := mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch, bson.D{
+		{Key: "_id", Value: senderID},
+		{Key: "first_name", Value: "Ama"},
+	})
+	wallet := func(minor int64) bson.D {
+		return mtest.CreateCursorResponse(0, "test.wallets", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "user_id", Value: senderID},
+			{Key: "balance", Value: bson.D{{Key: "minor", Value: minor}, {Key: "currency", Value: "GHS"}}},
+		})
+	}
+	ok := mtest.CreateSuccessResponse()
+
+	mt.Run("to yourself", func(mt *mtest.T) {
+		err := NewSihaTransferService(mt.DB).Transfer(context.Background(), newSend(), &models.User{ID: senderID})
+		if !errors.Is(err, ErrSelfTransfer) || mt.GetStartedEvent() != nil {
+			mt.Errorf("error = %v, want ErrSelfTransfer before any command", err)
+		}
+	})
+
+	mt.Run("amount plus investment over the balance", func(mt *mtest.T) {
+		mt.AddMockResponses(sender, wallet(1050), ok)
+		err := NewSihaTransferService(mt.DB).Transfer(context.Background(), newSend(), recipient)
+		if !errors.Is(err, ErrInsufficientFunds) {
+			mt.Errorf("error = %v, want ErrInsufficientFunds", err)
+		}
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName == "insert" || event.CommandName == "commitTransaction" {
+				mt.Errorf("%s sent for a refused transfer", event.CommandName)
+			}
+		}
+	})
+
+	mt.Run("balance spent by a concurrent transfer", func(mt *mtest.T) {
+		// The wallet read allows it, but the guarded debit matches nothing
+		mt.AddMockResponses(sender, wallet(5000), ok,
+			mockUpdated(1), mockUpdated(1), mockUpdated(1), ok,
+			mockUpdated(0), mockCount(1), ok)
+		err := NewSihaTransferService(mt.DB).Transfer(context.Background(), newSend(), recipient)
+		if !errors.Is(err, ErrInsufficientFunds) {
+			mt.Errorf("error = %v, want ErrInsufficientFunds", err)
+		}
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName == "commitTransaction" {
+				mt.Error("refused transfer committed")
+			}
+		}
+	})
+
+	mt.Run("completed", func(mt *mtest.T) {
+		mt.AddMockResponses(sender, wallet(5000), ok,
+			mockUpdated(1), mockUpdated(1), mockUpdated(1), ok,
+			mockUpdated(1), mockUpdated(1), ok, ok, ok)
+		send := newSend()
+		if err := NewSihaTransferService(mt.DB).Transfer(context.Background(), send, recipient); err != nil {
+			mt.Fatal(err)
+		}
+		if send.ID.IsZero() || send.ToUserID != recipient.ID || send.InvestmentStatus != "allocated" {
+			mt.Errorf("send = %+v", send)
+		}
+
+		var journal, committed bool
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			switch {
+			case event.CommandName == "commitTransaction":
+				committed = true
+			case event.CommandName == "insert" && event.Command.Lookup("insert").StringValue() == "journal_entries":
+				journal = true
+				postings, _ := event.Command.Lookup("documents").Array().Index(0).Value().Document().Lookup("postings").Array().Values()
+				if len(postings) != 3 {
+					mt.Errorf("%d postings, want sender, recipient and investment", len(postings))
+				}
+				want := map[string]int64{
+					WalletAccountCode(senderID):     1100,
+					WalletAccountCode(recipient.ID): 1000,
+					InvestmentAccountCode(senderID): 100,
+				}
+				for _, posting := range postings {
+					code := posting.Document().Lookup("account_code").StringValue()
+					if amount := posting.Document().Lookup("amount").AsInt64(); amount != want[code] {
+						mt.Errorf("posting to %s = %d, want %d", code, amount, want[code])
+					}
+				}
+			}
+		}
+		if !journal || !committed {
+			mt.Errorf("journal entry posted = %v, committed = %v", journal, committed)
+		}
+	})
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AuditSocialLink   = "social_link"
+	AuditSocialUnlink = "social_unlink"
+)
+
+const (
+	// Link attempts allowed per user per window, whatever their outcome
+	socialLinkAttempts = 5
+	socialLinkWindow   = time.Hour
+	// Wrong codes allowed per emailed link code
+	socialLinkCodeAttempts = 5
+	socialLinkCodeTTL      = 10 * time.Minute
+	// Minimum time between link codes
+	socialLinkCodeInterval = time.Minute
+)
+
+var (
+	ErrLinkConfirmationRequired = errors.New("password or email code required")
+	ErrLinkConfirmationFailed   = errors.New("wrong password or code")
+	ErrSocialAccountTaken       = errors.New("social account linked to another user")
+	ErrProviderAlreadyLinked    = errors.New("provider already linked")
+	ErrSocialAccountNotFound    = errors.New("social account not found")
+	ErrLastLoginMethod          = errors.New("last login method")
+)
+
+// SocialLinkRequest - Provider token to link, confirmed with the user's
+// password or a code from RequestLinkCode
+type SocialLinkRequest struct {
+	Token    string `json:"token" binding:"required"` // ID token for Google and Apple, access token for Facebook
+	Nonce    string `json:"nonce"`                    // From /auth/social/nonce; Google and Apple only
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// SocialAccountService lets signed-in users attach and detach provider
+// accounts. Linking needs the password or an emailed code, so a stolen
+// session can't add a way back in; unlinking never removes the last way to
+// log in.
+type SocialAccountService struct {
+	db         *mongo.Database
+	audit      *AuditService
+	identities *SocialIdentityService
+}
+
+func NewSocialAccountService(db *mongo.Database) *SocialAccountService {
+	return &SocialAccountService{db: db, audit: NewAuditService(db), identities: NewSocialIdentityService(db)}
+}
+
+// EnsureIndexes lets a provider account belong to one user, gives a user one
+// account per provider and drops expired link codes
+func (s *SocialAccountService) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("social_accounts").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "provider_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "provider", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create social account indexes: %w", err)
+	}
+	_, err = s.db.Collection("social_link_codes").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create social link code indexes: %w", err)
+	}
+	return nil
+}
+
+// List returns the user's linked accounts and whether they have a password
+func (s *SocialAccountService) List(ctx context.Context, userID primitive.ObjectID) ([]models.SocialAccount, bool, error) {
+	user, err := s.user(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	cursor, err := s.db.Collection("social_accounts").Find(ctx, bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list social accounts: %w", err)
+	}
+	accounts := []models.SocialAccount{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, false, fmt.Errorf("failed to list social accounts: %w", err)
+	}
+	return accounts, user.Password != "", nil
+}
+
+// RequestLinkCode emails the user a code that confirms a link, for users
+// without a password. A new request replaces the previous code.
+func (s *SocialAccountService) RequestLinkCode(ctx context.Context, userID primitive.ObjectID) error {
+	user, err := s.user(ctx, userID)
+	if err != nil {
+		return err
+	}
+	codes := s.db.Collection("social_link_codes")
+
+	var previous struct {
+		CreatedAt time.Time `bson:"created_at"`
+	}
+	err = codes.FindOne(ctx, bson.M{"_id": userID}).Decode(&previous)
+	if err == nil && time.Since(previous.CreatedAt) < socialLinkCodeInterval {
+		return ErrTooManyAttempts
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to load link code: %w", err)
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	hashed, err := utils.HashPassword(code)
+	if err != nil {
+		return fmt.Errorf("failed to hash link code: %w", err)
+	}
+	now := time.Now()
+	_, err = codes.ReplaceOne(ctx, bson.M{"_id": userID}, bson.M{
+		"code":       hashed,
+		"attempts":   0,
+		"created_at": now,
+		"expires_at": now.Add(socialLinkCodeTTL),
+	}, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to store link code: %w", err)
+	}
+	return utils.SendVerificationEmail(user.Email, code)
+}
+
+// Link attaches the provider account in req.Token to the user. Linking an
+// account the user already has is a no-op.
+func (s *SocialAccountService) Link(ctx context.Context, userID primitive.ObjectID, provider string, req SocialLinkRequest) (*models.SocialAccount, error) {
+	if req.Password == "" && req.Code == "" {
+		return nil, ErrLinkConfirmationRequired
+	}
+	// Record the attempt before counting, so parallel requests can't all pass the limit
+	event := models.AuditEvent{UserID: userID, Action: AuditSocialLink, Outcome: AuditOutcomePending}
+	id, err := s.audit.Record(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	event.ID = id
+	refuse := func(outcome string, err error) (*models.SocialAccount, error) {
+		event.Outcome = outcome
+		s.audit.Resolve(ctx, event)
+		return nil, err
+	}
+
+	attempts, err := s.audit.CountSince(ctx, userID, AuditSocialLink, time.Now().Add(-socialLinkWindow), "rate_limited")
+	if err != nil {
+		return refuse("error", fmt.Errorf("failed to count link attempts: %w", err))
+	}
+	if attempts > socialLinkAttempts {
+		return refuse("rate_limited", ErrTooManyAttempts)
+	}
+
+	if err := s.confirm(ctx, userID, req); err != nil {
+		if errors.Is(err, ErrLinkConfirmationFailed) {
+			return refuse("confirmation_failed", err)
+		}
+		return refuse("error", err)
+	}
+
+	identity, err := s.identities.Verify(ctx, provider, req.Token, req.Nonce)
+	if err != nil {
+		return refuse("invalid_token", err)
+	}
+
+	accounts := s.db.Collection("social_accounts")
+	var existing models.SocialAccount
+	err = accounts.FindOne(ctx, bson.M{"provider": identity.Provider, "provider_id": identity.ProviderID}).Decode(&existing)
+	if err == nil {
+		if existing.UserID != userID {
+			return refuse("taken", ErrSocialAccountTaken)
+		}
+		event.Outcome = "already_linked"
+		s.audit.Resolve(ctx, event)
+		return &existing, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return refuse("error", fmt.Errorf("failed to load social account: %w", err))
+	}
+
+	account := models.SocialAccount{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		Provider:       identity.Provider,
+		ProviderID:     identity.ProviderID,
+		Email:          identity.Email,
+		IsPrivateEmail: identity.IsPrivateEmail,
+		CreatedAt:      time.Now(),
+	}
+	if _, err := accounts.InsertOne(ctx, account); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			return refuse("error", fmt.Errorf("failed to link social account: %w", err))
+		}
+		// Either index: the provider account was just linked elsewhere, or the
+		// user already has one from this provider
+		if count, _ := accounts.CountDocuments(ctx, bson.M{"user_id": userID, "provider": identity.Provider}); count > 0 {
+			return refuse("provider_already_linked", ErrProviderAlreadyLinked)
+		}
+		return refuse("taken", ErrSocialAccountTaken)
+	}
+
+	event.Outcome = "success"
+	s.audit.Resolve(ctx, event)
+	return &account, nil
+}
+
+// confirm checks the password, or uses up the emailed link code
+func (s *SocialAccountService) confirm(ctx context.Context, userID primitive.ObjectID, req SocialLinkRequest) error {
+	if req.Password != "" {
+		user, err := s.user(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.Password == "" || !utils.CheckPassword(req.Password, user.Password) {
+			return ErrLinkConfirmationFailed
+		}
+		return nil
+	}
+
+	// Count the attempt first, as PIN resets do
+	codes := s.db.Collection("social_link_codes")
+	var stored struct {
+		Code string `bson:"code"`
+	}
+	err := codes.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID, "expires_at": bson.M{"$gt": time.Now()}, "attempts": bson.M{"$lt": socialLinkCodeAttempts}},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+	).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		return ErrLinkConfirmationFailed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load link code: %w", err)
+	}
+	if !utils.CheckPassword(req.Code, stored.Code) {
+		return ErrLinkConfirmationFailed
+	}
+	result, err := codes.DeleteOne(ctx, bson.M{"_id": userID, "code": stored.Code})
+	if err != nil {
+		return fmt.Errorf("failed to use link code: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		// Another link used the code first
+		return ErrLinkConfirmationFailed
+	}
+	return nil
+}
+
+// Unlink detaches the user's account from provider, unless it is their only
+// way to log in
+func (s *SocialAccountService) Unlink(ctx context.Context, userID primitive.ObjectID, provider string) error {
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// Writing the user makes parallel unlinks conflict, so two of them
+		// can't each leave the other's account as the last one and remove both
+		var user models.User
+		err := s.db.Collection("users").FindOneAndUpdate(sc, bson.M{"_id": userID},
+			bson.M{"$set": bson.M{"updated_at": time.Now()}}).Decode(&user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load user: %w", err)
+		}
+
+		accounts := s.db.Collection("social_accounts")
+		linked, err := accounts.CountDocuments(sc, bson.M{"user_id": userID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to count social accounts: %w", err)
+		}
+		result, err := accounts.DeleteOne(sc, bson.M{"user_id": userID, "provider": provider})
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlink social account: %w", err)
+		}
+		if result.DeletedCount == 0 {
+			return nil, ErrSocialAccountNotFound
+		}
+		if user.Password == "" && linked <= 1 {
+			return nil, ErrLastLoginMethod
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.audit.Record(ctx, models.AuditEvent{UserID: userID, Action: AuditSocialUnlink, Outcome: "success"})
+	return nil
+}
+
+func (s *SocialAccountService) user(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	var user models.User
+	if err := s.db.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	return &user, nil
+}
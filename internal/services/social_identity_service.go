@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How long a sign-in nonce can wait for the provider's token
+const socialNonceTTL = 10 * time.Minute
+
+var ErrInvalidNonce = errors.New("invalid or expired sign-in nonce")
+
+// Verifiers are shared so their JWKS caches outlive a handler
+var (
+	socialVerifiersOnce sync.Once
+	googleVerifier      *OIDCTokenVerifier
+	appleVerifier       *OIDCTokenVerifier
+	facebookVerifier    *FacebookTokenVerifier
+)
+
+func loadSocialVerifiers() {
+	socialVerifiersOnce.Do(func() {
+		if clientIDs := splitList(os.Getenv("GOOGLE_CLIENT_IDS")); len(clientIDs) > 0 {
+			googleVerifier = NewOIDCTokenVerifier(OIDCConfig{
+				Provider:  "google",
+				Issuers:   []string{"https://accounts.google.com", "accounts.google.com"},
+				Audiences: clientIDs,
+				JWKSURL:   "https://www.googleapis.com/oauth2/v3/certs",
+			})
+		}
+		if clientIDs := splitList(os.Getenv("APPLE_CLIENT_IDS")); len(clientIDs) > 0 {
+			appleVerifier = NewOIDCTokenVerifier(OIDCConfig{
+				Provider:  "apple",
+				Issuers:   []string{"https://appleid.apple.com"},
+				Audiences: clientIDs,
+				JWKSURL:   "https://appleid.apple.com/auth/keys",
+			})
+		}
+		appID, appSecret := os.Getenv("FACEBOOK_APP_ID"), os.Getenv("FACEBOOK_APP_SECRET")
+		if appID != "" && appSecret != "" {
+			facebookVerifier = NewFacebookTokenVerifier(FacebookConfig{AppID: appID, AppSecret: appSecret})
+		}
+	})
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SocialIdentityService turns provider tokens into verified identities. Google
+// and Apple tokens must carry a nonce we issued, which is used up by the
+// sign-in, so a token can't be replayed.
+type SocialIdentityService struct {
+	db       *mongo.Database
+	google   *OIDCTokenVerifier
+	apple    *OIDCTokenVerifier
+	facebook *FacebookTokenVerifier
+}
+
+// NewSocialIdentityService uses the providers configured by GOOGLE_CLIENT_IDS,
+// APPLE_CLIENT_IDS and FACEBOOK_APP_ID/FACEBOOK_APP_SECRET
+func NewSocialIdentityService(db *mongo.Database) *SocialIdentityService {
+	loadSocialVerifiers()
+	return &SocialIdentityService{db: db, google: googleVerifier, apple: appleVerifier, facebook: facebookVerifier}
+}
+
+// EnsureIndexes drops nonces once they expire
+func (s *SocialIdentityService) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("social_nonces").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create social nonce indexes: %w", err)
+	}
+	return nil
+}
+
+// IssueNonce returns a nonce for the client to pass to Google or Apple
+func (s *SocialIdentityService) IssueNonce(ctx context.Context) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(raw)
+	expiresAt := time.Now().Add(socialNonceTTL)
+	_, err := s.db.Collection("social_nonces").InsertOne(ctx, bson.M{"_id": nonce, "expires_at": expiresAt})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store nonce: %w", err)
+	}
+	return nonce, expiresAt, nil
+}
+
+// Verify checks a provider token and returns who it identifies. token is an
+// ID token for Google and Apple, which need nonce, and an access token for
+// Facebook.
+func (s *SocialIdentityService) Verify(ctx context.Context, provider, token, nonce string) (*SocialIdentity, error) {
+	var identity *SocialIdentity
+	var err error
+	switch provider {
+	case "google", "apple":
+		verifier := s.google
+		if provider == "apple" {
+			verifier = s.apple
+		}
+		if verifier == nil {
+			return nil, fmt.Errorf("%w: %s", ErrSocialProviderNotConfigured, provider)
+		}
+		if identity, err = verifier.Verify(ctx, token, nonce); err != nil {
+			return nil, err
+		}
+		if err := s.useNonce(ctx, nonce); err != nil {
+			return nil, err
+		}
+	case "facebook":
+		if s.facebook == nil {
+			return nil, fmt.Errorf("%w: %s", ErrSocialProviderNotConfigured, provider)
+		}
+		if identity, err = s.facebook.Verify(ctx, token); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrSocialProviderNotConfigured, provider)
+	}
+	return identity, nil
+}
+
+// useNonce deletes an unexpired nonce; only one sign-in can use it
+func (s *SocialIdentityService) useNonce(ctx context.Context, nonce string) error {
+	result, err := s.db.Collection("social_nonces").DeleteOne(ctx, bson.M{"_id": nonce, "expires_at": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("failed to use nonce: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrInvalidNonce
+	}
+	return nil
+}
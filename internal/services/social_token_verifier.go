@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// Keys are refetched this often, and sooner when a token names an unknown kid
+	jwksCacheTTL = time.Hour
+	// Unknown kids can't make us refetch more often than this
+	jwksMinRefreshInterval = time.Minute
+	// Clock skew allowed on exp, iat and nbf
+	socialTokenLeeway = time.Minute
+)
+
+var (
+	ErrInvalidSocialToken          = errors.New("invalid social sign-in token")
+	ErrSocialProviderNotConfigured = errors.New("social sign-in provider not configured")
+	ErrSocialProviderUnavailable   = errors.New("social sign-in provider unavailable")
+)
+
+// SocialIdentity - Who a provider vouches for, taken from verified claims only
+type SocialIdentity struct {
+	Provider       string
+	ProviderID     string // Stable user ID at the provider: sub, or the Facebook user ID
+	Email          string
+	EmailVerified  bool // The provider says the user controls Email
+	IsPrivateEmail bool // An Apple private relay address, unique to our app
+	Name           string
+}
+
+// JWKSCache - A provider's token signing keys, fetched from its JWKS URL
+type JWKSCache struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func NewJWKSCache(url string) *JWKSCache {
+	return &JWKSCache{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Key returns the key named kid, refetching the JWKS when it is stale or
+// doesn't have kid yet
+func (j *JWKSCache) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	key, ok := j.keys[kid]
+	age := time.Since(j.fetchedAt)
+	if ok && age < jwksCacheTTL {
+		return key, nil
+	}
+	if !ok && age < jwksMinRefreshInterval {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidSocialToken, kid)
+	}
+
+	if err := j.fetch(ctx); err != nil {
+		if ok {
+			// Keep verifying with the key we have until the provider answers again
+			log.Printf("⚠️ Refreshing %s failed, using cached keys: %v", j.url, err)
+			return key, nil
+		}
+		return nil, err
+	}
+	if key, ok = j.keys[kid]; !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidSocialToken, kid)
+	}
+	return key, nil
+}
+
+func (j *JWKSCache) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: fetching %s: %v", ErrSocialProviderUnavailable, j.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: fetching %s: status %d", ErrSocialProviderUnavailable, j.url, resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("%w: invalid JWKS from %s: %v", ErrSocialProviderUnavailable, j.url, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" || jwk.KeyID == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			log.Printf("⚠️ Skipping malformed key %s in %s", jwk.KeyID, j.url)
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	return nil
+}
+
+// OIDCConfig - What an OpenID Connect provider's ID tokens must carry
+type OIDCConfig struct {
+	Provider  string
+	Issuers   []string
+	Audiences []string // Our client IDs at the provider, one per app
+	JWKSURL   string
+}
+
+// OIDCTokenVerifier checks ID tokens from Google or Apple: signature against
+// the provider's JWKS, issuer, audience, expiry and nonce
+type OIDCTokenVerifier struct {
+	config OIDCConfig
+	keys   *JWKSCache
+}
+
+func NewOIDCTokenVerifier(config OIDCConfig) *OIDCTokenVerifier {
+	return &OIDCTokenVerifier{config: config, keys: NewJWKSCache(config.JWKSURL)}
+}
+
+// Verify returns the identity in idToken. The token's nonce claim must be
+// nonce itself or, as Apple clients send it, its SHA-256 in hex.
+func (v *OIDCTokenVerifier) Verify(ctx context.Context, idToken, nonce string) (*SocialIdentity, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.keys.Key(ctx, kid)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithLeeway(socialTokenLeeway), jwt.WithIssuedAt())
+	if errors.Is(err, ErrSocialProviderUnavailable) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSocialToken, err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidSocialToken
+	}
+
+	if exp, err := claims.GetExpirationTime(); err != nil || exp == nil {
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidSocialToken)
+	}
+	issuer, _ := claims.GetIssuer()
+	if !contains(v.config.Issuers, issuer) {
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidSocialToken, issuer)
+	}
+	audiences, _ := claims.GetAudience()
+	if !containsAny(v.config.Audiences, audiences) {
+		return nil, fmt.Errorf("%w: audience %v", ErrInvalidSocialToken, audiences)
+	}
+	if !nonceMatches(claims["nonce"], nonce) {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidSocialToken)
+	}
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidSocialToken)
+	}
+
+	identity := &SocialIdentity{Provider: v.config.Provider, ProviderID: subject}
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.EmailVerified = claimTrue(claims["email_verified"])
+	identity.IsPrivateEmail = claimTrue(claims["is_private_email"])
+	return identity, nil
+}
+
+// claimTrue reads a boolean claim, which Apple sends as a string
+func claimTrue(claim interface{}) bool {
+	switch value := claim.(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+func nonceMatches(claim interface{}, nonce string) bool {
+	got, _ := claim.(string)
+	if got == "" || nonce == "" {
+		return false
+	}
+	hashed := sha256.Sum256([]byte(nonce))
+	return subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) == 1 ||
+		subtle.ConstantTimeCompare([]byte(got), []byte(hex.EncodeToString(hashed[:]))) == 1
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v != "" && v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values, candidates []string) bool {
+	for _, candidate := range candidates {
+		if contains(values, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// FacebookConfig - Our Facebook app, for debug_token checks
+type FacebookConfig struct {
+	AppID     string
+	AppSecret string
+	GraphURL  string // Defaults to https://graph.facebook.com
+}
+
+// FacebookTokenVerifier checks user access tokens with the Graph API's
+// debug_token, then reads the profile the token belongs to
+type FacebookTokenVerifier struct {
+	config FacebookConfig
+	client *http.Client
+}
+
+func NewFacebookTokenVerifier(config FacebookConfig) *FacebookTokenVerifier {
+	if config.GraphURL == "" {
+		config.GraphURL = "https://graph.facebook.com"
+	}
+	config.GraphURL = strings.TrimRight(config.GraphURL, "/")
+	return &FacebookTokenVerifier{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Verify returns the identity of a user access token issued to our app.
+// Facebook doesn't say whether the email is confirmed, so it is never
+// treated as verified.
+func (f *FacebookTokenVerifier) Verify(ctx context.Context, accessToken string) (*SocialIdentity, error) {
+	var debug struct {
+		Data struct {
+			AppID     string `json:"app_id"`
+			Type      string `json:"type"`
+			IsValid   bool   `json:"is_valid"`
+			UserID    string `json:"user_id"`
+			ExpiresAt int64  `json:"expires_at"` // 0 for tokens that don't expire
+		} `json:"data"`
+	}
+	err := f.get(ctx, "/debug_token", url.Values{
+		"input_token":  {accessToken},
+		"access_token": {f.config.AppID + "|" + f.config.AppSecret},
+	}, &debug)
+	if err != nil {
+		return nil, err
+	}
+	data := debug.Data
+	if !data.IsValid || data.AppID != f.config.AppID || data.UserID == "" || (data.Type != "" && data.Type != "USER") {
+		return nil, fmt.Errorf("%w: token not valid for this app", ErrInvalidSocialToken)
+	}
+	if data.ExpiresAt != 0 && time.Now().After(time.Unix(data.ExpiresAt, 0)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidSocialToken)
+	}
+
+	var profile struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	proof := hmac.New(sha256.New, []byte(f.config.AppSecret))
+	proof.Write([]byte(accessToken))
+	err = f.get(ctx, "/me", url.Values{
+		"fields":          {"id,name,email"},
+		"access_token":    {accessToken},
+		"appsecret_proof": {hex.EncodeToString(proof.Sum(nil))},
+	}, &profile)
+	if err != nil {
+		return nil, err
+	}
+	if profile.ID != data.UserID {
+		return nil, fmt.Errorf("%w: profile doesn't match token", ErrInvalidSocialToken)
+	}
+	return &SocialIdentity{Provider: "facebook", ProviderID: profile.ID, Email: profile.Email, Name: profile.Name}, nil
+}
+
+// get calls the Graph API. Its 4xx answers mean the token was refused.
+func (f *FacebookTokenVerifier) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.config.GraphURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSocialProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return fmt.Errorf("%w: Facebook answered %d", ErrInvalidSocialToken, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: Facebook %s answered %d", ErrSocialProviderUnavailable, path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: invalid Facebook %s response: %v", ErrSocialProviderUnavailable, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcStandIn is a local stand-in for Google's or Apple's JWKS endpoint. It
+// signs ID tokens with its current key; rotate swaps in a new one.
+type oidcStandIn struct {
+	t       *testing.T
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	kid     string
+	fetches int32
+}
+
+func newOIDCStandIn(t *testing.T) *oidcStandIn {
+	s := &oidcStandIn{t: t}
+	s.rotate("key-1")
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": s.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *oidcStandIn) rotate(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.key, s.kid = key, kid
+}
+
+func (s *oidcStandIn) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return signed
+}
+
+func validIDTokenClaims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":              "https://appleid.apple.com",
+		"aud":              "com.healthypay.app",
+		"sub":              "001234.abcdef",
+		"email":            "ama@privaterelay.appleid.com",
+		"email_verified":   "true",
+		"is_private_email": "true",
+		"nonce":            nonce,
+		"iat":              now.Unix(),
+		"exp":              now.Add(10 * time.Minute).Unix(),
+	}
+}
+
+func TestOIDCTokenVerifier(t *testing.T) {
+	ctx := context.Background()
+	provider := newOIDCStandIn(t)
+	verifier := NewOIDCTokenVerifier(OIDCConfig{
+		Provider:  "apple",
+		Issuers:   []string{"https://appleid.apple.com"},
+		Audiences: []string{"com.healthypay.app"},
+		JWKSURL:   provider.server.URL,
+	})
+
+	identity, err := verifier.Verify(ctx, provider.sign(validIDTokenClaims("n1")), "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Provider != "apple" || identity.ProviderID != "001234.abcdef" || identity.Email != "ama@privaterelay.appleid.com" || !identity.EmailVerified || !identity.IsPrivateEmail {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	// Apple clients put the SHA-256 of the nonce in the token
+	hashed := sha256.Sum256([]byte("n2"))
+	if _, err := verifier.Verify(ctx, provider.sign(validIDTokenClaims(hex.EncodeToString(hashed[:]))), "n2"); err != nil {
+		t.Errorf("hashed nonce: %v", err)
+	}
+
+	tampered := func(change func(jwt.MapClaims)) string {
+		claims := validIDTokenClaims("n1")
+		change(claims)
+		return provider.sign(claims)
+	}
+	rejected := map[string]string{
+		"wrong audience": tampered(func(c jwt.MapClaims) { c["aud"] = "com.other.app" }),
+		"wrong issuer":   tampered(func(c jwt.MapClaims) { c["iss"] = "https://accounts.google.com" }),
+		"expired":        tampered(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }),
+		"no expiry":      tampered(func(c jwt.MapClaims) { delete(c, "exp") }),
+		"wrong nonce":    tampered(func(c jwt.MapClaims) { c["nonce"] = "other" }),
+		"no nonce":       tampered(func(c jwt.MapClaims) { delete(c, "nonce") }),
+		"no subject":     tampered(func(c jwt.MapClaims) { delete(c, "sub") }),
+		"not a JWT":      "not-a-token",
+	}
+	for name, token := range rejected {
+		if _, err := verifier.Verify(ctx, token, "n1"); !errors.Is(err, ErrInvalidSocialToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidSocialToken", name, err)
+		}
+	}
+
+	// A token signed by someone else under a kid the provider uses
+	forger := &oidcStandIn{t: t}
+	forger.rotate(provider.kid)
+	if _, err := verifier.Verify(ctx, forger.sign(validIDTokenClaims("n1")), "n1"); !errors.Is(err, ErrInvalidSocialToken) {
+		t.Errorf("forged signature: err = %v", err)
+	}
+
+	// A rotated provider key is fetched once the refresh interval has passed
+	provider.rotate("key-2")
+	rotated := provider.sign(validIDTokenClaims("n1"))
+	if _, err := verifier.Verify(ctx, rotated, "n1"); !errors.Is(err, ErrInvalidSocialToken) {
+		t.Errorf("unknown kid within the refresh interval: err = %v", err)
+	}
+	fetches := atomic.LoadInt32(&provider.fetches)
+	verifier.keys.fetchedAt = time.Now().Add(-jwksMinRefreshInterval)
+	if _, err := verifier.Verify(ctx, rotated, "n1"); err != nil {
+		t.Errorf("rotated key: %v", err)
+	}
+	if got := atomic.LoadInt32(&provider.fetches); got != fetches+1 {
+		t.Errorf("JWKS fetched %d times for the rotated key, want 1", got-fetches)
+	}
+}
+
+func TestOIDCTokenVerifierProviderDown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	provider := &oidcStandIn{t: t}
+	provider.rotate("key-1")
+
+	verifier := NewOIDCTokenVerifier(OIDCConfig{Provider: "google", Issuers: []string{"https://appleid.apple.com"}, Audiences: []string{"com.healthypay.app"}, JWKSURL: server.URL})
+	_, err := verifier.Verify(context.Background(), provider.sign(validIDTokenClaims("n1")), "n1")
+	if !errors.Is(err, ErrSocialProviderUnavailable) {
+		t.Errorf("err = %v, want ErrSocialProviderUnavailable", err)
+	}
+}
+
+// newGraphStandIn answers debug_token and /me like the Graph API for one
+// valid user token
+func newGraphStandIn(t *testing.T, debug map[string]interface{}, profileID string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/debug_token":
+			if query.Get("access_token") != "app-1|secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": debug})
+		case "/me":
+			if query.Get("access_token") != "user-token" || query.Get("appsecret_proof") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"id": profileID, "name": "Kofi Mensah", "email": "kofi@example.com"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFacebookTokenVerifier(t *testing.T) {
+	ctx := context.Background()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"app_id": "app-1", "type": "USER", "is_valid": true, "user_id": "fb-42", "expires_at": time.Now().Add(time.Hour).Unix()}
+	}
+	verifier := func(server *httptest.Server) *FacebookTokenVerifier {
+		return NewFacebookTokenVerifier(FacebookConfig{AppID: "app-1", AppSecret: "secret", GraphURL: server.URL})
+	}
+
+	identity, err := verifier(newGraphStandIn(t, valid(), "fb-42")).Verify(ctx, "user-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.ProviderID != "fb-42" || identity.Email != "kofi@example.com" || identity.EmailVerified {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	otherApp := valid()
+	otherApp["app_id"] = "app-2"
+	invalid := valid()
+	invalid["is_valid"] = false
+	expired := valid()
+	expired["expires_at"] = time.Now().Add(-time.Minute).Unix()
+	for name, server := range map[string]*httptest.Server{
+		"other app":        newGraphStandIn(t, otherApp, "fb-42"),
+		"invalid":          newGraphStandIn(t, invalid, "fb-42"),
+		"expired":          newGraphStandIn(t, expired, "fb-42"),
+		"profile mismatch": newGraphStandIn(t, valid(), "fb-43"),
+	} {
+		if _, err := verifier(server).Verify(ctx, "user-token"); !errors.Is(err, ErrInvalidSocialToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidSocialToken", name, err)
+		}
+	}
+}
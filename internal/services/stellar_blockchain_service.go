@@ -2,15 +2,24 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/utils"
 
 	"github.com/stellar/go/clients/horizonclient"
+	"github.com/stellar/go/keypair"
 	"github.com/stellar/go/network"
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/go/xdr"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -25,6 +34,46 @@ type StellarBlockchainService struct {
 	distributorSecretKey  string
 	distributorPublicKey  string
 	stellarService 	   *StellarService
+	jobs                  *JobService
+}
+
+// Stellar job types
+const JobConfirmStellarTransaction = "blockchain.confirm_stellar_transaction"
+
+// A send whose outcome is unknown is looked up this often until it lands in a
+// ledger or its time bound passes
+const stellarConfirmPollDelay = 15 * time.Second
+
+// Circle's USDC issuers, STELLAR_USDC_CONTRACT_ADDRESS overrides the one on
+// the server's own network
+var stellarUSDCIssuers = map[string]string{
+	"mainnet": "GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN",
+	"testnet": "GBBD47IF6LWK7P7MDEVSCWR7DPUWV3NY3DTQEVFL4NAT4AQH3ZLLFLA5",
+}
+
+// stellarTxClient is the part of a Horizon client that settles a submitted send
+type stellarTxClient interface {
+	TransactionDetail(txHash string) (hProtocol.Transaction, error)
+	SubmitTransactionXDR(transactionXdr string) (hProtocol.Transaction, error)
+}
+
+// ErrInvalidSend is returned for sends rejected before anything is submitted
+var ErrInvalidSend = errors.New("invalid send")
+
+// ErrWalletConflict is returned when a restored wallet clashes with an existing one
+var ErrWalletConflict = errors.New("wallet conflict")
+
+// SendAssetRequest - Payment from the user's own Stellar wallet
+type SendAssetRequest struct {
+	ToAddress string `json:"to_address" binding:"required"`
+	Amount    string `json:"amount" binding:"required"`
+	AssetCode string `json:"asset_code" binding:"required"` // "XLM" or "USDC"
+	Memo      string `json:"memo"`
+}
+
+// RestoreWalletRequest - Recovery phrase of an existing Stellar wallet
+type RestoreWalletRequest struct {
+	Mnemonic string `json:"mnemonic" binding:"required"`
 }
 
 type CreateWalletRequest struct {
@@ -58,9 +107,15 @@ func NewStellarBlockchainService(db interface{}) *StellarBlockchainService {
 		distributorSecretKey:  distributorSecret,
 		distributorPublicKey:  distributorPublic,
 		stellarService:        NewStellarService(),
+		jobs:                  NewJobService(db.(*mongo.Database)),
 	}
 }
 
+// RegisterJobHandlers adds the send confirmation handler to a worker
+func (s *StellarBlockchainService) RegisterJobHandlers(worker *JobWorker) {
+	worker.Handle(JobConfirmStellarTransaction, s.ConfirmTransaction)
+}
+
 func (s *StellarBlockchainService) CreateWallet(userID primitive.ObjectID, network string) (*models.BlockchainWallet, error) {
 	// Check if blockchain wallet already exists
 	var existingWallet models.BlockchainWallet
@@ -90,7 +145,87 @@ func (s *StellarBlockchainService) CreateWallet(userID primitive.ObjectID, netwo
 	}
 
 	// Convert StellarWallet to BlockchainWallet format
-	wallet := &models.BlockchainWallet{
+	wallet := newStellarBlockchainWallet(userID, stellarNetwork, stellarWallet)
+
+	fmt.Printf("Saving wallet to blockchain_wallets collection: %s\n", wallet.PublicKey)
+	
+	result, err := s.db.Collection("blockchain_wallets").InsertOne(context.Background(), wallet)
+	if err != nil {
+		fmt.Printf("Error inserting blockchain wallet: %v\n", err)
+		return nil, fmt.Errorf("failed to save blockchain wallet: %v", err)
+	}
+
+	wallet.ID = result.InsertedID.(primitive.ObjectID)
+	fmt.Printf("Successfully created blockchain wallet with ID: %s\n", wallet.ID.Hex())
+	return wallet, nil
+}
+
+// RestoreWallet rebuilds the user's Stellar wallet from its recovery phrase.
+// Restoring the wallet the user already has re-saves its secrets; a wallet
+// owned by another user or a different active wallet is a conflict.
+func (s *StellarBlockchainService) RestoreWallet(userID primitive.ObjectID, mnemonic string) (*models.BlockchainWallet, error) {
+	stellarWallet, err := s.stellarService.RestoreActiveAccount(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	collection := s.db.Collection("blockchain_wallets")
+
+	var existing models.BlockchainWallet
+	err = collection.FindOne(ctx, bson.M{
+		"blockchain": "stellar",
+		"public_key": stellarWallet.PublicKey,
+		"is_active":  true,
+		"user_id":    bson.M{"$ne": userID},
+	}).Decode(&existing)
+	if err == nil {
+		return nil, fmt.Errorf("%w: wallet belongs to another user", ErrWalletConflict)
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to look up wallet: %w", err)
+	}
+
+	err = collection.FindOne(ctx, bson.M{"user_id": userID, "blockchain": "stellar", "is_active": true}).Decode(&existing)
+	if err == nil {
+		if existing.PublicKey != stellarWallet.PublicKey {
+			return nil, fmt.Errorf("%w: user already has wallet %s", ErrWalletConflict, existing.PublicKey)
+		}
+		existing.PrivateKey = stellarWallet.PrivateKey
+		existing.MnemonicPhrase = stellarWallet.MnemonicPhrase
+		existing.UpdatedAt = time.Now()
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": existing.ID}, bson.M{"$set": bson.M{
+			"private_key":     existing.PrivateKey,
+			"mnemonic_phrase": existing.MnemonicPhrase,
+			"updated_at":      existing.UpdatedAt,
+		}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update blockchain wallet: %w", err)
+		}
+		log.Printf("🔑 Restored Stellar wallet %s for user %s", existing.PublicKey, userID.Hex())
+		s.updateWalletBalances(&existing)
+		return &existing, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to look up wallet: %w", err)
+	}
+
+	stellarNetwork := os.Getenv("STELLAR_NETWORK")
+	if stellarNetwork == "" {
+		stellarNetwork = "testnet"
+	}
+	wallet := newStellarBlockchainWallet(userID, stellarNetwork, stellarWallet)
+	result, err := collection.InsertOne(ctx, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save blockchain wallet: %w", err)
+	}
+	wallet.ID = result.InsertedID.(primitive.ObjectID)
+	log.Printf("🔑 Restored Stellar wallet %s for user %s", wallet.PublicKey, userID.Hex())
+	s.updateWalletBalances(wallet)
+	return wallet, nil
+}
+
+func newStellarBlockchainWallet(userID primitive.ObjectID, stellarNetwork string, stellarWallet *WalletModel) *models.BlockchainWallet {
+	return &models.BlockchainWallet{
 		UserID:         userID,
 		Blockchain:     "stellar",
 		Network:        stellarNetwork,
@@ -106,18 +241,6 @@ func (s *StellarBlockchainService) CreateWallet(userID primitive.ObjectID, netwo
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-
-	fmt.Printf("Saving wallet to blockchain_wallets collection: %s\n", wallet.PublicKey)
-	
-	result, err := s.db.Collection("blockchain_wallets").InsertOne(context.Background(), wallet)
-	if err != nil {
-		fmt.Printf("Error inserting blockchain wallet: %v\n", err)
-		return nil, fmt.Errorf("failed to save blockchain wallet: %v", err)
-	}
-
-	wallet.ID = result.InsertedID.(primitive.ObjectID)
-	fmt.Printf("Successfully created blockchain wallet with ID: %s\n", wallet.ID.Hex())
-	return wallet, nil
 }
 
 func (s *StellarBlockchainService) GetWallet(userID primitive.ObjectID) (*models.BlockchainWallet, error) {
@@ -151,10 +274,343 @@ func (s *StellarBlockchainService) GetBalance(walletID primitive.ObjectID, asset
 	return 0, fmt.Errorf("asset not found")
 }
 
+// SendAsset pays XLM or USDC from a user's own wallet, recording the payment in
+// blockchain_transactions as pending before it is submitted. toAddress may be
+// a G- or M-address; USDC needs an authorized trustline at the destination.
 func (s *StellarBlockchainService) SendAsset(fromWalletID primitive.ObjectID, toAddress string, amount float64, assetCode string, memo string) (*models.BlockchainTransaction, error) {
-	// Implementation for sending assets on Stellar
-	// This would use the existing Stellar service logic
-	return nil, fmt.Errorf("not implemented")
+	ctx := context.Background()
+
+	wallet, err := s.getWalletByID(fromWalletID)
+	if err != nil {
+		return nil, fmt.Errorf("wallet not found: %w", err)
+	}
+	if !wallet.IsActive {
+		return nil, fmt.Errorf("wallet %s is not active", wallet.ID.Hex())
+	}
+
+	assetCode = strings.ToUpper(strings.TrimSpace(assetCode))
+	asset, issuer, err := s.stellarAsset(assetCode, wallet.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount <= 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidSend)
+	}
+	// Stellar amounts have at most 7 decimal places
+	amountStr := strconv.FormatFloat(amount, 'f', 7, 64)
+
+	if len(memo) > 28 {
+		return nil, fmt.Errorf("%w: memo is longer than 28 bytes", ErrInvalidSend)
+	}
+
+	destination, err := baseAccount(toAddress)
+	if err != nil {
+		return nil, err
+	}
+	if destination == wallet.PublicKey {
+		return nil, fmt.Errorf("%w: cannot send to the same wallet", ErrInvalidSend)
+	}
+
+	client, passphrase := s.networkClient(wallet.Network)
+	if err := s.checkDestination(client, destination, assetCode, issuer); err != nil {
+		return nil, err
+	}
+
+	secret, err := utils.DecryptPrivateKey(wallet.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt wallet key: %w", err)
+	}
+	senderKP, err := keypair.ParseFull(secret)
+	if err != nil || senderKP.Address() != wallet.PublicKey {
+		return nil, fmt.Errorf("wallet %s has an invalid key", wallet.ID.Hex())
+	}
+
+	sourceAccount, err := client.AccountDetail(horizonclient.AccountRequest{AccountID: wallet.PublicKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load wallet account: %w", err)
+	}
+
+	params := txnbuild.TransactionParams{
+		SourceAccount:        &sourceAccount,
+		IncrementSequenceNum: true,
+		Operations:           []txnbuild.Operation{&txnbuild.Payment{Destination: toAddress, Amount: amountStr, Asset: asset}},
+		BaseFee:              txnbuild.MinBaseFee,
+		Preconditions:        txnbuild.Preconditions{TimeBounds: txnbuild.NewTimeout(180)},
+	}
+	if memo != "" {
+		params.Memo = txnbuild.MemoText(memo)
+	}
+
+	tx, err := txnbuild.NewTransaction(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build transaction: %w", err)
+	}
+	tx, err = tx.Sign(passphrase, senderKP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	hash, err := tx.HashHex(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash transaction: %w", err)
+	}
+	envelope, err := tx.Base64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode transaction: %w", err)
+	}
+
+	record := &models.BlockchainTransaction{
+		UserID:      wallet.UserID,
+		WalletID:    wallet.ID,
+		Blockchain:  "stellar",
+		Network:     wallet.Network,
+		FromAddress: wallet.PublicKey,
+		ToAddress:   toAddress,
+		Amount:      amount,
+		AssetCode:   assetCode,
+		AssetIssuer: issuer,
+		TxHash:      hash,
+		Status:      "pending",
+		Type:        "send",
+		Memo:        memo,
+		Envelope:    envelope,
+		ValidUntil:  time.Unix(tx.Timebounds().MaxTime, 0),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	result, err := s.db.Collection("blockchain_transactions").InsertOne(ctx, record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record transaction: %w", err)
+	}
+	record.ID = result.InsertedID.(primitive.ObjectID)
+
+	resp, err := client.SubmitTransaction(tx)
+	if err != nil {
+		if !StellarSubmitRejected(err) {
+			// The transaction may still make it into a ledger; leave it pending
+			// under its hash for ConfirmTransaction to settle
+			log.Printf("⚠️ Stellar send %s outcome unknown: %v", hash, err)
+			if err := s.jobs.Enqueue(ctx, JobConfirmStellarTransaction, JobConfirmStellarTransaction+":"+hash,
+				bson.M{"blockchain_transaction_id": record.ID.Hex()}, time.Now().Add(stellarConfirmPollDelay)); err != nil {
+				log.Printf("Error queueing confirmation for Stellar send %s: %v", hash, err)
+			}
+			return record, fmt.Errorf("stellar payment %s not confirmed yet: %w", hash, err)
+		}
+
+		reason := stellarFailureReason(err)
+		s.finishSend(record, "failed", 0, reason)
+		if stellarUnderfunded(horizonclient.GetError(err)) {
+			return record, fmt.Errorf("%w: %s", ErrInsufficientFunds, reason)
+		}
+		return record, fmt.Errorf("stellar payment failed: %s", reason)
+	}
+
+	fee := float64(resp.FeeCharged) / 1e7 // Stroops to XLM
+	if !resp.Successful {
+		s.finishSend(record, "failed", fee, "transaction not successful")
+		return record, fmt.Errorf("stellar transaction %s was not successful", hash)
+	}
+	s.finishSend(record, "confirmed", fee, "")
+	log.Printf("⭐ Stellar send %s: %s %s from %s to %s", hash, amountStr, assetCode, wallet.PublicKey, toAddress)
+	return record, nil
+}
+
+// ConfirmTransaction settles a send whose outcome was unknown when it was
+// submitted
+func (s *StellarBlockchainService) ConfirmTransaction(ctx context.Context, job *models.Job) error {
+	idHex, _ := job.Payload["blockchain_transaction_id"].(string)
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return fmt.Errorf("%w: invalid blockchain_transaction_id %q", ErrPermanentJobFailure, idHex)
+	}
+
+	var record models.BlockchainTransaction
+	err = s.db.Collection("blockchain_transactions").FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: blockchain transaction %s not found", ErrPermanentJobFailure, idHex)
+	}
+	if err != nil {
+		return err
+	}
+	if record.Status != "pending" {
+		return nil
+	}
+
+	client, _ := s.networkClient(record.Network)
+	status, fee, reason, err := settleStellarSend(client, &record)
+	if err != nil {
+		return err
+	}
+	if status == "pending" {
+		return RescheduleJob(stellarConfirmPollDelay)
+	}
+	s.finishSend(&record, status, fee, reason)
+	log.Printf("⭐ Stellar send %s settled as %s", record.TxHash, status)
+	return nil
+}
+
+// settleStellarSend works out what happened to a pending send: its result if
+// it is in a ledger, failed if it can no longer get into one, and otherwise
+// pending after resubmitting the same envelope, which can't pay twice
+func settleStellarSend(client stellarTxClient, record *models.BlockchainTransaction) (status string, fee float64, reason string, err error) {
+	tx, err := client.TransactionDetail(record.TxHash)
+	if err == nil {
+		return stellarSendResult(tx)
+	}
+	if !horizonclient.IsNotFoundError(err) {
+		return "", 0, "", fmt.Errorf("failed to look up transaction %s: %w", record.TxHash, err)
+	}
+
+	validUntil := record.ValidUntil
+	if validUntil.IsZero() {
+		// Sends recorded before envelopes were kept had a 180 second timeout
+		validUntil = record.CreatedAt.Add(180 * time.Second)
+	}
+	// Allowing for Horizon ingesting the ledger after the time bound passed
+	if time.Now().After(validUntil.Add(time.Minute)) {
+		return "failed", 0, "transaction expired without reaching a ledger", nil
+	}
+	if record.Envelope == "" {
+		return "pending", 0, "", nil
+	}
+
+	tx, err = client.SubmitTransactionXDR(record.Envelope)
+	if err == nil {
+		return stellarSendResult(tx)
+	}
+	if StellarSubmitRejected(err) {
+		return "failed", 0, stellarFailureReason(err), nil
+	}
+	return "pending", 0, "", nil
+}
+
+func stellarSendResult(tx hProtocol.Transaction) (string, float64, string, error) {
+	fee := float64(tx.FeeCharged) / 1e7 // Stroops to XLM
+	if !tx.Successful {
+		return "failed", fee, "transaction not successful", nil
+	}
+	return "confirmed", fee, "", nil
+}
+
+// stellarFailureReason describes a rejected submission by its result codes
+func stellarFailureReason(err error) string {
+	hErr := horizonclient.GetError(err)
+	if hErr == nil {
+		return err.Error()
+	}
+	codes, codesErr := hErr.ResultCodes()
+	if codesErr != nil {
+		return err.Error()
+	}
+	return strings.Join(append([]string{codes.TransactionCode}, codes.OperationCodes...), ", ")
+}
+
+// finishSend stores the final status of a send
+func (s *StellarBlockchainService) finishSend(record *models.BlockchainTransaction, status string, fee float64, reason string) {
+	record.Status = status
+	record.Fee = fee
+	record.FailureReason = reason
+	record.UpdatedAt = time.Now()
+
+	_, err := s.db.Collection("blockchain_transactions").UpdateOne(context.Background(),
+		bson.M{"_id": record.ID},
+		bson.M{"$set": bson.M{"status": status, "fee": fee, "failure_reason": reason, "updated_at": record.UpdatedAt}})
+	if err != nil {
+		log.Printf("Error updating Stellar send %s to %s: %v", record.TxHash, status, err)
+	}
+}
+
+// stellarAsset returns the txnbuild asset and issuer for a supported asset
+// code on a wallet's network
+func (s *StellarBlockchainService) stellarAsset(assetCode, stellarNetwork string) (txnbuild.Asset, string, error) {
+	switch assetCode {
+	case "XLM":
+		return txnbuild.NativeAsset{}, "", nil
+	case "USDC":
+		issuer := s.usdcIssuer(stellarNetwork)
+		return txnbuild.CreditAsset{Code: "USDC", Issuer: issuer}, issuer, nil
+	default:
+		return nil, "", fmt.Errorf("%w: unsupported asset %q", ErrInvalidSend, assetCode)
+	}
+}
+
+// usdcIssuer returns the USDC issuer on a wallet's network. The server's
+// configured issuer only applies on the network the server itself runs on.
+func (s *StellarBlockchainService) usdcIssuer(stellarNetwork string) string {
+	if stellarNetwork != "mainnet" {
+		stellarNetwork = "testnet"
+	}
+	serverNetwork := "mainnet"
+	if s.stellarService.config.AppEnv == "dev" {
+		serverNetwork = "testnet"
+	}
+	if stellarNetwork == serverNetwork {
+		return s.stellarService.config.Stellar.USDCContractAddress
+	}
+	return stellarUSDCIssuers[stellarNetwork]
+}
+
+// checkDestination makes sure the destination account exists and, for USDC,
+// holds an authorized USDC trustline, so the payment isn't rejected on-chain
+func (s *StellarBlockchainService) checkDestination(client *horizonclient.Client, destination, assetCode, issuer string) error {
+	account, err := client.AccountDetail(horizonclient.AccountRequest{AccountID: destination})
+	if horizonclient.IsNotFoundError(err) {
+		return fmt.Errorf("%w: destination account %s does not exist", ErrInvalidSend, destination)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load destination account: %w", err)
+	}
+
+	if assetCode == "XLM" {
+		return nil
+	}
+	for _, balance := range account.Balances {
+		if balance.Code == assetCode && balance.Issuer == issuer {
+			if balance.IsAuthorized != nil && !*balance.IsAuthorized {
+				return fmt.Errorf("%w: destination is not authorized to hold %s", ErrInvalidSend, assetCode)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: destination has no %s trustline", ErrInvalidSend, assetCode)
+}
+
+// networkClient returns the Horizon client and passphrase for a wallet's network
+func (s *StellarBlockchainService) networkClient(stellarNetwork string) (*horizonclient.Client, string) {
+	if stellarNetwork == "mainnet" {
+		return horizonclient.DefaultPublicNetClient, network.PublicNetworkPassphrase
+	}
+	return horizonclient.DefaultTestNetClient, network.TestNetworkPassphrase
+}
+
+// baseAccount validates a G- or M-address and returns the G-address of the account
+func baseAccount(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if strings.HasPrefix(address, "M") {
+		muxed, err := xdr.AddressToMuxedAccount(address)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid Stellar address %s", ErrInvalidSend, address)
+		}
+		accountID := muxed.ToAccountId()
+		return accountID.Address(), nil
+	}
+	if _, err := keypair.ParseAddress(address); err != nil {
+		return "", fmt.Errorf("%w: invalid Stellar address %s", ErrInvalidSend, address)
+	}
+	return address, nil
+}
+
+func stellarUnderfunded(hErr *horizonclient.Error) bool {
+	codes, err := hErr.ResultCodes()
+	if err != nil {
+		return false
+	}
+	for _, code := range codes.OperationCodes {
+		if code == "op_underfunded" || code == "op_low_reserve" {
+			return true
+		}
+	}
+	return codes.TransactionCode == "tx_insufficient_balance"
 }
 
 func (s *StellarBlockchainService) GetTransactions(walletID primitive.ObjectID) ([]models.BlockchainTransaction, error) {
@@ -231,12 +687,33 @@ func (s *StellarBlockchainService) getHorizonClient() *horizonclient.Client {
 }
 
 
-// Placeholder methods for compatibility
-func (s *StellarBlockchainService) SendUSDC(userID primitive.ObjectID, req SendUSDCRequest) (interface{}, error) {
-	return nil, fmt.Errorf("SendUSDC not implemented in updated service")
+// SendUSDC sends USDC from the user's active Stellar wallet
+func (s *StellarBlockchainService) SendUSDC(userID primitive.ObjectID, req SendUSDCRequest) (*models.BlockchainTransaction, error) {
+	return s.SendFromUserWallet(userID, SendAssetRequest{
+		ToAddress: req.ToAddress,
+		Amount:    req.Amount,
+		AssetCode: "USDC",
+		Memo:      req.Memo,
+	})
 }
 
+// SendFromUserWallet sends an asset from the user's active Stellar wallet
+func (s *StellarBlockchainService) SendFromUserWallet(userID primitive.ObjectID, req SendAssetRequest) (*models.BlockchainTransaction, error) {
+	var wallet models.BlockchainWallet
+	err := s.db.Collection("blockchain_wallets").FindOne(
+		context.Background(),
+		bson.M{"user_id": userID, "blockchain": "stellar", "is_active": true},
+	).Decode(&wallet)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no active Stellar wallet", ErrInvalidSend)
+	}
 
+	amount, err := strconv.ParseFloat(strings.TrimSpace(req.Amount), 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid amount %q", ErrInvalidSend, req.Amount)
+	}
+	return s.SendAsset(wallet.ID, req.ToAddress, amount, req.AssetCode, req.Memo)
+}
 
 func (s *StellarBlockchainService) GetUSDCAsset(network string) interface{} {
 	return nil
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"github.com/stellar/go/clients/horizonclient"
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/support/render/problem"
+)
+
+// fakeHorizon answers lookups from txs and submissions with submitErr
+type fakeHorizon struct {
+	txs       map[string]hProtocol.Transaction
+	lookupErr error
+	submitErr error
+	submitted []string
+}
+
+func (f *fakeHorizon) TransactionDetail(hash string) (hProtocol.Transaction, error) {
+	if f.lookupErr != nil {
+		return hProtocol.Transaction{}, f.lookupErr
+	}
+	if tx, ok := f.txs[hash]; ok {
+		return tx, nil
+	}
+	return hProtocol.Transaction{}, &horizonclient.Error{Problem: problem.P{Type: "https://stellar.org/horizon-errors/not_found", Status: 404}}
+}
+
+func (f *fakeHorizon) SubmitTransactionXDR(envelope string) (hProtocol.Transaction, error) {
+	f.submitted = append(f.submitted, envelope)
+	if f.submitErr != nil {
+		return hProtocol.Transaction{}, f.submitErr
+	}
+	return hProtocol.Transaction{Hash: "abc", Successful: true, FeeCharged: 100}, nil
+}
+
+func TestSettleStellarSend(t *testing.T) {
+	rejected := &horizonclient.Error{Problem: problem.P{Status: 400, Extras: map[string]interface{}{
+		"result_codes": map[string]interface{}{"transaction": "tx_failed", "operations": []string{"op_no_trust"}},
+	}}}
+	timeout := &horizonclient.Error{Problem: problem.P{Status: 504}}
+	live := time.Now().Add(time.Minute)
+	expired := time.Now().Add(-2 * time.Minute)
+
+	for _, tc := range []struct {
+		name       string
+		horizon    *fakeHorizon
+		validUntil time.Time
+		status     string
+		reason     string
+		resubmit   bool
+	}{
+		{"in a ledger", &fakeHorizon{txs: map[string]hProtocol.Transaction{"abc": {Successful: true, FeeCharged: 100}}}, live, "confirmed", "", false},
+		{"failed in a ledger", &fakeHorizon{txs: map[string]hProtocol.Transaction{"abc": {FeeCharged: 100}}}, live, "failed", "transaction not successful", false},
+		{"expired", &fakeHorizon{}, expired, "failed", "transaction expired without reaching a ledger", false},
+		{"resubmitted", &fakeHorizon{}, live, "confirmed", "", true},
+		{"resubmit rejected", &fakeHorizon{submitErr: rejected}, live, "failed", "tx_failed, op_no_trust", true},
+		{"resubmit timed out", &fakeHorizon{submitErr: timeout}, live, "pending", "", true},
+	} {
+		record := &models.BlockchainTransaction{TxHash: "abc", Envelope: "AAAA", ValidUntil: tc.validUntil}
+		status, fee, reason, err := settleStellarSend(tc.horizon, record)
+		if err != nil || status != tc.status || reason != tc.reason {
+			t.Errorf("%s: settleStellarSend = %s, %q, %v; want %s, %q", tc.name, status, reason, err, tc.status, tc.reason)
+		}
+		if status == "confirmed" && fee != 0.00001 {
+			t.Errorf("%s: fee = %v, want 0.00001", tc.name, fee)
+		}
+		if resubmitted := len(tc.horizon.submitted) > 0; resubmitted != tc.resubmit {
+			t.Errorf("%s: resubmitted = %v, want %v", tc.name, resubmitted, tc.resubmit)
+		}
+	}
+
+	// A lookup that fails leaves the send for the job to retry
+	lookupErr := errors.New("connection refused")
+	if _, _, _, err := settleStellarSend(&fakeHorizon{lookupErr: lookupErr}, &models.BlockchainTransaction{TxHash: "abc"}); !errors.Is(err, lookupErr) {
+		t.Errorf("failed lookup error = %v, want %v", err, lookupErr)
+	}
+}
+
+func TestUSDCIssuerPerNetwork(t *testing.T) {
+	const custom = "GCUSTOMISSUER"
+	for _, tc := range []struct {
+		appEnv  string
+		network string
+		want    string
+	}{
+		{"dev", "testnet", custom},
+		{"dev", "mainnet", stellarUSDCIssuers["mainnet"]},
+		{"production", "mainnet", custom},
+		{"production", "testnet", stellarUSDCIssuers["testnet"]},
+		{"production", "", stellarUSDCIssuers["testnet"]},
+	} {
+		s := &StellarBlockchainService{stellarService: &StellarService{config: &GlobalConfig{
+			AppEnv:  tc.appEnv,
+			Stellar: StellarConfig{USDCContractAddress: custom},
+		}}}
+		if got := s.usdcIssuer(tc.network); got != tc.want {
+			t.Errorf("usdcIssuer(%q) on %s = %s, want %s", tc.network, tc.appEnv, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,565 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/statemachine"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Stellar deposit statuses
+const (
+	StellarDepositCredited = "credited"
+	StellarDepositSuspense = "suspense"
+	StellarDepositReturned = "returned"
+)
+
+// Why a payment was parked in suspense
+const (
+	SuspenseNoReference      = "no_reference"      // Sent to the base address without a usable memo
+	SuspenseUnknownReference = "unknown_reference" // Muxed ID or memo matches no user
+	SuspenseUnsupportedAsset = "unsupported_asset" // Anything but USDC from the configured issuer
+	SuspenseNoWallet         = "no_wallet"         // The user has no Siha wallet to credit
+	SuspenseBelowMinimum     = "below_minimum"     // Worth less than the smallest unit of the wallet currency
+)
+
+const depositIDAttempts = 5
+
+var (
+	ErrDepositNotInSuspense = errors.New("stellar deposit is not in suspense")
+	ErrWalletNotFound       = errors.New("wallet not found")
+)
+
+// StellarDepositService credits custodial users for USDC paid into the custody
+// account. Each user has a muxed ID; a payment is matched by the muxed ID of
+// the M-address it was sent to, or by a memo holding the muxed ID or the
+// user's @handle when it was sent to the base address. Every operation is
+// credited at most once; payments that can't be matched go to suspense.
+type StellarDepositService struct {
+	db      *mongo.Database
+	stellar *StellarService
+	ledger  *LedgerService
+	rates   *RateService
+	handles *WalletHandleService
+}
+
+func NewStellarDepositService(db *mongo.Database) *StellarDepositService {
+	return &StellarDepositService{
+		db:      db,
+		stellar: NewStellarService(),
+		ledger:  NewLedgerService(db),
+		rates:   NewRateService(),
+		handles: NewWalletHandleService(db),
+	}
+}
+
+// EnsureIndexes makes operations and muxed IDs unique
+func (s *StellarDepositService) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Collection("stellar_deposits").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "operation_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create stellar deposit indexes: %w", err)
+	}
+
+	_, err = s.db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "stellar_deposit_id", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"stellar_deposit_id": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create stellar deposit ID index: %w", err)
+	}
+	return nil
+}
+
+// DepositAddress returns the user's M-address, assigning their muxed ID on first use
+func (s *StellarDepositService) DepositAddress(ctx context.Context, userID primitive.ObjectID) (*MuxedWallet, error) {
+	if s.stellar.CustodyAddress() == "" {
+		return nil, fmt.Errorf("%w: stellar custody account", ErrPSPNotConfigured)
+	}
+
+	depositID, err := s.assignDepositID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	address, err := s.stellar.MuxedAddress(depositID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MuxedWallet{
+		MuxedAddress:   address,
+		BaseAddress:    s.stellar.CustodyAddress(),
+		MuxedAccountID: strconv.FormatUint(depositID, 10),
+		PublicKey:      s.stellar.CustodyAddress(),
+		Chain:          ChainStellar,
+	}, nil
+}
+
+// assignDepositID derives the muxed ID from the user ID, rehashing on the
+// rare collision with another user's
+func (s *StellarDepositService) assignDepositID(ctx context.Context, userID primitive.ObjectID) (uint64, error) {
+	users := s.db.Collection("users")
+
+	var user models.User
+	if err := users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return 0, fmt.Errorf("failed to load user: %w", err)
+	}
+	if user.StellarDepositID != 0 {
+		return user.StellarDepositID, nil
+	}
+
+	seed := userID.Hex()
+	for attempt := 0; attempt < depositIDAttempts; attempt++ {
+		depositID := s.stellar.generateMuxedAccountID(seed)
+
+		result, err := users.UpdateOne(ctx,
+			bson.M{"_id": userID, "stellar_deposit_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"stellar_deposit_id": depositID, "updated_at": time.Now()}})
+		if mongo.IsDuplicateKeyError(err) {
+			seed = fmt.Sprintf("%s:%d", userID.Hex(), attempt+1)
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if result.MatchedCount == 1 {
+			return depositID, nil
+		}
+
+		// Assigned concurrently
+		if err := users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+			return 0, err
+		}
+		return user.StellarDepositID, nil
+	}
+	return 0, fmt.Errorf("no free stellar deposit ID for user %s after %d attempts", userID.Hex(), depositIDAttempts)
+}
+
+// Ingest handles one payment seen on the custody account. Returning an error
+// means the payment should be offered again; it is never credited twice.
+func (s *StellarDepositService) Ingest(ctx context.Context, payment *TransactionDetails) error {
+	// Payments out of the custody account show up in the same stream
+	if payment.SenderAddress == s.stellar.CustodyAddress() {
+		return nil
+	}
+
+	err := s.db.Collection("stellar_deposits").FindOne(ctx, bson.M{"operation_id": payment.OperationID}).Err()
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	deposit := &models.StellarDeposit{
+		OperationID: payment.OperationID,
+		TxHash:      payment.TransactionHash,
+		FromAddress: payment.SenderAddress,
+		ToAddress:   payment.DestinationAddress,
+		MuxedID:     payment.DestinationMuxedID,
+		Memo:        payment.Memo,
+		MemoType:    payment.MemoType,
+		AssetCode:   payment.Asset,
+		AssetIssuer: payment.AssetIssuer,
+		Amount:      payment.Amount,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if !s.isUSDC(deposit) {
+		return s.suspend(ctx, deposit, SuspenseUnsupportedAsset)
+	}
+
+	user, reason, err := s.matchUser(ctx, deposit)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return s.suspend(ctx, deposit, reason)
+	}
+
+	err = s.credit(ctx, deposit, user)
+	switch {
+	case errors.Is(err, ErrWalletNotFound):
+		return s.suspend(ctx, deposit, SuspenseNoWallet)
+	case errors.Is(err, models.ErrInvalidAmount):
+		return s.suspend(ctx, deposit, SuspenseBelowMinimum)
+	}
+	return err
+}
+
+// matchUser finds the user a payment is for, or the suspense reason when there is none
+func (s *StellarDepositService) matchUser(ctx context.Context, deposit *models.StellarDeposit) (*models.User, string, error) {
+	depositID := deposit.MuxedID
+	if depositID == 0 {
+		memo := strings.TrimSpace(deposit.Memo)
+		switch {
+		case deposit.MemoType == "id" || deposit.MemoType == "text" && isNumericMemo(memo):
+			depositID, _ = strconv.ParseUint(memo, 10, 64)
+		case deposit.MemoType == "text" && strings.HasPrefix(memo, "@"):
+			user, err := s.handles.Resolve(ctx, memo)
+			if errors.Is(err, ErrRecipientNotFound) {
+				return nil, SuspenseUnknownReference, nil
+			}
+			return user, "", err
+		}
+		if depositID == 0 {
+			return nil, SuspenseNoReference, nil
+		}
+	}
+	if depositID > math.MaxInt64 {
+		// Larger than any assigned ID, and than Mongo can store
+		return nil, SuspenseUnknownReference, nil
+	}
+
+	var user models.User
+	err := s.db.Collection("users").FindOne(ctx, bson.M{"stellar_deposit_id": depositID}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, SuspenseUnknownReference, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &user, "", nil
+}
+
+// credit converts the USDC to the user's wallet currency and posts it from
+// custody to the wallet, recording the deposit and the user's history entry
+// in the same transaction. The ledger reference makes a second credit for the
+// same operation a no-op.
+func (s *StellarDepositService) credit(ctx context.Context, deposit *models.StellarDeposit, user *models.User) error {
+	var wallet models.Wallet
+	err := s.db.Collection("wallets").FindOne(ctx, bson.M{"user_id": user.ID}).Decode(&wallet)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrWalletNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load wallet: %w", err)
+	}
+
+	usd, err := stellarAmountToUSD(deposit.Amount)
+	if err != nil {
+		return err
+	}
+	credited, err := s.rates.ConvertAmount(usd, wallet.Balance.Currency, models.RoundDown)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s to %s: %w", usd, wallet.Balance.Currency, err)
+	}
+	if !credited.IsPositive() {
+		return fmt.Errorf("%w: %s USDC is less than 1 minor unit of %s", models.ErrInvalidAmount, deposit.Amount, credited.Currency)
+	}
+
+	now := time.Now()
+	deposit.UserID = user.ID
+	deposit.Credited = credited
+	deposit.Status = StellarDepositCredited
+	deposit.SuspenseReason = ""
+	deposit.UpdatedAt = now
+	deposit.ResolvedAt = &now
+
+	history, err := statemachine.Deposit.Start(statemachine.DepositPending, "Stellar payment received")
+	if err != nil {
+		return err
+	}
+	history = append(history, models.StatusChange{From: statemachine.DepositPending, To: statemachine.DepositCollected, Reason: "Confirmed on Stellar", At: now})
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start deposit session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		err := s.ledger.PostInTransaction(sc, &models.JournalEntry{
+			Reference:   "stellar-deposit:" + deposit.OperationID,
+			Type:        "deposit",
+			Description: fmt.Sprintf("%s USDC received on Stellar in %s", deposit.Amount, deposit.TxHash),
+			Currency:    credited.Currency,
+			Postings: []models.Posting{
+				{AccountCode: LedgerAccountStellarCustody, Direction: "debit", Amount: credited.Minor},
+				{AccountCode: WalletAccountCode(user.ID), Direction: "credit", Amount: credited.Minor},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = s.db.Collection("stellar_deposits").ReplaceOne(sc,
+			bson.M{"operation_id": deposit.OperationID}, deposit, options.Replace().SetUpsert(true))
+		if err != nil {
+			return nil, fmt.Errorf("failed to record stellar deposit: %w", err)
+		}
+
+		_, err = s.db.Collection("transactions").InsertOne(sc, models.UnifiedTransaction{
+			UserID:        user.ID,
+			Type:          "deposit",
+			Amount:        credited,
+			Status:        statemachine.DepositCollected,
+			StatusHistory: history,
+			PSPName:       "stellar",
+			TransactionID: deposit.OperationID,
+			PSPReference:  deposit.TxHash,
+			QueueStatus:   "completed",
+			ProcessedAt:   &now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		return nil, err
+	})
+	if errors.Is(err, ErrDuplicateEntry) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("⭐ Credited %s USDC (%s) to user %s from Stellar operation %s", deposit.Amount, credited, user.ID.Hex(), deposit.OperationID)
+	return nil
+}
+
+// suspend parks a payment for ops to resolve
+func (s *StellarDepositService) suspend(ctx context.Context, deposit *models.StellarDeposit, reason string) error {
+	deposit.Status = StellarDepositSuspense
+	deposit.SuspenseReason = reason
+
+	_, err := s.db.Collection("stellar_deposits").InsertOne(ctx, deposit)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to park stellar deposit: %w", err)
+	}
+
+	log.Printf("⚠️ Stellar payment %s of %s %s parked in suspense: %s", deposit.OperationID, deposit.Amount, deposit.AssetCode, reason)
+	return nil
+}
+
+// ListSuspense returns the payments waiting in suspense, oldest first
+func (s *StellarDepositService) ListSuspense(ctx context.Context) ([]models.StellarDeposit, error) {
+	cursor, err := s.db.Collection("stellar_deposits").Find(ctx,
+		bson.M{"status": StellarDepositSuspense},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var deposits []models.StellarDeposit
+	if err := cursor.All(ctx, &deposits); err != nil {
+		return nil, err
+	}
+	return deposits, nil
+}
+
+// ResolveSuspense credits a payment in suspense to the user identified by
+// recipient (handle, phone, email or user ID)
+func (s *StellarDepositService) ResolveSuspense(ctx context.Context, operationID, recipient, resolvedBy string) (*models.StellarDeposit, error) {
+	deposit, err := s.suspended(ctx, operationID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.isUSDC(deposit) {
+		return nil, fmt.Errorf("%s %s can't be credited to a wallet, return it instead", deposit.Amount, deposit.AssetCode)
+	}
+
+	user, err := s.handles.Resolve(ctx, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	deposit.ResolvedBy = resolvedBy
+	if err := s.credit(ctx, deposit, user); err != nil {
+		return nil, err
+	}
+	return deposit, nil
+}
+
+// ReturnSuspense sends a USDC payment in suspense back to the account it came
+// from. The deposit is marked returned and the signed return stored before it
+// is submitted. Only a definite rejection by Horizon puts the payment back in
+// suspense; when the outcome is unknown the return stays pending under its
+// hash for CheckReturn, so it can never be sent twice.
+func (s *StellarDepositService) ReturnSuspense(ctx context.Context, operationID, resolvedBy string) (*models.StellarDeposit, error) {
+	deposit, err := s.suspended(ctx, operationID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.isUSDC(deposit) {
+		return nil, fmt.Errorf("only USDC can be returned automatically, return %s %s manually", deposit.Amount, deposit.AssetCode)
+	}
+
+	now := time.Now()
+	result, err := s.db.Collection("stellar_deposits").UpdateOne(ctx,
+		bson.M{"operation_id": operationID, "status": StellarDepositSuspense},
+		bson.M{"$set": bson.M{"status": StellarDepositReturned, "resolved_by": resolvedBy, "resolved_at": now, "updated_at": now}})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrDepositNotInSuspense
+	}
+
+	payment, err := s.stellar.SignPayment(s.stellar.config.Stellar.SecretKey, deposit.FromAddress, deposit.Amount, "return")
+	if err != nil {
+		// Nothing was signed, so nothing can have been sent
+		s.unreturn(ctx, operationID)
+		return nil, fmt.Errorf("failed to return stellar payment: %w", err)
+	}
+	_, err = s.db.Collection("stellar_deposits").UpdateOne(ctx,
+		bson.M{"operation_id": operationID},
+		bson.M{"$set": bson.M{
+			"return_tx_hash":     payment.Hash,
+			"return_status":      "pending",
+			"return_envelope":    payment.Envelope,
+			"return_valid_until": payment.ValidUntil,
+			"updated_at":         time.Now(),
+		}})
+	if err != nil {
+		s.unreturn(ctx, operationID)
+		return nil, fmt.Errorf("failed to record stellar return: %w", err)
+	}
+
+	deposit.Status = StellarDepositReturned
+	deposit.ReturnTxHash = payment.Hash
+	deposit.ReturnStatus = "pending"
+	deposit.ReturnEnvelope = payment.Envelope
+	deposit.ReturnValidUntil = payment.ValidUntil
+	if err := s.submitReturn(ctx, deposit); err != nil {
+		return nil, err
+	}
+	log.Printf("↩️ Returned Stellar payment %s to %s in %s", operationID, deposit.FromAddress, deposit.ReturnTxHash)
+	return deposit, nil
+}
+
+// CheckReturn settles a return whose outcome was unknown. It confirms a
+// return Horizon has applied, puts the payment back in suspense if the return
+// failed or expired unapplied, and otherwise resubmits the same signed return.
+func (s *StellarDepositService) CheckReturn(ctx context.Context, operationID string) (*models.StellarDeposit, error) {
+	var deposit models.StellarDeposit
+	err := s.db.Collection("stellar_deposits").FindOne(ctx, bson.M{"operation_id": operationID}).Decode(&deposit)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("no stellar deposit for operation %s", operationID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deposit.Status != StellarDepositReturned || deposit.ReturnStatus != "pending" {
+		return &deposit, nil
+	}
+
+	result, err := s.stellar.PaymentResult(deposit.ReturnTxHash)
+	switch {
+	case err == nil && result.Status:
+		s.confirmReturn(ctx, &deposit)
+		return &deposit, nil
+	case err == nil:
+		s.unreturn(ctx, operationID)
+		return nil, fmt.Errorf("stellar return %s failed on ledger, payment is back in suspense", deposit.ReturnTxHash)
+	case !errors.Is(err, ErrStellarTxNotFound):
+		return nil, err
+	case time.Now().After(deposit.ReturnValidUntil.Add(time.Minute)):
+		// Allowing for Horizon ingesting the ledger after the time bound passed
+		s.unreturn(ctx, operationID)
+		return nil, fmt.Errorf("stellar return %s expired without reaching a ledger, payment is back in suspense", deposit.ReturnTxHash)
+	}
+	if err := s.submitReturn(ctx, &deposit); err != nil {
+		return nil, err
+	}
+	return &deposit, nil
+}
+
+// submitReturn submits the deposit's signed return
+func (s *StellarDepositService) submitReturn(ctx context.Context, deposit *models.StellarDeposit) error {
+	payment := &SignedPayment{Hash: deposit.ReturnTxHash, Envelope: deposit.ReturnEnvelope, ValidUntil: deposit.ReturnValidUntil}
+	tx, err := s.stellar.SubmitSignedPayment(payment)
+	if err == nil && !tx.Status {
+		err = fmt.Errorf("stellar transaction %s was not successful", tx.TransactionHash)
+	}
+	if err != nil {
+		if StellarSubmitRejected(err) {
+			// Horizon refused it, so nothing was sent
+			s.unreturn(ctx, deposit.OperationID)
+			return fmt.Errorf("failed to return stellar payment: %w", err)
+		}
+		log.Printf("⚠️ Stellar return %s for %s outcome unknown: %v", deposit.ReturnTxHash, deposit.OperationID, err)
+		return fmt.Errorf("stellar return %s not confirmed yet, run stellar_suspense check-return: %w", deposit.ReturnTxHash, err)
+	}
+	s.confirmReturn(ctx, deposit)
+	return nil
+}
+
+func (s *StellarDepositService) confirmReturn(ctx context.Context, deposit *models.StellarDeposit) {
+	deposit.ReturnStatus = "confirmed"
+	s.db.Collection("stellar_deposits").UpdateOne(ctx,
+		bson.M{"operation_id": deposit.OperationID},
+		bson.M{"$set": bson.M{"return_status": "confirmed", "updated_at": time.Now()}, "$unset": bson.M{"return_envelope": ""}})
+}
+
+// unreturn puts a payment whose return was never sent back in suspense
+func (s *StellarDepositService) unreturn(ctx context.Context, operationID string) {
+	s.db.Collection("stellar_deposits").UpdateOne(ctx,
+		bson.M{"operation_id": operationID, "status": StellarDepositReturned},
+		bson.M{
+			"$set": bson.M{"status": StellarDepositSuspense, "updated_at": time.Now()},
+			"$unset": bson.M{"resolved_by": "", "resolved_at": "", "return_tx_hash": "", "return_status": "",
+				"return_envelope": "", "return_valid_until": ""},
+		})
+}
+
+func (s *StellarDepositService) suspended(ctx context.Context, operationID string) (*models.StellarDeposit, error) {
+	var deposit models.StellarDeposit
+	err := s.db.Collection("stellar_deposits").FindOne(ctx, bson.M{"operation_id": operationID}).Decode(&deposit)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("no stellar deposit for operation %s", operationID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deposit.Status != StellarDepositSuspense {
+		return nil, fmt.Errorf("%w: %s is %s", ErrDepositNotInSuspense, operationID, deposit.Status)
+	}
+	return &deposit, nil
+}
+
+func (s *StellarDepositService) isUSDC(deposit *models.StellarDeposit) bool {
+	return deposit.AssetCode == "USDC" && deposit.AssetIssuer == s.stellar.config.Stellar.USDCContractAddress
+}
+
+// stellarAmountToUSD reads a 7-decimal Stellar amount as USD, dropping fractions of a cent
+func stellarAmountToUSD(amount string) (models.Money, error) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(amount), ".")
+	if len(frac) > 2 {
+		frac = frac[:2]
+	}
+	if frac != "" {
+		whole += "." + frac
+	}
+	return models.ParseMoney(whole, "USD")
+}
+
+func isNumericMemo(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
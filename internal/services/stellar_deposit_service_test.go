@@ -0,0 +1,25 @@
+package services
+
+import (
+	"testing"
+
+	"healthy_pay_backend/internal/models"
+)
+
+func TestStellarAmountToUSD(t *testing.T) {
+	cases := map[string]int64{
+		"12.5000000": 1250,
+		"0.0099999":  0,
+		"3":          300,
+		"7.1299999":  712,
+	}
+	for amount, want := range cases {
+		got, err := stellarAmountToUSD(amount)
+		if err != nil {
+			t.Fatalf("stellarAmountToUSD(%q): %v", amount, err)
+		}
+		if got != models.NewMoney(want, "USD") {
+			t.Errorf("stellarAmountToUSD(%q) = %s, want %d cents", amount, got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// StellarDepositWorker streams payments to the custody account into the
+// StellarDepositService. Its position is stored after every payment, so a
+// restart resumes where it stopped and backfills what arrived meanwhile.
+// Several replicas can run it; each operation is still credited once.
+type StellarDepositWorker struct {
+	stellar  *StellarService
+	deposits *StellarDepositService
+	cursors  *StreamCursorService
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewStellarDepositWorker(db *mongo.Database) *StellarDepositWorker {
+	return &StellarDepositWorker{
+		stellar:  NewStellarService(),
+		deposits: NewStellarDepositService(db),
+		cursors:  NewStreamCursorService(db),
+	}
+}
+
+func (w *StellarDepositWorker) Start() {
+	if w.stellar.CustodyAddress() == "" {
+		log.Println("⚠️ STELLAR_PUBLIC_KEY not configured, Stellar deposit ingestion disabled")
+		return
+	}
+	log.Printf("Starting Stellar deposit ingestion for %s...", w.stellar.CustodyAddress())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+		stream := StellarPaymentStream(w.stellar.CustodyAddress())
+
+		cursor, err := w.startCursor(ctx, stream)
+		if err != nil {
+			log.Printf("Stellar deposit ingestion stopped: %v", err)
+			return
+		}
+
+		err = w.stellar.StreamPayments(ctx, cursor,
+			func(payment *TransactionDetails) error {
+				return w.deposits.Ingest(ctx, payment)
+			},
+			func(position StreamPosition) error {
+				return w.cursors.Save(ctx, stream, position)
+			})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("Stellar deposit ingestion stopped: %v", err)
+		}
+	}()
+}
+
+// startCursor resumes from the stored cursor. The first run starts at the
+// newest payment, storing it straight away so that payments arriving during
+// a restart before any other payment are not skipped.
+func (w *StellarDepositWorker) startCursor(ctx context.Context, stream string) (string, error) {
+	for {
+		cursor, err := w.cursors.Load(ctx, stream)
+		if err == nil {
+			log.Printf("Resuming Stellar deposit ingestion after cursor %s", cursor)
+			return cursor, nil
+		}
+		if errors.Is(err, ErrNoStreamCursor) {
+			cursor, err = w.stellar.LatestPaymentCursor()
+			if err == nil {
+				err = w.cursors.Save(ctx, stream, StreamPosition{Cursor: cursor, LedgerCloseTime: time.Now()})
+			}
+			if err == nil {
+				log.Printf("Starting Stellar deposit ingestion at cursor %s", cursor)
+				return cursor, nil
+			}
+		}
+
+		log.Printf("Error loading Stellar ingestion cursor, retrying: %v", err)
+		if err := sleepContext(ctx, 5*time.Second); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (w *StellarDepositWorker) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	log.Println("Stopping Stellar deposit ingestion...")
+	w.cancel()
+	<-w.done
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -16,9 +17,12 @@ import (
 	"healthy_pay_backend/internal/utils"
 
 	"github.com/stellar/go/clients/horizonclient"
+	"github.com/stellar/go/exp/crypto/derivation"
 	"github.com/stellar/go/keypair"
 	"github.com/stellar/go/network"
+	"github.com/stellar/go/protocols/horizon/operations"
 	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/go/xdr"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -119,15 +123,21 @@ type WalletModel struct {
 type TransactionResult struct {
 	TransactionHash string `json:"transaction_hash"`
 	Status          bool   `json:"status"`
+	FeeCharged      int64  `json:"fee_charged"` // In stroops
 }
 
 type TransactionDetails struct {
+	OperationID        string  `json:"operation_id"`
 	SenderAddress      string  `json:"sender_address"`
-	DestinationAddress string  `json:"destination_address"`
+	DestinationAddress string  `json:"destination_address"` // M-address when the payment was sent to one
+	DestinationMuxedID uint64  `json:"destination_muxed_id,omitempty"`
+	Amount             string  `json:"amount"` // Exact amount as reported by Horizon, 7 decimal places
 	TokenValue         float64 `json:"token_value"`
 	TransactionHash    string  `json:"transaction_hash"`
 	Asset              string  `json:"asset"`
+	AssetIssuer        string  `json:"asset_issuer,omitempty"`
 	Memo               string  `json:"memo,omitempty"`
+	MemoType           string  `json:"memo_type,omitempty"` // "text", "id", "hash", "return" or "none"
 }
 
 type MuxedWallet struct {
@@ -143,6 +153,7 @@ type SendUSDCRequest struct {
 	FromUserID  primitive.ObjectID `json:"from_user_id"`
 	ToAddress   string             `json:"to_address"`
 	Amount      string             `json:"amount"`
+	Memo        string             `json:"memo"`
 }
 
 
@@ -182,10 +193,16 @@ func (s *StellarService) SendTokenWithMemo(senderSecret, receiverPublicKey, amou
 	return s.sendTokenWithRetry(senderSecret, receiverPublicKey, amount, memo, 3)
 }
 
+// sendTokenWithRetry signs the payment once and resubmits the same envelope,
+// so a retry after a timeout can't pay twice
 func (s *StellarService) sendTokenWithRetry(senderSecret, receiverPublicKey, amount, memo string, maxRetries int) (*TransactionResult, error) {
+	payment, err := s.SignPayment(senderSecret, receiverPublicKey, amount, memo)
+	if err != nil {
+		return nil, err
+	}
 	baseDelay := 2 * time.Second
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		result, err := s.sendTokenInternal(senderSecret, receiverPublicKey, amount, memo)
+		result, err := s.SubmitSignedPayment(payment)
 		if err == nil {
 			return result, nil
 		}
@@ -198,7 +215,21 @@ func (s *StellarService) sendTokenWithRetry(senderSecret, receiverPublicKey, amo
 	return nil, fmt.Errorf("max retries exceeded")
 }
 
-func (s *StellarService) sendTokenInternal(senderSecret, receiverPublicKey, amount, memo string) (*TransactionResult, error) {
+// SignedPayment is a signed USDC payment envelope. Submitting the same
+// envelope again can never pay twice, because it carries one sequence number.
+type SignedPayment struct {
+	Hash       string
+	Envelope   string    // Base64 transaction envelope XDR
+	ValidUntil time.Time // Upper time bound; after it the envelope can't reach a ledger
+}
+
+// ErrStellarTxNotFound is returned by PaymentResult for a hash Horizon has no
+// record of
+var ErrStellarTxNotFound = errors.New("stellar transaction not found")
+
+// SignPayment builds and signs a USDC payment without submitting it, so the
+// caller can store the hash and envelope first
+func (s *StellarService) SignPayment(senderSecret, receiverPublicKey, amount, memo string) (*SignedPayment, error) {
 	if !strings.HasPrefix(senderSecret, "S") || len(senderSecret) != 56 {
 		return nil, fmt.Errorf("invalid Stellar secret key format")
 	}
@@ -231,12 +262,13 @@ func (s *StellarService) sendTokenInternal(senderSecret, receiverPublicKey, amou
 		SourceAccount: senderKP.Address(),
 	}
 
+	timeBounds := txnbuild.NewTimeout(180)
 	txParams := txnbuild.TransactionParams{
 		SourceAccount:        &sourceAccount,
 		IncrementSequenceNum: true,
 		Operations:           []txnbuild.Operation{payment},
 		BaseFee:              txnbuild.MinBaseFee,
-		Preconditions:        txnbuild.Preconditions{TimeBounds: txnbuild.NewTimeout(180)},
+		Preconditions:        txnbuild.Preconditions{TimeBounds: timeBounds},
 	}
 
 	if memo != "" {
@@ -252,13 +284,55 @@ func (s *StellarService) sendTokenInternal(senderSecret, receiverPublicKey, amou
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign transaction: %w", err)
 	}
+	hash, err := tx.HashHex(s.networkPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash transaction: %w", err)
+	}
+	envelope, err := tx.Base64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode transaction: %w", err)
+	}
 
-	resp, err := s.client.SubmitTransaction(tx)
+	return &SignedPayment{Hash: hash, Envelope: envelope, ValidUntil: time.Unix(timeBounds.MaxTime, 0)}, nil
+}
+
+// SubmitSignedPayment submits a payment from SignPayment. It may be called
+// again with the same payment when an earlier submission's outcome is unknown.
+func (s *StellarService) SubmitSignedPayment(payment *SignedPayment) (*TransactionResult, error) {
+	resp, err := s.client.SubmitTransactionXDR(payment.Envelope)
 	if err != nil {
 		return nil, fmt.Errorf("failed to submit transaction: %w", err)
 	}
 
-	return &TransactionResult{TransactionHash: resp.Hash, Status: resp.Successful}, nil
+	return &TransactionResult{TransactionHash: resp.Hash, Status: resp.Successful, FeeCharged: resp.FeeCharged}, nil
+}
+
+// PaymentResult looks a submitted transaction up on Horizon by hash. It returns
+// ErrStellarTxNotFound if the transaction isn't in a ledger (yet).
+func (s *StellarService) PaymentResult(hash string) (*TransactionResult, error) {
+	tx, err := s.client.TransactionDetail(hash)
+	if horizonclient.IsNotFoundError(err) {
+		return nil, ErrStellarTxNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up transaction %s: %w", hash, err)
+	}
+	return &TransactionResult{TransactionHash: tx.Hash, Status: tx.Successful, FeeCharged: tx.FeeCharged}, nil
+}
+
+// StellarSubmitRejected reports whether Horizon definitely refused a
+// submission, so the envelope was not applied by it. Timeouts and 5xx
+// responses leave the outcome unknown. tx_bad_seq is also unknown: it is what
+// resubmitting an envelope that already made it into a ledger returns.
+func StellarSubmitRejected(err error) bool {
+	var hErr *horizonclient.Error
+	if !errors.As(err, &hErr) || hErr.Problem.Status < 400 || hErr.Problem.Status >= 500 {
+		return false
+	}
+	if codes, codesErr := hErr.ResultCodes(); codesErr == nil && codes.TransactionCode == "tx_bad_seq" {
+		return false
+	}
+	return true
 }
 
 func (s *StellarService) GetUSDCBalance(accountPublicKey string) (string, error) {
@@ -433,56 +507,191 @@ func (s *StellarService) EstablishTrustLine(userPublicKey, userPrivateKey string
 
 
 
-func (s *StellarService) StreamPayments(ctx context.Context, callback func(*TransactionDetails)) error {
-	cursor := "now"
+// StreamPosition is how far a payment stream has got
+type StreamPosition struct {
+	Cursor          string    // Paging token of the last handled record
+	LedgerCloseTime time.Time // Close time of that record's ledger
+	AtHead          bool      // The last poll found no newer payments
+}
+
+const paymentPageLimit = 200
+
+// StreamPayments polls Horizon for payments to and from the custody account
+// (STELLAR_PUBLIC_KEY) starting after cursor. A payment is only passed over
+// once callback returns nil; on an error the page is fetched again from the
+// last handled payment after a pause. Full pages are fetched back to back, so
+// a stream resumed from an old cursor backfills the gap before it idles.
+// checkpoint is told the position after every record and at every poll that
+// reaches the newest payment.
+func (s *StellarService) StreamPayments(ctx context.Context, cursor string, callback func(*TransactionDetails) error, checkpoint func(StreamPosition) error) error {
+	backfilling := false
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			payments, err := s.client.Payments(horizonclient.OperationRequest{
-				ForAccount: s.config.Stellar.PublicKey,
-				Cursor:     cursor,
-				Order:      horizonclient.OrderAsc,
-				Limit:      10,
-			})
-			if err != nil {
-				log.Printf("Error fetching payments: %v", err)
-				time.Sleep(5 * time.Second)
-				continue
+		if !backfilling {
+			if err := sleepContext(ctx, time.Second); err != nil {
+				return err
 			}
+		}
 
-			for _, payment := range payments.Embedded.Records {
-				if payment.GetType() == "payment" {
-					details := &TransactionDetails{
-						TransactionHash: payment.GetTransactionHash(),
-						Asset:           "USDC",
+		payments, err := s.client.Payments(horizonclient.OperationRequest{
+			ForAccount: s.config.Stellar.PublicKey,
+			Cursor:     cursor,
+			Order:      horizonclient.OrderAsc,
+			Limit:      paymentPageLimit,
+			Join:       "transactions",
+		})
+		if err != nil {
+			log.Printf("Error fetching payments: %v", err)
+			backfilling = false
+			if err := sleepContext(ctx, 5*time.Second); err != nil {
+				return err
+			}
+			continue
+		}
+
+		records := payments.Embedded.Records
+		backfilling = len(records) == paymentPageLimit
+		for _, record := range records {
+			if details, ok := paymentDetails(record); ok {
+				if err := callback(details); err != nil {
+					log.Printf("Error handling payment %s, retrying: %v", details.OperationID, err)
+					backfilling = false
+					if err := sleepContext(ctx, 5*time.Second); err != nil {
+						return err
 					}
-					callback(details)
+					break
 				}
-				cursor = payment.PagingToken()
 			}
-			time.Sleep(1 * time.Second)
+			cursor = record.PagingToken()
+			s.saveCheckpoint(checkpoint, StreamPosition{Cursor: cursor, LedgerCloseTime: record.GetBase().LedgerCloseTime})
 		}
+
+		if len(records) == 0 {
+			s.saveCheckpoint(checkpoint, StreamPosition{Cursor: cursor, AtHead: true})
+		}
+	}
+}
+
+// saveCheckpoint only logs failures: a lost checkpoint means records are
+// offered again after a restart, which callbacks must tolerate anyway
+func (s *StellarService) saveCheckpoint(checkpoint func(StreamPosition) error, position StreamPosition) {
+	if checkpoint == nil {
+		return
+	}
+	if err := checkpoint(position); err != nil {
+		log.Printf("Error saving payment stream position %s: %v", position.Cursor, err)
+	}
+}
+
+// LatestPaymentCursor returns the paging token of the newest payment to or
+// from the custody account, "0" when it has none
+func (s *StellarService) LatestPaymentCursor() (string, error) {
+	payments, err := s.client.Payments(horizonclient.OperationRequest{
+		ForAccount: s.config.Stellar.PublicKey,
+		Order:      horizonclient.OrderDesc,
+		Limit:      1,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch latest payment: %w", err)
+	}
+	if len(payments.Embedded.Records) == 0 {
+		return "0", nil
+	}
+	return payments.Embedded.Records[0].PagingToken(), nil
+}
+
+// paymentDetails extracts the payment from a payment or path payment operation
+func paymentDetails(record operations.Operation) (*TransactionDetails, bool) {
+	var payment operations.Payment
+	switch op := record.(type) {
+	case operations.Payment:
+		payment = op
+	case operations.PathPayment:
+		payment = op.Payment
+	case operations.PathPaymentStrictSend:
+		payment = op.Payment
+	default:
+		return nil, false
+	}
+
+	details := &TransactionDetails{
+		OperationID:        payment.ID,
+		SenderAddress:      payment.From,
+		DestinationAddress: payment.To,
+		DestinationMuxedID: payment.ToMuxedID,
+		Amount:             payment.Amount,
+		TransactionHash:    payment.TransactionHash,
+		Asset:              payment.Code,
+		AssetIssuer:        payment.Issuer,
+	}
+	if payment.ToMuxed != "" {
+		details.DestinationAddress = payment.ToMuxed
+	}
+	if payment.Asset.Type == "native" {
+		details.Asset = "XLM"
+	}
+	details.TokenValue, _ = strconv.ParseFloat(payment.Amount, 64)
+	if payment.Transaction != nil {
+		details.Memo = payment.Transaction.Memo
+		details.MemoType = payment.Transaction.MemoType
+	}
+	return details, true
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
 	}
 }
 
 // Wallet Operations
+
+// CreateActiveAccount generates a 12-word BIP-39 phrase, derives the wallet's
+// keypair from it (SEP-0005, m/44'/148'/0') and creates the sponsored account
+// with its USDC trustline
 func (s *StellarService) CreateActiveAccount() (*WalletModel, error) {
-	pair, err := keypair.Random()
+	mnemonic, err := utils.GenerateMnemonic(utils.MnemonicEntropy12Words)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate keypair: %w", err)
+		return nil, fmt.Errorf("failed to generate mnemonic: %w", err)
+	}
+	pair, err := DeriveStellarKeypair(mnemonic, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive keypair: %w", err)
 	}
-	address:= pair.Address()
-	privateKey:= pair.Seed()
-	mnemonic := utils.GenerateMnemonic()
 
-	err = s.EstablishTrustLine(address, privateKey)
+	err = s.EstablishTrustLine(pair.Address(), pair.Seed())
 	if err != nil {
 		return nil, fmt.Errorf("failed to establish trust line: %w", err)
 	}
 
-	encryptedPrivateKey, err := utils.EncryptPrivateKey(privateKey)
+	return newWalletModel(pair, mnemonic)
+}
+
+// RestoreActiveAccount rebuilds a wallet from its phrase. An account that
+// was never created on the network is created as a new one would be.
+func (s *StellarService) RestoreActiveAccount(mnemonic string) (*WalletModel, error) {
+	mnemonic = utils.NormalizeMnemonic(mnemonic)
+	pair, err := DeriveStellarKeypair(mnemonic, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.client.AccountDetail(horizonclient.AccountRequest{AccountID: pair.Address()})
+	if horizonclient.IsNotFoundError(err) {
+		if err := s.EstablishTrustLine(pair.Address(), pair.Seed()); err != nil {
+			return nil, fmt.Errorf("failed to establish trust line: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load account: %w", err)
+	}
+
+	return newWalletModel(pair, mnemonic)
+}
+
+func newWalletModel(pair *keypair.Full, mnemonic string) (*WalletModel, error) {
+	encryptedPrivateKey, err := utils.EncryptPrivateKey(pair.Seed())
 	if err != nil {
 		return nil, fmt.Errorf("failed to encrypt private key: %v", err)
 	}
@@ -493,16 +702,28 @@ func (s *StellarService) CreateActiveAccount() (*WalletModel, error) {
 	}
 
 	return &WalletModel{
-		PublicKey:  address,
-		Address:    address,
-		PrivateKey: encryptedPrivateKey,
+		PublicKey:      pair.Address(),
+		Address:        pair.Address(),
+		PrivateKey:     encryptedPrivateKey,
 		MnemonicPhrase: encryptedMnemonic,
-		Chain:      ChainStellar,
-		Type:       WalletTypeNonCustodial,
+		Chain:          ChainStellar,
+		Type:           WalletTypeNonCustodial,
 	}, nil
 }
 
-
+// DeriveStellarKeypair derives the SEP-0005 account m/44'/148'/index' from a
+// BIP-39 phrase without passphrase, as Stellar wallets restoring it do
+func DeriveStellarKeypair(mnemonic string, index uint32) (*keypair.Full, error) {
+	seed, err := utils.MnemonicSeed(mnemonic, "")
+	if err != nil {
+		return nil, err
+	}
+	key, err := derivation.DeriveForPath(fmt.Sprintf(derivation.StellarAccountPathFormat, index), seed)
+	if err != nil {
+		return nil, err
+	}
+	return keypair.FromRawSeed(key.RawSeed())
+}
 
 func (s *StellarService) SponsorAccount(userWallet *keypair.Full) error {
 	if s.config.Stellar.DistributorSecretKey == "" {
@@ -577,7 +798,10 @@ func (s *StellarService) GenerateMuxedAddress(userID string) (*MuxedWallet, erro
 	}
 
 	muxedAccountID := s.generateMuxedAccountID(userID)
-	muxedAddress := fmt.Sprintf("M%s%d", s.config.Stellar.PublicKey[1:], muxedAccountID)
+	muxedAddress, err := s.MuxedAddress(muxedAccountID)
+	if err != nil {
+		return nil, err
+	}
 
 	return &MuxedWallet{
 		MuxedAddress:   muxedAddress,
@@ -616,10 +840,45 @@ func (s *StellarService) generateMuxedAccountID(userID string) uint64 {
 	return muxedID
 }
 
+// MuxedAddress encodes the M-address of the custody account for a muxed ID
+func (s *StellarService) MuxedAddress(muxedAccountID uint64) (string, error) {
+	muxed, err := xdr.MuxedAccountFromAccountId(s.config.Stellar.PublicKey, muxedAccountID)
+	if err != nil {
+		return "", fmt.Errorf("failed to build muxed address: %w", err)
+	}
+	return muxed.GetAddress()
+}
+
+// CustodyAddress returns the account that custodial wallets receive into
+func (s *StellarService) CustodyAddress() string {
+	return s.config.Stellar.PublicKey
+}
+
 func (s *StellarService) IsMuxedAddress(address string) bool {
 	return address != "" && address[0] == 'M'
 }
 
+// NetworkName returns "testnet" or "mainnet"
+func (s *StellarService) NetworkName() string {
+	if s.config.AppEnv == "dev" {
+		return "testnet"
+	}
+	return "mainnet"
+}
+
+// DistributorAddress returns the public key of the distributor account, empty
+// when no distributor is configured
+func (s *StellarService) DistributorAddress() string {
+	if s.config.Stellar.DistributorSecretKey == "" {
+		return ""
+	}
+	kp, err := keypair.ParseFull(s.config.Stellar.DistributorSecretKey)
+	if err != nil {
+		return ""
+	}
+	return kp.Address()
+}
+
 func (s *StellarService) isRetryableError(err error) bool {
 	errStr := err.Error()
 	return strings.Contains(errStr, "503") ||
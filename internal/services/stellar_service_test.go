@@ -0,0 +1,54 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"healthy_pay_backend/internal/utils"
+)
+
+func TestDeriveStellarKeypair(t *testing.T) {
+	// SEP-0005 test vector 1
+	mnemonic := "illness spike retreat truth genius clock brain pass fit cave bargain toe"
+	for _, tc := range []struct {
+		index   uint32
+		address string
+		seed    string
+	}{
+		{0, "GDRXE2BQUC3AZNPVFSCEZ76NJ3WWL25FYFK6RGZGIEKWE4SOOHSUJUJ6", "SBGWSG6BTNCKCOB3DIFBGCVMUPQFYPA2G4O34RMTB343OYPXU5DJDVMN"},
+		{1, "GBAW5XGWORWVFE2XTJYDTLDHXTY2Q2MO73HYCGB3XMFMQ562Q2W2GJQX", "SCEPFFWGAG5P2VX5DHIYK3XEMZYLTYWIPWYEKXFHSK25RVMIUNJ7CTIS"},
+	} {
+		pair, err := DeriveStellarKeypair(mnemonic, tc.index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pair.Address() != tc.address || pair.Seed() != tc.seed {
+			t.Errorf("account %d = %s, want %s", tc.index, pair.Address(), tc.address)
+		}
+	}
+
+	// Restoring tolerates the spacing and case a user types
+	pair, err := DeriveStellarKeypair("  Illness spike retreat truth genius clock\nbrain pass fit cave bargain TOE ", 0)
+	if err != nil || pair.Address() != "GDRXE2BQUC3AZNPVFSCEZ76NJ3WWL25FYFK6RGZGIEKWE4SOOHSUJUJ6" {
+		t.Errorf("normalized phrase = %v, %v", pair, err)
+	}
+
+	for _, bad := range []string{
+		"illness spike retreat truth genius clock brain pass fit cave bargain bargain", // Bad checksum
+		"illness spike retreat truth genius clock brain pass fit cave bargain",         // 11 words
+		"illness spike retreat truth genius clock brain pass fit cave bargain toz",     // Not in the wordlist
+	} {
+		if _, err := DeriveStellarKeypair(bad, 0); !errors.Is(err, utils.ErrInvalidMnemonic) {
+			t.Errorf("%q error = %v, want ErrInvalidMnemonic", bad, err)
+		}
+	}
+
+	generated, err := utils.GenerateMnemonic(utils.MnemonicEntropy24Words)
+	if err != nil || len(strings.Fields(generated)) != 24 {
+		t.Fatalf("generated = %q, %v", generated, err)
+	}
+	if _, err := DeriveStellarKeypair(generated, 0); err != nil {
+		t.Errorf("deriving from a generated phrase: %v", err)
+	}
+}
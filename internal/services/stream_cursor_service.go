@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ingestion further behind than this is reported as unhealthy
+const MaxIngestionLag = 5 * time.Minute
+
+var ErrNoStreamCursor = errors.New("stream has no cursor yet")
+
+// StreamCursorService persists how far each ingestion stream has got, so a
+// restarted stream resumes where it stopped instead of skipping what happened
+// while it was down
+type StreamCursorService struct {
+	db *mongo.Database
+}
+
+func NewStreamCursorService(db *mongo.Database) *StreamCursorService {
+	return &StreamCursorService{db: db}
+}
+
+// StellarPaymentStream names the payment stream of a Stellar account
+func StellarPaymentStream(account string) string {
+	return "stellar_payments:" + account
+}
+
+// Load returns the stream's stored cursor, or ErrNoStreamCursor
+func (c *StreamCursorService) Load(ctx context.Context, stream string) (string, error) {
+	var cursor models.StreamCursor
+	err := c.db.Collection("stream_cursors").FindOne(ctx, bson.M{"_id": stream}).Decode(&cursor)
+	if errors.Is(err, mongo.ErrNoDocuments) || err == nil && cursor.Cursor == "" {
+		return "", ErrNoStreamCursor
+	}
+	if err != nil {
+		return "", err
+	}
+	return cursor.Cursor, nil
+}
+
+// Save records a stream position. Cursors must be numeric, like Horizon
+// paging tokens; a position behind the stored one is ignored.
+func (c *StreamCursorService) Save(ctx context.Context, stream string, position StreamPosition) error {
+	now := time.Now()
+	cursors := c.db.Collection("stream_cursors")
+
+	if position.AtHead {
+		_, err := cursors.UpdateOne(ctx,
+			bson.M{"_id": stream},
+			bson.M{"$set": bson.M{"at_head": true, "head_checked_at": now, "updated_at": now}},
+			options.Update().SetUpsert(true))
+		return err
+	}
+
+	sequence, err := strconv.ParseInt(position.Cursor, 10, 64)
+	if err != nil {
+		return fmt.Errorf("cursor %q is not numeric: %w", position.Cursor, err)
+	}
+
+	_, err = cursors.UpdateOne(ctx,
+		bson.M{"_id": stream, "$or": bson.A{
+			bson.M{"sequence": bson.M{"$lt": sequence}},
+			bson.M{"sequence": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{
+			"cursor":        position.Cursor,
+			"sequence":      sequence,
+			"last_event_at": position.LedgerCloseTime,
+			"at_head":       false,
+			"updated_at":    now,
+		}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Another replica is already further along
+		return nil
+	}
+	return err
+}
+
+// StreamLag - How far behind an ingestion stream is
+type StreamLag struct {
+	Stream  string        `json:"stream"`
+	Cursor  string        `json:"cursor"`
+	AtHead  bool          `json:"atHead"`
+	Lag     time.Duration `json:"-"`
+	Healthy bool          `json:"healthy"`
+}
+
+// Lag reports how long ago the stream was last known to be up to date: the
+// last poll that found nothing newer while it is at the head, otherwise the
+// time of the last event it ingested
+func (c *StreamCursorService) Lag(ctx context.Context, stream string) (*StreamLag, error) {
+	var cursor models.StreamCursor
+	err := c.db.Collection("stream_cursors").FindOne(ctx, bson.M{"_id": stream}).Decode(&cursor)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNoStreamCursor
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upToDateAt := cursor.LastEventAt
+	if cursor.AtHead {
+		upToDateAt = cursor.HeadCheckedAt
+	}
+	lag := time.Since(upToDateAt)
+
+	return &StreamLag{
+		Stream:  stream,
+		Cursor:  cursor.Cursor,
+		AtHead:  cursor.AtHead,
+		Lag:     lag,
+		Healthy: lag <= MaxIngestionLag,
+	}, nil
+}
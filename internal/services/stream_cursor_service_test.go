@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestStreamCursorSave(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	stream := StellarPaymentStream("GCUSTODY")
+
+	mt.Run("only moves forward", func(mt *mtest.T) {
+		mt.AddMockResponses(mockUpdated(1))
+		err := NewStreamCursorService(mt.DB).Save(context.Background(), stream, StreamPosition{Cursor: "1200", LedgerCloseTime: time.Now()})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		behind := update.Lookup("q", "$or").Array().Index(0).Value().Document()
+		if behind.Lookup("sequence", "$lt").AsInt64() != 1200 {
+			mt.Errorf("save filter = %s, want only cursors behind 1200", update.Lookup("q"))
+		}
+		if update.Lookup("u", "$set", "cursor").StringValue() != "1200" || update.Lookup("u", "$set", "at_head").Boolean() {
+			mt.Errorf("save update = %s", update.Lookup("u"))
+		}
+	})
+
+	mt.Run("a replica further along wins", func(mt *mtest.T) {
+		// The filter misses the stored cursor, so the upsert collides with it
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}))
+		if err := NewStreamCursorService(mt.DB).Save(context.Background(), stream, StreamPosition{Cursor: "900"}); err != nil {
+			mt.Errorf("saving an older cursor = %v, want it ignored", err)
+		}
+	})
+
+	mt.Run("at head keeps the cursor", func(mt *mtest.T) {
+		mt.AddMockResponses(mockUpdated(1))
+		if err := NewStreamCursorService(mt.DB).Save(context.Background(), stream, StreamPosition{AtHead: true}); err != nil {
+			mt.Fatal(err)
+		}
+		set := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set").Document()
+		if _, err := set.LookupErr("cursor"); err == nil || !set.Lookup("at_head").Boolean() {
+			mt.Errorf("at head update = %s, want at_head without a cursor", set)
+		}
+	})
+
+	mt.Run("cursors must be numeric", func(mt *mtest.T) {
+		if err := NewStreamCursorService(mt.DB).Save(context.Background(), stream, StreamPosition{Cursor: "now"}); err == nil {
+			mt.Error("non-numeric cursor saved")
+		}
+	})
+}
+
+func TestStreamCursorLag(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	stream := StellarPaymentStream("GCUSTODY")
+
+	found := func(atHead bool, lastEvent, headChecked time.Time) bson.D {
+		return mtest.CreateCursorResponse(0, "test.stream_cursors", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: stream},
+			{Key: "cursor", Value: "1200"},
+			{Key: "sequence", Value: int64(1200)},
+			{Key: "last_event_at", Value: lastEvent},
+			{Key: "head_checked_at", Value: headChecked},
+			{Key: "at_head", Value: atHead},
+		})
+	}
+	quietDay := time.Now().Add(-24 * time.Hour)
+
+	mt.Run("at head with no new payments", func(mt *mtest.T) {
+		mt.AddMockResponses(found(true, quietDay, time.Now().Add(-10*time.Second)))
+		lag, err := NewStreamCursorService(mt.DB).Lag(context.Background(), stream)
+		if err != nil || !lag.Healthy || lag.Lag > time.Minute {
+			mt.Errorf("Lag = %+v, %v; want healthy from the last head check", lag, err)
+		}
+	})
+
+	mt.Run("behind the head", func(mt *mtest.T) {
+		mt.AddMockResponses(found(false, quietDay, time.Now()))
+		lag, err := NewStreamCursorService(mt.DB).Lag(context.Background(), stream)
+		if err != nil || lag.Healthy || lag.Lag < 23*time.Hour {
+			mt.Errorf("Lag = %+v, %v; want unhealthy from the last event", lag, err)
+		}
+	})
+
+	mt.Run("never saved", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.stream_cursors", mtest.FirstBatch))
+		if _, err := NewStreamCursorService(mt.DB).Lag(context.Background(), stream); !errors.Is(err, ErrNoStreamCursor) {
+			mt.Errorf("error = %v, want ErrNoStreamCursor", err)
+		}
+	})
+}
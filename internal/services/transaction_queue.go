@@ -2,18 +2,47 @@ package services
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
 	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/statemachine"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const (
+	// A claimed document is left alone by other instances for this long
+	queueLeaseDuration = 2 * time.Minute
+	// After a check the lease is shortened so the document is due again next tick
+	queueRecheckDelay = 20 * time.Second
+)
+
+// queueLease names the lease fields in a document; sends use snake_case
+// fields and deposits camelCase
+type queueLease struct {
+	owner     string
+	expiresAt string
+}
+
+var (
+	sendQueueLease    = queueLease{owner: "queue_lease_owner", expiresAt: "queue_lease_expires_at"}
+	depositQueueLease = queueLease{owner: "queueLeaseOwner", expiresAt: "queueLeaseExpiresAt"}
+)
+
+// TransactionQueue polls PSPs for transactions that haven't settled. Every
+// document is claimed with a lease before it is checked, so with several
+// instances running each one is handled by exactly one of them; leases held
+// by an instance that died expire and are picked up by the others.
 type TransactionQueue struct {
 	db         *mongo.Database
 	pspService *PSPService
+	settlement *SettlementService
+	workerID   string
 	ticker     *time.Ticker
 	stopChan   chan bool
 }
@@ -22,6 +51,8 @@ func NewTransactionQueue(db *mongo.Database) *TransactionQueue {
 	return &TransactionQueue{
 		db:         db,
 		pspService: NewPSPService(db),
+		settlement: NewSettlementService(db),
+		workerID:   newWorkerID(),
 		stopChan:   make(chan bool),
 	}
 }
@@ -54,161 +85,83 @@ func (tq *TransactionQueue) processPendingTransactions() {
 	
 	// Process pending deposits
 	tq.processPendingDeposits()
+
+	// Confirm payouts with PSPs that don't send callbacks
+	tq.processPendingPayouts()
 }
 
 func (tq *TransactionQueue) processPendingRegularTransactions() {
-	collection := tq.db.Collection("transactions")
-	
-	// Find all pending transactions with PSP transaction IDs
-	cursor, err := collection.Find(context.Background(), bson.M{
-		"status": bson.M{"$in": []string{"collection_pending", "pending"}},
-		"psp_transaction_id": bson.M{"$ne": ""},
-	})
-	if err != nil {
-		log.Printf("Error fetching pending transactions: %v", err)
-		return
+	filter := bson.M{
+		"type":               bson.M{"$ne": "deposit"},
+		"status":             bson.M{"$in": []string{statemachine.SendCollectionPending, statemachine.SendPending}},
+		"psp_transaction_id": bson.M{"$nin": []interface{}{"", nil}},
+		"manual_review":      bson.M{"$ne": true},
 	}
-	defer cursor.Close(context.Background())
-
-	var pendingTransactions []models.Transaction
-	if err := cursor.All(context.Background(), &pendingTransactions); err != nil {
-		log.Printf("Error decoding transactions: %v", err)
-		return
-	}
-
-	if len(pendingTransactions) > 0 {
-		log.Printf("Processing %d pending transactions...", len(pendingTransactions))
-		processed := 0
-
-		for _, transaction := range pendingTransactions {
-			// Check PSP status
-			status, err := tq.pspService.CheckCollectionStatus(transaction.PSPTransactionID)
-			if err != nil {
-				log.Printf("Error checking PSP status for transaction %s: %v", transaction.ID.Hex(), err)
-				continue
-			}
-
-			updateFields := bson.M{"updated_at": time.Now()}
-			
-			switch status {
-			case "collected", "completed", "success":
-				updateFields["status"] = "completed"
-				updateFields["collection_status"] = "collected"
-				processed++
-				log.Printf("✅ Transaction %s marked as completed", transaction.ID.Hex())
-				
-			case "failed", "cancelled", "error":
-				updateFields["status"] = "failed"
-				updateFields["collection_status"] = "failed"
-				processed++
-				log.Printf("❌ Transaction %s marked as failed", transaction.ID.Hex())
-				
-			default:
-				// Still pending, check if it's been too long (24 hours)
-				if time.Since(transaction.CreatedAt) > 24*time.Hour {
-					updateFields["status"] = "failed"
-					updateFields["collection_status"] = "timeout"
-					processed++
-					log.Printf("⏰ Transaction %s timed out after 24 hours", transaction.ID.Hex())
-				}
-			}
 
-			// Update transaction if status changed
-			if len(updateFields) > 1 { // More than just updated_at
-				_, err = collection.UpdateOne(
-					context.Background(),
-					bson.M{"_id": transaction.ID},
-					bson.M{"$set": updateFields},
-				)
-				if err != nil {
-					log.Printf("Error updating transaction %s: %v", transaction.ID.Hex(), err)
-				}
-			}
+	processed := 0
+	passStart := time.Now()
+	for {
+		var transaction models.Transaction
+		if !tq.claimNext(passStart, filter, sendQueueLease, &transaction) {
+			break
 		}
 
-		if processed > 0 {
-			log.Printf("🔄 Successfully processed %d transactions", processed)
+		if tq.processPendingTransaction(transaction) {
+			processed++
 		}
+		tq.releaseLease(transaction.ID, sendQueueLease)
+	}
+
+	if processed > 0 {
+		log.Printf("🔄 Successfully processed %d transactions", processed)
 	}
 }
 
-func (tq *TransactionQueue) processPendingDeposits() {
-	collection := tq.db.Collection("deposits")
-	
-	// Find all pending deposits with transaction IDs
-	cursor, err := collection.Find(context.Background(), bson.M{
-		"status": bson.M{"$in": []string{"initiated", "pending"}},
-		"queueStatus": bson.M{"$in": []string{"queued", "processing"}},
-		"transactionId": bson.M{"$ne": ""},
-	})
+func (tq *TransactionQueue) processPendingTransaction(transaction models.Transaction) bool {
+	// Check PSP status with the PSP that handled the collection
+	status, err := tq.pspService.CheckCollectionStatus(transaction.PSPName, transaction.PSPTransactionID)
+	if errors.Is(err, ErrPSPNotConfigured) {
+		tq.settlement.FlagSendForReview(context.Background(), transaction, err.Error())
+		return false
+	}
 	if err != nil {
-		log.Printf("Error fetching pending deposits: %v", err)
-		return
+		log.Printf("Error checking PSP status for transaction %s: %v", transaction.ID.Hex(), err)
+		return false
 	}
-	defer cursor.Close(context.Background())
 
-	var pendingDeposits []models.UnifiedTransaction
-	if err := cursor.All(context.Background(), &pendingDeposits); err != nil {
-		log.Printf("Error decoding deposits: %v", err)
-		return
+	// Still pending, check if it's been too long (24 hours)
+	if status != "collected" && status != "failed" && time.Since(transaction.CreatedAt) > 24*time.Hour {
+		log.Printf("⏰ Transaction %s timed out after 24 hours", transaction.ID.Hex())
+		status = "timeout"
 	}
 
-	if len(pendingDeposits) == 0 {
-		return
+	applied, err := tq.settlement.ApplySendCollectionStatus(context.Background(), transaction, status)
+	if err != nil {
+		log.Printf("Error applying status to transaction %s: %v", transaction.ID.Hex(), err)
+	}
+	return applied
+}
+
+func (tq *TransactionQueue) processPendingDeposits() {
+	filter := bson.M{
+		"type":          "deposit",
+		"status":        bson.M{"$in": []string{statemachine.DepositInitiated, statemachine.DepositPending}},
+		"transactionId": bson.M{"$nin": []interface{}{"", nil}},
+		"manualReview":  bson.M{"$ne": true},
 	}
 
-	log.Printf("Processing %d pending deposits...", len(pendingDeposits))
 	processed := 0
-
-	for _, deposit := range pendingDeposits {
-		// Check PSP status
-		status, err := tq.pspService.CheckCollectionStatus(deposit.TransactionID)
-		if err != nil {
-			log.Printf("Error checking PSP status for deposit %s: %v", deposit.ID.Hex(), err)
-			continue
+	passStart := time.Now()
+	for {
+		var deposit models.UnifiedTransaction
+		if !tq.claimNext(passStart, filter, depositQueueLease, &deposit) {
+			break
 		}
 
-		updateFields := bson.M{"updatedAt": time.Now()}
-		
-		switch status {
-		case "collected", "completed", "success":
-			updateFields["status"] = "collected"
-			updateFields["queueStatus"] = "completed"
-			now := time.Now()
-			updateFields["processedAt"] = now
+		if tq.processPendingDeposit(deposit) {
 			processed++
-			log.Printf("✅ Deposit %s marked as collected", deposit.ID.Hex())
-			
-			// Process successful deposit (update wallet, investments, etc.)
-			tq.processSuccessfulDeposit(deposit)
-			
-		case "failed", "cancelled", "error":
-			updateFields["status"] = "failed"
-			updateFields["queueStatus"] = "failed"
-			processed++
-			log.Printf("❌ Deposit %s marked as failed", deposit.ID.Hex())
-			
-		default:
-			// Still pending, check if it's been too long (24 hours)
-			if time.Since(deposit.CreatedAt) > 24*time.Hour {
-				updateFields["status"] = "failed"
-				updateFields["queueStatus"] = "timeout"
-				processed++
-				log.Printf("⏰ Deposit %s timed out after 24 hours", deposit.ID.Hex())
-			}
-		}
-
-		// Update deposit if status changed
-		if len(updateFields) > 1 { // More than just updatedAt
-			_, err = collection.UpdateOne(
-				context.Background(),
-				bson.M{"_id": deposit.ID},
-				bson.M{"$set": updateFields},
-			)
-			if err != nil {
-				log.Printf("Error updating deposit %s: %v", deposit.ID.Hex(), err)
-			}
 		}
+		tq.releaseLease(deposit.ID, depositQueueLease)
 	}
 
 	if processed > 0 {
@@ -216,80 +169,101 @@ func (tq *TransactionQueue) processPendingDeposits() {
 	}
 }
 
-func (tq *TransactionQueue) processSuccessfulDeposit(deposit models.UnifiedTransaction) {
-	// Update user wallet balance
-	walletCollection := tq.db.Collection("wallets")
-	
-	// Calculate amounts
-	savingsAmount := deposit.Amount * (100 - deposit.InvestmentPercentage) / 100
-	investmentAmount := deposit.Amount * deposit.InvestmentPercentage / 100
-
-	// Update wallet balance
-	_, err := walletCollection.UpdateOne(
-		context.Background(),
-		bson.M{"userId": deposit.UserID},
-		bson.M{
-			"$inc": bson.M{
-				"balance": savingsAmount,
-			},
-			"$set": bson.M{
-				"updatedAt": time.Now(),
-			},
-		},
-	)
+func (tq *TransactionQueue) processPendingDeposit(deposit models.UnifiedTransaction) bool {
+	// Check PSP status with the PSP that handled the collection
+	status, err := tq.pspService.CheckCollectionStatus(deposit.PSPName, deposit.TransactionID)
+	if errors.Is(err, ErrPSPNotConfigured) {
+		tq.settlement.FlagDepositForReview(context.Background(), deposit, err.Error())
+		return false
+	}
+	if err != nil {
+		log.Printf("Error checking PSP status for deposit %s: %v", deposit.ID.Hex(), err)
+		return false
+	}
+
+	// Still pending, check if it's been too long (24 hours)
+	if status != "collected" && status != "failed" && time.Since(deposit.CreatedAt) > 24*time.Hour {
+		log.Printf("⏰ Deposit %s timed out after 24 hours", deposit.ID.Hex())
+		status = "timeout"
+	}
+
+	applied, err := tq.settlement.ApplyDepositCollectionStatus(context.Background(), deposit, status)
 	if err != nil {
-		log.Printf("Error updating wallet for deposit %s: %v", deposit.ID.Hex(), err)
+		log.Printf("Error applying status to deposit %s: %v", deposit.ID.Hex(), err)
+	}
+	return applied
+}
+
+func (tq *TransactionQueue) processPendingPayouts() {
+	pspNames := tq.pspService.DeliveryPollingProviders()
+	if len(pspNames) == 0 {
 		return
 	}
 
-	log.Printf("💰 Updated wallet balance for user %s: +%.2f", deposit.UserID.Hex(), savingsAmount)
-
-	// Create investment record if applicable
-	if investmentAmount > 0 {
-		investmentCollection := tq.db.Collection("investments")
-		investment := bson.M{
-			"userId":    deposit.UserID,
-			"amount":    investmentAmount,
-			"type":      "deposit_investment",
-			"status":    "active",
-			"depositId": deposit.ID,
-			"createdAt": time.Now(),
-			"updatedAt": time.Now(),
-		}
-		_, err := investmentCollection.InsertOne(context.Background(), investment)
-		if err != nil {
-			log.Printf("Error creating investment for deposit %s: %v", deposit.ID.Hex(), err)
-		} else {
-			log.Printf("📈 Created investment record: %.2f for user %s", investmentAmount, deposit.UserID.Hex())
-		}
+	filter := bson.M{
+		"delivery_status":   "delivered",
+		"delivery_psp_name": bson.M{"$in": pspNames},
+		"manual_review":     bson.M{"$ne": true},
 	}
 
-	// Handle donation if applicable
-	if deposit.DonationChoice != "none" && deposit.DonationChoice != "" {
-		donationCollection := tq.db.Collection("donations")
-		donationAmount := 0.0
-		
-		if deposit.DonationChoice == "both" {
-			donationAmount = deposit.Amount
-		} else if deposit.DonationChoice == "profit" {
-			donationAmount = investmentAmount
+	processed := 0
+	passStart := time.Now()
+	for {
+		var transaction models.Transaction
+		if !tq.claimNext(passStart, filter, sendQueueLease, &transaction) {
+			break
 		}
 
-		if donationAmount > 0 {
-			donation := bson.M{
-				"userId":     deposit.UserID,
-				"amount":     donationAmount,
-				"type":       deposit.DonationChoice,
-				"depositId":  deposit.ID,
-				"status":     "pending",
-				"createdAt":  time.Now(),
-			}
-			_, err := donationCollection.InsertOne(context.Background(), donation)
+		reference := transaction.ID.Hex()
+		status, _, err := tq.pspService.CheckDeliveryStatus(transaction.DeliveryPSPName, reference)
+		if err != nil {
+			log.Printf("Error checking payout status for transaction %s: %v", reference, err)
+		} else {
+			applied, err := tq.settlement.ApplyPayoutStatus(context.Background(), reference, status)
 			if err != nil {
-				log.Printf("Error creating donation for deposit %s: %v", deposit.ID.Hex(), err)
-			} else {
-				log.Printf("🎁 Created donation record: %.2f (%s) for user %s", donationAmount, deposit.DonationChoice, deposit.UserID.Hex())
+				log.Printf("Error applying payout status to transaction %s: %v", reference, err)
+			}
+			if applied {
+				processed++
 			}
 		}
+		tq.releaseLease(transaction.ID, sendQueueLease)
+	}
+
+	if processed > 0 {
+		log.Printf("📤 Successfully confirmed %d payouts", processed)
+	}
+}
+
+// claimNext leases the next document matching filter to this instance and
+// decodes it into result. Only documents whose lease had expired when the
+// pass started are claimed, so each is checked at most once per pass. Returns
+// false when there is nothing left to claim.
+func (tq *TransactionQueue) claimNext(passStart time.Time, filter bson.M, lease queueLease, result interface{}) bool {
+	now := time.Now()
+	claim := bson.M{lease.expiresAt: bson.M{"$not": bson.M{"$gt": passStart}}}
+	for key, value := range filter {
+		claim[key] = value
+	}
+
+	err := tq.db.Collection("transactions").FindOneAndUpdate(context.Background(), claim, bson.M{"$set": bson.M{
+		lease.owner:     tq.workerID,
+		lease.expiresAt: now.Add(queueLeaseDuration),
+	}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(result)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Error claiming queued transaction: %v", err)
+	}
+	return err == nil
+}
+
+// releaseLease hands the document back after a check, keeping other instances
+// off it until their next poll
+func (tq *TransactionQueue) releaseLease(id primitive.ObjectID, lease queueLease) {
+	_, err := tq.db.Collection("transactions").UpdateOne(context.Background(), bson.M{
+		"_id":       id,
+		lease.owner: tq.workerID,
+	}, bson.M{"$set": bson.M{lease.expiresAt: time.Now().Add(queueRecheckDelay)}})
+	if err != nil {
+		log.Printf("Error releasing lease on transaction %s: %v", id.Hex(), err)
 	}
 }
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"healthy_pay_backend/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrTransactionTokenUsed = errors.New("transaction token already used")
+
+// TransactionTokenService records the transaction tokens that authorized a
+// request in used_transaction_tokens, so one PIN verification moves money once
+type TransactionTokenService struct {
+	db *mongo.Database
+}
+
+func NewTransactionTokenService(db *mongo.Database) *TransactionTokenService {
+	return &TransactionTokenService{db: db}
+}
+
+// EnsureIndexes drops used tokens once they would have expired anyway
+func (t *TransactionTokenService) EnsureIndexes(ctx context.Context) error {
+	_, err := t.db.Collection("used_transaction_tokens").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create used transaction token indexes: %w", err)
+	}
+	return nil
+}
+
+// Use spends the token jti on the request with idempotencyKey. Retries of
+// that request may present the token again, so they get its stored response;
+// any other request gets ErrTransactionTokenUsed.
+func (t *TransactionTokenService) Use(ctx context.Context, jti, userID, idempotencyKey string) error {
+	collection := t.db.Collection("used_transaction_tokens")
+	_, err := collection.InsertOne(ctx, bson.M{
+		"_id":             jti,
+		"user_id":         userID,
+		"idempotency_key": idempotencyKey,
+		"expires_at":      time.Now().Add(utils.TransactionTokenTTL),
+	})
+	if err == nil {
+		return nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to record transaction token: %w", err)
+	}
+
+	var used struct {
+		IdempotencyKey string `bson:"idempotency_key"`
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": jti}).Decode(&used); err != nil {
+		return fmt.Errorf("failed to load transaction token: %w", err)
+	}
+	if used.IdempotencyKey != idempotencyKey {
+		return ErrTransactionTokenUsed
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestTransactionTokenUse(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	used := func(key string) []bson.D {
+		return []bson.D{
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}),
+			mtest.CreateCursorResponse(0, "test.used_transaction_tokens", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: "jti-1"},
+				{Key: "idempotency_key", Value: key},
+			}),
+		}
+	}
+
+	mt.Run("first use", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		if err := NewTransactionTokenService(mt.DB).Use(context.Background(), "jti-1", "user-1", "key-1"); err != nil {
+			mt.Error(err)
+		}
+	})
+
+	mt.Run("retry of the same request", func(mt *mtest.T) {
+		mt.AddMockResponses(used("key-1")...)
+		if err := NewTransactionTokenService(mt.DB).Use(context.Background(), "jti-1", "user-1", "key-1"); err != nil {
+			mt.Errorf("retry refused: %v", err)
+		}
+	})
+
+	mt.Run("another request", func(mt *mtest.T) {
+		mt.AddMockResponses(used("key-1")...)
+		err := NewTransactionTokenService(mt.DB).Use(context.Background(), "jti-1", "user-1", "key-2")
+		if !errors.Is(err, ErrTransactionTokenUsed) {
+			mt.Errorf("error = %v, want ErrTransactionTokenUsed", err)
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrRecipientNotFound = errors.New("recipient not found")
+
+// Country code assumed for phone numbers given in local format
+const defaultCountryCode = "233"
+
+const (
+	maxHandleBaseLength = 16
+	handleAttempts      = 5
+)
+
+var (
+	handleBaseChars = regexp.MustCompile(`[^a-z0-9]`)
+	phoneChars      = regexp.MustCompile(`^\+?[0-9 ()-]{7,20}$`)
+)
+
+// WalletHandleService gives every user a wallet handle such as @kofimensah4821
+// and resolves the handles, phone numbers and emails senders type in to users
+type WalletHandleService struct {
+	db *mongo.Database
+}
+
+func NewWalletHandleService(db *mongo.Database) *WalletHandleService {
+	return &WalletHandleService{db: db}
+}
+
+// EnsureIndexes makes handles unique across users that have one
+func (w *WalletHandleService) EnsureIndexes(ctx context.Context) error {
+	_, err := w.db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "wallet_handle", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"wallet_handle": bson.M{"$type": "string"}}),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create wallet handle index: %w", err)
+	}
+	return nil
+}
+
+// AssignHandle gives a user a handle built from their name and returns it.
+// A user keeps the handle they already have.
+func (w *WalletHandleService) AssignHandle(ctx context.Context, userID primitive.ObjectID, firstName, lastName string) (string, error) {
+	users := w.db.Collection("users")
+
+	base := handleBaseChars.ReplaceAllString(strings.ToLower(firstName+lastName), "")
+	if len(base) > maxHandleBaseLength {
+		base = base[:maxHandleBaseLength]
+	}
+	if base == "" {
+		base = "siha"
+	}
+
+	for attempt := 0; attempt < handleAttempts; attempt++ {
+		suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return "", err
+		}
+		handle := fmt.Sprintf("%s%04d", base, suffix.Int64())
+
+		result, err := users.UpdateOne(ctx,
+			bson.M{"_id": userID, "wallet_handle": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"wallet_handle": handle, "updated_at": time.Now()}})
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if result.MatchedCount == 1 {
+			return handle, nil
+		}
+
+		// Already has a handle
+		var user models.User
+		if err := users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+			return "", err
+		}
+		return user.WalletHandle, nil
+	}
+	return "", fmt.Errorf("no free wallet handle for %q after %d attempts", base, handleAttempts)
+}
+
+// AssignMissingHandles gives a handle to every user that doesn't have one yet
+func (w *WalletHandleService) AssignMissingHandles(ctx context.Context) (int, error) {
+	cursor, err := w.db.Collection("users").Find(ctx, bson.M{"wallet_handle": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"first_name": 1, "last_name": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	assigned := 0
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return assigned, err
+		}
+		if _, err := w.AssignHandle(ctx, user.ID, user.FirstName, user.LastName); err != nil {
+			log.Printf("Error assigning wallet handle to user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		assigned++
+	}
+	return assigned, cursor.Err()
+}
+
+// Resolve finds the user a sender means by identifier: a wallet handle (with or
+// without the "@"), an email, a phone number, or for older clients a user ID
+func (w *WalletHandleService) Resolve(ctx context.Context, identifier string) (*models.User, error) {
+	identifier = strings.TrimSpace(identifier)
+	users := w.db.Collection("users")
+
+	var filter bson.M
+	switch {
+	case identifier == "":
+		return nil, ErrRecipientNotFound
+	case strings.HasPrefix(identifier, "@"):
+		filter = bson.M{"wallet_handle": strings.ToLower(identifier[1:])}
+	case strings.Contains(identifier, "@"):
+		filter = bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(identifier) + "$", Options: "i"}}
+	case phoneChars.MatchString(identifier):
+		return w.resolvePhone(ctx, identifier)
+	case primitive.IsValidObjectID(identifier):
+		id, _ := primitive.ObjectIDFromHex(identifier)
+		filter = bson.M{"_id": id}
+	default:
+		filter = bson.M{"wallet_handle": strings.ToLower(identifier)}
+	}
+
+	var user models.User
+	err := users.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrRecipientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// resolvePhone matches a phone number in local or international format against
+// users' verified profile numbers. A number verified by more than one user
+// resolves to no one rather than a guess.
+func (w *WalletHandleService) resolvePhone(ctx context.Context, phone string) (*models.User, error) {
+	cursor, err := w.db.Collection("users").Find(ctx,
+		bson.M{"phone_number": bson.M{"$in": phoneVariants(phone)}, "phone_verified": true},
+		options.Find().SetLimit(2))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	if len(users) != 1 {
+		return nil, ErrRecipientNotFound
+	}
+	return &users[0], nil
+}
+
+// phoneVariants returns the formats a number may have been stored in
+func phoneVariants(phone string) []string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+
+	national := digits
+	if strings.HasPrefix(digits, defaultCountryCode) {
+		national = "0" + strings.TrimPrefix(digits, defaultCountryCode)
+	}
+	international := defaultCountryCode + strings.TrimPrefix(national, "0")
+
+	return []string{national, international, "+" + international}
+}
@@ -0,0 +1,15 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPhoneVariants(t *testing.T) {
+	want := []string{"0244123456", "233244123456", "+233244123456"}
+	for _, phone := range []string{"0244123456", "+233 24 412 3456", "233244123456", "024-412-3456"} {
+		if got := phoneVariants(phone); !reflect.DeepEqual(got, want) {
+			t.Errorf("phoneVariants(%q) = %v, want %v", phone, got, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package statemachine
+
+// Send statuses, stored on models.Transaction
+const (
+	SendPending                = "pending" // Legacy name for collection_pending
+	SendCollectionPending      = "collection_pending"
+	SendProcessingDistribution = "processing_distribution"
+	SendCompleted              = "completed"
+	SendDeliveryFailed         = "delivery_failed"
+	SendRefunded               = "refunded"
+	SendFailed                 = "failed"
+)
+
+// Deposit statuses, stored on models.UnifiedTransaction
+const (
+	DepositPending   = "pending"
+	DepositInitiated = "initiated"
+	DepositCollected = "collected"
+	DepositFailed    = "failed"
+)
+
+// Withdrawal statuses
+const (
+	WithdrawalPending    = "pending"
+	WithdrawalProcessing = "processing"
+	WithdrawalCompleted  = "completed"
+	WithdrawalFailed     = "failed"
+	WithdrawalCancelled  = "cancelled"
+)
+
+// Refund statuses, stored on models.Refund
+const (
+	RefundPending    = "pending"
+	RefundProcessing = "processing" // Disbursement accepted by the PSP, awaiting its final status
+	RefundCompleted  = "completed"
+	RefundFailed     = "failed"
+)
+
+// Send covers mobile money sends, which are collected before they are
+// delivered, wallet sends, which are debited when they are created and start
+// out processing, and Siha transfers, which complete when they are created.
+// A send stays processing until its payout is final.
+var Send = &Machine{
+	Name:          "send",
+	StatusField:   "status",
+	HistoryField:  "status_history",
+	UpdatedField:  "updated_at",
+	InitialStates: []string{SendCollectionPending, SendProcessingDistribution, SendCompleted},
+	Transitions: map[string][]string{
+		SendPending:                {SendProcessingDistribution, SendFailed},
+		SendCollectionPending:      {SendProcessingDistribution, SendFailed},
+		SendProcessingDistribution: {SendCompleted, SendDeliveryFailed},
+		SendDeliveryFailed:         {SendRefunded},
+	},
+}
+
+var Deposit = &Machine{
+	Name:          "deposit",
+	StatusField:   "status",
+	HistoryField:  "statusHistory",
+	UpdatedField:  "updatedAt",
+	InitialStates: []string{DepositPending},
+	Transitions: map[string][]string{
+		DepositPending:   {DepositInitiated, DepositCollected, DepositFailed},
+		DepositInitiated: {DepositCollected, DepositFailed},
+	},
+}
+
+var Withdrawal = &Machine{
+	Name:          "withdrawal",
+	StatusField:   "status",
+	HistoryField:  "status_history",
+	UpdatedField:  "updated_at",
+	InitialStates: []string{WithdrawalPending},
+	Transitions: map[string][]string{
+		WithdrawalPending:    {WithdrawalProcessing, WithdrawalCancelled, WithdrawalFailed},
+		WithdrawalProcessing: {WithdrawalCompleted, WithdrawalFailed},
+	},
+}
+
+var Refund = &Machine{
+	Name:          "refund",
+	StatusField:   "status",
+	HistoryField:  "status_history",
+	UpdatedField:  "updated_at",
+	InitialStates: []string{RefundPending},
+	Transitions: map[string][]string{
+		RefundPending:    {RefundProcessing, RefundCompleted, RefundFailed},
+		RefundProcessing: {RefundCompleted, RefundFailed},
+	},
+}
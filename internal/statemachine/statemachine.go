@@ -0,0 +1,120 @@
+// Package statemachine defines the statuses deposits, sends and withdrawals
+// move through and applies status changes with a compare-and-set update that
+// also appends to the document's status history.
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"healthy_pay_backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrIllegalTransition    = errors.New("illegal status transition")
+	ErrConcurrentTransition = errors.New("status changed concurrently")
+)
+
+// A transition is retried this many times when another writer changes the
+// status between the read and the compare-and-set
+const maxAttempts = 3
+
+// TransitionError reports a rejected transition; it matches ErrIllegalTransition
+type TransitionError struct {
+	Machine string
+	From    string
+	To      string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s: illegal status transition from %q to %q", e.Machine, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// Machine describes the statuses of one kind of transaction and the document
+// fields they are stored in
+type Machine struct {
+	Name          string
+	StatusField   string
+	HistoryField  string
+	UpdatedField  string
+	InitialStates []string
+	Transitions   map[string][]string // From -> allowed next statuses
+}
+
+// CanTransition reports whether from -> to is allowed. Staying in the same
+// status is not a transition.
+func (m *Machine) CanTransition(from, to string) bool {
+	for _, next := range m.Transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether nothing can follow status
+func (m *Machine) IsTerminal(status string) bool {
+	return len(m.Transitions[status]) == 0
+}
+
+// Start returns the status history for a new document created in status
+func (m *Machine) Start(status, reason string) ([]models.StatusChange, error) {
+	for _, initial := range m.InitialStates {
+		if initial == status {
+			return []models.StatusChange{{To: status, Reason: reason, At: time.Now()}}, nil
+		}
+	}
+	return nil, &TransitionError{Machine: m.Name, To: status}
+}
+
+// Transition moves the document to status to if the machine allows it from
+// the current status, setting the extra fields in set in the same update. The
+// update only matches while the status is still the one that was checked, so
+// two writers can never both apply a transition from the same status; this
+// relies on machines having no cycles. Returns the status the document was in.
+func (m *Machine) Transition(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, to, reason string, set bson.M) (string, error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		var current bson.M
+		projection := options.FindOne().SetProjection(bson.M{m.StatusField: 1})
+		if err := collection.FindOne(ctx, bson.M{"_id": id}, projection).Decode(&current); err != nil {
+			return "", err
+		}
+
+		from, _ := current[m.StatusField].(string)
+		if !m.CanTransition(from, to) {
+			return from, &TransitionError{Machine: m.Name, From: from, To: to}
+		}
+
+		now := time.Now()
+		fields := bson.M{}
+		for key, value := range set {
+			fields[key] = value
+		}
+		fields[m.StatusField] = to
+		fields[m.UpdatedField] = now
+
+		result, err := collection.UpdateOne(ctx, bson.M{"_id": id, m.StatusField: from}, bson.M{
+			"$set":  fields,
+			"$push": bson.M{m.HistoryField: models.StatusChange{From: from, To: to, Reason: reason, At: now}},
+		})
+		if err != nil {
+			return from, err
+		}
+		if result.MatchedCount == 1 {
+			return from, nil
+		}
+		// Lost the race, check the transition again against the new status
+	}
+	return "", fmt.Errorf("%s %s: %w", m.Name, id.Hex(), ErrConcurrentTransition)
+}
@@ -0,0 +1,85 @@
+package statemachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSendTransitions(t *testing.T) {
+	legal := [][2]string{
+		{SendCollectionPending, SendProcessingDistribution},
+		{SendPending, SendFailed},
+		{SendProcessingDistribution, SendCompleted},
+		{SendDeliveryFailed, SendRefunded},
+	}
+	for _, tr := range legal {
+		if !Send.CanTransition(tr[0], tr[1]) {
+			t.Errorf("Expected %s -> %s to be allowed", tr[0], tr[1])
+		}
+	}
+
+	illegal := [][2]string{
+		{SendCollectionPending, SendCompleted},   // Skips distribution
+		{SendFailed, SendProcessingDistribution}, // Failed is terminal
+		{SendCompleted, SendCompleted},           // Not a transition
+		{SendProcessingDistribution, SendCollectionPending},
+		{SendCompleted, SendRefunded},       // Only undelivered sends are refunded
+		{SendCompleted, SendDeliveryFailed}, // Completed only once the payout is final
+	}
+	for _, tr := range illegal {
+		if Send.CanTransition(tr[0], tr[1]) {
+			t.Errorf("Expected %s -> %s to be rejected", tr[0], tr[1])
+		}
+	}
+
+	if !Send.IsTerminal(SendFailed) || !Send.IsTerminal(SendCompleted) || Send.IsTerminal(SendProcessingDistribution) {
+		t.Error("Expected failed and completed to be terminal and processing_distribution not to be")
+	}
+}
+
+func TestDepositTransitions(t *testing.T) {
+	if !Deposit.CanTransition(DepositInitiated, DepositCollected) {
+		t.Error("Expected initiated -> collected to be allowed")
+	}
+	if Deposit.CanTransition(DepositCollected, DepositFailed) {
+		t.Error("Expected a collected deposit not to fail")
+	}
+}
+
+func TestStart(t *testing.T) {
+	history, err := Deposit.Start(DepositPending, "Deposit created")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(history) != 1 || history[0].From != "" || history[0].To != DepositPending || history[0].At.IsZero() {
+		t.Errorf("Expected one entry into pending, got %+v", history)
+	}
+
+	_, err = Deposit.Start(DepositCollected, "Deposit created")
+	var transitionErr *TransitionError
+	if !errors.Is(err, ErrIllegalTransition) || !errors.As(err, &transitionErr) || transitionErr.To != DepositCollected {
+		t.Errorf("Expected an illegal transition error for collected, got %v", err)
+	}
+}
+
+// Transition's compare-and-set on the status alone relies on no status being
+// reachable again once left
+func TestMachinesHaveNoCycles(t *testing.T) {
+	for _, machine := range []*Machine{Send, Deposit, Withdrawal, Refund} {
+		var visit func(status string, path map[string]bool)
+		visit = func(status string, path map[string]bool) {
+			if path[status] {
+				t.Errorf("%s: status %s can be reached again", machine.Name, status)
+				return
+			}
+			path[status] = true
+			for _, next := range machine.Transitions[status] {
+				visit(next, path)
+			}
+			delete(path, status)
+		}
+		for from := range machine.Transitions {
+			visit(from, map[string]bool{})
+		}
+	}
+}
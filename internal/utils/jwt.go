@@ -1,44 +1,133 @@
 package utils
 
 import (
-	"os"
+	"crypto/rand"
+	"encoding/hex"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func GenerateJWT(userID primitive.ObjectID) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID.Hex(),
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(),
-	})
+const (
+	// AccessTokenTTL - Lifetime of access tokens; sessions outlive them through refresh tokens
+	AccessTokenTTL = 15 * time.Minute
+	// TransactionTokenTTL - How long a PIN verification authorizes money movement
+	TransactionTokenTTL = 5 * time.Minute
+)
 
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "your-super-secret-jwt-key-change-this-in-production"
-	}
+// Token type of transaction authorizations, so they can't pass as session tokens or the reverse
+const transactionTokenType = "txn_auth"
 
-	return token.SignedString([]byte(secret))
+// TokenClaims - What the API reads from a validated token
+type TokenClaims struct {
+	ID        string // jti, unique per token
+	UserID    string
+	SessionID string    // Empty for tokens issued before sessions, which AuthMiddleware refuses
+	AuthTime  time.Time // When the user last entered their credentials; zero for tokens issued before it was recorded
+}
+
+// GenerateAccessToken issues a short-lived access token for a session.
+// authTime is when the user signed in, not when the token was refreshed.
+func GenerateAccessToken(userID primitive.ObjectID, sessionID string, authTime time.Time) (string, time.Time, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(AccessTokenTTL)
+	signed, err := signToken(jwt.MapClaims{
+		"jti":       hex.EncodeToString(jti),
+		"user_id":   userID.Hex(),
+		"sid":       sessionID,
+		"auth_time": authTime.Unix(),
+		"iat":       now.Unix(),
+		"exp":       expiresAt.Unix(),
+	})
+	return signed, expiresAt, err
 }
 
 func ValidateJWT(tokenString string) (string, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "your-super-secret-jwt-key-change-this-in-production"
+	claims, err := ParseJWT(tokenString)
+	if err != nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+
+// ParseJWT validates an access token and returns its claims
+func ParseJWT(tokenString string) (*TokenClaims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if _, typed := claims["typ"]; typed {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	userID, ok := claims["user_id"].(string)
+	if !ok {
+		return nil, jwt.ErrTokenInvalidClaims
 	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
+	parsed := &TokenClaims{UserID: userID}
+	parsed.ID, _ = claims["jti"].(string)
+	parsed.SessionID, _ = claims["sid"].(string)
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		parsed.AuthTime = time.Unix(int64(authTime), 0)
+	}
+	return parsed, nil
+}
+
+// GenerateTransactionToken issues the short-lived token money-moving requests
+// carry once the user has verified their PIN, bound to the session it was
+// verified in
+func GenerateTransactionToken(userID primitive.ObjectID, sessionID string) (string, time.Time, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(TransactionTokenTTL)
+	signed, err := signToken(jwt.MapClaims{
+		"jti":     hex.EncodeToString(jti),
+		"user_id": userID.Hex(),
+		"sid":     sessionID,
+		"typ":     transactionTokenType,
+		"exp":     expiresAt.Unix(),
 	})
+	return signed, expiresAt, err
+}
 
+// ValidateTransactionToken returns the claims of a transaction token
+func ValidateTransactionToken(tokenString string) (*TokenClaims, error) {
+	claims, err := parseToken(tokenString)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	userID, ok := claims["user_id"].(string)
+	if !ok || claims["typ"] != transactionTokenType {
+		return nil, jwt.ErrTokenInvalidClaims
 	}
+	parsed := &TokenClaims{UserID: userID}
+	parsed.ID, _ = claims["jti"].(string)
+	parsed.SessionID, _ = claims["sid"].(string)
+	if parsed.ID == "" || parsed.SessionID == "" {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return parsed, nil
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims["user_id"].(string), nil
+func signToken(claims jwt.MapClaims) (string, error) {
+	keys, err := DefaultSigningKeys()
+	if err != nil {
+		return "", err
 	}
+	return keys.Sign(claims)
+}
 
-	return "", jwt.ErrInvalidKey
+func parseToken(tokenString string) (jwt.MapClaims, error) {
+	keys, err := DefaultSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	return keys.Parse(tokenString)
 }
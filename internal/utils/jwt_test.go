@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTransactionTokenIsNotASessionToken(t *testing.T) {
+	userID := primitive.NewObjectID()
+
+	authTime := time.Now().Add(-time.Hour)
+	session, _, err := GenerateAccessToken(userID, "session-1", authTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseJWT(session)
+	if err != nil || claims.UserID != userID.Hex() || claims.SessionID != "session-1" || claims.AuthTime.Unix() != authTime.Unix() {
+		t.Fatalf("session claims = %+v, %v", claims, err)
+	}
+
+	transaction, expiresAt, err := GenerateTransactionToken(userID, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ValidateTransactionToken(transaction); err != nil || got.UserID != userID.Hex() || got.SessionID != "session-1" || got.ID == "" {
+		t.Errorf("transaction token claims = %+v, %v", got, err)
+	}
+	if time.Until(expiresAt) > TransactionTokenTTL {
+		t.Errorf("transaction token expires at %v, more than %v from now", expiresAt, TransactionTokenTTL)
+	}
+
+	if _, err := ParseJWT(transaction); err == nil {
+		t.Error("transaction token accepted as a session token")
+	}
+	if _, err := ValidateTransactionToken(session); err == nil {
+		t.Error("session token accepted as a transaction token")
+	}
+}
+
+func TestTokenWithoutSessionHasNoSessionID(t *testing.T) {
+	// Tokens issued before sessions carry no sid; AuthMiddleware refuses them on that
+	token, err := signToken(jwt.MapClaims{
+		"user_id": primitive.NewObjectID().Hex(),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseJWT(token)
+	if err != nil || claims.SessionID != "" {
+		t.Fatalf("claims = %+v, %v", claims, err)
+	}
+}
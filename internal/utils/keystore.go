@@ -0,0 +1,355 @@
+package utils
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Envelopes look like "ks1:<master key id>:<wrapped data key>:<ciphertext>"
+const envelopeVersion = "ks1"
+
+var (
+	ErrUnknownMasterKey      = errors.New("unknown master key")
+	ErrKeystoreNotConfigured = errors.New("keystore not configured")
+
+	masterKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// MasterKeyStore wraps and unwraps data keys with master keys that never
+// leave it. A KMS, an HSM or a local key file can sit behind it.
+type MasterKeyStore interface {
+	// ActiveKeyID names the master key new data keys are wrapped with
+	ActiveKeyID() string
+	Wrap(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Keystore - Envelope encryption for stored secrets. Every secret is sealed
+// with its own random data key, the data key is wrapped by a master key and
+// the master key's ID is stored with the ciphertext, so master keys can be
+// rotated without losing access to older secrets.
+type Keystore struct {
+	masters MasterKeyStore
+}
+
+func NewKeystore(masters MasterKeyStore) *Keystore {
+	return &Keystore{masters: masters}
+}
+
+// Encrypt seals plaintext under a fresh data key wrapped with the active master key
+func (k *Keystore) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	sealed, err := sealAESGCM(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	return k.wrap(ctx, dataKey, sealed)
+}
+
+func (k *Keystore) wrap(ctx context.Context, dataKey, sealed []byte) (string, error) {
+	keyID := k.masters.ActiveKeyID()
+	if !masterKeyIDPattern.MatchString(keyID) {
+		return "", fmt.Errorf("invalid master key id %q", keyID)
+	}
+	wrapped, err := k.masters.Wrap(ctx, keyID, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key with %s: %w", keyID, err)
+	}
+	return strings.Join([]string{
+		envelopeVersion,
+		keyID,
+		base64.RawStdEncoding.EncodeToString(wrapped),
+		base64.RawStdEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+// Decrypt opens an envelope, or a value written before envelope encryption
+func (k *Keystore) Decrypt(ctx context.Context, value string) (string, error) {
+	env, ok := parseEnvelope(value)
+	if !ok {
+		return legacyDecrypt(value)
+	}
+	dataKey, err := k.masters.Unwrap(ctx, env.keyID, env.wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key with %s: %w", env.keyID, err)
+	}
+	plaintext, err := openAESGCM(dataKey, env.sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap re-wraps value's data key with the active master key, leaving the
+// ciphertext untouched; legacy values are encrypted into a new envelope.
+// changed is false when value already uses the active master key.
+func (k *Keystore) Rewrap(ctx context.Context, value string) (rewrapped string, changed bool, err error) {
+	env, ok := parseEnvelope(value)
+	if !ok {
+		plaintext, err := legacyDecrypt(value)
+		if err != nil {
+			return "", false, err
+		}
+		rewrapped, err = k.Encrypt(ctx, plaintext)
+		return rewrapped, err == nil, err
+	}
+	if env.keyID == k.masters.ActiveKeyID() {
+		return value, false, nil
+	}
+
+	dataKey, err := k.masters.Unwrap(ctx, env.keyID, env.wrapped)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to unwrap data key with %s: %w", env.keyID, err)
+	}
+	rewrapped, err = k.wrap(ctx, dataKey, env.sealed)
+	return rewrapped, err == nil, err
+}
+
+// MasterKeyID returns the ID of the master key value is wrapped with, or ""
+// for a value written before envelope encryption
+func MasterKeyID(value string) string {
+	env, _ := parseEnvelope(value)
+	return env.keyID
+}
+
+type envelope struct {
+	keyID   string
+	wrapped []byte
+	sealed  []byte
+}
+
+func parseEnvelope(value string) (envelope, bool) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 || parts[0] != envelopeVersion || !masterKeyIDPattern.MatchString(parts[1]) {
+		return envelope{}, false
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return envelope{}, false
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return envelope{}, false
+	}
+	return envelope{keyID: parts[1], wrapped: wrapped, sealed: sealed}, true
+}
+
+// sealAESGCM encrypts plaintext with key, prefixing the random nonce
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// Master key backends by KEYSTORE_BACKEND name. A KMS backend registers
+// itself from an init function in the file that implements it.
+var (
+	backendsMu        sync.Mutex
+	masterKeyBackends = map[string]func() (MasterKeyStore, error){
+		"file": func() (MasterKeyStore, error) { return OpenFileMasterKeyStore(KeystoreFilePath()) },
+	}
+
+	defaultKeystore     *Keystore
+	defaultKeystoreErr  error
+	defaultKeystoreOnce sync.Once
+)
+
+// RegisterMasterKeyBackend makes a master key store selectable with KEYSTORE_BACKEND=name
+func RegisterMasterKeyBackend(name string, open func() (MasterKeyStore, error)) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	masterKeyBackends[name] = open
+}
+
+// DefaultKeystore opens the backend named by KEYSTORE_BACKEND ("file" by
+// default) the first time it is called
+func DefaultKeystore() (*Keystore, error) {
+	defaultKeystoreOnce.Do(func() {
+		open, err := masterKeyBackend(os.Getenv("KEYSTORE_BACKEND"), os.Getenv("APP_ENV"))
+		if err != nil {
+			defaultKeystoreErr = err
+			return
+		}
+
+		masters, err := open()
+		if err != nil {
+			defaultKeystoreErr = err
+			return
+		}
+		defaultKeystore = NewKeystore(masters)
+	})
+	return defaultKeystore, defaultKeystoreErr
+}
+
+// masterKeyBackend looks up a KEYSTORE_BACKEND. The file backend keeps master
+// keys next to the data they protect, so it is refused outside dev.
+func masterKeyBackend(name, appEnv string) (func() (MasterKeyStore, error), error) {
+	if name == "" {
+		name = "file"
+	}
+	if name == "file" && appEnv != "" && appEnv != "dev" {
+		return nil, fmt.Errorf("%w: the file backend is for development only, set KEYSTORE_BACKEND for APP_ENV %q", ErrKeystoreNotConfigured, appEnv)
+	}
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	open, ok := masterKeyBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown KEYSTORE_BACKEND %q", ErrKeystoreNotConfigured, name)
+	}
+	return open, nil
+}
+
+// KeystoreFilePath - Local master key file, KEYSTORE_FILE or ./keystore.json
+func KeystoreFilePath() string {
+	if path := os.Getenv("KEYSTORE_FILE"); path != "" {
+		return path
+	}
+	return "keystore.json"
+}
+
+// FileMasterKeyStore - Master keys kept in a local JSON file, for development.
+// Old keys stay in the file after a new one becomes active so that secrets
+// wrapped with them can still be read and re-wrapped.
+type FileMasterKeyStore struct {
+	active string
+	keys   map[string][]byte
+}
+
+type masterKeyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"` // Base64 AES-256 keys by ID
+}
+
+func OpenFileMasterKeyStore(path string) (*FileMasterKeyStore, error) {
+	file, err := readMasterKeyFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s not found, create it with `go run ./scripts/keystore init`", ErrKeystoreNotConfigured, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	store := &FileMasterKeyStore{active: file.Active, keys: map[string][]byte{}}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("master key %s in %s is not a base64 AES-256 key", id, path)
+		}
+		store.keys[id] = key
+	}
+	if _, ok := store.keys[store.active]; !ok {
+		return nil, fmt.Errorf("active master key %q is missing from %s", store.active, path)
+	}
+	return store, nil
+}
+
+// AddFileMasterKey generates a master key and makes it the active one,
+// creating the key file if it doesn't exist yet
+func AddFileMasterKey(path string) (string, error) {
+	file, err := readMasterKeyFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = &masterKeyFile{Keys: map[string]string{}}, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	id := "local-" + time.Now().UTC().Format("20060102T150405Z")
+	if _, exists := file.Keys[id]; exists {
+		return "", fmt.Errorf("master key %s already exists", id)
+	}
+	file.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	file.Active = id
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	// Write then rename, so a crash never leaves a truncated key file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return "", err
+	}
+	return id, os.Rename(tmp, path)
+}
+
+func readMasterKeyFile(path string) (*masterKeyFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file masterKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	if file.Keys == nil {
+		file.Keys = map[string]string{}
+	}
+	return &file, nil
+}
+
+func (f *FileMasterKeyStore) ActiveKeyID() string {
+	return f.active
+}
+
+// Wrap seals the data key with the master key, bound to the key's ID
+func (f *FileMasterKeyStore) Wrap(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	key, ok := f.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, keyID)
+	}
+	return sealAESGCM(key, dataKey, []byte(keyID))
+}
+
+func (f *FileMasterKeyStore) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := f.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, keyID)
+	}
+	return openAESGCM(key, wrapped, []byte(keyID))
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystoreRotation(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keystore.json")
+
+	first, err := AddFileMasterKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masters, err := OpenFileMasterKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keystore := NewKeystore(masters)
+
+	sealed, err := keystore.Encrypt(ctx, "SBSECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if MasterKeyID(sealed) != first {
+		t.Fatalf("envelope key id = %q, want %q", MasterKeyID(sealed), first)
+	}
+	if again, _ := keystore.Encrypt(ctx, "SBSECRET"); again == sealed {
+		t.Error("two encryptions of the same secret are identical")
+	}
+
+	// A legacy value is moved into an envelope
+	legacy, err := sealAESGCM(getEncryptionKey(), []byte("old mnemonic"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, changed, err := keystore.Rewrap(ctx, hex.EncodeToString(legacy))
+	if err != nil || !changed || MasterKeyID(moved) != first {
+		t.Fatalf("legacy rewrap = %q, %v, %v", moved, changed, err)
+	}
+	if plaintext, err := keystore.Decrypt(ctx, moved); err != nil || plaintext != "old mnemonic" {
+		t.Errorf("decrypting rewrapped legacy value = %q, %v", plaintext, err)
+	}
+
+	if _, changed, _ := keystore.Rewrap(ctx, sealed); changed {
+		t.Error("rewrap with an unchanged active key reported a change")
+	}
+
+	// Rotate the master key: old envelopes still open and re-wrap to the new key
+	second := "next"
+	rotated := &FileMasterKeyStore{active: second, keys: map[string][]byte{first: masters.keys[first], second: make([]byte, 32)}}
+	keystore = NewKeystore(rotated)
+	rewrapped, changed, err := keystore.Rewrap(ctx, sealed)
+	if err != nil || !changed || MasterKeyID(rewrapped) != second {
+		t.Fatalf("rewrap = %q, %v, %v", rewrapped, changed, err)
+	}
+	if plaintext, err := keystore.Decrypt(ctx, rewrapped); err != nil || plaintext != "SBSECRET" {
+		t.Errorf("decrypting rewrapped value = %q, %v", plaintext, err)
+	}
+
+	// Once the old key is retired only re-wrapped secrets can be read
+	keystore = NewKeystore(&FileMasterKeyStore{active: second, keys: map[string][]byte{second: rotated.keys[second]}})
+	if _, err := keystore.Decrypt(ctx, sealed); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Errorf("decrypting with a retired key error = %v, want ErrUnknownMasterKey", err)
+	}
+	if plaintext, err := keystore.Decrypt(ctx, rewrapped); err != nil || plaintext != "SBSECRET" {
+		t.Errorf("decrypting rewrapped value after retiring the old key = %q, %v", plaintext, err)
+	}
+}
+
+func TestFileBackendIsDevOnly(t *testing.T) {
+	for _, appEnv := range []string{"", "dev"} {
+		if _, err := masterKeyBackend("", appEnv); err != nil {
+			t.Errorf("file backend in APP_ENV %q: %v", appEnv, err)
+		}
+	}
+	if _, err := masterKeyBackend("file", "production"); !errors.Is(err, ErrKeystoreNotConfigured) {
+		t.Errorf("file backend in production error = %v, want ErrKeystoreNotConfigured", err)
+	}
+	if _, err := masterKeyBackend("vault", "dev"); !errors.Is(err, ErrKeystoreNotConfigured) {
+		t.Errorf("unknown backend error = %v, want ErrKeystoreNotConfigured", err)
+	}
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// SealedBoxAlgorithm names the scheme so clients can tell how to open a box
+const SealedBoxAlgorithm = "X25519-HKDF-SHA256-A256GCM"
+
+var (
+	ErrInvalidPublicKey = errors.New("invalid X25519 public key")
+
+	sealedBoxInfo = []byte("healthy-pay sealed box v1")
+)
+
+// SealedBox - Secret encrypted to a public key the client generated for one
+// request. Only the holder of the matching private key can open it.
+type SealedBox struct {
+	Algorithm          string `json:"alg"`
+	EphemeralPublicKey string `json:"epk"`        // Base64 X25519 public key
+	Ciphertext         string `json:"ciphertext"` // Base64 nonce followed by the AES-GCM ciphertext
+}
+
+// SealToPublicKey encrypts plaintext to a base64 X25519 public key. The AES key
+// is derived with HKDF-SHA256 from the shared secret, salted with both public
+// keys; additionalData is authenticated but not encrypted.
+func SealToPublicKey(recipientPublicKey string, plaintext, additionalData []byte) (*SealedBox, error) {
+	recipient, err := ParseX25519PublicKey(recipientPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		// A low-order point gives an all-zero shared secret
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	key, err := sealedBoxKey(shared, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := sealAESGCM(key, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return &SealedBox{
+		Algorithm:          SealedBoxAlgorithm,
+		EphemeralPublicKey: base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		Ciphertext:         base64.StdEncoding.EncodeToString(sealed),
+	}, nil
+}
+
+// ParseX25519PublicKey decodes a base64 X25519 public key
+func ParseX25519PublicKey(encoded string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not base64", ErrInvalidPublicKey)
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	return key, nil
+}
+
+func sealedBoxKey(shared, ephemeralPublicKey, recipientPublicKey []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeralPublicKey...), recipientPublicKey...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, sealedBoxInfo), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestSealToPublicKey(t *testing.T) {
+	recipient, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := base64.StdEncoding.EncodeToString(recipient.PublicKey().Bytes())
+
+	box, err := SealToPublicKey(publicKey, []byte("illness spike retreat"), []byte("wallet:1700000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if box.Algorithm != SealedBoxAlgorithm {
+		t.Errorf("alg = %q", box.Algorithm)
+	}
+
+	// Open it as a client would
+	open := func(additionalData string) ([]byte, error) {
+		epkBytes, _ := base64.StdEncoding.DecodeString(box.EphemeralPublicKey)
+		epk, err := ecdh.X25519().NewPublicKey(epkBytes)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := recipient.ECDH(epk)
+		if err != nil {
+			return nil, err
+		}
+		key, err := sealedBoxKey(shared, epkBytes, recipient.PublicKey().Bytes())
+		if err != nil {
+			return nil, err
+		}
+		sealed, _ := base64.StdEncoding.DecodeString(box.Ciphertext)
+		return openAESGCM(key, sealed, []byte(additionalData))
+	}
+	if plaintext, err := open("wallet:1700000000"); err != nil || string(plaintext) != "illness spike retreat" {
+		t.Errorf("opened = %q, %v", plaintext, err)
+	}
+	if _, err := open("wallet:1900000000"); err == nil {
+		t.Error("box opened with altered additional data")
+	}
+
+	for _, bad := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short")), base64.StdEncoding.EncodeToString(make([]byte, 32))} {
+		if _, err := SealToPublicKey(bad, []byte("x"), nil); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("public key %q error = %v, want ErrInvalidPublicKey", bad, err)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWT signing algorithms we issue and accept
+const (
+	SigningAlgorithmEdDSA = "EdDSA"
+	SigningAlgorithmRS256 = "RS256"
+)
+
+const minRSAKeyBits = 2048
+
+var (
+	ErrSigningKeysNotConfigured = errors.New("JWT signing keys not configured")
+	ErrUnknownSigningKey        = errors.New("unknown JWT signing key")
+)
+
+// SigningKey - A JWT key named by its kid. Keys without a private half only
+// verify, e.g. a retired key whose tokens haven't all expired yet.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	private   crypto.Signer
+	public    crypto.PublicKey
+}
+
+// ParseSigningKey reads a PEM PKCS #8 private key or PKIX public key, RSA or Ed25519
+func ParseSigningKey(id, pemData string) (*SigningKey, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM", id)
+	}
+	key := &SigningKey{ID: id}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key %s: %w", id, err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s cannot sign", id)
+		}
+		key.private, key.public = signer, signer.Public()
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key %s: %w", id, err)
+		}
+		key.public = parsed
+	default:
+		return nil, fmt.Errorf("signing key %s has unsupported PEM type %q", id, block.Type)
+	}
+
+	switch public := key.public.(type) {
+	case ed25519.PublicKey:
+		key.Algorithm = SigningAlgorithmEdDSA
+	case *rsa.PublicKey:
+		if public.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA signing key %s is shorter than %d bits", id, minRSAKeyBits)
+		}
+		key.Algorithm = SigningAlgorithmRS256
+	default:
+		return nil, fmt.Errorf("signing key %s is neither RSA nor Ed25519", id)
+	}
+	return key, nil
+}
+
+// GenerateSigningKey creates a key for algorithm and returns it with its PEM private key
+func GenerateSigningKey(id, algorithm string) (*SigningKey, string, error) {
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case SigningAlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case SigningAlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, "", fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, "", err
+	}
+	encoded := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	return &SigningKey{ID: id, Algorithm: algorithm, private: signer, public: signer.Public()}, encoded, nil
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == SigningAlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// SigningKeySet - Signs with the active key and verifies with any key in the
+// set, so tokens outlive a rotation
+type SigningKeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+func NewSigningKeySet(activeID string, keys ...*SigningKey) (*SigningKeySet, error) {
+	set := &SigningKeySet{keys: map[string]*SigningKey{}}
+	for _, key := range keys {
+		if !masterKeyIDPattern.MatchString(key.ID) {
+			return nil, fmt.Errorf("invalid signing key id %q", key.ID)
+		}
+		set.keys[key.ID] = key
+	}
+	set.active = set.keys[activeID]
+	if set.active == nil || set.active.private == nil {
+		return nil, fmt.Errorf("active signing key %q is missing or has no private key", activeID)
+	}
+	return set, nil
+}
+
+// ActiveKeyID names the key new tokens are signed with
+func (s *SigningKeySet) ActiveKeyID() string {
+	return s.active.ID
+}
+
+// Sign issues a token signed with the active key, naming it in the kid header
+func (s *SigningKeySet) Sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(s.active.method(), claims)
+	token.Header["kid"] = s.active.ID
+	return token.SignedString(s.active.private)
+}
+
+// Parse verifies a token with the key its kid names, in that key's algorithm only
+func (s *SigningKeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownSigningKey, kid)
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{SigningAlgorithmEdDSA, SigningAlgorithmRS256}))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// JSONWebKey - Public half of a signing key, as published in the JWKS
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Curve     string `json:"crv,omitempty"` // Ed25519
+	X         string `json:"x,omitempty"`   // Ed25519 public key
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS lists every key tokens may be verified with, active key first
+func (s *SigningKeySet) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{s.active.jwk()}}
+	for id, key := range s.keys {
+		if id != s.active.ID {
+			set.Keys = append(set.Keys, key.jwk())
+		}
+	}
+	return set
+}
+
+func (k *SigningKey) jwk() JSONWebKey {
+	jwk := JSONWebKey{Use: "sig", Algorithm: k.Algorithm, KeyID: k.ID}
+	switch public := k.public.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return jwk
+}
+
+var (
+	defaultSigningKeys     *SigningKeySet
+	defaultSigningKeysErr  error
+	defaultSigningKeysOnce sync.Once
+)
+
+// DefaultSigningKeys loads the JWT key file the first time it is called. In
+// dev (APP_ENV unset or "dev") a missing file gives a throwaway key, so tokens
+// stop working on restart; anywhere else it is an error.
+func DefaultSigningKeys() (*SigningKeySet, error) {
+	defaultSigningKeysOnce.Do(func() {
+		path := JWTKeysFilePath()
+		defaultSigningKeys, defaultSigningKeysErr = OpenSigningKeyFile(path)
+		if !errors.Is(defaultSigningKeysErr, os.ErrNotExist) {
+			return
+		}
+		if env := os.Getenv("APP_ENV"); env != "" && env != "dev" {
+			defaultSigningKeysErr = fmt.Errorf("%w: %s not found, create it with `go run ./scripts/jwtkeys init`", ErrSigningKeysNotConfigured, path)
+			return
+		}
+
+		log.Printf("⚠️ %s not found, signing tokens with a temporary key", path)
+		key, _, err := GenerateSigningKey("dev-"+time.Now().UTC().Format("20060102T150405Z"), SigningAlgorithmEdDSA)
+		if err != nil {
+			defaultSigningKeysErr = err
+			return
+		}
+		defaultSigningKeys, defaultSigningKeysErr = NewSigningKeySet(key.ID, key)
+	})
+	return defaultSigningKeys, defaultSigningKeysErr
+}
+
+// JWTKeysFilePath - JWT key file, JWT_KEYS_FILE or ./jwt_keys.json
+func JWTKeysFilePath() string {
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		return path
+	}
+	return "jwt_keys.json"
+}
+
+type signingKeyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"` // PEM keys by kid
+}
+
+// OpenSigningKeyFile loads every key in the file. A missing file is an
+// os.ErrNotExist error.
+func OpenSigningKeyFile(path string) (*SigningKeySet, error) {
+	file, err := readSigningKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*SigningKey, 0, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := ParseSigningKey(id, encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	set, err := NewSigningKeySet(file.Active, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return set, nil
+}
+
+// AddSigningKeyToFile generates a key, creating the file if it doesn't exist
+// yet. A new file's first key is made active; otherwise the key is only
+// published until ActivateSigningKey, giving verifiers time to fetch it.
+func AddSigningKeyToFile(path, algorithm string) (string, error) {
+	file, err := readSigningKeyFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = &signingKeyFile{Keys: map[string]string{}}, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	id := "jwt-" + time.Now().UTC().Format("20060102T150405Z")
+	if _, exists := file.Keys[id]; exists {
+		return "", fmt.Errorf("signing key %s already exists", id)
+	}
+	_, encoded, err := GenerateSigningKey(id, algorithm)
+	if err != nil {
+		return "", err
+	}
+	file.Keys[id] = encoded
+	if file.Active == "" {
+		file.Active = id
+	}
+	return id, writeSigningKeyFile(path, file)
+}
+
+// ActivateSigningKey makes new tokens be signed with id
+func ActivateSigningKey(path, id string) error {
+	file, err := readSigningKeyFile(path)
+	if err != nil {
+		return err
+	}
+	key, ok := file.Keys[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSigningKey, id)
+	}
+	parsed, err := ParseSigningKey(id, key)
+	if err != nil {
+		return err
+	}
+	if parsed.private == nil {
+		return fmt.Errorf("signing key %s has no private key", id)
+	}
+	file.Active = id
+	return writeSigningKeyFile(path, file)
+}
+
+// RetireSigningKey removes an inactive key. Tokens signed with it stop verifying.
+func RetireSigningKey(path, id string) error {
+	file, err := readSigningKeyFile(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := file.Keys[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSigningKey, id)
+	}
+	if id == file.Active {
+		return fmt.Errorf("signing key %s is active; activate another key first", id)
+	}
+	delete(file.Keys, id)
+	return writeSigningKeyFile(path, file)
+}
+
+func readSigningKeyFile(path string) (*signingKeyFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file signingKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	if file.Keys == nil {
+		file.Keys = map[string]string{}
+	}
+	return &file, nil
+}
+
+func writeSigningKeyFile(path string, file *signingKeyFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write then rename, so a crash never leaves a truncated key file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
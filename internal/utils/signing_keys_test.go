@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSigningKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt_keys.json")
+	claims := jwt.MapClaims{"user_id": "u1", "exp": time.Now().Add(time.Minute).Unix()}
+
+	first, err := AddSigningKeyToFile(path, SigningAlgorithmRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := OpenSigningKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := keys.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Key IDs have second resolution
+	time.Sleep(time.Second)
+	second, err := AddSigningKeyToFile(path, SigningAlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ = OpenSigningKeyFile(path); keys.ActiveKeyID() != first {
+		t.Fatalf("adding a key activated it")
+	}
+	if err := ActivateSigningKey(path, second); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = OpenSigningKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := keys.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, _, _ := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	if header.Header["kid"] != second || header.Method.Alg() != SigningAlgorithmEdDSA {
+		t.Errorf("new token header = %v", header.Header)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if got, err := keys.Parse(token); err != nil || got["user_id"] != "u1" {
+			t.Errorf("Parse = %v, %v", got, err)
+		}
+	}
+
+	jwks := keys.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != second || jwks.Keys[0].KeyType != "OKP" || jwks.Keys[1].KeyType != "RSA" || jwks.Keys[1].E != "AQAB" {
+		t.Errorf("JWKS = %+v", jwks)
+	}
+
+	if err := RetireSigningKey(path, second); err == nil {
+		t.Error("retired the active key")
+	}
+	if err := RetireSigningKey(path, first); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ = OpenSigningKeyFile(path)
+	if _, err := keys.Parse(oldToken); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Errorf("token of a retired key: %v", err)
+	}
+}
+
+func TestSigningKeySetRejectsForgedTokens(t *testing.T) {
+	key, _, err := GenerateSigningKey("k1", SigningAlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewSigningKeySet("k1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{"user_id": "u1", "exp": time.Now().Add(time.Minute).Unix()}
+
+	// HS256 keyed with the public key, the classic algorithm confusion
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = "k1"
+	forged, _ := hs.SignedString([]byte(key.public.(ed25519.PublicKey)))
+	if _, err := keys.Parse(forged); err == nil {
+		t.Error("accepted an HS256 token")
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	none.Header["kid"] = "k1"
+	unsigned, _ := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if _, err := keys.Parse(unsigned); err == nil {
+		t.Error("accepted an unsigned token")
+	}
+
+	other, _, _ := GenerateSigningKey("k1", SigningAlgorithmEdDSA)
+	otherKeys, _ := NewSigningKeySet("k1", other)
+	signed, _ := otherKeys.Sign(claims)
+	if _, err := keys.Parse(signed); err == nil {
+		t.Error("accepted a token signed by another key with the same kid")
+	}
+}
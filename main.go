@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
@@ -11,6 +12,7 @@ import (
 	"healthy_pay_backend/internal/middleware"
 	"healthy_pay_backend/internal/routes"
 	"healthy_pay_backend/internal/services"
+	"healthy_pay_backend/internal/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -22,16 +24,83 @@ func main() {
 	}
 
 	cfg := config.Load()
+
+	// Tokens can't be issued or checked without signing keys
+	if _, err := utils.DefaultSigningKeys(); err != nil {
+		log.Fatalf("JWT signing key setup failed: %v", err)
+	}
+	// Wallet keys can't be encrypted or decrypted without the keystore
+	if _, err := utils.DefaultKeystore(); err != nil {
+		log.Fatalf("Keystore setup failed: %v", err)
+	}
 	
 	db, err := database.Connect(cfg.MongoURI)
 	if err != nil {
 		log.Fatalf("Database connection failed: %v", err)
 	}
 
+	// Ledger relies on unique references for idempotent postings
+	if err := services.NewLedgerService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Ledger index setup failed: %v", err)
+	}
+	if err := services.NewPSPWebhookService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Webhook index setup failed: %v", err)
+	}
+	if err := services.NewIdempotencyService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Idempotency key index setup failed: %v", err)
+	}
+	if err := services.NewJobService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Job index setup failed: %v", err)
+	}
+	if err := services.NewRefundService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Refund index setup failed: %v", err)
+	}
+	if err := services.NewStellarDepositService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Stellar deposit index setup failed: %v", err)
+	}
+	if err := services.NewBitcoinBlockchainService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Bitcoin index setup failed: %v", err)
+	}
+	if err := services.NewAuditService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Audit event index setup failed: %v", err)
+	}
+	if err := services.NewSessionService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Session index setup failed: %v", err)
+	}
+	if err := services.NewTransactionTokenService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Transaction token index setup failed: %v", err)
+	}
+	if err := services.NewSocialIdentityService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Social nonce index setup failed: %v", err)
+	}
+	if err := services.NewSocialAccountService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Social account index setup failed: %v", err)
+	}
+	if err := services.NewRateLimitService(db).EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Rate limit index setup failed: %v", err)
+	}
+	handles := services.NewWalletHandleService(db)
+	if err := handles.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Wallet handle index setup failed: %v", err)
+	}
+	if assigned, err := handles.AssignMissingHandles(context.Background()); err != nil {
+		log.Printf("Error assigning wallet handles: %v", err)
+	} else if assigned > 0 {
+		log.Printf("Assigned wallet handles to %d users", assigned)
+	}
+
 	// Start automated transaction queue
 	queue := services.NewTransactionQueue(db)
 	queue.Start()
 
+	// Start durable job worker for send collection, allocation and delivery
+	worker := services.NewJobWorker(db)
+	worker.Start()
+
+	// Credit custodial users for USDC paid to their Stellar deposit addresses
+	stellarDeposits := services.NewStellarDepositWorker(db)
+	stellarDeposits.Start()
+
 	// Setup graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -39,6 +108,8 @@ func main() {
 		<-c
 		log.Println("Shutting down gracefully...")
 		queue.Stop()
+		worker.Stop()
+		stellarDeposits.Stop()
 		os.Exit(0)
 	}()
 
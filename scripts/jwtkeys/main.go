@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"healthy_pay_backend/internal/utils"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Manages the keys access and transaction tokens are signed with
+
+  jwtkeys init [EdDSA|RS256]    create the key file (JWT_KEYS_FILE) with a first, active key
+  jwtkeys add-key [EdDSA|RS256] add a key; it is published in the JWKS but not used yet
+  jwtkeys activate <kid>        sign new tokens with the key
+  jwtkeys retire <kid>          remove an inactive key; tokens signed with it stop working
+  jwtkeys list                  show the keys and which one is active`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+	path := utils.JWTKeysFilePath()
+
+	switch cmd := os.Args[1]; {
+	case cmd == "init" && len(os.Args) <= 3:
+		if _, err := os.Stat(path); err == nil {
+			log.Fatalf("%s already exists; use add-key to rotate", path)
+		}
+		addKey(path)
+
+	case cmd == "add-key" && len(os.Args) <= 3:
+		if _, err := os.Stat(path); err != nil {
+			log.Fatalf("Cannot read %s: %v", path, err)
+		}
+		id := addKey(path)
+		fmt.Printf("Restart the servers so the JWKS publishes it, wait for verifiers to refresh their copy, then run `jwtkeys activate %s`\n", id)
+
+	case cmd == "activate" && len(os.Args) == 3:
+		if err := utils.ActivateSigningKey(path, os.Args[2]); err != nil {
+			log.Fatalf("Activating signing key failed: %v", err)
+		}
+		fmt.Printf("✅ Signing key %s is now active in %s; restart the servers to sign with it\n", os.Args[2], path)
+
+	case cmd == "retire" && len(os.Args) == 3:
+		if err := utils.RetireSigningKey(path, os.Args[2]); err != nil {
+			log.Fatalf("Retiring signing key failed: %v", err)
+		}
+		fmt.Printf("✅ Signing key %s removed from %s; restart the servers to stop accepting it\n", os.Args[2], path)
+
+	case cmd == "list" && len(os.Args) == 2:
+		keys, err := utils.OpenSigningKeyFile(path)
+		if err != nil {
+			log.Fatalf("Cannot read %s: %v", path, err)
+		}
+		for _, key := range keys.JWKS().Keys {
+			active := ""
+			if key.KeyID == keys.ActiveKeyID() {
+				active = " (active)"
+			}
+			fmt.Printf("%s %s%s\n", key.KeyID, key.Algorithm, active)
+		}
+
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+}
+
+func addKey(path string) string {
+	algorithm := utils.SigningAlgorithmEdDSA
+	if len(os.Args) == 3 {
+		algorithm = os.Args[2]
+	}
+	id, err := utils.AddSigningKeyToFile(path, algorithm)
+	if err != nil {
+		log.Fatalf("Adding signing key failed: %v", err)
+	}
+	fmt.Printf("✅ Added %s signing key %s to %s\n", algorithm, id, path)
+	return id
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"healthy_pay_backend/internal/config"
+	"healthy_pay_backend/internal/database"
+	"healthy_pay_backend/internal/utils"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const usage = `Manages the master keys that wrap stored wallet secrets
+
+  keystore init             create the local key file (KEYSTORE_FILE) with a first master key
+  keystore add-key          add a master key to the local key file and make it active
+  keystore rotate [-dry-run] re-wrap stored secrets with the active master key`
+
+// Encrypted wallet secrets
+var secretFields = []struct {
+	collection string
+	field      string
+}{
+	{"stellar_wallets", "private_key"},
+	{"stellar_wallets", "mnemonic_phrase"},
+	{"blockchain_wallets", "private_key"},
+	{"blockchain_wallets", "mnemonic_phrase"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	switch cmd := os.Args[1]; {
+	case cmd == "init":
+		path := utils.KeystoreFilePath()
+		if _, err := os.Stat(path); err == nil {
+			log.Fatalf("%s already exists; use add-key to rotate", path)
+		}
+		addKey(path)
+
+	case cmd == "add-key":
+		path := utils.KeystoreFilePath()
+		if _, err := os.Stat(path); err != nil {
+			log.Fatalf("Cannot read %s: %v", path, err)
+		}
+		addKey(path)
+		fmt.Println("Restart the servers to encrypt with it, then run `keystore rotate`")
+
+	case cmd == "rotate" && (len(os.Args) == 2 || len(os.Args) == 3 && os.Args[2] == "-dry-run"):
+		rotate(len(os.Args) == 3)
+
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+}
+
+func addKey(path string) {
+	id, err := utils.AddFileMasterKey(path)
+	if err != nil {
+		log.Fatalf("Adding master key failed: %v", err)
+	}
+	fmt.Printf("✅ Master key %s is now active in %s\n", id, path)
+}
+
+// rotate re-wraps every secret not yet wrapped with the active master key.
+// Each document is updated only if the secret is unchanged since it was read,
+// so the script is safe to run alongside the servers and to re-run.
+func rotate(dryRun bool) {
+	keystore, err := utils.DefaultKeystore()
+	if err != nil {
+		log.Fatalf("Keystore unavailable: %v", err)
+	}
+
+	cfg := config.Load()
+	db, err := database.Connect(cfg.MongoURI)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+
+	ctx := context.Background()
+	failed := 0
+	for _, sf := range secretFields {
+		rewrapped, current, errs, err := rotateField(ctx, keystore, db.Collection(sf.collection), sf.field, dryRun)
+		if err != nil {
+			log.Fatalf("Failed rotating %s.%s after %d documents: %v", sf.collection, sf.field, rewrapped, err)
+		}
+		failed += errs
+		verb := "re-wrapped"
+		if dryRun {
+			verb = "to re-wrap"
+		}
+		fmt.Printf("✅ %s.%s: %d %s, %d already current, %d unreadable\n", sf.collection, sf.field, rewrapped, verb, current, errs)
+	}
+	if failed > 0 {
+		log.Fatalf("%d secrets could not be decrypted; they were left as they are", failed)
+	}
+}
+
+func rotateField(ctx context.Context, keystore *utils.Keystore, collection *mongo.Collection, field string, dryRun bool) (rewrapped, current, failed int, err error) {
+	cursor, err := collection.Find(ctx, bson.M{field: bson.M{"$type": "string", "$ne": ""}})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		id := cursor.Current.Lookup("_id")
+		value := cursor.Current.Lookup(field).StringValue()
+
+		updated, changed, err := keystore.Rewrap(ctx, value)
+		if err != nil {
+			// Never log the value itself
+			log.Printf("⚠️ %s %s.%s: %v", collection.Name(), id, field, err)
+			failed++
+			continue
+		}
+		if !changed {
+			current++
+			continue
+		}
+		if !dryRun {
+			_, err := collection.UpdateOne(ctx,
+				bson.M{"_id": id, field: value},
+				bson.M{"$set": bson.M{field: updated}})
+			if err != nil {
+				return rewrapped, current, failed, err
+			}
+		}
+		rewrapped++
+	}
+	return rewrapped, current, failed, cursor.Err()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"healthy_pay_backend/internal/config"
+	"healthy_pay_backend/internal/database"
+	"healthy_pay_backend/internal/models"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Every fiat amount stored before the Money type was a float in major units.
+// All collections and payouts ran through GHS rails, so legacy values are GHS.
+// Wallets carry their own currency label, and only GHS ones are migrated;
+// any other label has to be checked by hand before its balance is converted.
+const legacyCurrency = "GHS"
+
+var moneyFields = []struct {
+	collection string
+	field      string
+	filter     bson.M // Documents of the collection that hold legacy GHS amounts
+}{
+	{"wallets", "balance", bson.M{"currency": legacyCurrency}},
+	{"transactions", "amount", nil},
+	{"transactions", "investment_amount", nil},
+	{"investments", "amount", nil},
+	{"investments", "returns", nil},
+	{"donations", "amount", nil},
+	{"mobile_money_wallets", "balance", nil},
+}
+
+// One-time migration of float amounts to {minor, currency} documents. Documents
+// that are already migrated are skipped, so the script is safe to re-run.
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	cfg := config.Load()
+	db, err := database.Connect(cfg.MongoURI)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+
+	fmt.Println("💱 Migrating float amounts to minor-unit Money")
+
+	ctx := context.Background()
+	for _, mf := range moneyFields {
+		migrated, err := migrateField(ctx, db.Collection(mf.collection), mf.field, mf.filter)
+		if err != nil {
+			log.Fatalf("Failed migrating %s.%s after %d documents: %v", mf.collection, mf.field, migrated, err)
+		}
+		fmt.Printf("✅ %s.%s: %d documents migrated\n", mf.collection, mf.field, migrated)
+	}
+
+	unmigrated, err := reportUnmigratedWallets(ctx, db.Collection("wallets"))
+	if err != nil {
+		log.Fatalf("Failed listing unmigrated wallets: %v", err)
+	}
+	if unmigrated > 0 {
+		fmt.Printf("⚠️ %d wallets are not %s and were left for manual review; resolve them before running opening_balances\n", unmigrated, legacyCurrency)
+	}
+}
+
+func migrateField(ctx context.Context, collection *mongo.Collection, field string, filter bson.M) (int, error) {
+	query := bson.M{field: bson.M{"$type": "number"}}
+	for k, v := range filter {
+		query[k] = v
+	}
+	cursor, err := collection.Find(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		value := cursor.Current.Lookup(field)
+		var major float64
+		switch value.Type {
+		case bson.TypeDouble:
+			major = value.Double()
+		case bson.TypeInt32:
+			major = float64(value.Int32())
+		case bson.TypeInt64:
+			major = float64(value.Int64())
+		default:
+			continue
+		}
+
+		id := cursor.Current.Lookup("_id")
+		amount := models.MoneyFromMajor(major, legacyCurrency, models.RoundHalfEven)
+
+		// Match on the old type so a concurrent write is never overwritten
+		match := bson.M{"_id": id}
+		for k, v := range query {
+			match[k] = v
+		}
+		_, err := collection.UpdateOne(ctx,
+			match,
+			bson.M{"$set": bson.M{field: amount}},
+		)
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
+
+// reportUnmigratedWallets lists the wallets whose balance is still a float
+// because their currency label isn't GHS
+func reportUnmigratedWallets(ctx context.Context, wallets *mongo.Collection) (int, error) {
+	cursor, err := wallets.Find(ctx, bson.M{"balance": bson.M{"$type": "number"}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	for cursor.Next(ctx) {
+		var wallet struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			UserID   primitive.ObjectID `bson:"user_id"`
+			Balance  float64            `bson:"balance"`
+			Currency string             `bson:"currency"`
+		}
+		if err := cursor.Decode(&wallet); err != nil {
+			return count, err
+		}
+		fmt.Printf("   wallet %s  user %s  balance %v  currency %q\n", wallet.ID.Hex(), wallet.UserID.Hex(), wallet.Balance, wallet.Currency)
+		count++
+	}
+	return count, cursor.Err()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"healthy_pay_backend/internal/config"
+	"healthy_pay_backend/internal/database"
+	"healthy_pay_backend/internal/services"
+
+	"github.com/joho/godotenv"
+)
+
+// One-time migration that journals wallet balances from before the ledger as
+// opening_balance entries. Run it after scripts/migrate_money and before the
+// first scripts/rebuild_balances; re-running it is safe.
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	cfg := config.Load()
+	db, err := database.Connect(cfg.MongoURI)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+
+	fmt.Println("📒 Posting opening balances for existing wallets")
+
+	ctx := context.Background()
+	ledger := services.NewLedgerService(db)
+	if err := ledger.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Ledger index setup failed: %v", err)
+	}
+	posted, err := ledger.PostOpeningBalances(ctx)
+	if err != nil {
+		log.Fatalf("Opening balances failed after %d wallets: %v", posted, err)
+	}
+
+	fmt.Printf("✅ Posted %d opening balance entries\n", posted)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"healthy_pay_backend/internal/config"
+	"healthy_pay_backend/internal/database"
+	"healthy_pay_backend/internal/services"
+
+	"github.com/joho/godotenv"
+)
+
+// Recomputes every wallet balance from the ledger journal. Wallets funded
+// before the ledger need their opening balances journaled first, so the order
+// on an existing database is scripts/migrate_money, scripts/opening_balances,
+// then this script; it refuses to run until opening balances are posted.
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	cfg := config.Load()
+	db, err := database.Connect(cfg.MongoURI)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+
+	fmt.Println("📒 Rebuilding wallet balances from ledger")
+
+	changed, err := services.NewLedgerService(db).RebuildAllWalletBalances(context.Background())
+	if err != nil {
+		log.Fatalf("Rebuild failed after %d wallets: %v", changed, err)
+	}
+
+	fmt.Printf("✅ Rebuilt balances, %d wallets corrected\n", changed)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"healthy_pay_backend/internal/config"
+	"healthy_pay_backend/internal/database"
+	"healthy_pay_backend/internal/services"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Resolves Stellar payments parked in suspense
+
+  stellar_suspense list
+  stellar_suspense credit <operation-id> <@handle|phone|email|user-id>
+  stellar_suspense return <operation-id>
+  stellar_suspense check-return <operation-id>`
+
+// Lists, credits or returns Stellar payments that couldn't be matched to a user
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	cfg := config.Load()
+	db, err := database.Connect(cfg.MongoURI)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+
+	ctx := context.Background()
+	deposits := services.NewStellarDepositService(db)
+	operator := os.Getenv("USER")
+
+	switch cmd := os.Args[1]; {
+	case cmd == "list":
+		suspended, err := deposits.ListSuspense(ctx)
+		if err != nil {
+			log.Fatalf("Listing suspense failed: %v", err)
+		}
+		for _, d := range suspended {
+			fmt.Printf("%s  %s %s  from %s  to %s  memo %q  %s  (%s)\n",
+				d.OperationID, d.Amount, d.AssetCode, d.FromAddress, d.ToAddress, d.Memo,
+				d.SuspenseReason, d.CreatedAt.Format("2006-01-02 15:04"))
+		}
+		fmt.Printf("%d payments in suspense\n", len(suspended))
+
+	case cmd == "credit" && len(os.Args) == 4:
+		deposit, err := deposits.ResolveSuspense(ctx, os.Args[2], os.Args[3], operator)
+		if err != nil {
+			log.Fatalf("Credit failed: %v", err)
+		}
+		fmt.Printf("✅ Credited %s to user %s\n", deposit.Credited, deposit.UserID.Hex())
+
+	case cmd == "return" && len(os.Args) == 3:
+		deposit, err := deposits.ReturnSuspense(ctx, os.Args[2], operator)
+		if err != nil {
+			log.Fatalf("Return failed: %v", err)
+		}
+		fmt.Printf("✅ Returned %s %s to %s in %s\n", deposit.Amount, deposit.AssetCode, deposit.FromAddress, deposit.ReturnTxHash)
+
+	case cmd == "check-return" && len(os.Args) == 3:
+		deposit, err := deposits.CheckReturn(ctx, os.Args[2])
+		if err != nil {
+			log.Fatalf("Check failed: %v", err)
+		}
+		fmt.Printf("Return of %s is %s %s\n", deposit.OperationID, deposit.Status, deposit.ReturnStatus)
+
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+}
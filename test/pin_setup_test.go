@@ -12,6 +12,7 @@ import (
 	"healthy_pay_backend/internal/handlers"
 	"healthy_pay_backend/internal/middleware"
 	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/services"
 	"healthy_pay_backend/internal/utils"
 
 	"github.com/gin-gonic/gin"
@@ -43,8 +44,8 @@ func createTestUser(db *mongo.Database) (primitive.ObjectID, string) {
 	collection := db.Collection("users")
 	collection.InsertOne(context.Background(), user)
 	
-	token, _ := utils.GenerateJWT(user.ID)
-	return user.ID, token
+	tokens, _ := services.NewSessionService(db).Create(context.Background(), user.ID, services.SessionClient{})
+	return user.ID, tokens.AccessToken
 }
 
 func TestPINSetupEndpoint(t *testing.T) {
@@ -59,7 +60,7 @@ func TestPINSetupEndpoint(t *testing.T) {
 		authHandler := handlers.NewAuthHandler(db)
 		
 		protected := router.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(db))
 		protected.POST("/auth/setup-pin", authHandler.SetupPIN)
 
 		pinData := map[string]string{"pin": "1234"}
@@ -91,7 +92,7 @@ func TestPINSetupEndpoint(t *testing.T) {
 		authHandler := handlers.NewAuthHandler(db)
 		
 		protected := router.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(db))
 		protected.POST("/auth/setup-pin", authHandler.SetupPIN)
 
 		pinData := map[string]string{"pin": "1234"}
@@ -115,7 +116,7 @@ func TestPINSetupEndpoint(t *testing.T) {
 		authHandler := handlers.NewAuthHandler(db)
 		
 		protected := router.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(db))
 		protected.POST("/auth/setup-pin", authHandler.SetupPIN)
 
 		pinData := map[string]string{"pin": "1234"}
@@ -142,7 +143,7 @@ func TestPINSetupEndpoint(t *testing.T) {
 		authHandler := handlers.NewAuthHandler(db)
 		
 		protected := router.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(db))
 		protected.POST("/auth/setup-pin", authHandler.SetupPIN)
 
 		// Test with 5-digit PIN
@@ -170,7 +171,7 @@ func TestPINSetupEndpoint(t *testing.T) {
 		authHandler := handlers.NewAuthHandler(db)
 		
 		protected := router.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(db))
 		protected.POST("/auth/setup-pin", authHandler.SetupPIN)
 
 		pinData := map[string]string{"pin": "123"}
@@ -193,7 +194,7 @@ func TestPINSetupEndpoint(t *testing.T) {
 		authHandler := handlers.NewAuthHandler(db)
 		
 		protected := router.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(db))
 		protected.POST("/auth/setup-pin", authHandler.SetupPIN)
 
 		pinData := map[string]string{"pin": "abcd"}
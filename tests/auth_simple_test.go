@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"healthy_pay_backend/internal/utils"
 
@@ -36,8 +37,8 @@ func TestJWTGeneration(t *testing.T) {
 	// Mock user ID
 	userID, _ := primitive.ObjectIDFromHex("507f1f77bcf86cd799439011")
 	
-	// Generate JWT token
-	token, err := utils.GenerateJWT(userID)
+	// Generate an access token, as SessionService.Create does at sign-in
+	token, _, err := utils.GenerateAccessToken(userID, primitive.NewObjectID().Hex(), time.Now())
 	assert.NoError(t, err)
 	assert.NotEmpty(t, token)
 	
@@ -12,6 +12,7 @@ import (
 	"healthy_pay_backend/internal/handlers"
 	"healthy_pay_backend/internal/middleware"
 	"healthy_pay_backend/internal/models"
+	"healthy_pay_backend/internal/services"
 	"healthy_pay_backend/internal/utils"
 
 	"github.com/gin-gonic/gin"
@@ -50,8 +51,8 @@ func createPINTestUser(db *mongo.Database, withPIN bool) (primitive.ObjectID, st
 	collection := db.Collection("users")
 	collection.InsertOne(context.Background(), user)
 	
-	token, _ := utils.GenerateJWT(user.ID)
-	return user.ID, token
+	tokens, _ := services.NewSessionService(db).Create(context.Background(), user.ID, services.SessionClient{})
+	return user.ID, tokens.AccessToken
 }
 
 func TestPINSetupFlow(t *testing.T) {
@@ -66,7 +67,7 @@ func TestPINSetupFlow(t *testing.T) {
 		authHandler := handlers.NewAuthHandler(db)
 		
 		protected := router.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(db))
 		protected.POST("/auth/setup-pin", authHandler.SetupPIN)
 
 		pinData := map[string]string{"pin": "1234"}
@@ -93,7 +94,7 @@ func TestPINSetupFlow(t *testing.T) {
 		authHandler := handlers.NewAuthHandler(db)
 		
 		protected := router.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(db))
 		protected.POST("/auth/setup-pin", authHandler.SetupPIN)
 
 		pinData := map[string]string{"pin": "1234"}
@@ -115,7 +116,7 @@ func TestPINSetupFlow(t *testing.T) {
 		authHandler := handlers.NewAuthHandler(db)
 		
 		protected := router.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(db))
 		protected.POST("/auth/setup-pin", authHandler.SetupPIN)
 
 		pinData := map[string]string{"pin": "12345"}